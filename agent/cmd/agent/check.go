@@ -0,0 +1,91 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/xugou/agent/pkg/config"
+	"github.com/xugou/agent/pkg/diagnose"
+	"github.com/xugou/agent/pkg/reporter"
+)
+
+func init() {
+	checkCmd := &cobra.Command{
+		Use:   "check-connection",
+		Short: "诊断与服务器之间的连接",
+		Long: `逐步检查 URL 解析、DNS、TCP 连接、代理连接（HTTP CONNECT 或 SOCKS5）、TLS 握手和注册请求，输出每一步的结果、耗时和修复建议。
+
+退出码:
+  0   全部通过
+  10  配置错误（缺少服务器地址/令牌或地址无效）
+  11  DNS 解析失败
+  12  代理连接失败
+  13  TCP 连接失败
+  14  TLS 握手失败
+  15  令牌被服务器拒绝
+  16  服务器错误`,
+		Run: runCheckConnection,
+	}
+	checkCmd.Flags().Duration("timeout", 10*time.Second, "每个检查步骤的超时时间")
+
+	rootCmd.AddCommand(checkCmd)
+}
+
+func runCheckConnection(cmd *cobra.Command, args []string) {
+	loadRuntimeConfig()
+	timeout, _ := cmd.Flags().GetDuration("timeout")
+
+	out := cmd.OutOrStdout()
+
+	httpReporter, err := reporter.NewHTTPReporter()
+	if err != nil {
+		fmt.Fprintln(out, "[失败] 加载连接配置")
+		fmt.Fprintf(out, "       错误: %v\n", err)
+		fmt.Fprintln(out, "       建议: 检查 token_file、proxy、no_proxy 和 tls 相关配置")
+		os.Exit(diagnose.ExitConfig)
+	}
+
+	report := diagnose.Run(context.Background(), diagnose.Options{
+		ServerURL: config.ServerURL,
+		Tokens:    httpReporter.Tokens,
+		ProxyURL:  config.ProxyURL,
+		NoProxy:   config.NoProxy,
+		Timeout:   timeout,
+		Client:    httpReporter.Client,
+		TLSConfig: httpReporter.Client.Transport.(*http.Transport).TLSClientConfig,
+	})
+
+	for _, step := range report.Steps {
+		switch {
+		case step.Skipped:
+			fmt.Fprintf(out, "[跳过] %s\n", step.Name)
+			continue
+		case step.Err != nil:
+			fmt.Fprintf(out, "[失败] %s (%s)\n", step.Name, step.Duration.Round(time.Millisecond))
+		default:
+			fmt.Fprintf(out, "[通过] %s (%s)\n", step.Name, step.Duration.Round(time.Millisecond))
+		}
+		for _, detail := range step.Detail {
+			fmt.Fprintf(out, "       %s\n", detail)
+		}
+		for _, warning := range step.Warnings {
+			fmt.Fprintf(out, "       警告: %s\n", warning)
+		}
+		if step.Err != nil {
+			fmt.Fprintf(out, "       错误: %v\n", step.Err)
+			if step.Hint != "" {
+				fmt.Fprintf(out, "       建议: %s\n", step.Hint)
+			}
+		}
+	}
+
+	code := report.ExitCode()
+	if code == diagnose.ExitOK {
+		fmt.Fprintln(out, "连接检查全部通过")
+	}
+	os.Exit(code)
+}
@@ -0,0 +1,90 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/xugou/agent/pkg/collector"
+	"github.com/xugou/agent/pkg/config"
+	"github.com/xugou/agent/pkg/output"
+)
+
+func init() {
+	collectCmd := &cobra.Command{
+		Use:   "collect",
+		Short: "采集系统信息并输出，不上报到服务器",
+		Long: `执行一次（或按间隔持续执行）所有采集步骤并在本地输出结果，不会连接服务器。
+可用于检查 --devices、--interfaces 等过滤条件是否符合预期。
+使用 --once 时如果有采集步骤失败，以状态码 2 退出。`,
+		RunE:          runCollect,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+	collectCmd.Flags().Bool("once", false, "只采集一次后退出")
+	collectCmd.Flags().StringP("output", "o", output.FormatTable, "输出格式: json、table 或 prometheus")
+
+	rootCmd.AddCommand(collectCmd)
+}
+
+func runCollect(cmd *cobra.Command, args []string) error {
+	loadRuntimeConfig()
+
+	once, _ := cmd.Flags().GetBool("once")
+	format, _ := cmd.Flags().GetString("output")
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	dataCollector := collector.NewCollector()
+
+	collectOnce := func() (bool, error) {
+		info, results := dataCollector.CollectSteps(ctx)
+
+		if err := output.Write(cmd.OutOrStdout(), format, info, results); err != nil {
+			return false, err
+		}
+
+		failed := false
+		for _, r := range results {
+			if r.Err != nil {
+				failed = true
+				fmt.Fprintf(cmd.ErrOrStderr(), "采集步骤 %s 失败: %v\n", r.Name, r.Err)
+			}
+		}
+		return failed, nil
+	}
+
+	if once {
+		failed, err := collectOnce()
+		if err != nil {
+			return err
+		}
+		if failed {
+			os.Exit(2)
+		}
+		return nil
+	}
+
+	interval := config.Current().Interval
+	if interval < 1 {
+		return fmt.Errorf("无效的采集间隔 %d 秒，必须大于 0", interval)
+	}
+	ticker := time.NewTicker(time.Duration(interval) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		if _, err := collectOnce(); err != nil {
+			return err
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+
+	"github.com/xugou/agent/pkg/config"
+	"github.com/xugou/agent/pkg/logger"
+	"github.com/xugou/agent/pkg/utils"
+)
+
+func init() {
+	configCmd := &cobra.Command{
+		Use:   "config",
+		Short: "管理配置文件",
+		Long:  `生成、校验和查看 Xugou Agent 的配置文件`,
+	}
+
+	initCmd := &cobra.Command{
+		Use:           "init",
+		Short:         "生成带注释的配置文件模板",
+		Long:          `生成带注释的配置文件模板，可以通过命令行参数或交互方式填写配置`,
+		RunE:          runConfigInit,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+	initCmd.Flags().StringP("output", "o", "", "配置文件输出路径 (默认为 --config 指定的路径或 $HOME/.xugou-agent.yaml)")
+	initCmd.Flags().Bool("force", false, "覆盖已存在的配置文件")
+	initCmd.Flags().Bool("interactive", false, "交互式填写配置")
+
+	validateCmd := &cobra.Command{
+		Use:           "validate [配置文件]",
+		Short:         "严格校验配置文件",
+		Long:          `严格校验配置文件，发现未知配置项、无效的URL或采集间隔等问题时以非零状态码退出`,
+		Args:          cobra.MaximumNArgs(1),
+		RunE:          runConfigValidate,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+
+	showCmd := &cobra.Command{
+		Use:           "show",
+		Short:         "显示最终生效的配置",
+		Long:          `显示合并配置文件、环境变量和命令行参数后最终生效的配置，以及每个配置项的来源`,
+		RunE:          runConfigShow,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+
+	configCmd.AddCommand(initCmd, validateCmd, showCmd)
+	rootCmd.AddCommand(configCmd)
+}
+
+func runConfigInit(cmd *cobra.Command, args []string) error {
+	output, _ := cmd.Flags().GetString("output")
+	force, _ := cmd.Flags().GetBool("force")
+	interactive, _ := cmd.Flags().GetBool("interactive")
+
+	if output == "" {
+		output = cfgFile
+	}
+
+	if _, err := os.Stat(output); err == nil && !force {
+		return fmt.Errorf("配置文件 %s 已存在，如需覆盖请使用 --force", output)
+	}
+
+	// 命令行中显式指定的参数会写入模板
+	values := make(map[string]interface{})
+	for _, key := range config.Keys {
+		if flag := cmd.Flags().Lookup(flagName(key.Name)); flag != nil && flag.Changed {
+			values[key.Name] = viper.Get(key.Name)
+		}
+	}
+
+	if interactive {
+		if err := promptValues(cmd.InOrStdin(), cmd.OutOrStdout(), values); err != nil {
+			return err
+		}
+	}
+
+	content := config.RenderTemplate(values)
+
+	// 生成的配置必须能通过校验，避免写出一个无法使用的文件
+	var settings map[string]interface{}
+	if err := yaml.Unmarshal([]byte(content), &settings); err != nil {
+		return fmt.Errorf("生成的配置文件无法解析: %w", err)
+	}
+	if errs := config.ValidateSettings(settings); len(errs) > 0 {
+		return fmt.Errorf("生成的配置无效: %w", errors.Join(errs...))
+	}
+
+	if err := os.MkdirAll(filepath.Dir(output), 0755); err != nil {
+		return fmt.Errorf("创建配置目录失败: %w", err)
+	}
+	// 配置文件中包含令牌，只允许当前用户读写
+	if err := os.WriteFile(output, []byte(content), 0600); err != nil {
+		return fmt.Errorf("写入配置文件失败: %w", err)
+	}
+
+	fmt.Fprintf(cmd.OutOrStdout(), "配置文件已写入: %s\n", output)
+	return nil
+}
+
+// promptValues 交互式询问常用配置项，直接回车则保留当前值
+func promptValues(in io.Reader, out io.Writer, values map[string]interface{}) error {
+	reader := bufio.NewReader(in)
+	prompts := []string{"server", "token", "interval", "proxy"}
+
+	for _, name := range prompts {
+		key, _ := config.LookupKey(name)
+		current := ""
+		if v, ok := values[name]; ok {
+			current = fmt.Sprint(v)
+		} else if key.Default != nil {
+			current = fmt.Sprint(key.Default)
+		}
+
+		for {
+			fmt.Fprintf(out, "%s [%s]: ", key.Description, current)
+			line, err := reader.ReadString('\n')
+			if err != nil && err != io.EOF {
+				return fmt.Errorf("读取输入失败: %w", err)
+			}
+			line = strings.TrimSpace(line)
+			if line == "" {
+				if current != "" {
+					values[name] = parseInput(key, current)
+				}
+				break
+			}
+
+			value := parseInput(key, line)
+			if verr := config.ValidateValue(key, value); verr != nil {
+				fmt.Fprintf(out, "输入无效: %v\n", verr)
+				if err == io.EOF {
+					return fmt.Errorf("%s: %w", name, verr)
+				}
+				continue
+			}
+			values[name] = value
+			break
+		}
+	}
+	return nil
+}
+
+func parseInput(key config.Key, input string) interface{} {
+	if key.Kind == config.KindInt {
+		if n, err := strconv.Atoi(input); err == nil {
+			return n
+		}
+	}
+	return input
+}
+
+func runConfigValidate(cmd *cobra.Command, args []string) error {
+	path := cfgFile
+	if len(args) > 0 {
+		path = args[0]
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("读取配置文件失败: %w", err)
+	}
+
+	var settings map[string]interface{}
+	if err := yaml.Unmarshal(data, &settings); err != nil {
+		return fmt.Errorf("配置文件 %s 不是有效的 YAML: %w", path, err)
+	}
+
+	errs := config.ValidateSettings(settings)
+	for _, e := range errs {
+		fmt.Fprintf(cmd.ErrOrStderr(), "  - %v\n", e)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("配置文件 %s 校验失败，共 %d 个问题", path, len(errs))
+	}
+
+	fmt.Fprintf(cmd.OutOrStdout(), "配置文件 %s 校验通过\n", path)
+	return nil
+}
+
+func runConfigShow(cmd *cobra.Command, args []string) error {
+	w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "配置项\t值\t来源")
+
+	for _, key := range config.Keys {
+		value := formatConfigValue(redactConfigValue(key, viper.Get(key.Name)))
+		if key.Secret {
+			value = utils.RedactSecret(value)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", key.Name, value, configSource(cmd, key.Name))
+	}
+	return w.Flush()
+}
+
+// configSource 按 viper 的优先级判断配置项的来源
+func configSource(cmd *cobra.Command, name string) string {
+	if flag := cmd.Flags().Lookup(flagName(name)); flag != nil && flag.Changed {
+		return "命令行参数"
+	}
+	if _, ok := os.LookupEnv(config.EnvName(name)); ok {
+		return "环境变量 " + config.EnvName(name)
+	}
+	if viper.InConfig(name) {
+		return "配置文件"
+	}
+	return "默认值"
+}
+
+// redactConfigValue 移除配置值中的凭据：URL 中的用户名和密码，以及通知渠道和探测中携带凭据的请求头
+func redactConfigValue(key config.Key, value interface{}) interface{} {
+	if s, ok := value.(string); ok && key.Kind == config.KindURL {
+		return stripUserinfo(s)
+	}
+	list, ok := value.([]interface{})
+	if !ok || (key.Name != "sinks" && key.Name != "probes") {
+		return value
+	}
+
+	redacted := make([]interface{}, len(list))
+	for i, item := range list {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			redacted[i] = item
+			continue
+		}
+		out := make(map[string]interface{}, len(m))
+		for k, v := range m {
+			out[k] = v
+		}
+		if u, ok := m["url"].(string); ok {
+			out["url"] = stripUserinfo(u)
+		}
+		if headers, ok := m["headers"].(map[string]interface{}); ok {
+			secret, _ := m["secret_headers"].([]interface{})
+			names := make([]string, 0, len(secret))
+			for _, name := range secret {
+				names = append(names, fmt.Sprint(name))
+			}
+			safe := make(map[string]interface{}, len(headers))
+			for name, v := range headers {
+				if logger.HeaderIsSecret(name, names) {
+					v = logger.Redacted
+				}
+				safe[name] = v
+			}
+			out["headers"] = safe
+		}
+		redacted[i] = out
+	}
+	return redacted
+}
+
+// stripUserinfo 移除 URL 中的用户名和密码
+func stripUserinfo(s string) string {
+	u, err := url.Parse(s)
+	if err != nil {
+		return logger.Redact(s)
+	}
+	if u.User == nil {
+		return s
+	}
+	u.User = nil
+	return u.String()
+}
+
+// formatConfigValue 将配置值格式化为一行，列表和键值对使用 JSON 表示
+func formatConfigValue(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+	switch reflect.ValueOf(value).Kind() {
+	case reflect.Slice, reflect.Map:
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(value); err == nil {
+			return strings.TrimSpace(buf.String())
+		}
+	}
+	return fmt.Sprint(value)
+}
@@ -0,0 +1,203 @@
+package agent
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"runtime"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/xugou/agent/pkg/config"
+	"github.com/xugou/agent/pkg/control"
+	"github.com/xugou/agent/pkg/diagnose"
+	"github.com/xugou/agent/pkg/model"
+	"github.com/xugou/agent/pkg/probes"
+	"github.com/xugou/agent/pkg/proxy"
+	"github.com/xugou/agent/pkg/remoteconfig"
+	"github.com/xugou/agent/pkg/reporter"
+	"github.com/xugou/agent/pkg/telemetry"
+	"github.com/xugou/agent/pkg/utils"
+)
+
+// commandTimeout 单个控制命令的最长执行时间
+const commandTimeout = 2 * time.Minute
+
+// restartIDEnv 重启时通过该环境变量把触发重启的请求 ID 传给新进程，避免服务器重发命令时再次重启
+const restartIDEnv = "XUGOU_CONTROL_RESTART_ID"
+
+// newControlClient 创建控制通道客户端并注册各命令的实现，watcher 为空表示没有启用远程配置。
+// 收到重启命令时通过 restart 通知主循环，由主循环停止所有后台任务后再重新执行程序
+func newControlClient(r reporter.Reporter, collectNow func(ctx context.Context) error, watcher *remoteconfig.Watcher, scheduler *probes.Scheduler, restart chan<- struct{}) (*control.Client, error) {
+	signer, ok := r.(reporter.RequestSigner)
+	if !ok {
+		return nil, fmt.Errorf("上报器不支持控制通道")
+	}
+	heartbeat, err := time.ParseDuration(viper.GetString("control.heartbeat"))
+	if err != nil {
+		return nil, fmt.Errorf("control.heartbeat 无效: %w", err)
+	}
+
+	transport, err := reporter.NewTransport(config.TLS)
+	if err != nil {
+		return nil, err
+	}
+	// WebSocket 依赖 HTTP/1.1 的 Upgrade，不能使用 HTTP/2
+	transport.ForceAttemptHTTP2 = false
+	transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+
+	client := &control.Client{
+		URL:       utils.NormalizeURL(config.ServerURL) + "/api/agents/control",
+		HTTP:      &http.Client{Transport: transport}, // 长连接不能设置整体超时
+		Prepare:   signer.SignRequest,
+		Check:     signer.CheckResponse,
+		Mode:      viper.GetString("control.mode"),
+		Heartbeat: heartbeat,
+		Allowed:   viper.GetStringSlice("control.commands"),
+		Timeout:   commandTimeout,
+		Handlers: map[string]control.Handler{
+			control.CommandCollectNow: func(ctx context.Context, args json.RawMessage) (interface{}, error) {
+				if err := collectNow(ctx); err != nil {
+					return nil, err
+				}
+				return map[string]interface{}{"reported": true}, nil
+			},
+		},
+	}
+
+	if uploader, ok := r.(reporter.DiagnosticsUploader); ok {
+		client.Handlers[control.CommandUploadDiagnostics] = func(ctx context.Context, args json.RawMessage) (interface{}, error) {
+			bundle := collectDiagnostics(ctx)
+			if err := uploader.UploadDiagnostics(ctx, bundle); err != nil {
+				return nil, fmt.Errorf("上传诊断信息失败: %w", err)
+			}
+			return map[string]interface{}{"uploaded": true}, nil
+		}
+	}
+	if watcher != nil {
+		client.Handlers[control.CommandReloadConfig] = func(ctx context.Context, args json.RawMessage) (interface{}, error) {
+			if err := watcher.Refresh(ctx); err != nil {
+				return nil, err
+			}
+			return map[string]interface{}{"version": watcher.Version()}, nil
+		}
+	}
+	if scheduler.Len() > 0 {
+		// 参数 {"name": "..."} 指定要执行的探测，省略时执行所有探测
+		client.Handlers[control.CommandRunProbe] = func(ctx context.Context, args json.RawMessage) (interface{}, error) {
+			var params struct {
+				Name string `json:"name"`
+			}
+			if len(args) > 0 {
+				if err := json.Unmarshal(args, &params); err != nil {
+					return nil, fmt.Errorf("参数无效: %w", err)
+				}
+			}
+			results, err := scheduler.RunNow(ctx, params.Name)
+			if err != nil {
+				return nil, err
+			}
+			return map[string]interface{}{"probes": results}, nil
+		}
+	}
+	if canRestart {
+		if id := os.Getenv(restartIDEnv); id != "" {
+			client.MarkDone(id, control.CommandRestart, map[string]interface{}{"restarting": true})
+			os.Unsetenv(restartIDEnv)
+		}
+		client.Handlers[control.CommandRestart] = func(ctx context.Context, args json.RawMessage) (interface{}, error) {
+			os.Setenv(restartIDEnv, control.RequestID(ctx))
+			// 稍后再重启，先把结果发回服务器
+			time.AfterFunc(time.Second, func() {
+				select {
+				case restart <- struct{}{}:
+				default:
+				}
+			})
+			return map[string]interface{}{"restarting": true}, nil
+		}
+	}
+	return client, nil
+}
+
+// diagnosticsBundle 上传到服务器的诊断信息
+type diagnosticsBundle struct {
+	Version     string                `json:"version"`
+	OS          string                `json:"os"`
+	Arch        string                `json:"arch"`
+	GeneratedAt time.Time             `json:"generated_at"`
+	State       telemetry.State       `json:"state"`
+	Telemetry   *model.AgentTelemetry `json:"telemetry"`
+	Config      map[string]string     `json:"config"`     // 生效的配置，令牌和代理密码已脱敏，不包含可能带有凭据的告警通知渠道和探测
+	Connection  []diagnosticsStep     `json:"connection"` // 与 check-connection 相同的连接检查结果
+}
+
+type diagnosticsStep struct {
+	Name       string   `json:"name"`
+	DurationMS float64  `json:"duration_ms"`
+	Skipped    bool     `json:"skipped,omitempty"`
+	Detail     []string `json:"detail,omitempty"`
+	Warnings   []string `json:"warnings,omitempty"`
+	Error      string   `json:"error,omitempty"`
+	Hint       string   `json:"hint,omitempty"`
+}
+
+// collectDiagnostics 收集运行状态、生效的配置和连接检查结果
+func collectDiagnostics(ctx context.Context) *diagnosticsBundle {
+	bundle := &diagnosticsBundle{
+		Version:     Version,
+		OS:          runtime.GOOS,
+		Arch:        runtime.GOARCH,
+		GeneratedAt: time.Now(),
+		State:       telemetry.Default.State(),
+		Telemetry:   telemetry.Default.Snapshot(),
+		Config:      make(map[string]string),
+	}
+
+	for _, key := range config.Keys {
+		if key.Name == "sinks" || key.Name == "probes" {
+			continue
+		}
+		value := formatConfigValue(viper.Get(key.Name))
+		switch {
+		case key.Secret:
+			value = utils.RedactSecret(value)
+		case key.Name == "proxy":
+			value = proxy.Redacted(value)
+		}
+		bundle.Config[key.Name] = value
+	}
+
+	httpReporter, err := reporter.NewHTTPReporter()
+	if err != nil {
+		bundle.Connection = append(bundle.Connection, diagnosticsStep{Name: "加载连接配置", Error: err.Error()})
+		return bundle
+	}
+	report := diagnose.Run(ctx, diagnose.Options{
+		ServerURL: config.ServerURL,
+		Tokens:    httpReporter.Tokens,
+		ProxyURL:  config.ProxyURL,
+		NoProxy:   config.NoProxy,
+		Timeout:   10 * time.Second,
+		Client:    httpReporter.Client,
+		TLSConfig: httpReporter.Client.Transport.(*http.Transport).TLSClientConfig,
+	})
+	for _, s := range report.Steps {
+		step := diagnosticsStep{
+			Name:       s.Name,
+			DurationMS: float64(s.Duration.Microseconds()) / 1000,
+			Skipped:    s.Skipped,
+			Detail:     s.Detail,
+			Warnings:   s.Warnings,
+			Hint:       s.Hint,
+		}
+		if s.Err != nil {
+			step.Error = s.Err.Error()
+		}
+		bundle.Connection = append(bundle.Connection, step)
+	}
+	return bundle
+}
@@ -0,0 +1,72 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/xugou/agent/pkg/model"
+	"github.com/xugou/agent/pkg/output"
+	"github.com/xugou/agent/pkg/probes"
+)
+
+func init() {
+	probeCmd := &cobra.Command{
+		Use:   "probe [名称...]",
+		Short: "执行一次配置文件中的探测并输出结果，不上报到服务器",
+		Long: `立即执行配置文件 probes 中的探测（默认全部，也可以指定名称）并在本地输出结果，不会连接服务器。
+可用于上线前确认探测配置和断言是否符合预期。有探测失败时以状态码 2 退出。`,
+		RunE:          runProbe,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+	probeCmd.Flags().StringP("output", "o", output.FormatTable, "输出格式: json、table 或 prometheus")
+
+	rootCmd.AddCommand(probeCmd)
+}
+
+func runProbe(cmd *cobra.Command, args []string) error {
+	loadRuntimeConfig()
+
+	format, _ := cmd.Flags().GetString("output")
+
+	configs, err := probes.ParseConfig(viper.Get("probes"))
+	if err != nil {
+		return fmt.Errorf("解析探测失败: %w", err)
+	}
+	probes.SetMaxCommands(viper.GetInt("nagios.max_concurrent"))
+	scheduler, err := probes.NewScheduler(configs)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	names := args
+	if len(names) == 0 {
+		names = []string{""}
+	}
+	var results []model.ProbeResult
+	for _, name := range names {
+		r, err := scheduler.RunNow(ctx, name)
+		if err != nil {
+			return err
+		}
+		results = append(results, r...)
+	}
+
+	if err := output.WriteProbes(cmd.OutOrStdout(), format, results); err != nil {
+		return err
+	}
+	for _, r := range results {
+		if r.Status == model.ProbeDown {
+			os.Exit(2)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,12 @@
+//go:build windows || plan9
+
+package agent
+
+import "errors"
+
+// canRestart 当前平台是否支持 restart 命令
+const canRestart = false
+
+func restartSelf() error {
+	return errors.New("当前平台不支持重启")
+}
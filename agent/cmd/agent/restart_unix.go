@@ -0,0 +1,21 @@
+//go:build !windows && !plan9
+
+package agent
+
+import (
+	"os"
+	"syscall"
+)
+
+// canRestart 当前平台是否支持 restart 命令
+const canRestart = true
+
+// restartSelf 用相同的参数和环境变量重新执行当前程序。进程 ID 不变，systemd 等服务管理器不会认为服务退出；
+// 程序文件被更新替换后会执行新的版本
+func restartSelf() error {
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	return syscall.Exec(self, os.Args, os.Environ())
+}
@@ -0,0 +1,159 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/xugou/agent/pkg/config"
+	"github.com/xugou/agent/pkg/service"
+)
+
+func init() {
+	defaults := service.DefaultOptions()
+
+	serviceCmd := &cobra.Command{
+		Use:   "service",
+		Short: "管理系统服务",
+		Long:  `把 Xugou Agent 安装为 systemd、OpenRC 或 SysV 服务，并管理服务的启停和状态`,
+	}
+	flags := serviceCmd.PersistentFlags()
+	flags.String("name", defaults.Name, "服务名称")
+	flags.String("init", service.InitAuto, "初始化系统: auto、systemd、openrc 或 sysv")
+	flags.String("root", defaults.Root, "安装根目录，指定 / 以外的目录时只写入文件，不执行系统命令")
+	flags.String("bin", defaults.BinaryPath, "二进制文件安装路径")
+	flags.String("config-path", defaults.ConfigPath, "服务使用的配置文件路径")
+	flags.String("user", defaults.User, "运行服务的用户，留空则以 root 运行")
+
+	installCmd := &cobra.Command{
+		Use:   "install",
+		Short: "安装并启动服务",
+		Long: `创建运行用户、安装二进制文件、写入配置文件（权限 0600）和服务文件，然后启用并启动服务。
+服务器地址、令牌等配置取自命令行参数、环境变量或 --config 指定的配置文件，只写入显式设置的配置项，令牌不会出现在服务文件中。
+服务使用的配置文件已存在时保留原有配置，使用 --overwrite-config 覆盖。`,
+		RunE:          runServiceInstall,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+	installCmd.Flags().Bool("no-start", false, "安装后不启动服务")
+	installCmd.Flags().Bool("auto-update", false, "安装每天运行一次的自动更新任务（systemd 定时器或 cron.daily）")
+	installCmd.Flags().Bool("overwrite-config", false, "覆盖已存在的服务配置文件")
+
+	uninstallCmd := &cobra.Command{
+		Use:           "uninstall",
+		Short:         "停止并卸载服务",
+		RunE:          runServiceUninstall,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+	uninstallCmd.Flags().Bool("purge", false, "同时删除配置文件和二进制文件")
+
+	statusCmd := &cobra.Command{
+		Use:           "status",
+		Short:         "查看服务状态",
+		RunE:          runServiceStatus,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+
+	serviceCmd.AddCommand(installCmd, uninstallCmd, statusCmd)
+	for _, action := range []string{"start", "stop", "restart"} {
+		action := action
+		serviceCmd.AddCommand(&cobra.Command{
+			Use:   action,
+			Short: action + " 服务",
+			RunE: func(cmd *cobra.Command, args []string) error {
+				return service.Control(serviceOptions(cmd), action)
+			},
+			SilenceUsage:  true,
+			SilenceErrors: true,
+		})
+	}
+	rootCmd.AddCommand(serviceCmd)
+}
+
+// serviceOptions 根据命令行参数构造服务安装参数
+func serviceOptions(cmd *cobra.Command) service.Options {
+	opts := service.DefaultOptions()
+	opts.Name, _ = cmd.Flags().GetString("name")
+	opts.InitSystem, _ = cmd.Flags().GetString("init")
+	opts.Root, _ = cmd.Flags().GetString("root")
+	opts.BinaryPath, _ = cmd.Flags().GetString("bin")
+	opts.ConfigPath, _ = cmd.Flags().GetString("config-path")
+	opts.User, _ = cmd.Flags().GetString("user")
+	opts.Out = cmd.OutOrStdout()
+	return opts
+}
+
+func runServiceInstall(cmd *cobra.Command, args []string) error {
+	loadRuntimeConfig()
+	opts := serviceOptions(cmd)
+	opts.Overwrite, _ = cmd.Flags().GetBool("overwrite-config")
+	opts.NoStart, _ = cmd.Flags().GetBool("no-start")
+	opts.AutoUpdate, _ = cmd.Flags().GetBool("auto-update")
+
+	// 已有的配置文件会被保留，只有需要写入配置文件时才要求服务器地址和令牌
+	if opts.Overwrite || !opts.ConfigExists() {
+		if config.ServerURL == "" || (config.Token == "" && config.TokenFile == "") {
+			return errors.New("安装服务需要服务器地址和令牌，请使用 --server 和 --token-file 参数或在配置文件中设置")
+		}
+
+		// 只写入通过命令行参数、环境变量或配置文件显式设置的配置项，默认值不写入，以后随新版本的默认值变化
+		values := make(map[string]interface{})
+		for _, key := range config.Keys {
+			if explicitlySet(cmd, key.Name) {
+				values[key.Name] = viper.Get(key.Name)
+			}
+		}
+		if _, ok := values["state_dir"]; !ok {
+			values["state_dir"] = opts.StateDir
+		}
+		opts.Config = []byte(config.RenderTemplate(values))
+	}
+
+	if err := service.Install(opts); err != nil {
+		return err
+	}
+	fmt.Fprintln(cmd.OutOrStdout(), "服务安装完成")
+	return nil
+}
+
+func runServiceUninstall(cmd *cobra.Command, args []string) error {
+	opts := serviceOptions(cmd)
+	opts.Purge, _ = cmd.Flags().GetBool("purge")
+
+	if err := service.Uninstall(opts); err != nil {
+		return err
+	}
+	fmt.Fprintln(cmd.OutOrStdout(), "服务卸载完成")
+	return nil
+}
+
+func runServiceStatus(cmd *cobra.Command, args []string) error {
+	status, err := service.Status(serviceOptions(cmd))
+	if err != nil {
+		return err
+	}
+
+	yesNo := func(b bool) string {
+		if b {
+			return "是"
+		}
+		return "否"
+	}
+	out := cmd.OutOrStdout()
+	fmt.Fprintf(out, "初始化系统: %s\n", status.InitSystem)
+	fmt.Fprintf(out, "服务文件:   %s\n", status.ServiceFile)
+	fmt.Fprintf(out, "已安装:     %s\n", yesNo(status.Installed))
+	fmt.Fprintf(out, "开机启动:   %s\n", yesNo(status.Enabled))
+	fmt.Fprintf(out, "正在运行:   %s\n", yesNo(status.Active))
+	if status.Detail != "" {
+		fmt.Fprintf(out, "\n%s\n", status.Detail)
+	}
+	if !status.Active {
+		return errors.New("服务未运行")
+	}
+	return nil
+}
@@ -3,8 +3,10 @@ package agent
 import (
 	"context"
 	"fmt"
+	"log/slog"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 
@@ -12,7 +14,18 @@ import (
 	"github.com/spf13/viper"
 	"github.com/xugou/agent/pkg/collector"
 	"github.com/xugou/agent/pkg/config"
+	"github.com/xugou/agent/pkg/localserver"
+	"github.com/xugou/agent/pkg/model"
+	"github.com/xugou/agent/pkg/probes"
+	"github.com/xugou/agent/pkg/proxy"
+	"github.com/xugou/agent/pkg/remoteconfig"
 	"github.com/xugou/agent/pkg/reporter"
+	"github.com/xugou/agent/pkg/rules"
+	"github.com/xugou/agent/pkg/sinks"
+	"github.com/xugou/agent/pkg/statsd"
+	"github.com/xugou/agent/pkg/telemetry"
+	"github.com/xugou/agent/pkg/updater"
+	"github.com/xugou/agent/pkg/useragent"
 )
 
 func init() {
@@ -25,43 +38,176 @@ func init() {
 	rootCmd.AddCommand(startCmd)
 }
 
+// shutdownTimeout 停止时等待后台任务退出的最长时间
+const shutdownTimeout = 10 * time.Second
+
 func runStart(cmd *cobra.Command, args []string) {
+	if !runAgent() {
+		return
+	}
+	// 所有后台任务已经停止，延迟执行的清理也已完成，此时重新执行程序不会丢失数据或占用端口
+	slog.Info("收到重启命令，正在重启...")
+	if err := restartSelf(); err != nil {
+		slog.Error("重启失败", "error", err)
+		os.Exit(1)
+	}
+}
 
-	config.ServerURL = viper.GetString("server")
-	config.Token = viper.GetString("token")
-	config.Interval = viper.GetInt("interval")
-	config.ProxyURL = viper.GetString("proxy")
+// runAgent 运行 Agent 直到收到停止信号或重启命令，收到重启命令时返回 true
+func runAgent() bool {
+	loadRuntimeConfig()
 	// 检查必要的配置
 
-	if config.Token == "" {
-		fmt.Println("错误: 未设置 API 令牌，请使用 --token 参数或在配置文件中设置")
+	if config.Token == "" && config.TokenFile == "" {
+		slog.Error("未设置 API 令牌，请使用 --token-file 参数、XUGOU_TOKEN 环境变量或在配置文件中设置")
 		os.Exit(1)
 	}
 
 	if config.ServerURL == "" {
-		fmt.Println("错误: 未设置服务器地址，请使用 --server 参数或在配置文件中设置")
+		slog.Error("未设置服务器地址，请使用 --server 参数或在配置文件中设置")
 		os.Exit(1)
 	}
 
-	fmt.Println("Xugou Agent 启动中...")
-	fmt.Printf("服务器地址: %s\n", config.ServerURL)
-	fmt.Printf("上报数据间隔: %d秒\n", config.Interval)
+	slog.Info("Xugou Agent 启动中...", "version", Version, "server", config.ServerURL, "interval", config.Current().Interval)
 	if config.ProxyURL != "" {
-		fmt.Printf("使用代理服务器: %s\n", config.ProxyURL)
+		slog.Info("使用代理服务器", "proxy", proxy.Redacted(config.ProxyURL))
 	}
-	fmt.Println("使用令牌自动注册/上报数据")
 
 	// 设置上下文，用于处理取消信号
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	// 后台任务在 ctx 取消后退出，停止或重启前等待它们完成
+	var workers sync.WaitGroup
+	spawn := func(f func()) {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			f()
+		}()
+	}
+
 	// 初始化数据收集器和上报器
 	dataCollector := collector.NewCollector()
-	dataReporter := reporter.NewReporter()
-	fmt.Println("使用HTTP上报器")
+	dataReporter, err := reporter.NewReporter()
+	if err != nil {
+		slog.Error("初始化上报器失败", "error", err)
+		os.Exit(1)
+	}
+	telemetry.Default.SetVersion(Version)
+
+	// 本地探测在后台按各自的间隔运行，结果随下一次上报发送
+	probeConfigs, err := probes.ParseConfig(viper.Get("probes"))
+	if err != nil {
+		slog.Error("解析探测失败", "error", err)
+		os.Exit(1)
+	}
+	probes.SetMaxCommands(viper.GetInt("nagios.max_concurrent"))
+	scheduler, err := probes.NewScheduler(probeConfigs)
+	if err != nil {
+		slog.Error("创建探测失败", "error", err)
+		os.Exit(1)
+	}
+	probeRules, err := probes.AlertRules(probeConfigs)
+	if err != nil {
+		slog.Error("创建证书到期告警规则失败", "error", err)
+		os.Exit(1)
+	}
+
+	alertRules, err := rules.ParseConfig(viper.Get("rules"))
+	if err != nil {
+		slog.Error("解析告警规则失败", "error", err)
+		os.Exit(1)
+	}
+	sinkConfigs, err := sinks.ParseConfig(viper.Get("sinks"))
+	if err != nil {
+		slog.Error("解析告警通知渠道失败", "error", err)
+		os.Exit(1)
+	}
+	dispatcher, err := sinks.NewDispatcher(sinkConfigs)
+	if err != nil {
+		slog.Error("创建告警通知渠道失败", "error", err)
+		os.Exit(1)
+	}
+	dispatcher.OnError = func(sink string, err error) {
+		telemetry.Default.RecordError("sink "+sink, err)
+	}
+	alerts := &alerting{engine: rules.NewEngine(nil), dispatcher: dispatcher, probes: scheduler, probeRules: probeRules}
+	if err := alerts.setRules(alertRules); err != nil {
+		slog.Error("解析告警规则失败", "error", err)
+		os.Exit(1)
+	}
+	if n := len(alerts.engine.Rules()); n > 0 {
+		slog.Info("已加载本地告警规则", "rules", n, "sinks", dispatcher.Len())
+	}
+	if scheduler.Len() > 0 {
+		slog.Info("已加载本地探测", "probes", scheduler.Len())
+		useragent.AddCapability("probes")
+		scheduler.Start(ctx)
+	}
+
+	// 按需启动 StatsD 监听器，指标按上报间隔聚合后随数据上报
+	statsdServer, err := newStatsdServer()
+	if err != nil {
+		slog.Error("创建 StatsD 监听器失败", "error", err)
+		os.Exit(1)
+	}
+	if statsdServer.Enabled() {
+		if err := statsdServer.Start(); err != nil {
+			slog.Error("启动 StatsD 监听器失败", "error", err)
+			os.Exit(1)
+		}
+		defer statsdServer.Close()
+		slog.Info("StatsD 监听器已启动", "listen", viper.GetStringSlice("statsd.listen"))
+		useragent.AddCapability("statsd")
+	}
+
+	// 启用远程配置时先应用保存的远程配置，再在后台定期拉取
+	reload := make(chan struct{}, 1)
+	var watcher *remoteconfig.Watcher
+	if viper.GetBool("remote_config.enabled") {
+		watcher, err = newRemoteConfigWatcher(dataReporter, alerts, reload)
+		if err != nil {
+			slog.Error("初始化远程配置失败", "error", err)
+			os.Exit(1)
+		}
+		useragent.AddCapability("remote-config")
+		watcher.LoadSaved()
+		spawn(func() { watcher.Run(ctx) })
+	}
+
+	// 按需连接控制通道，接收服务器下发的命令
+	restart := make(chan struct{}, 1)
+	if viper.GetBool("control.enabled") {
+		collectNow := func(ctx context.Context) error {
+			return collectAndReportBatch(ctx, dataCollector, dataReporter, alerts, scheduler, statsdServer)
+		}
+		client, err := newControlClient(dataReporter, collectNow, watcher, scheduler, restart)
+		if err != nil {
+			slog.Error("初始化控制通道失败", "error", err)
+			os.Exit(1)
+		}
+		useragent.AddCapability("control")
+		spawn(func() { client.Run(ctx) })
+	}
+
+	// 按需启动本地 HTTP 服务
+	if listen := viper.GetString("local_server.listen"); listen != "" {
+		server := newLocalServer(listen, alerts.engine, scheduler)
+		if err := server.Start(); err != nil {
+			slog.Error("启动本地服务失败", "error", err)
+			os.Exit(1)
+		}
+		defer func() {
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			server.Shutdown(shutdownCtx)
+		}()
+	}
 
 	// 设置定时器，按指定间隔上报数据
-	ticker := time.NewTicker(time.Duration(config.Interval) * time.Second)
+	interval := currentInterval()
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
 	// 设置信号处理，用于优雅退出
@@ -69,54 +215,251 @@ func runStart(cmd *cobra.Command, args []string) {
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 
 	// 启动时立即执行一次收集和上报
-	go collectAndReport(ctx, dataCollector, dataReporter)
+	telemetry.Default.Tick()
+	spawn(func() { collectAndReport(ctx, dataCollector, dataReporter, alerts, scheduler, statsdServer) })
 
-	fmt.Println("Xugou Agent 已启动，按 Ctrl+C 停止")
+	slog.Info("Xugou Agent 已启动，按 Ctrl+C 停止")
 
 	// 主循环
 	for {
 		select {
 		case <-ticker.C:
-			go collectAndReportBatch(ctx, dataCollector, dataReporter)
+			telemetry.Default.Tick()
+			spawn(func() { collectAndReportBatch(ctx, dataCollector, dataReporter, alerts, scheduler, statsdServer) })
+		case <-reload:
+			// 远程配置修改了采集间隔
+			if d := currentInterval(); d != interval {
+				slog.Info("采集间隔已更新", "from", interval, "to", d)
+				interval = d
+				ticker.Reset(d)
+			}
 		case sig := <-sigCh:
-			fmt.Printf("收到信号 %v，正在停止...\n", sig)
-			return
+			slog.Info("收到信号，正在停止...", "signal", sig.String())
+			shutdown(cancel, &workers, scheduler)
+			return false
+		case <-restart:
+			shutdown(cancel, &workers, scheduler)
+			return true
 		}
 	}
 }
 
+// shutdown 取消所有后台任务并等待它们退出，最多等待 shutdownTimeout
+func shutdown(cancel context.CancelFunc, workers *sync.WaitGroup, scheduler *probes.Scheduler) {
+	cancel()
+	done := make(chan struct{})
+	go func() {
+		workers.Wait()
+		scheduler.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(shutdownTimeout):
+		slog.Warn("等待后台任务退出超时", "timeout", shutdownTimeout)
+	}
+}
+
 // collectAndReport 收集并上报系统信息
-func collectAndReport(ctx context.Context, c collector.Collector, r reporter.Reporter) {
+func collectAndReport(ctx context.Context, c collector.Collector, r reporter.Reporter, a *alerting, p *probes.Scheduler, s *statsd.Server) {
+	telemetry.Default.InFlightAdd(1)
+	defer telemetry.Default.InFlightAdd(-1)
+
 	// 收集系统信息
 	info, err := c.Collect(ctx)
 	if err != nil {
-		fmt.Printf("采集系统信息失败: %v\n", err)
+		slog.Error("采集系统信息失败", "error", err)
+		telemetry.Default.RecordError("collect", err)
 		return
 	}
+	info.Statsd = s.Flush()
+	a.evaluate(ctx, info)
+	info.Agent = telemetry.Default.Snapshot()
+	info.Probes = p.Drain()
 
 	// 上报系统信息
 	err = r.Report(ctx, info)
 	if err != nil {
-		fmt.Printf("上报系统信息失败: %v\n", err)
+		slog.Error("上报系统信息失败", "error", err)
+		p.Requeue(info.Probes)
+		telemetry.Default.AddDropped(1)
+		telemetry.Default.RecordError("report", err)
 		return
 	}
 
-	fmt.Printf("系统信息已收集并上报，时间: %s\n", time.Now().Format("2006-01-02 15:04:05"))
+	slog.Info("系统信息已收集并上报")
+	onReported()
 }
 
-func collectAndReportBatch(ctx context.Context, c collector.Collector, r reporter.Reporter) {
+// collectAndReportBatch 批量收集并上报系统信息，返回的错误已经记录过日志
+func collectAndReportBatch(ctx context.Context, c collector.Collector, r reporter.Reporter, a *alerting, p *probes.Scheduler, s *statsd.Server) error {
+	telemetry.Default.InFlightAdd(1)
+	defer telemetry.Default.InFlightAdd(-1)
+
 	infoList, err := c.CollectBatch(ctx)
 	if err != nil {
-		fmt.Printf("采集系统信息失败: %v\n", err)
-		return
+		slog.Error("采集系统信息失败", "error", err)
+		telemetry.Default.RecordError("collect", err)
+		return err
 	}
 
-	fmt.Printf("采集到 %d 条系统信息\n", len(infoList))
+	slog.Debug("采集到系统信息", "count", len(infoList))
+	// StatsD 聚合结果、运行指标和探测结果只附加在最后一条数据上
+	last := infoList[len(infoList)-1]
+	last.Statsd = s.Flush()
+	for _, info := range infoList {
+		a.evaluate(ctx, info)
+	}
+	last.Agent = telemetry.Default.Snapshot()
+	last.Probes = p.Drain()
 
 	err = r.ReportBatch(ctx, infoList)
 	if err != nil {
-		fmt.Printf("上报系统信息失败: %v\n", err)
+		slog.Error("上报系统信息失败", "error", err)
+		p.Requeue(last.Probes)
+		telemetry.Default.AddDropped(len(infoList))
+		telemetry.Default.RecordError("report", err)
+		return err
+	}
+	slog.Info("系统信息已收集并上报")
+	onReported()
+	return nil
+}
+
+// alerting 本地告警规则和通知渠道
+type alerting struct {
+	engine     *rules.Engine
+	dispatcher *sinks.Dispatcher
+	probes     *probes.Scheduler
+	probeRules []*rules.Rule // 根据证书检查生成的到期告警规则
+}
+
+// setRules 设置用户配置的告警规则，证书到期告警规则会追加在后面
+func (a *alerting) setRules(userRules []*rules.Rule) error {
+	all := append([]*rules.Rule{}, userRules...)
+	names := make(map[string]bool)
+	for _, rule := range userRules {
+		names[rule.Name] = true
+	}
+	for _, rule := range a.probeRules {
+		if names[rule.Name] {
+			return fmt.Errorf("规则名称 %s 与自动生成的证书到期告警规则重复", rule.Name)
+		}
+		all = append(all, rule)
+	}
+	a.engine.SetRules(all)
+	return nil
+}
+
+// evaluate 用采集到的数据和最近的探测结果计算本地告警规则，把产生的告警事件附加到上报数据中并发送到通知渠道
+func (a *alerting) evaluate(ctx context.Context, info *model.SystemInfo) {
+	view := *info
+	view.Probes = a.probes.Latest()
+	events, err := a.engine.Evaluate(&view, info.Timestamp)
+	if err != nil {
+		slog.Error("计算告警规则失败", "error", err)
+		telemetry.Default.RecordError("rules", err)
 		return
 	}
-	fmt.Printf("系统信息已收集并上报，时间: %s\n", time.Now().Format("2006-01-02 15:04:05"))
+	for _, event := range events {
+		attrs := []any{"rule", event.Rule, "instance", event.Instance, "severity", event.Severity, "value", event.Value, "threshold", event.Threshold}
+		if event.State == model.AlertFiring {
+			slog.Warn("告警触发", attrs...)
+		} else {
+			slog.Info("告警恢复", attrs...)
+		}
+	}
+	info.Alerts = events
+	a.dispatcher.Notify(ctx, info.Hostname, events)
+}
+
+// newLocalServer 创建本地 HTTP 服务并注册所有接口
+func newLocalServer(listen string, e *rules.Engine, p *probes.Scheduler) *localserver.Server {
+	server := localserver.New(listen)
+	server.RegisterAgentHandlers(localserver.AgentOptions{
+		Recorder:       telemetry.Default,
+		Version:        Version,
+		ServerURL:      config.ServerURL,
+		ConfigDigest:   config.Digest(viper.AllSettings()),
+		Interval:       currentInterval,
+		ReadyIntervals: viper.GetInt("local_server.ready_intervals"),
+		Pprof:          viper.GetBool("local_server.pprof"),
+		Alerts:         e.Firing,
+		Probes:         p.Latest,
+	})
+	return server
+}
+
+// newStatsdServer 根据配置创建 StatsD 监听器，没有设置 statsd.listen 时不启用
+func newStatsdServer() (*statsd.Server, error) {
+	percentiles, err := statsd.ParsePercentiles(viper.Get("statsd.percentiles"))
+	if err != nil {
+		return nil, fmt.Errorf("statsd.percentiles: %w", err)
+	}
+	buckets, err := statsd.ParseBuckets(viper.Get("statsd.buckets"))
+	if err != nil {
+		return nil, fmt.Errorf("statsd.buckets: %w", err)
+	}
+	return statsd.New(statsd.Options{
+		Listen:      viper.GetStringSlice("statsd.listen"),
+		Percentiles: percentiles,
+		Buckets:     buckets,
+		MaxSeries:   viper.GetInt("statsd.max_series"),
+	})
+}
+
+// currentInterval 返回当前生效的采集和上报间隔
+func currentInterval() time.Duration {
+	// 命令行参数和环境变量没有经过配置校验，间隔不合法时按 1 秒处理，避免定时器 panic
+	return time.Duration(max(config.Current().Interval, 1)) * time.Second
+}
+
+// newRemoteConfigWatcher 创建远程配置的拉取器，远程配置生效后更新运行时设置和告警规则，并通知主循环重新设置定时器
+func newRemoteConfigWatcher(r reporter.Reporter, a *alerting, reload chan<- struct{}) (*remoteconfig.Watcher, error) {
+	fetcher, ok := r.(reporter.ConfigFetcher)
+	if !ok {
+		return nil, fmt.Errorf("上报器不支持远程配置")
+	}
+	interval, err := time.ParseDuration(viper.GetString("remote_config.interval"))
+	if err != nil {
+		return nil, fmt.Errorf("remote_config.interval 无效: %w", err)
+	}
+
+	return &remoteconfig.Watcher{
+		Fetcher:  fetcher,
+		StateDir: config.StateDir,
+		Interval: interval,
+		Apply: func(remote *model.RemoteConfig) error {
+			settings := mergeSettings(remote.Config)
+			alertRules, err := rules.ParseConfig(settings.Get("rules"))
+			if err != nil {
+				return fmt.Errorf("解析告警规则失败: %w", err)
+			}
+			if err := a.setRules(alertRules); err != nil {
+				return err
+			}
+			config.SetCurrent(newRuntime(settings, remote.Version))
+			telemetry.Default.SetConfigVersion(remote.Version)
+
+			select {
+			case reload <- struct{}{}:
+			default:
+			}
+			return nil
+		},
+	}, nil
+}
+
+var commitUpdateOnce sync.Once
+
+// onReported 在每次成功上报后调用
+func onReported() {
+	telemetry.Default.ReportSucceeded()
+
+	// 第一次成功上报说明新版本运行正常，确认之前的更新，避免更新程序回滚
+	commitUpdateOnce.Do(func() {
+		if err := updater.CommitPending(config.StateDir); err != nil {
+			slog.Warn("确认更新失败", "error", err)
+		}
+	})
 }
@@ -0,0 +1,199 @@
+package agent
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/xugou/agent/pkg/config"
+	"github.com/xugou/agent/pkg/reporter"
+	"github.com/xugou/agent/pkg/service"
+	"github.com/xugou/agent/pkg/tlsconfig"
+	"github.com/xugou/agent/pkg/updater"
+)
+
+func init() {
+	updateCmd := &cobra.Command{
+		Use:   "update",
+		Short: "更新 Xugou Agent 到最新版本",
+		Long: `获取发布清单，下载适用于当前平台的二进制文件，校验 SHA-256 和内置公钥的 ed25519 签名后原子替换当前文件。
+如果 Agent 以系统服务运行，会重启服务并等待新版本成功上报数据；新版本在超时时间内没有成功上报则自动回滚。`,
+		RunE:          runUpdate,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+	updateCmd.Flags().String("manifest-url", "", "发布清单地址 (默认使用配置项 update.url)")
+	updateCmd.Flags().String("bin", "", "需要更新的二进制文件路径 (默认为当前程序)")
+	updateCmd.Flags().String("service-name", service.DefaultOptions().Name, "更新后需要重启的服务名称")
+	updateCmd.Flags().Duration("health-timeout", 2*time.Minute, "等待新版本成功上报的时间，超时后回滚")
+	updateCmd.Flags().Bool("check", false, "只检查是否有新版本，不更新")
+	updateCmd.Flags().Bool("force", false, "即使版本相同也重新安装")
+	updateCmd.Flags().BoolP("yes", "y", false, "不询问确认，直接更新")
+	updateCmd.Flags().Bool("rollback", false, "恢复上一次更新前的版本")
+
+	rootCmd.AddCommand(updateCmd)
+}
+
+func runUpdate(cmd *cobra.Command, args []string) error {
+	loadRuntimeConfig()
+	out := cmd.OutOrStdout()
+
+	manifestURL, _ := cmd.Flags().GetString("manifest-url")
+	if manifestURL == "" {
+		manifestURL = config.UpdateURL
+	}
+	binaryPath, _ := cmd.Flags().GetString("bin")
+	if binaryPath == "" {
+		var err error
+		if binaryPath, err = updater.DefaultBinaryPath(); err != nil {
+			return fmt.Errorf("获取当前程序路径失败: %w", err)
+		}
+	}
+	serviceName, _ := cmd.Flags().GetString("service-name")
+	healthTimeout, _ := cmd.Flags().GetDuration("health-timeout")
+	checkOnly, _ := cmd.Flags().GetBool("check")
+	force, _ := cmd.Flags().GetBool("force")
+	yes, _ := cmd.Flags().GetBool("yes")
+	rollback, _ := cmd.Flags().GetBool("rollback")
+
+	svc := service.DefaultOptions()
+	svc.Name = serviceName
+	svc.Out = out
+
+	if rollback {
+		return rollbackUpdate(cmd, binaryPath, svc)
+	}
+
+	// 发布清单和二进制文件不在监控服务器上，只使用代理设置，不使用服务器的 CA、客户端证书和指纹
+	transport, err := reporter.NewTransport(tlsconfig.Options{})
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Transport: transport, Timeout: 5 * time.Minute}
+
+	u, err := updater.New(manifestURL, updater.PublicKey, binaryPath, config.StateDir, Version, client)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	manifest, artifact, newer, err := u.Check(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "当前版本: %s，最新版本: %s\n", Version, manifest.Version)
+	if updater.CompareVersions(manifest.Version, Version) < 0 {
+		return fmt.Errorf("发布清单中的版本 %s 比当前版本 %s 旧，拒绝降级", manifest.Version, Version)
+	}
+	if !newer && !force {
+		fmt.Fprintln(out, "已是最新版本")
+		return nil
+	}
+	if checkOnly {
+		fmt.Fprintln(out, "有可用的新版本，使用 xugou-agent update 进行更新")
+		return nil
+	}
+
+	if !yes && !confirm(cmd, fmt.Sprintf("更新到 %s 并替换 %s？[y/N] ", manifest.Version, binaryPath)) {
+		return errors.New("已取消更新")
+	}
+
+	fmt.Fprintf(out, "下载 %s/%s 的二进制文件...\n", artifact.OS, artifact.Arch)
+	staged, err := u.Download(ctx, manifest.Version, artifact)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(out, "SHA-256 和签名校验通过")
+
+	if err := updater.Preflight(ctx, staged); err != nil {
+		os.Remove(staged)
+		return err
+	}
+
+	if err := u.Swap(staged, manifest.Version); err != nil {
+		os.Remove(staged)
+		return err
+	}
+	fmt.Fprintf(out, "已替换 %s，旧版本备份为 %s.old\n", binaryPath, binaryPath)
+
+	status, err := service.Status(svc)
+	if err != nil || !status.Installed {
+		// 不是以服务运行，无法自动重启和检查，直接确认更新并保留备份
+		if err := updater.CommitPending(config.StateDir); err != nil {
+			fmt.Fprintf(out, "警告: 清除更新记录失败: %v\n", err)
+		}
+		fmt.Fprintln(out, "更新完成，请重启 Agent 使新版本生效；如有问题可使用 xugou-agent update --rollback 回滚")
+		return nil
+	}
+
+	if err := service.Control(svc, "restart"); err != nil {
+		return restoreAfterFailure(cmd, svc, fmt.Errorf("重启服务失败: %w", err))
+	}
+
+	fmt.Fprintf(out, "等待新版本成功上报数据（最长 %s）...\n", healthTimeout)
+	if err := waitForCommit(config.StateDir, healthTimeout); err != nil {
+		return restoreAfterFailure(cmd, svc, err)
+	}
+
+	os.Remove(binaryPath + ".old")
+	fmt.Fprintf(out, "已更新到 %s\n", manifest.Version)
+	return nil
+}
+
+// waitForCommit 等待新版本确认更新（成功上报一次数据）
+func waitForCommit(stateDir string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		pending, err := updater.ReadPending(stateDir)
+		if err != nil {
+			return err
+		}
+		if pending == nil {
+			return nil
+		}
+		time.Sleep(2 * time.Second)
+	}
+	return fmt.Errorf("新版本在 %s 内没有成功上报数据", timeout)
+}
+
+// restoreAfterFailure 新版本健康检查失败时回滚到旧版本并重启服务
+func restoreAfterFailure(cmd *cobra.Command, svc service.Options, cause error) error {
+	pending, err := updater.ReadPending(config.StateDir)
+	if err != nil || pending == nil {
+		return fmt.Errorf("%v，且找不到更新记录，无法自动回滚", cause)
+	}
+	if err := updater.Rollback(config.StateDir, pending); err != nil {
+		return fmt.Errorf("%v，回滚失败: %v", cause, err)
+	}
+	if err := service.Control(svc, "restart"); err != nil {
+		return fmt.Errorf("%v，已回滚到 %s 但重启服务失败: %v", cause, pending.FromVersion, err)
+	}
+	return fmt.Errorf("%v，已回滚到 %s", cause, pending.FromVersion)
+}
+
+// rollbackUpdate 手动恢复上一次更新前的版本
+func rollbackUpdate(cmd *cobra.Command, binaryPath string, svc service.Options) error {
+	backup := binaryPath + ".old"
+	if err := updater.Rollback(config.StateDir, &updater.Pending{Binary: binaryPath, Backup: backup}); err != nil {
+		return err
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "已从 %s 恢复\n", backup)
+
+	if status, err := service.Status(svc); err == nil && status.Installed {
+		return service.Control(svc, "restart")
+	}
+	return nil
+}
+
+func confirm(cmd *cobra.Command, prompt string) bool {
+	fmt.Fprint(cmd.OutOrStdout(), prompt)
+	line, _ := bufio.NewReader(cmd.InOrStdin()).ReadString('\n')
+	answer := strings.ToLower(strings.TrimSpace(line))
+	return answer == "y" || answer == "yes"
+}
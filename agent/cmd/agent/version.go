@@ -4,6 +4,7 @@ import (
 	"fmt"
 
 	"github.com/spf13/cobra"
+	"github.com/xugou/agent/pkg/useragent"
 )
 
 var (
@@ -14,6 +15,9 @@ var (
 )
 
 func init() {
+	// 请求头中的 User-Agent 使用编译时写入的版本信息
+	useragent.Set(Version, GitCommit)
+
 	versionCmd := &cobra.Command{
 		Use:   "version",
 		Short: "显示版本信息",
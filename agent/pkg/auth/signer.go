@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 请求签名使用的头部，服务器按相同的方式计算签名并校验
+const (
+	HeaderTimestamp = "X-Xugou-Timestamp"      // 签名时间（Unix 秒）
+	HeaderNonce     = "X-Xugou-Nonce"          // 每个请求唯一的随机数，服务器在时间窗口内拒绝重复的值
+	HeaderDigest    = "X-Xugou-Content-SHA256" // 请求体的 SHA-256（十六进制）
+	HeaderSignature = "X-Xugou-Signature"      // HMAC-SHA256(令牌, StringToSign)（十六进制）
+
+	// 服务器拒绝签名时间时返回 X-Xugou-Error: clock_skew，并在 X-Xugou-Server-Time 或 Date 中给出服务器时间
+	HeaderError      = "X-Xugou-Error"
+	HeaderServerTime = "X-Xugou-Server-Time"
+	ErrorClockSkew   = "clock_skew"
+)
+
+// MaxSkew 服务器接受的签名时间与服务器时间的最大偏差
+const MaxSkew = 5 * time.Minute
+
+// Signer 为请求添加令牌和签名，并根据服务器返回的时间校正本机时间偏差
+type Signer struct {
+	tokens TokenSource
+
+	mu     sync.Mutex
+	offset time.Duration // 服务器时间减去本机时间
+}
+
+// NewSigner 创建签名器，每次签名时从 tokens 获取当前的令牌
+func NewSigner(tokens TokenSource) *Signer {
+	return &Signer{tokens: tokens}
+}
+
+// Sign 设置 Authorization 头并对请求签名，body 为请求体，没有请求体时传 nil
+func (s *Signer) Sign(req *http.Request, body []byte) error {
+	token := s.tokens.Token()
+	s.mu.Lock()
+	offset := s.offset
+	s.mu.Unlock()
+
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	sum := sha256.Sum256(body)
+	timestamp := strconv.FormatInt(time.Now().Add(offset).Unix(), 10)
+	digest := hex.EncodeToString(sum[:])
+	nonceText := hex.EncodeToString(nonce)
+
+	mac := hmac.New(sha256.New, []byte(token))
+	mac.Write([]byte(StringToSign(req.Method, req.URL.RequestURI(), timestamp, nonceText, digest)))
+
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderNonce, nonceText)
+	req.Header.Set(HeaderDigest, digest)
+	req.Header.Set(HeaderSignature, hex.EncodeToString(mac.Sum(nil)))
+	return nil
+}
+
+// StringToSign 返回参与签名的内容：方法、路径（含查询参数）、时间戳、随机数和请求体摘要，以换行分隔
+func StringToSign(method, path, timestamp, nonce, digest string) string {
+	return strings.Join([]string{strings.ToUpper(method), path, timestamp, nonce, digest}, "\n")
+}
+
+// CheckSkew 判断服务器是否因为时间偏差拒绝了请求，是则按服务器时间校正之后的签名，
+// 返回本机与服务器的时间差以及是否应该重新签名后重试
+func (s *Signer) CheckSkew(resp *http.Response) (time.Duration, bool) {
+	if resp.StatusCode != http.StatusUnauthorized && resp.StatusCode != http.StatusForbidden {
+		return 0, false
+	}
+	if !strings.EqualFold(resp.Header.Get(HeaderError), ErrorClockSkew) {
+		return 0, false
+	}
+
+	var serverTime time.Time
+	if v := resp.Header.Get(HeaderServerTime); v != "" {
+		sec, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return 0, false
+		}
+		serverTime = time.Unix(sec, 0)
+	} else if t, err := http.ParseTime(resp.Header.Get("Date")); err == nil {
+		serverTime = t
+	} else {
+		return 0, false
+	}
+
+	offset := time.Until(serverTime)
+	s.mu.Lock()
+	s.offset = offset
+	s.mu.Unlock()
+	return offset, true
+}
@@ -0,0 +1,214 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var bearerPattern = regexp.MustCompile(`(?i)^Bearer\s+(\S+)$`)
+
+// verifyRequest 按 backend/src/utils/agentAuth.ts 的方式校验请求：令牌来自 Authorization 头，
+// 签名内容为 方法\n路径和查询参数\n时间戳\n随机数\n请求体摘要，以令牌为密钥计算 HMAC-SHA256
+func verifyRequest(r *http.Request, now time.Time, usedNonces map[string]bool) (string, error) {
+	raw, err := io.ReadAll(r.Body)
+	if err != nil {
+		return "", err
+	}
+	match := bearerPattern.FindStringSubmatch(r.Header.Get("Authorization"))
+	if match == nil {
+		return "", errors.New("缺少API令牌")
+	}
+	token := match[1]
+
+	timestamp := r.Header.Get(HeaderTimestamp)
+	nonce := r.Header.Get(HeaderNonce)
+	digest := strings.ToLower(r.Header.Get(HeaderDigest))
+	signature := strings.ToLower(r.Header.Get(HeaderSignature))
+	if timestamp == "" || nonce == "" || digest == "" || signature == "" {
+		return "", errors.New("缺少请求签名")
+	}
+
+	signedAt, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || now.Unix()-signedAt > int64(MaxSkew/time.Second) || signedAt-now.Unix() > int64(MaxSkew/time.Second) {
+		return "", errors.New(ErrorClockSkew)
+	}
+
+	sum := sha256.Sum256(raw)
+	if digest != hex.EncodeToString(sum[:]) {
+		return "", errors.New("请求体摘要不匹配")
+	}
+
+	stringToSign := strings.Join([]string{strings.ToUpper(r.Method), r.URL.Path + querySuffix(r), timestamp, nonce, digest}, "\n")
+	mac := hmac.New(sha256.New, []byte(token))
+	mac.Write([]byte(stringToSign))
+	if !hmac.Equal([]byte(signature), []byte(hex.EncodeToString(mac.Sum(nil)))) {
+		return "", errors.New("请求签名无效")
+	}
+
+	if usedNonces[nonce] {
+		return "", errors.New("重复的请求")
+	}
+	usedNonces[nonce] = true
+	return token, nil
+}
+
+// querySuffix 对应 JavaScript 中 URL.search，有查询参数时以 ? 开头
+func querySuffix(r *http.Request) string {
+	if r.URL.RawQuery == "" {
+		return ""
+	}
+	return "?" + r.URL.RawQuery
+}
+
+func TestSignVerifiedByServer(t *testing.T) {
+	const token = "xugou_test_token.0123456789"
+	usedNonces := make(map[string]bool)
+	var tokens []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, err := verifyRequest(r, time.Now(), usedNonces)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		tokens = append(tokens, got)
+	}))
+	defer srv.Close()
+
+	signer := NewSigner(StaticToken(token))
+	send := func(method, path string, body []byte) (*http.Request, int) {
+		t.Helper()
+		req, err := http.NewRequest(method, srv.URL+path, bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := signer.Sign(req, body); err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusOK {
+			t.Logf("%s %s: %s", method, path, msg)
+		}
+		return req, resp.StatusCode
+	}
+
+	req, status := send(http.MethodPost, "/api/agents/status", []byte(`{"hostname":"web"}`))
+	if status != http.StatusOK {
+		t.Fatalf("POST status = %d", status)
+	}
+	if got := req.Header.Get("Authorization"); got != "Bearer "+token {
+		t.Errorf("Authorization = %q", got)
+	}
+	// 没有请求体时对空内容计算摘要，查询参数参与签名
+	if _, status := send(http.MethodGet, "/api/agents/config?since=1&x=a%20b", nil); status != http.StatusOK {
+		t.Fatalf("GET status = %d", status)
+	}
+	if len(tokens) != 2 || tokens[0] != token || tokens[1] != token {
+		t.Errorf("server saw tokens %v", tokens)
+	}
+
+	// 签名后修改请求体、路径或重放同一个请求都会被拒绝
+	body := []byte(`{"hostname":"web"}`)
+	tampered, _ := http.NewRequest(http.MethodPost, srv.URL+"/api/agents/status", nil)
+	if err := signer.Sign(tampered, body); err != nil {
+		t.Fatal(err)
+	}
+	for name, mutate := range map[string]func(r *http.Request){
+		"body": func(r *http.Request) { r.Body = io.NopCloser(strings.NewReader(`{"hostname":"db"}`)) },
+		"path": func(r *http.Request) { r.URL.Path = "/api/agents/register" },
+	} {
+		r := tampered.Clone(tampered.Context())
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		mutate(r)
+		if _, err := verifyRequest(r, time.Now(), usedNonces); err == nil {
+			t.Errorf("request with modified %s verified", name)
+		}
+	}
+	replay := tampered.Clone(tampered.Context())
+	replay.Body = io.NopCloser(bytes.NewReader(body))
+	if _, err := verifyRequest(replay, time.Now(), usedNonces); err != nil {
+		t.Fatal(err)
+	}
+	replay.Body = io.NopCloser(bytes.NewReader(body))
+	if _, err := verifyRequest(replay, time.Now(), usedNonces); err == nil {
+		t.Error("replayed request verified")
+	}
+}
+
+func TestSignUsesFreshNonce(t *testing.T) {
+	signer := NewSigner(StaticToken("secret-token"))
+	seen := make(map[string]bool)
+	for range 20 {
+		req := httptest.NewRequest(http.MethodPost, "/api/agents/status", nil)
+		if err := signer.Sign(req, []byte("{}")); err != nil {
+			t.Fatal(err)
+		}
+		nonce := req.Header.Get(HeaderNonce)
+		if len(nonce) != 32 || seen[nonce] {
+			t.Fatalf("nonce %q is not a fresh 16 byte value", nonce)
+		}
+		seen[nonce] = true
+	}
+}
+
+func TestStringToSign(t *testing.T) {
+	got := StringToSign("post", "/api/agents/status?x=1", "1700000000", "abcd", "e3b0")
+	if want := "POST\n/api/agents/status?x=1\n1700000000\nabcd\ne3b0"; got != want {
+		t.Errorf("StringToSign() = %q, want %q", got, want)
+	}
+}
+
+func TestCheckSkew(t *testing.T) {
+	serverTime := time.Now().Add(time.Hour).Truncate(time.Second)
+	tests := []struct {
+		name   string
+		status int
+		header http.Header
+		ok     bool
+	}{
+		{"server time header", http.StatusUnauthorized, http.Header{HeaderError: {ErrorClockSkew}, HeaderServerTime: {strconv.FormatInt(serverTime.Unix(), 10)}}, true},
+		{"date header", http.StatusForbidden, http.Header{HeaderError: {"CLOCK_SKEW"}, "Date": {serverTime.UTC().Format(http.TimeFormat)}}, true},
+		{"other error", http.StatusUnauthorized, http.Header{HeaderError: {"invalid_signature"}, HeaderServerTime: {"1700000000"}}, false},
+		{"success", http.StatusOK, http.Header{HeaderError: {ErrorClockSkew}, HeaderServerTime: {"1700000000"}}, false},
+		{"invalid server time", http.StatusUnauthorized, http.Header{HeaderError: {ErrorClockSkew}, HeaderServerTime: {"soon"}}, false},
+		{"no server time", http.StatusUnauthorized, http.Header{HeaderError: {ErrorClockSkew}}, false},
+	}
+	for _, tt := range tests {
+		signer := NewSigner(StaticToken("secret-token"))
+		offset, ok := signer.CheckSkew(&http.Response{StatusCode: tt.status, Header: tt.header})
+		if ok != tt.ok {
+			t.Errorf("%s: CheckSkew() = %v, want %v", tt.name, ok, tt.ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if offset < 59*time.Minute || offset > 61*time.Minute {
+			t.Errorf("%s: offset = %s, want about 1h", tt.name, offset)
+		}
+
+		// 之后的签名使用服务器时间，能通过服务器的时间校验
+		req := httptest.NewRequest(http.MethodGet, "/api/agents/config", nil)
+		if err := signer.Sign(req, nil); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := verifyRequest(req, serverTime, map[string]bool{}); err != nil {
+			t.Errorf("%s: request signed after CheckSkew rejected: %v", tt.name, err)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xugou/agent/pkg/logger"
+	"github.com/xugou/agent/pkg/utils"
+)
+
+// HeaderRotateToken 服务器在成功的响应中通过该头部下发新令牌，Agent 保存后在之后的请求中使用
+const HeaderRotateToken = "X-Xugou-Rotate-Token"
+
+// RotatedTokenFile 状态目录中保存轮换后令牌的文件
+const RotatedTokenFile = "token.json"
+
+// fileCheckInterval 检查令牌文件是否变化的最短间隔
+const fileCheckInterval = 10 * time.Second
+
+// TokenSource 提供签名使用的令牌
+type TokenSource interface {
+	Token() string
+}
+
+// StaticToken 固定不变的令牌
+type StaticToken string
+
+// Token 返回令牌
+func (t StaticToken) Token() string {
+	return string(t)
+}
+
+// rotatedToken 保存在状态目录中的轮换后令牌
+type rotatedToken struct {
+	Token     string    `json:"token"`
+	Origin    string    `json:"origin"` // 配置的令牌的 SHA-256，配置的令牌变化后不再使用保存的令牌
+	RotatedAt time.Time `json:"rotated_at"`
+}
+
+// Tokens 管理 Agent 使用的令牌。配置的令牌来自 token 配置项，未设置时读取 token_file 文件；
+// 服务器轮换令牌后，新令牌保存在状态目录中并优先于配置的令牌使用，直到配置的令牌发生变化
+type Tokens struct {
+	mu         sync.Mutex
+	static     string
+	file       string
+	stateDir   string
+	modTime    time.Time
+	lastCheck  time.Time
+	configured string
+	current    string
+}
+
+// NewTokens 加载令牌，设置了 token 时忽略 file
+func NewTokens(token, file, stateDir string) (*Tokens, error) {
+	t := &Tokens{static: strings.TrimSpace(token), file: file, stateDir: stateDir}
+	configured := t.static
+	if configured == "" && file != "" {
+		var err error
+		if configured, err = t.readFile(); err != nil {
+			return nil, err
+		}
+		t.lastCheck = time.Now()
+	}
+	t.setConfigured(configured)
+	return t, nil
+}
+
+// Token 返回当前使用的令牌，令牌文件变化后自动重新读取
+func (t *Tokens) Token() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.static == "" && t.file != "" && time.Since(t.lastCheck) >= fileCheckInterval {
+		t.lastCheck = time.Now()
+		if info, err := os.Stat(t.file); err == nil && !info.ModTime().Equal(t.modTime) {
+			token, err := t.readFile()
+			switch {
+			case err != nil:
+				slog.Error("重新读取令牌文件失败，继续使用之前的令牌", "error", err)
+			case token != t.configured:
+				t.setConfigured(token)
+				slog.Info("令牌文件已变化，已切换到新的令牌", "path", t.file)
+			}
+		}
+	}
+	return t.current
+}
+
+// Rotate 把服务器下发的新令牌原子地写入状态目录并立即切换，保存失败时继续使用原来的令牌
+func (t *Tokens) Rotate(token string) error {
+	token = strings.TrimSpace(token)
+	if token == "" || strings.ContainsAny(token, " \t\r\n") {
+		return errors.New("服务器下发的令牌格式无效")
+	}
+	logger.AddSecret(token)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if token == t.current {
+		return nil
+	}
+	if t.stateDir == "" {
+		return errors.New("未设置状态目录，无法保存新令牌")
+	}
+
+	data, err := json.Marshal(rotatedToken{Token: token, Origin: digest(t.configured), RotatedAt: time.Now()})
+	if err != nil {
+		return err
+	}
+	if err := utils.WriteFileAtomic(filepath.Join(t.stateDir, RotatedTokenFile), data, 0600); err != nil {
+		return err
+	}
+	t.current = token
+	return nil
+}
+
+// HandleResponse 检查成功的响应中是否有服务器下发的新令牌，有则保存并切换
+func (t *Tokens) HandleResponse(resp *http.Response) {
+	token := resp.Header.Get(HeaderRotateToken)
+	if token == "" || resp.StatusCode >= 300 {
+		return
+	}
+	if err := t.Rotate(token); err != nil {
+		slog.Error("保存服务器下发的新令牌失败，继续使用原来的令牌", "error", err)
+		return
+	}
+	slog.Info("服务器轮换了令牌，已保存并切换到新令牌")
+}
+
+// setConfigured 更新配置的令牌，状态目录中保存的令牌由该令牌轮换而来时使用保存的令牌
+func (t *Tokens) setConfigured(configured string) {
+	logger.AddSecret(configured)
+	t.configured = configured
+	t.current = configured
+
+	saved, err := t.loadRotated()
+	if err != nil {
+		slog.Warn("读取轮换后的令牌失败，使用配置的令牌", "error", err)
+		return
+	}
+	if saved != nil && saved.Origin == digest(configured) {
+		logger.AddSecret(saved.Token)
+		t.current = saved.Token
+	}
+}
+
+func (t *Tokens) loadRotated() (*rotatedToken, error) {
+	if t.stateDir == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(filepath.Join(t.stateDir, RotatedTokenFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var saved rotatedToken
+	if err := json.Unmarshal(data, &saved); err != nil || saved.Token == "" {
+		return nil, fmt.Errorf("%s 内容无效", RotatedTokenFile)
+	}
+	return &saved, nil
+}
+
+// readFile 读取令牌文件，忽略首尾空白
+func (t *Tokens) readFile() (string, error) {
+	info, err := os.Stat(t.file)
+	if err != nil {
+		return "", fmt.Errorf("读取令牌文件失败: %w", err)
+	}
+	data, err := os.ReadFile(t.file)
+	if err != nil {
+		return "", fmt.Errorf("读取令牌文件失败: %w", err)
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("令牌文件 %s 为空", t.file)
+	}
+	t.modTime = info.ModTime()
+	return token, nil
+}
+
+func digest(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,214 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeToken 写入令牌文件并设置修改时间
+func writeToken(t *testing.T, path, token string, mtime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(token+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTokensFromFile(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "token")
+	mtime := time.Now().Add(-time.Hour)
+	writeToken(t, file, "  first-token ", mtime)
+
+	tokens, err := NewTokens("", file, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := tokens.Token(); got != "first-token" {
+		t.Fatalf("Token() = %q, want first-token", got)
+	}
+
+	// 检查间隔内不重新读取文件
+	writeToken(t, file, "second-token", mtime.Add(time.Minute))
+	if got := tokens.Token(); got != "first-token" {
+		t.Errorf("Token() within the check interval = %q, want first-token", got)
+	}
+
+	// 超过检查间隔后修改时间变化，重新读取
+	tokens.lastCheck = time.Time{}
+	if got := tokens.Token(); got != "second-token" {
+		t.Fatalf("Token() after the mtime changed = %q, want second-token", got)
+	}
+
+	// 修改时间没有变化时不重新读取
+	tokens.lastCheck = time.Time{}
+	writeToken(t, file, "same-mtime", mtime.Add(time.Minute))
+	if got := tokens.Token(); got != "second-token" {
+		t.Errorf("Token() with an unchanged mtime = %q, want second-token", got)
+	}
+
+	// 文件被清空时继续使用之前的令牌
+	tokens.lastCheck = time.Time{}
+	writeToken(t, file, "", mtime.Add(2*time.Minute))
+	if got := tokens.Token(); got != "second-token" {
+		t.Errorf("Token() after the file was emptied = %q, want second-token", got)
+	}
+
+	tokens.lastCheck = time.Time{}
+	writeToken(t, file, "third-token", mtime.Add(3*time.Minute))
+	if got := tokens.Token(); got != "third-token" {
+		t.Errorf("Token() = %q, want third-token", got)
+	}
+
+	// 同时设置 token 时忽略文件
+	static, err := NewTokens("static-token", filepath.Join(dir, "missing"), dir)
+	if err != nil || static.Token() != "static-token" {
+		t.Errorf("NewTokens() with a static token = %v, %v", static, err)
+	}
+	if _, err := NewTokens("", filepath.Join(dir, "missing"), dir); err == nil {
+		t.Error("NewTokens() succeeded with a missing token file")
+	}
+}
+
+func TestRotate(t *testing.T) {
+	dir := t.TempDir()
+	tokens, err := NewTokens("configured-token", "", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tokens.Rotate("rotated-token"); err != nil {
+		t.Fatal(err)
+	}
+	if got := tokens.Token(); got != "rotated-token" {
+		t.Errorf("Token() = %q, want rotated-token", got)
+	}
+
+	// 令牌通过临时文件和重命名写入，只有当前用户可以读写，目录中不留下临时文件
+	path := filepath.Join(dir, RotatedTokenFile)
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("%s mode = %s, want 0600", RotatedTokenFile, info.Mode().Perm())
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil || len(entries) != 1 {
+		t.Errorf("state directory = %v, %v, want only %s", entries, err, RotatedTokenFile)
+	}
+	var saved rotatedToken
+	data, _ := os.ReadFile(path)
+	if err := json.Unmarshal(data, &saved); err != nil || saved.Token != "rotated-token" || saved.Origin != digest("configured-token") {
+		t.Errorf("saved token = %+v, %v", saved, err)
+	}
+
+	for _, invalid := range []string{"", "  ", "two words", "line\nbreak"} {
+		if err := tokens.Rotate(invalid); err == nil {
+			t.Errorf("Rotate(%q) succeeded", invalid)
+		}
+	}
+	if got := tokens.Token(); got != "rotated-token" {
+		t.Errorf("Token() after invalid rotations = %q, want rotated-token", got)
+	}
+
+	// 没有状态目录时无法保存，继续使用原来的令牌
+	noState, _ := NewTokens("configured-token", "", "")
+	if err := noState.Rotate("rotated-token"); err == nil || noState.Token() != "configured-token" {
+		t.Errorf("Rotate() without a state directory = %v, token %q", err, noState.Token())
+	}
+}
+
+func TestRotatedTokenSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	tokens, err := NewTokens("configured-token", "", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tokens.Rotate("rotated-token"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 配置的令牌不变时重启后继续使用轮换后的令牌
+	restarted, err := NewTokens("configured-token", "", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := restarted.Token(); got != "rotated-token" {
+		t.Errorf("Token() after restart = %q, want rotated-token", got)
+	}
+
+	// 配置了新的令牌后不再使用之前轮换的令牌
+	reconfigured, err := NewTokens("new-configured-token", "", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := reconfigured.Token(); got != "new-configured-token" {
+		t.Errorf("Token() after reconfiguring = %q, want new-configured-token", got)
+	}
+
+	// 令牌文件变化时同样按新配置的令牌判断
+	file := filepath.Join(t.TempDir(), "token")
+	writeToken(t, file, "configured-token", time.Now().Add(-time.Hour))
+	fromFile, err := NewTokens("", file, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fromFile.Token(); got != "rotated-token" {
+		t.Errorf("Token() from file = %q, want rotated-token", got)
+	}
+	fromFile.lastCheck = time.Time{}
+	writeToken(t, file, "replaced-token", time.Now())
+	if got := fromFile.Token(); got != "replaced-token" {
+		t.Errorf("Token() after replacing the file = %q, want replaced-token", got)
+	}
+
+	// 损坏的记录被忽略
+	if err := os.WriteFile(filepath.Join(dir, RotatedTokenFile), []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	broken, err := NewTokens("configured-token", "", dir)
+	if err != nil || broken.Token() != "configured-token" {
+		t.Errorf("NewTokens() with a broken record = %v, token %q", err, broken.Token())
+	}
+}
+
+func TestHandleResponse(t *testing.T) {
+	tests := []struct {
+		status int
+		header string
+		want   string
+	}{
+		{http.StatusOK, "rotated-token", "rotated-token"},
+		{http.StatusNoContent, "rotated-token", "rotated-token"},
+		{http.StatusOK, "", "configured-token"},
+		// 失败的响应中的令牌不可信，不切换
+		{http.StatusUnauthorized, "rotated-token", "configured-token"},
+		{http.StatusInternalServerError, "rotated-token", "configured-token"},
+		{http.StatusFound, "rotated-token", "configured-token"},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		tokens, err := NewTokens("configured-token", "", dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp := &http.Response{StatusCode: tt.status, Header: http.Header{}}
+		if tt.header != "" {
+			resp.Header.Set(HeaderRotateToken, tt.header)
+		}
+		tokens.HandleResponse(resp)
+		if got := tokens.Token(); got != tt.want {
+			t.Errorf("status %d: Token() = %q, want %q", tt.status, got, tt.want)
+		}
+		_, err = os.Stat(filepath.Join(dir, RotatedTokenFile))
+		if saved := err == nil; saved != (tt.want == "rotated-token") {
+			t.Errorf("status %d: token saved = %v", tt.status, saved)
+		}
+	}
+}
@@ -6,8 +6,6 @@ import (
 	"runtime"
 	"time"
 
-	"github.com/spf13/viper"
-
 	"github.com/shirou/gopsutil/v3/cpu"
 	"github.com/shirou/gopsutil/v3/disk"
 	"github.com/shirou/gopsutil/v3/host"
@@ -16,13 +14,16 @@ import (
 	"github.com/shirou/gopsutil/v3/net"
 	"github.com/xugou/agent/pkg/config"
 	"github.com/xugou/agent/pkg/model"
+	"github.com/xugou/agent/pkg/telemetry"
+	"github.com/xugou/agent/pkg/textfile"
 	"github.com/xugou/agent/pkg/utils"
 )
 
 // Collector 定义数据收集器接口
 type Collector interface {
 	Collect(ctx context.Context) (*model.SystemInfo, error)
-	CollectBatch(ctx context.Context) ([]*model.SystemInfo, error) // 批量采集一段时间内的系统信息
+	CollectBatch(ctx context.Context) ([]*model.SystemInfo, error)      // 批量采集一段时间内的系统信息
+	CollectSteps(ctx context.Context) (*model.SystemInfo, []StepResult) // 采集系统信息并返回每个采集步骤的耗时和错误
 }
 
 // DefaultCollector 是默认的数据收集器实现
@@ -33,19 +34,80 @@ func NewCollector() Collector {
 	return &DefaultCollector{}
 }
 
+// Step 描述单个采集步骤
+type Step struct {
+	Name     string                                                  // 采集步骤名称
+	Required bool                                                    // 是否为必须成功的步骤，失败时整次采集失败
+	Run      func(ctx context.Context, info *model.SystemInfo) error // 采集函数，结果写入 info
+}
+
+// StepResult 记录单个采集步骤的耗时和错误
+type StepResult struct {
+	Name     string
+	Required bool
+	Duration time.Duration
+	Err      error
+}
+
+// Steps 按顺序执行的采集步骤
+var Steps = []Step{
+	{Name: "host", Required: true, Run: collectHost},
+	{Name: "cpu", Required: true, Run: collectCPU},
+	{Name: "memory", Required: true, Run: collectMemory},
+	{Name: "disk", Required: true, Run: collectDisk},
+	{Name: "network", Required: true, Run: collectNetwork},
+	{Name: "load", Required: false, Run: collectLoad},
+	{Name: "textfile", Required: false, Run: collectTextfile},
+}
+
 // Collect 收集系统信息
 func (c *DefaultCollector) Collect(ctx context.Context) (*model.SystemInfo, error) {
+	info, results := c.CollectSteps(ctx)
+	for _, result := range results {
+		if result.Err != nil && result.Required {
+			return nil, result.Err
+		}
+	}
+	return info, nil
+}
+
+// CollectSteps 依次执行所有启用的采集步骤，即使某个步骤失败也会继续执行后续步骤，并返回每个步骤的耗时和错误。
+// 未通过 collectors 启用的步骤不会执行，也不会出现在结果中，host 步骤始终执行
+func (c *DefaultCollector) CollectSteps(ctx context.Context) (*model.SystemInfo, []StepResult) {
+	rt := config.Current()
 	info := &model.SystemInfo{
-		Timestamp: time.Now(),
-		Keepalive: config.Interval,
+		SchemaVersion: model.SchemaVersion,
+		ConfigVersion: rt.ConfigVersion,
+		Labels:        rt.Labels,
+		Timestamp:     time.Now(),
+		Keepalive:     rt.Interval,
 	}
 
-	info.Token = config.Token
+	results := make([]StepResult, 0, len(Steps))
+	for _, step := range Steps {
+		if step.Name != "host" && !rt.CollectorEnabled(step.Name) {
+			continue
+		}
+		start := time.Now()
+		err := step.Run(ctx, info)
+		duration := time.Since(start)
+		telemetry.Default.ObserveCollector(step.Name, duration, err)
+		results = append(results, StepResult{
+			Name:     step.Name,
+			Required: step.Required,
+			Duration: duration,
+			Err:      err,
+		})
+	}
 
-	// 获取主机信息
-	hostInfo, err := host.Info()
+	return info, results
+}
+
+// collectHost 获取主机信息
+func collectHost(ctx context.Context, info *model.SystemInfo) error {
+	hostInfo, err := host.InfoWithContext(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("获取主机信息失败: %w", err)
+		return fmt.Errorf("获取主机信息失败: %w", err)
 	}
 	info.Hostname = hostInfo.Hostname
 	info.Platform = hostInfo.Platform
@@ -55,16 +117,19 @@ func (c *DefaultCollector) Collect(ctx context.Context) (*model.SystemInfo, erro
 
 	// 获取本地IP地址
 	info.IPAddresses = utils.GetLocalIPs()
+	return nil
+}
 
-	// 获取CPU信息
-	cpuPercent, err := cpu.Percent(time.Second, false)
+// collectCPU 获取CPU信息
+func collectCPU(ctx context.Context, info *model.SystemInfo) error {
+	cpuPercent, err := cpu.PercentWithContext(ctx, time.Second, false)
 	if err != nil {
-		return nil, fmt.Errorf("获取CPU使用率失败: %w", err)
+		return fmt.Errorf("获取CPU使用率失败: %w", err)
 	}
 
-	cpuInfo, err := cpu.Info()
+	cpuInfo, err := cpu.InfoWithContext(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("获取CPU信息失败: %w", err)
+		return fmt.Errorf("获取CPU信息失败: %w", err)
 	}
 
 	var modelName string
@@ -77,30 +142,37 @@ func (c *DefaultCollector) Collect(ctx context.Context) (*model.SystemInfo, erro
 		Cores:     runtime.NumCPU(),
 		ModelName: modelName,
 	}
+	return nil
+}
 
-	// 获取内存信息
-	memInfo, err := mem.VirtualMemory()
+// collectMemory 获取内存信息
+func collectMemory(ctx context.Context, info *model.SystemInfo) error {
+	memInfo, err := mem.VirtualMemoryWithContext(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("获取内存信息失败: %w", err)
+		return fmt.Errorf("获取内存信息失败: %w", err)
 	}
 
 	info.MemoryInfo = model.MemoryInfo{
 		Total:     memInfo.Total,
 		Used:      memInfo.Used,
 		Free:      memInfo.Free,
+		Available: memInfo.Available,
 		UsageRate: memInfo.UsedPercent,
 	}
+	return nil
+}
 
-	// 获取磁盘信息
-	configDevices := viper.GetStringSlice("devices")
+// collectDisk 获取磁盘信息
+func collectDisk(ctx context.Context, info *model.SystemInfo) error {
+	configDevices := config.Current().Devices
 	deviceSet := make(map[string]struct{})
 	for _, d := range configDevices {
 		deviceSet[d] = struct{}{}
 	}
 
-	partitions, err := disk.Partitions(false)
+	partitions, err := disk.PartitionsWithContext(ctx, false)
 	if err != nil {
-		return nil, fmt.Errorf("获取磁盘分区信息失败: %w", err)
+		return fmt.Errorf("获取磁盘分区信息失败: %w", err)
 	}
 
 	for _, partition := range partitions {
@@ -113,7 +185,7 @@ func (c *DefaultCollector) Collect(ctx context.Context) (*model.SystemInfo, erro
 			}
 		}
 
-		usage, err := disk.Usage(partition.Mountpoint)
+		usage, err := disk.UsageWithContext(ctx, partition.Mountpoint)
 		if err != nil {
 			// log.Printf("获取磁盘 %s 使用情况失败: %v", partition.Mountpoint, err) // 可选的日志记录
 			continue
@@ -130,17 +202,20 @@ func (c *DefaultCollector) Collect(ctx context.Context) (*model.SystemInfo, erro
 		}
 		info.DiskInfo = append(info.DiskInfo, diskInfo)
 	}
+	return nil
+}
 
-	// 获取网络信息
-	configInterfaces := viper.GetStringSlice("interfaces")
+// collectNetwork 获取网络信息
+func collectNetwork(ctx context.Context, info *model.SystemInfo) error {
+	configInterfaces := config.Current().Interfaces
 	interfaceSet := make(map[string]struct{})
 	for _, i := range configInterfaces {
 		interfaceSet[i] = struct{}{}
 	}
 
-	netIOCounters, err := net.IOCounters(true)
+	netIOCounters, err := net.IOCountersWithContext(ctx, true)
 	if err != nil {
-		return nil, fmt.Errorf("获取网络信息失败: %w", err)
+		return fmt.Errorf("获取网络信息失败: %w", err)
 	}
 
 	for _, netIO := range netIOCounters {
@@ -159,18 +234,33 @@ func (c *DefaultCollector) Collect(ctx context.Context) (*model.SystemInfo, erro
 		}
 		info.NetworkInfo = append(info.NetworkInfo, networkInfo)
 	}
+	return nil
+}
 
-	// 获取系统负载
-	loadAvg, err := load.Avg()
-	if err == nil {
-		info.LoadInfo = model.LoadInfo{
-			Load1:  loadAvg.Load1,
-			Load5:  loadAvg.Load5,
-			Load15: loadAvg.Load15,
-		}
+// collectLoad 获取系统负载，部分平台（如 Windows）不支持，失败时不影响整次采集
+func collectLoad(ctx context.Context, info *model.SystemInfo) error {
+	loadAvg, err := load.AvgWithContext(ctx)
+	if err != nil {
+		return fmt.Errorf("获取系统负载失败: %w", err)
 	}
+	info.LoadInfo = model.LoadInfo{
+		Load1:  loadAvg.Load1,
+		Load5:  loadAvg.Load5,
+		Load15: loadAvg.Load15,
+	}
+	return nil
+}
 
-	return info, nil
+// collectTextfile 读取 textfile.directory 中的自定义指标，单个文件的错误记录在 info.Textfiles 中，不影响其他文件
+func collectTextfile(ctx context.Context, info *model.SystemInfo) error {
+	rt := config.Current()
+	metrics, files, err := textfile.Read(rt.TextfileDir, rt.TextfileMaxAge)
+	if err != nil {
+		return err
+	}
+	info.CustomMetrics = append(info.CustomMetrics, metrics...)
+	info.Textfiles = files
+	return nil
 }
 
 // CollectBatch 在指定时间段内批量收集系统信息，现在只采集一条，以后再扩展
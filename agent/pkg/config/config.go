@@ -1,8 +1,67 @@
 package config
 
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"github.com/xugou/agent/pkg/tlsconfig"
+)
+
 var (
 	ServerURL string = ""
 	Token     string = ""
+	TokenFile string = ""
 	Interval  int    = 120
 	ProxyURL  string = ""
+	NoProxy   string = ""
+	StateDir  string = ""
+	UpdateURL string = DefaultUpdateURL
+	TLS       tlsconfig.Options
 )
+
+// DefaultUpdateURL 默认的发布清单地址
+const DefaultUpdateURL = "https://dl.xugou.mdzz.uk/latest/manifest.json"
+
+// ResolveStateDir 返回状态目录，未配置时优先使用 /var/lib/xugou-agent，没有写入权限则使用用户主目录。
+// 这里不创建目录，写入状态文件时才创建，避免 config validate 等命令留下空目录
+func ResolveStateDir(configured string) string {
+	if configured != "" {
+		return configured
+	}
+
+	if runtime.GOOS != "windows" {
+		system := "/var/lib/xugou-agent"
+		if _, err := os.Stat(system); errors.Is(err, os.ErrNotExist) {
+			// 目录不存在时只有 root 能在 /var/lib 下创建
+			if os.Geteuid() == 0 {
+				return system
+			}
+		} else if f, err := os.CreateTemp(system, ".probe-*"); err == nil {
+			f.Close()
+			os.Remove(f.Name())
+			return system
+		}
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return filepath.Join(os.TempDir(), "xugou-agent")
+	}
+	return filepath.Join(home, ".xugou-agent")
+}
+
+// Digest 计算配置内容的摘要，配置项的顺序不影响结果
+func Digest(settings map[string]interface{}) string {
+	// encoding/json 会按键名排序输出 map
+	data, err := json.Marshal(settings)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
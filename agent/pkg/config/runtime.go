@@ -0,0 +1,49 @@
+package config
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Runtime 运行期间可以被远程配置热更新的设置，更新时整体替换，读取方不需要加锁
+type Runtime struct {
+	Interval       int               // 采集和上报间隔（秒）
+	Collectors     []string          // 启用的采集步骤，为空表示全部启用
+	Devices        []string          // 监控的硬盘设备，为空表示全部
+	Interfaces     []string          // 监控的网络接口，为空表示全部
+	Labels         map[string]string // 主机标签
+	ConfigVersion  string            // 生效的远程配置版本，没有使用远程配置时为空
+	TextfileDir    string            // 读取自定义指标文本文件的目录，为空表示不启用
+	TextfileMaxAge time.Duration     // 文本文件超过多长时间没有更新时标记为过期
+}
+
+var current atomic.Pointer[Runtime]
+
+// Current 返回当前生效的运行时设置，未设置时只包含默认的采集间隔
+func Current() *Runtime {
+	if r := current.Load(); r != nil {
+		return r
+	}
+	return &Runtime{Interval: Interval}
+}
+
+// SetCurrent 替换当前生效的运行时设置，调用后不能再修改 r
+func SetCurrent(r *Runtime) {
+	current.Store(r)
+}
+
+// CollectorEnabled 判断采集步骤是否启用，textfile 步骤还需要设置 textfile.directory
+func (r *Runtime) CollectorEnabled(name string) bool {
+	if name == "textfile" && r.TextfileDir == "" {
+		return false
+	}
+	if len(r.Collectors) == 0 {
+		return true
+	}
+	for _, c := range r.Collectors {
+		if c == name {
+			return true
+		}
+	}
+	return false
+}
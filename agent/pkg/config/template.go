@@ -0,0 +1,81 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// RenderTemplate 生成带注释的配置文件模板，values 中给出的配置项会被写入，其余配置项以注释形式给出默认值
+func RenderTemplate(values map[string]interface{}) string {
+	var b strings.Builder
+	b.WriteString("# Xugou Agent 配置文件\n")
+	b.WriteString("# 命令行参数和 XUGOU_* 环境变量的优先级高于本文件\n")
+
+	section := ""
+	for _, key := range Keys {
+		name := key.Name
+		indent := ""
+		if i := strings.Index(name, "."); i >= 0 {
+			if sec := name[:i]; sec != section {
+				section = sec
+				b.WriteString("\n")
+				if sectionHasValues(sec, values) {
+					fmt.Fprintf(&b, "%s:\n", sec)
+				} else {
+					fmt.Fprintf(&b, "# %s:\n", sec)
+				}
+			}
+			name = name[i+1:]
+			indent = "  "
+		} else {
+			section = ""
+			b.WriteString("\n")
+		}
+
+		fmt.Fprintf(&b, "%s# %s\n", indent, key.Description)
+		if value, ok := values[key.Name]; ok {
+			fmt.Fprintf(&b, "%s%s: %s\n", indent, name, formatValue(value))
+		} else {
+			fmt.Fprintf(&b, "%s# %s: %s\n", indent, name, formatValue(key.Default))
+		}
+	}
+
+	return b.String()
+}
+
+func sectionHasValues(section string, values map[string]interface{}) bool {
+	for name := range values {
+		if strings.HasPrefix(name, section+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// formatValue 把配置值格式化为单行 YAML
+func formatValue(value interface{}) string {
+	if value == nil {
+		return `""`
+	}
+	node := &yaml.Node{}
+	if err := node.Encode(value); err != nil {
+		return fmt.Sprint(value)
+	}
+	setFlowStyle(node)
+	out, err := yaml.Marshal(node)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func setFlowStyle(node *yaml.Node) {
+	if node.Kind == yaml.SequenceNode || node.Kind == yaml.MappingNode {
+		node.Style = yaml.FlowStyle
+	}
+	for _, child := range node.Content {
+		setFlowStyle(child)
+	}
+}
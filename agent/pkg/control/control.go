@@ -0,0 +1,349 @@
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/xugou/agent/pkg/telemetry"
+	"github.com/xugou/agent/pkg/useragent"
+)
+
+// 连接方式
+const (
+	ModeAuto      = "auto"      // 优先使用 WebSocket，服务器或代理不支持时改用长轮询
+	ModeWebSocket = "websocket" // 只使用 WebSocket
+	ModeLongPoll  = "longpoll"  // 只使用 HTTP 长轮询
+)
+
+// Modes 所有支持的连接方式
+var Modes = []string{ModeAuto, ModeWebSocket, ModeLongPoll}
+
+// 消息类型
+const (
+	TypeHello     = "hello"     // 连接建立后 Agent 发送，包含版本和允许执行的命令
+	TypeHeartbeat = "heartbeat" // WebSocket 连接上 Agent 定期发送，服务器也可以发送
+	TypeCommand   = "command"   // 服务器下发的命令
+	TypeAck       = "ack"       // Agent 收到命令后立即发送的确认
+	TypeResult    = "result"    // 命令的执行结果，Error 为空表示成功
+)
+
+// 服务器可以下发的命令
+const (
+	CommandCollectNow        = "collect-now"        // 立即采集并上报一次
+	CommandReloadConfig      = "reload-config"      // 立即拉取远程配置
+	CommandRunProbe          = "run-probe"          // 立即执行探测任务
+	CommandUploadDiagnostics = "upload-diagnostics" // 生成并上传诊断信息
+	CommandRestart           = "restart"            // 重启 Agent 进程
+)
+
+// Commands 所有支持的命令，只有在 control.commands 中允许的命令才会执行
+var Commands = []string{CommandCollectNow, CommandReloadConfig, CommandRunProbe, CommandUploadDiagnostics, CommandRestart}
+
+// 重连间隔，连接失败后按指数增加并加入随机抖动
+const (
+	minBackoff = time.Second
+	maxBackoff = 5 * time.Minute
+
+	// fallbackDuration 服务器不支持 WebSocket 时使用长轮询的时长，之后再尝试 WebSocket
+	fallbackDuration = 30 * time.Minute
+
+	// maxRecent 记住的最近请求 ID 数量，用于识别服务器重发的命令
+	maxRecent = 256
+)
+
+// Message 控制通道上的消息
+type Message struct {
+	Type     string          `json:"type"`
+	ID       string          `json:"id,omitempty"`       // 命令的请求 ID，确认和结果使用相同的 ID
+	Command  string          `json:"command,omitempty"`  // 命令名称
+	Args     json.RawMessage `json:"args,omitempty"`     // 命令参数
+	Result   json.RawMessage `json:"result,omitempty"`   // 命令的执行结果
+	Error    string          `json:"error,omitempty"`    // 命令执行失败或被拒绝的原因
+	Version  string          `json:"version,omitempty"`  // Agent 版本，只在 hello 中发送
+	Commands []string        `json:"commands,omitempty"` // 允许执行的命令，只在 hello 中发送
+	Time     string          `json:"time,omitempty"`     // 发送时间（RFC 3339）
+}
+
+// Handler 执行一个命令，返回的结果会序列化为 JSON 发回服务器
+type Handler func(ctx context.Context, args json.RawMessage) (interface{}, error)
+
+type requestIDKey struct{}
+
+// RequestID 返回 Handler 正在执行的命令的请求 ID
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// Client 维护 Agent 到服务器的控制通道，接收并执行服务器下发的命令，连接断开后自动重连
+type Client struct {
+	URL       string                                     // 控制通道地址，例如 https://api.xugou.mdzz.uk/api/agents/control
+	HTTP      *http.Client                               // 不能设置超时，Transport 不能启用 HTTP/2
+	Prepare   func(req *http.Request, body []byte) error // 为请求设置通用头部和签名
+	Check     func(resp *http.Response) bool             // 保存服务器轮换的令牌，服务器提示时间偏差过大时返回 true 表示需要重新签名后重试，可以为空
+	Mode      string                                     // 连接方式，见 Modes
+	Heartbeat time.Duration                              // WebSocket 心跳间隔，超过 3 个间隔没有收到数据时重连；也是长轮询的等待时间
+	Allowed   []string                                   // 允许执行的命令
+	Handlers  map[string]Handler                         // 各命令的实现，没有实现的命令会返回错误
+	Timeout   time.Duration                              // 单个命令的最长执行时间
+
+	mu            sync.Mutex
+	current       session
+	recent        map[string]*Message // 最近收到的请求 ID 和执行结果，结果为空表示正在执行
+	order         []string
+	fallbackUntil time.Time
+}
+
+// Run 连接控制通道并处理命令，直到 ctx 被取消
+func (c *Client) Run(ctx context.Context) {
+	backoff := minBackoff
+	for ctx.Err() == nil {
+		start := time.Now()
+		err := c.connect(ctx)
+		telemetry.Default.SetControl("disconnected")
+		if ctx.Err() != nil {
+			return
+		}
+
+		// 连接保持了较长时间说明服务器正常，重新从最短的间隔开始
+		if time.Since(start) > time.Minute {
+			backoff = minBackoff
+		}
+		delay := backoff/2 + rand.N(backoff/2+1)
+		slog.Warn("控制通道已断开，稍后重连", "error", err, "retry_in", delay.Round(time.Second))
+		telemetry.Default.RecordError("control", err)
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// connect 建立一次连接并处理消息，直到连接断开
+func (c *Client) connect(ctx context.Context) error {
+	s, err := c.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	sessCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	hello := Message{Type: TypeHello, Version: useragent.Version(), Commands: c.Allowed, Time: now()}
+	if err := s.Send(sessCtx, hello); err != nil {
+		return err
+	}
+	c.setSession(s)
+	defer c.setSession(nil)
+	telemetry.Default.SetControl(s.Mode())
+	slog.Info("控制通道已连接", "mode", s.Mode())
+
+	if ws, ok := s.(*wsSession); ok {
+		go c.keepalive(sessCtx, ws)
+	}
+	for {
+		msgs, err := s.Receive(sessCtx)
+		if err != nil {
+			return err
+		}
+		for _, msg := range msgs {
+			c.handle(ctx, msg)
+		}
+	}
+}
+
+// open 按连接方式建立连接，自动模式下服务器不支持 WebSocket 时改用长轮询
+func (c *Client) open(ctx context.Context) (session, error) {
+	mode := c.Mode
+	if mode == ModeAuto && time.Now().Before(c.fallbackUntil) {
+		mode = ModeLongPoll
+	}
+
+	if mode != ModeLongPoll {
+		conn, err := dialWebSocket(ctx, c.HTTP, c.URL, func(req *http.Request) error {
+			return c.Prepare(req, nil)
+		}, c.Check)
+		if err == nil {
+			return &wsSession{conn: conn}, nil
+		}
+		if mode != ModeAuto || !errors.Is(err, ErrUnsupported) {
+			return nil, err
+		}
+		slog.Info("服务器不支持 WebSocket，改用长轮询", "error", err)
+		c.fallbackUntil = time.Now().Add(fallbackDuration)
+	}
+	return &pollSession{client: c.HTTP, baseURL: c.URL, wait: c.Heartbeat, prepare: c.Prepare, check: c.Check}, nil
+}
+
+// keepalive 定期发送心跳，超过 3 个心跳间隔没有收到任何数据时关闭连接，让 Run 重新连接
+func (c *Client) keepalive(ctx context.Context, s *wsSession) {
+	ticker := time.NewTicker(c.Heartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			s.Close()
+			return
+		case <-ticker.C:
+			if since := time.Since(s.conn.LastRead()); since > 3*c.Heartbeat {
+				slog.Warn("控制通道心跳超时", "since", since.Round(time.Second))
+				s.Close()
+				return
+			}
+			if err := s.Send(ctx, Message{Type: TypeHeartbeat, Time: now()}); err != nil {
+				s.Close()
+				return
+			}
+		}
+	}
+}
+
+// handle 处理服务器发来的消息。命令先确认再在后台执行，重复的请求 ID 不会重复执行
+func (c *Client) handle(ctx context.Context, msg Message) {
+	switch msg.Type {
+	case TypeCommand:
+	case TypeHeartbeat, TypeHello:
+		return
+	default:
+		slog.Debug("忽略未知的控制消息", "type", msg.Type)
+		return
+	}
+	if msg.ID == "" {
+		slog.Warn("忽略没有请求 ID 的控制命令", "command", msg.Command)
+		return
+	}
+
+	c.mu.Lock()
+	result, seen := c.recent[msg.ID]
+	if !seen {
+		c.remember(msg.ID, nil)
+	}
+	c.mu.Unlock()
+
+	ack := Message{Type: TypeAck, ID: msg.ID, Command: msg.Command, Time: now()}
+	if seen {
+		// 服务器重发了同一个命令（例如重连之后），只重新发送确认和已有的结果
+		c.send(ctx, ack)
+		if result != nil {
+			c.send(ctx, *result)
+		}
+		return
+	}
+	c.send(ctx, ack)
+
+	handler, err := c.handler(msg.Command)
+	if err != nil {
+		c.finish(ctx, msg, nil, err)
+		return
+	}
+	go func() {
+		cmdCtx, cancel := context.WithTimeout(context.WithValue(ctx, requestIDKey{}, msg.ID), c.Timeout)
+		defer cancel()
+		slog.Info("执行控制命令", "command", msg.Command, "id", msg.ID)
+		result, err := handler(cmdCtx, msg.Args)
+		c.finish(ctx, msg, result, err)
+	}()
+}
+
+// handler 返回允许执行的命令的实现
+func (c *Client) handler(command string) (Handler, error) {
+	if !slices.Contains(c.Allowed, command) {
+		return nil, fmt.Errorf("命令 %s 不在允许列表中", command)
+	}
+	h, ok := c.Handlers[command]
+	if !ok {
+		return nil, fmt.Errorf("不支持命令 %s 或相关功能未启用", command)
+	}
+	return h, nil
+}
+
+// finish 记录并发送命令的执行结果，结果发送失败时服务器可以用相同的请求 ID 重发命令取回结果
+func (c *Client) finish(ctx context.Context, cmd Message, result interface{}, err error) {
+	msg := Message{Type: TypeResult, ID: cmd.ID, Command: cmd.Command, Time: now()}
+	if err == nil && result != nil {
+		msg.Result, err = json.Marshal(result)
+	}
+	if err != nil {
+		msg.Error = err.Error()
+		slog.Warn("控制命令执行失败", "command", cmd.Command, "id", cmd.ID, "error", err)
+	} else {
+		slog.Info("控制命令执行完成", "command", cmd.Command, "id", cmd.ID)
+	}
+
+	c.mu.Lock()
+	c.remember(cmd.ID, &msg)
+	c.mu.Unlock()
+	c.send(ctx, msg)
+}
+
+// MarkDone 把请求 ID 记录为已经执行完成，服务器重发该命令时直接返回 result 而不再执行，
+// 用于在重启之后识别触发重启的命令
+func (c *Client) MarkDone(id, command string, result interface{}) {
+	msg := Message{Type: TypeResult, ID: id, Command: command, Time: now()}
+	msg.Result, _ = json.Marshal(result)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.remember(id, &msg)
+}
+
+// remember 记录请求 ID，只保留最近的 maxRecent 个，调用方需要持有锁
+func (c *Client) remember(id string, result *Message) {
+	if c.recent == nil {
+		c.recent = make(map[string]*Message)
+	}
+	if _, ok := c.recent[id]; !ok {
+		c.order = append(c.order, id)
+		if len(c.order) > maxRecent {
+			delete(c.recent, c.order[0])
+			c.order = c.order[1:]
+		}
+	}
+	c.recent[id] = result
+}
+
+// send 通过当前连接发送消息，没有连接时丢弃
+func (c *Client) send(ctx context.Context, msg Message) {
+	c.mu.Lock()
+	s := c.current
+	c.mu.Unlock()
+	if s == nil {
+		slog.Debug("控制通道未连接，丢弃消息", "type", msg.Type, "id", msg.ID)
+		return
+	}
+	if err := s.Send(ctx, msg); err != nil {
+		slog.Warn("发送控制消息失败", "type", msg.Type, "id", msg.ID, "error", err)
+	}
+}
+
+func (c *Client) setSession(s session) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.current = s
+}
+
+func now() string {
+	return time.Now().UTC().Format(time.RFC3339)
+}
+
+// statusError 把服务器返回的错误状态码转换为错误
+func statusError(status int) error {
+	switch status {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return fmt.Errorf("服务器拒绝了令牌（状态码 %d）", status)
+	case http.StatusUpgradeRequired:
+		return fmt.Errorf("服务器不再支持当前版本 %s，请运行 xugou-agent update 升级", useragent.Version())
+	}
+	return fmt.Errorf("服务器返回状态码 %d", status)
+}
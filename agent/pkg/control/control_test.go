@@ -0,0 +1,232 @@
+package control
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xugou/agent/pkg/auth"
+)
+
+func TestPollSessionReceive(t *testing.T) {
+	var polls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" || r.URL.Path != "/control/poll" || r.URL.Query().Get("wait") != "2" {
+			t.Errorf("request = %s %s", r.Method, r.URL)
+		}
+		if r.Header.Get("Authorization") != "Bearer test-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		polls++
+		switch polls {
+		case 1:
+			json.NewEncoder(w).Encode([]Message{{Type: TypeCommand, ID: "c1", Command: CommandCollectNow}})
+		case 2:
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	_, sign, check := signing(t, "test-token")
+	s := &pollSession{client: srv.Client(), baseURL: srv.URL + "/control", wait: 2 * time.Second, prepare: sign, check: check}
+	ctx := context.Background()
+
+	msgs, err := s.Receive(ctx)
+	if err != nil || len(msgs) != 1 || msgs[0].ID != "c1" || msgs[0].Command != CommandCollectNow {
+		t.Fatalf("Receive() = %+v, %v", msgs, err)
+	}
+	// 服务器没有等待就返回 204 时，至少间隔 minPollInterval 再轮询
+	start := time.Now()
+	if msgs, err := s.Receive(ctx); msgs != nil || err != nil {
+		t.Errorf("Receive() on 204 = %+v, %v", msgs, err)
+	}
+	if elapsed := time.Since(start); elapsed < minPollInterval {
+		t.Errorf("Receive() on 204 returned after %s, want at least %s", elapsed, minPollInterval)
+	}
+	if _, err := s.Receive(ctx); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("Receive() on 404 error = %v, want ErrUnsupported", err)
+	}
+}
+
+func TestPollSessionSendClockSkew(t *testing.T) {
+	var bodies []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		sum := sha256.Sum256(body)
+		if r.Method != "POST" || r.URL.Path != "/control/messages" || r.Header.Get(auth.HeaderDigest) != hex.EncodeToString(sum[:]) {
+			t.Errorf("request = %s %s, digest %s does not match the body", r.Method, r.URL, r.Header.Get(auth.HeaderDigest))
+		}
+		if rejectSkew(w, r) {
+			return
+		}
+		w.Header().Set(auth.HeaderRotateToken, "new-token")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	tokens, sign, check := signing(t, "old-token")
+	s := &pollSession{client: srv.Client(), baseURL: srv.URL + "/control", wait: time.Second, prepare: sign, check: check}
+	if err := s.Send(context.Background(), Message{Type: TypeAck, ID: "c1"}); err != nil {
+		t.Fatal(err)
+	}
+	// 重新签名后再发送一次，请求体保持不变
+	if len(bodies) != 2 || bodies[0] != bodies[1] || !strings.Contains(bodies[1], `"id":"c1"`) {
+		t.Errorf("bodies = %q, want the same message sent twice", bodies)
+	}
+	if got := tokens.Token(); got != "new-token" {
+		t.Errorf("token after Send = %q, want the rotated token", got)
+	}
+}
+
+// controlServer 模拟服务器的控制接口：不支持 WebSocket，通过长轮询依次下发 commands，并记录 Agent 发来的消息
+type controlServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	commands []Message
+	received []Message
+	notify   chan struct{}
+}
+
+func newControlServer(t *testing.T, commands ...Message) *controlServer {
+	s := &controlServer{commands: commands, notify: make(chan struct{}, 100)}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		switch r.URL.Path {
+		case "/control/poll":
+			if len(s.commands) == 0 {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			json.NewEncoder(w).Encode(s.commands[:1])
+			s.commands = s.commands[1:]
+		case "/control/messages":
+			var msgs []Message
+			if err := json.NewDecoder(r.Body).Decode(&msgs); err != nil {
+				t.Errorf("decode messages: %v", err)
+			}
+			s.received = append(s.received, msgs...)
+			w.WriteHeader(http.StatusNoContent)
+			s.notify <- struct{}{}
+		default:
+			// WebSocket 握手请求
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// waitFor 等待服务器收到满足 done 的消息
+func (s *controlServer) waitFor(t *testing.T, done func([]Message) bool) []Message {
+	t.Helper()
+	timeout := time.After(10 * time.Second)
+	for {
+		s.mu.Lock()
+		received := append([]Message{}, s.received...)
+		s.mu.Unlock()
+		if done(received) {
+			return received
+		}
+		select {
+		case <-s.notify:
+		case <-timeout:
+			t.Fatalf("timed out, received %+v", received)
+		}
+	}
+}
+
+// find 返回指定类型和请求 ID 的消息
+func find(msgs []Message, typ, id string) *Message {
+	for i := range msgs {
+		if msgs[i].Type == typ && msgs[i].ID == id {
+			return &msgs[i]
+		}
+	}
+	return nil
+}
+
+func TestClientFallsBackToLongPoll(t *testing.T) {
+	srv := newControlServer(t,
+		Message{Type: TypeCommand, ID: "c1", Command: CommandCollectNow},
+		Message{Type: TypeCommand, ID: "c2", Command: CommandRestart},
+		Message{Type: TypeCommand, ID: "c1", Command: CommandCollectNow}, // 服务器重发
+	)
+	var collects int
+	var mu sync.Mutex
+	_, sign, check := signing(t, "test-token")
+	client := &Client{
+		URL:       srv.URL + "/control",
+		HTTP:      srv.Client(),
+		Prepare:   sign,
+		Check:     check,
+		Mode:      ModeAuto,
+		Heartbeat: time.Second,
+		Allowed:   []string{CommandCollectNow},
+		Timeout:   time.Minute,
+		Handlers: map[string]Handler{
+			CommandCollectNow: func(ctx context.Context, args json.RawMessage) (interface{}, error) {
+				if RequestID(ctx) != "c1" {
+					t.Errorf("RequestID() = %q", RequestID(ctx))
+				}
+				mu.Lock()
+				collects++
+				mu.Unlock()
+				return map[string]bool{"reported": true}, nil
+			},
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		client.Run(ctx)
+		close(stopped)
+	}()
+
+	// c1 重发后再次确认，但不会再次执行
+	received := srv.waitFor(t, func(msgs []Message) bool {
+		acks := 0
+		for _, msg := range msgs {
+			if msg.Type == TypeAck && msg.ID == "c1" {
+				acks++
+			}
+		}
+		return acks == 2 && find(msgs, TypeResult, "c1") != nil && find(msgs, TypeResult, "c2") != nil
+	})
+	cancel()
+	<-stopped
+
+	if received[0].Type != TypeHello || !strings.Contains(strings.Join(received[0].Commands, ","), CommandCollectNow) {
+		t.Errorf("first message = %+v, want hello", received[0])
+	}
+	if find(received, TypeAck, "c1") == nil || find(received, TypeAck, "c2") == nil {
+		t.Errorf("received = %+v, want acks for c1 and c2", received)
+	}
+	if result := find(received, TypeResult, "c1"); result == nil || result.Error != "" || string(result.Result) != `{"reported":true}` {
+		t.Errorf("c1 result = %+v", result)
+	}
+	if result := find(received, TypeResult, "c2"); result == nil || !strings.Contains(result.Error, "不在允许列表中") {
+		t.Errorf("c2 result = %+v, want rejected", result)
+	}
+	if collects != 1 {
+		t.Errorf("collect-now ran %d times, want 1 (resent command is not executed again)", collects)
+	}
+	if client.fallbackUntil.IsZero() {
+		t.Error("client did not record the WebSocket fallback")
+	}
+}
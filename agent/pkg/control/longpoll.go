@@ -0,0 +1,154 @@
+package control
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// session 一次控制通道连接，WebSocket 和长轮询都实现该接口
+type session interface {
+	Mode() string
+	// Receive 阻塞等待服务器发来的消息
+	Receive(ctx context.Context) ([]Message, error)
+	Send(ctx context.Context, msg Message) error
+	Close() error
+}
+
+// wsSession 基于 WebSocket 的连接
+type wsSession struct {
+	conn *wsConn
+}
+
+func (s *wsSession) Mode() string { return ModeWebSocket }
+
+func (s *wsSession) Receive(ctx context.Context) ([]Message, error) {
+	data, err := s.conn.ReadMessage()
+	if err != nil {
+		return nil, err
+	}
+	var msg Message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return nil, fmt.Errorf("解析控制消息失败: %w", err)
+	}
+	return []Message{msg}, nil
+}
+
+func (s *wsSession) Send(ctx context.Context, msg Message) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return s.conn.WriteMessage(data)
+}
+
+func (s *wsSession) Close() error {
+	return s.conn.Close()
+}
+
+// minPollInterval 两次长轮询之间的最短间隔
+const minPollInterval = time.Second
+
+// pollSession 基于 HTTP 长轮询的连接，用于服务器或代理不支持 WebSocket 的环境。
+// Agent 通过 GET /api/agents/control/poll 等待命令，通过 POST /api/agents/control/messages 发送确认和结果
+type pollSession struct {
+	client  *http.Client
+	baseURL string
+	wait    time.Duration
+	prepare func(req *http.Request, body []byte) error
+	check   func(resp *http.Response) bool
+}
+
+func (s *pollSession) Mode() string { return ModeLongPoll }
+
+func (s *pollSession) Receive(ctx context.Context) ([]Message, error) {
+	// 服务器最多等待 wait 后返回，多留一些时间给网络往返
+	ctx, cancel := context.WithTimeout(ctx, s.wait+15*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	url := fmt.Sprintf("%s/poll?wait=%d", s.baseURL, int(s.wait.Seconds()))
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.do(req, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNoContent:
+		// 服务器没有等待就返回时稍后再轮询，避免频繁请求
+		if elapsed := time.Since(start); elapsed < minPollInterval {
+			select {
+			case <-time.After(minPollInterval - elapsed):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		return nil, nil
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusMethodNotAllowed:
+		return nil, fmt.Errorf("%w（状态码 %d）", ErrUnsupported, resp.StatusCode)
+	case resp.StatusCode >= 300:
+		return nil, statusError(resp.StatusCode)
+	}
+
+	var msgs []Message
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxMessageSize)).Decode(&msgs); err != nil {
+		return nil, fmt.Errorf("解析控制消息失败: %w", err)
+	}
+	return msgs, nil
+}
+
+func (s *pollSession) Send(ctx context.Context, msg Message) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	body, err := json.Marshal([]Message{msg})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", s.baseURL+"/messages", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(req, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode >= 300 {
+		return statusError(resp.StatusCode)
+	}
+	return nil
+}
+
+func (s *pollSession) Close() error {
+	return nil
+}
+
+// do 签名并发送请求，处理响应中的令牌轮换。服务器提示时间偏差过大时按服务器时间重新签名后再发送一次
+func (s *pollSession) do(req *http.Request, body []byte) (*http.Response, error) {
+	for retried := false; ; retried = true {
+		if err := s.prepare(req, body); err != nil {
+			return nil, err
+		}
+		if body != nil {
+			req.Body = io.NopCloser(bytes.NewReader(body))
+			req.ContentLength = int64(len(body))
+		}
+		resp, err := s.client.Do(req)
+		if err != nil || s.check == nil || !s.check(resp) || retried {
+			return resp, err
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+}
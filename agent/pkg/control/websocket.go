@@ -0,0 +1,250 @@
+package control
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Subprotocol 控制通道使用的 WebSocket 子协议
+const Subprotocol = "xugou-control.v1"
+
+// maxMessageSize 单条消息的最大长度
+const maxMessageSize = 1 << 20
+
+// websocketGUID RFC 6455 中用于计算 Sec-WebSocket-Accept 的固定值
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+)
+
+// ErrUnsupported 服务器（或中间的代理）不支持该连接方式
+var ErrUnsupported = errors.New("服务器不支持该连接方式")
+
+// wsConn 客户端 WebSocket 连接，只实现控制通道需要的部分：文本消息、分片、ping/pong 和关闭
+type wsConn struct {
+	rwc       io.ReadWriteCloser
+	br        *bufio.Reader
+	writeMu   sync.Mutex
+	lastRead  atomic.Int64 // 最近一次收到帧的时间（UnixNano），用于检测连接是否存活
+	closeOnce sync.Once
+}
+
+// dialWebSocket 通过 HTTP Upgrade 建立 WebSocket 连接。client 的 Transport 不能启用 HTTP/2，
+// prepare 用于为握手请求设置认证头部，check 处理握手响应中的令牌轮换和时间偏差，可以为空。
+// 服务器不支持升级时返回 ErrUnsupported
+func dialWebSocket(ctx context.Context, client *http.Client, url string, prepare func(*http.Request) error, check func(*http.Response) bool) (*wsConn, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Protocol", Subprotocol)
+
+	// 服务器提示时间偏差过大时，按服务器时间重新签名后再握手一次
+	var resp *http.Response
+	for retried := false; ; retried = true {
+		if err := prepare(req); err != nil {
+			return nil, err
+		}
+		resp, err = client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if check == nil || !check(resp) || retried {
+			break
+		}
+		resp.Body.Close()
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		resp.Body.Close()
+		return nil, upgradeError(resp.StatusCode)
+	}
+
+	rwc, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok {
+		resp.Body.Close()
+		return nil, errors.New("WebSocket 连接不可写")
+	}
+	if !strings.EqualFold(resp.Header.Get("Upgrade"), "websocket") || resp.Header.Get("Sec-WebSocket-Accept") != acceptKey(key) {
+		rwc.Close()
+		return nil, errors.New("服务器的 WebSocket 握手响应无效")
+	}
+
+	c := &wsConn{rwc: rwc, br: bufio.NewReader(rwc)}
+	c.lastRead.Store(time.Now().UnixNano())
+	return c, nil
+}
+
+// upgradeError 把握手失败的状态码转换为错误，服务器没有实现该接口或代理不支持升级时返回 ErrUnsupported
+func upgradeError(status int) error {
+	switch status {
+	case http.StatusOK, http.StatusBadRequest, http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusNotImplemented:
+		return fmt.Errorf("%w（状态码 %d）", ErrUnsupported, status)
+	}
+	return statusError(status)
+}
+
+func acceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// ReadMessage 读取一条完整的数据消息，自动回复 ping，收到关闭帧时返回 io.EOF
+func (c *wsConn) ReadMessage() ([]byte, error) {
+	var msg []byte
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case opPing:
+			if err := c.writeFrame(opPong, payload); err != nil {
+				return nil, err
+			}
+		case opPong:
+		case opClose:
+			code := payload
+			if len(code) > 2 {
+				code = code[:2]
+			}
+			c.writeFrame(opClose, code)
+			return nil, io.EOF
+		case opText, opBinary, opContinuation:
+			msg = append(msg, payload...)
+			if len(msg) > maxMessageSize {
+				return nil, fmt.Errorf("消息超过 %d 字节", maxMessageSize)
+			}
+			if fin {
+				return msg, nil
+			}
+		default:
+			return nil, fmt.Errorf("未知的 WebSocket 帧类型 %#x", op)
+		}
+	}
+}
+
+func (c *wsConn) readFrame() (fin bool, op byte, payload []byte, err error) {
+	var header [2]byte
+	if _, err = io.ReadFull(c.br, header[:]); err != nil {
+		return
+	}
+	fin = header[0]&0x80 != 0
+	op = header[0] & 0x0F
+	masked := header[1]&0x80 != 0
+
+	length := uint64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(c.br, ext[:]); err != nil {
+			return
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(c.br, ext[:]); err != nil {
+			return
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if length > maxMessageSize {
+		err = fmt.Errorf("WebSocket 帧超过 %d 字节", maxMessageSize)
+		return
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err = io.ReadFull(c.br, mask[:]); err != nil {
+			return
+		}
+	}
+	payload = make([]byte, length)
+	if _, err = io.ReadFull(c.br, payload); err != nil {
+		return
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	c.lastRead.Store(time.Now().UnixNano())
+	return
+}
+
+// WriteMessage 发送一条文本消息
+func (c *wsConn) WriteMessage(data []byte) error {
+	return c.writeFrame(opText, data)
+}
+
+// writeFrame 发送单个帧，客户端发送的帧必须使用随机掩码
+func (c *wsConn) writeFrame(op byte, payload []byte) error {
+	frame := make([]byte, 0, 14+len(payload))
+	frame = append(frame, 0x80|op)
+	switch n := len(payload); {
+	case n < 126:
+		frame = append(frame, 0x80|byte(n))
+	case n <= 0xFFFF:
+		frame = append(frame, 0x80|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(n))
+	default:
+		frame = append(frame, 0x80|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+
+	var mask [4]byte
+	if _, err := rand.Read(mask[:]); err != nil {
+		return err
+	}
+	frame = append(frame, mask[:]...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	_, err := c.rwc.Write(frame)
+	return err
+}
+
+// LastRead 返回最近一次收到帧的时间
+func (c *wsConn) LastRead() time.Time {
+	return time.Unix(0, c.lastRead.Load())
+}
+
+// Close 发送关闭帧后关闭连接，可以重复调用
+func (c *wsConn) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		c.writeFrame(opClose, []byte{0x03, 0xE8}) // 1000 正常关闭
+		err = c.rwc.Close()
+	})
+	return err
+}
@@ -0,0 +1,343 @@
+package control
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xugou/agent/pkg/auth"
+)
+
+// upgrade 接管连接并完成服务器端的握手，accept 为空时使用正确的 Sec-WebSocket-Accept，w.Header() 中的头部一起返回
+func upgrade(t *testing.T, w http.ResponseWriter, r *http.Request, accept string) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	if accept == "" {
+		accept = acceptKey(r.Header.Get("Sec-WebSocket-Key"))
+	}
+	conn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 接管连接后 w.Header() 不会自动发送，与握手头部一起写出
+	header := w.Header().Clone()
+	header.Set("Upgrade", "websocket")
+	header.Set("Connection", "Upgrade")
+	header.Set("Sec-WebSocket-Accept", accept)
+	fmt.Fprint(conn, "HTTP/1.1 101 Switching Protocols\r\n")
+	header.Write(conn)
+	fmt.Fprint(conn, "\r\n")
+	return conn, brw.Reader
+}
+
+// wsServer 启动一个测试服务器，校验握手请求后在 serve 中收发原始帧，serve 返回后关闭连接
+func wsServer(t *testing.T, serve func(conn net.Conn, br *bufio.Reader)) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") || r.Header.Get("Sec-WebSocket-Version") != "13" ||
+			r.Header.Get("Sec-WebSocket-Protocol") != Subprotocol || r.Header.Get("Sec-WebSocket-Key") == "" {
+			http.Error(w, "bad handshake", http.StatusBadRequest)
+			return
+		}
+		conn, br := upgrade(t, w, r, "")
+		defer conn.Close()
+		serve(conn, br)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func noPrepare(*http.Request) error { return nil }
+
+// serverFrame 编码一个服务器发送的帧，服务器发送的帧不使用掩码
+func serverFrame(fin bool, op byte, payload []byte) []byte {
+	b := op
+	if fin {
+		b |= 0x80
+	}
+	frame := []byte{b}
+	switch n := len(payload); {
+	case n < 126:
+		frame = append(frame, byte(n))
+	case n <= 0xFFFF:
+		frame = append(frame, 126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(n))
+	default:
+		frame = append(frame, 127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+	return append(frame, payload...)
+}
+
+// readClientFrame 读取客户端发送的帧，客户端发送的帧必须使用掩码
+func readClientFrame(br *bufio.Reader) (op byte, payload []byte, err error) {
+	var header [2]byte
+	if _, err = io.ReadFull(br, header[:]); err != nil {
+		return
+	}
+	if header[0]&0x80 == 0 {
+		return 0, nil, errors.New("client frame without FIN")
+	}
+	if header[1]&0x80 == 0 {
+		return 0, nil, errors.New("client frame is not masked")
+	}
+	op = header[0] & 0x0F
+	length := uint64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(br, ext[:]); err != nil {
+			return
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(br, ext[:]); err != nil {
+			return
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	var mask [4]byte
+	if _, err = io.ReadFull(br, mask[:]); err != nil {
+		return
+	}
+	payload = make([]byte, length)
+	if _, err = io.ReadFull(br, payload); err != nil {
+		return
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return
+}
+
+func TestAcceptKey(t *testing.T) {
+	// RFC 6455 第 1.3 节的示例
+	if got := acceptKey("dGhlIHNhbXBsZSBub25jZQ=="); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("acceptKey() = %q", got)
+	}
+}
+
+func TestDialWebSocketErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		handler     http.HandlerFunc
+		unsupported bool
+		want        string
+	}{
+		{
+			name:        "not found",
+			handler:     func(w http.ResponseWriter, r *http.Request) { http.NotFound(w, r) },
+			unsupported: true,
+		},
+		{
+			name:        "proxy answers 200",
+			handler:     func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("ok")) },
+			unsupported: true,
+		},
+		{
+			name:    "unauthorized",
+			handler: func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusUnauthorized) },
+			want:    "令牌",
+		},
+		{
+			name: "bad accept",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				conn, _ := upgrade(t, w, r, acceptKey("another key"))
+				conn.Close()
+			},
+			want: "握手响应无效",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(tt.handler)
+			defer srv.Close()
+			conn, err := dialWebSocket(context.Background(), srv.Client(), srv.URL, noPrepare, nil)
+			if err == nil {
+				conn.Close()
+				t.Fatal("dialWebSocket() succeeded")
+			}
+			if errors.Is(err, ErrUnsupported) != tt.unsupported {
+				t.Errorf("dialWebSocket() error = %v, ErrUnsupported = %v", err, tt.unsupported)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("dialWebSocket() error = %v, want containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestWebSocketFrames(t *testing.T) {
+	medium := bytes.Repeat([]byte("m"), 300)  // 16 位长度
+	large := bytes.Repeat([]byte("l"), 70000) // 64 位长度
+	done := make(chan struct{})
+	srv := wsServer(t, func(conn net.Conn, br *bufio.Reader) {
+		defer close(done)
+		var out []byte
+		out = append(out, serverFrame(true, opPing, []byte("p1"))...)
+		out = append(out, serverFrame(false, opText, []byte("hel"))...)
+		// 分片消息中间可以插入控制帧
+		out = append(out, serverFrame(true, opPing, []byte("p2"))...)
+		out = append(out, serverFrame(true, opContinuation, []byte("lo"))...)
+		out = append(out, serverFrame(true, opText, medium)...)
+		out = append(out, serverFrame(true, opBinary, large)...)
+		if _, err := conn.Write(out); err != nil {
+			t.Error(err)
+			return
+		}
+
+		want := []struct {
+			op      byte
+			payload string
+		}{{opPong, "p1"}, {opPong, "p2"}, {opText, "hi"}, {opText, string(medium)}}
+		for _, w := range want {
+			op, payload, err := readClientFrame(br)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if op != w.op || string(payload) != w.payload {
+				t.Errorf("client frame = %#x %.10q, want %#x %.10q", op, payload, w.op, w.payload)
+			}
+		}
+
+		conn.Write(serverFrame(true, opClose, []byte{0x03, 0xE8, 'b', 'y', 'e'}))
+		op, payload, err := readClientFrame(br)
+		if err != nil || op != opClose || !bytes.Equal(payload, []byte{0x03, 0xE8}) {
+			t.Errorf("close reply = %#x %v, %v, want close 1000", op, payload, err)
+		}
+	})
+
+	conn, err := dialWebSocket(context.Background(), srv.Client(), srv.URL, noPrepare, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	for _, want := range [][]byte{[]byte("hello"), medium, large} {
+		msg, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(msg, want) {
+			t.Errorf("ReadMessage() = %d bytes %.10q, want %d bytes %.10q", len(msg), msg, len(want), want)
+		}
+	}
+	if err := conn.WriteMessage([]byte("hi")); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.WriteMessage(medium); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.ReadMessage(); err != io.EOF {
+		t.Errorf("ReadMessage() after close frame error = %v, want io.EOF", err)
+	}
+	<-done
+}
+
+func TestWebSocketRejectsInvalidFrames(t *testing.T) {
+	oversize := []byte{0x82, 127}
+	oversize = binary.BigEndian.AppendUint64(oversize, maxMessageSize+1)
+
+	// 每个分片都没有超过限制，但合起来超过
+	half := bytes.Repeat([]byte("x"), maxMessageSize/2+1)
+	fragmented := append(serverFrame(false, opText, half), serverFrame(true, opContinuation, half)...)
+
+	tests := []struct {
+		name  string
+		frame []byte
+		want  string
+	}{
+		{"oversize frame", oversize, "WebSocket 帧超过"},
+		{"oversize message", fragmented, "消息超过"},
+		{"unknown opcode", serverFrame(true, 0x3, nil), "未知的 WebSocket 帧类型"},
+		{"truncated frame", []byte{0x81, 10, 'a'}, "EOF"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := wsServer(t, func(conn net.Conn, br *bufio.Reader) {
+				conn.Write(tt.frame)
+			})
+			conn, err := dialWebSocket(context.Background(), srv.Client(), srv.URL, noPrepare, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			if _, err := conn.ReadMessage(); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("ReadMessage() error = %v, want containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+// signing 返回与上报器相同的签名函数和响应检查函数
+func signing(t *testing.T, token string) (*auth.Tokens, func(*http.Request, []byte) error, func(*http.Response) bool) {
+	t.Helper()
+	tokens, err := auth.NewTokens(token, "", t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := auth.NewSigner(tokens)
+	check := func(resp *http.Response) bool {
+		tokens.HandleResponse(resp)
+		_, skew := signer.CheckSkew(resp)
+		return skew
+	}
+	return tokens, signer.Sign, check
+}
+
+// rejectSkew 模拟时钟比本机快一小时的服务器，签名时间偏差过大时返回 clock_skew 并给出服务器时间
+func rejectSkew(w http.ResponseWriter, r *http.Request) bool {
+	serverTime := time.Now().Add(time.Hour)
+	signed, _ := strconv.ParseInt(r.Header.Get(auth.HeaderTimestamp), 10, 64)
+	if d := time.Duration(serverTime.Unix()-signed) * time.Second; d <= auth.MaxSkew && d >= -auth.MaxSkew {
+		return false
+	}
+	w.Header().Set(auth.HeaderError, auth.ErrorClockSkew)
+	w.Header().Set(auth.HeaderServerTime, strconv.FormatInt(serverTime.Unix(), 10))
+	w.WriteHeader(http.StatusUnauthorized)
+	return true
+}
+
+func TestDialWebSocketClockSkewAndRotation(t *testing.T) {
+	var handshakes int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handshakes++
+		if r.Header.Get("Authorization") != "Bearer old-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if rejectSkew(w, r) {
+			return
+		}
+		w.Header().Set(auth.HeaderRotateToken, "new-token")
+		conn, _ := upgrade(t, w, r, "")
+		conn.Close()
+	}))
+	defer srv.Close()
+
+	tokens, sign, check := signing(t, "old-token")
+	conn, err := dialWebSocket(context.Background(), srv.Client(), srv.URL, func(req *http.Request) error {
+		return sign(req, nil)
+	}, check)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if handshakes != 2 {
+		t.Errorf("handshakes = %d, want 2 (retry after clock skew)", handshakes)
+	}
+	if got := tokens.Token(); got != "new-token" {
+		t.Errorf("token after handshake = %q, want the rotated token", got)
+	}
+}
@@ -0,0 +1,509 @@
+package diagnose
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/xugou/agent/pkg/auth"
+	"github.com/xugou/agent/pkg/model"
+	"github.com/xugou/agent/pkg/proxy"
+	"github.com/xugou/agent/pkg/tlsconfig"
+	"github.com/xugou/agent/pkg/useragent"
+	"github.com/xugou/agent/pkg/utils"
+)
+
+// 各检查步骤失败时的退出码，安装脚本可以据此判断失败原因
+const (
+	ExitOK       = 0
+	ExitConfig   = 10 // 缺少服务器地址或令牌、URL 无效
+	ExitDNS      = 11 // 域名解析失败
+	ExitProxy    = 12 // 代理连接、CONNECT 或 SOCKS5 握手失败
+	ExitTCP      = 13 // 无法建立 TCP 连接
+	ExitTLS      = 14 // TLS 握手或证书校验失败
+	ExitAuth     = 15 // 令牌被服务器拒绝
+	ExitServer   = 16 // 服务器返回错误或无法识别的响应
+	ExitInternal = 1
+)
+
+// Options 连接检查的参数
+type Options struct {
+	ServerURL string
+	Tokens    *auth.Tokens // 上报使用的令牌，服务器在注册响应中轮换令牌时保存新令牌
+	ProxyURL  string
+	NoProxy   string
+	Timeout   time.Duration // 每个步骤的超时时间
+	Client    *http.Client  // 用于注册请求的客户端，应与上报时使用的客户端一致
+	TLSConfig *tls.Config   // TLS 握手使用的配置，为空时使用系统默认配置
+}
+
+// StepResult 单个检查步骤的结果
+type StepResult struct {
+	Name     string
+	Duration time.Duration
+	Detail   []string // 检查过程中获取到的信息，例如解析到的 IP、证书信息
+	Warnings []string // 不影响结果但需要关注的问题，例如证书即将过期
+	Err      error
+	Hint     string // 失败时的修复建议
+	ExitCode int
+	Skipped  bool
+}
+
+// Report 连接检查报告
+type Report struct {
+	Steps []*StepResult
+}
+
+// ExitCode 返回第一个失败步骤对应的退出码
+func (r *Report) ExitCode() int {
+	for _, s := range r.Steps {
+		if s.Err != nil {
+			return s.ExitCode
+		}
+	}
+	return ExitOK
+}
+
+// checker 保存检查过程中各步骤之间共享的状态
+type checker struct {
+	opts   Options
+	report *Report
+	server *url.URL
+	proxy  *url.URL
+	addrs  []string // 解析到的服务器地址
+	conn   net.Conn // 到服务器（或经代理到服务器）的连接
+}
+
+// Run 按顺序执行所有检查步骤，某一步失败后后续步骤会被跳过
+func Run(ctx context.Context, opts Options) *Report {
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	c := &checker{opts: opts, report: &Report{}}
+	defer func() {
+		if c.conn != nil {
+			c.conn.Close()
+		}
+	}()
+
+	steps := []struct {
+		name string
+		run  func(ctx context.Context, s *StepResult) error
+		skip func() bool
+	}{
+		{name: "解析服务器地址", run: c.parseURL},
+		{name: "DNS 解析", run: c.resolve, skip: func() bool { return c.proxy != nil }},
+		{name: "TCP 连接", run: c.dialServer, skip: func() bool { return c.proxy != nil }},
+		{name: "代理连接", run: c.dialProxy, skip: func() bool { return c.proxy == nil }},
+		{name: "TLS 握手", run: c.handshake, skip: func() bool { return c.server.Scheme != "https" }},
+		{name: "注册请求", run: c.register},
+	}
+
+	failed := false
+	for _, step := range steps {
+		result := &StepResult{Name: step.name}
+		c.report.Steps = append(c.report.Steps, result)
+		if failed || (step.skip != nil && step.skip()) {
+			result.Skipped = true
+			continue
+		}
+
+		stepCtx, cancel := context.WithTimeout(ctx, opts.Timeout)
+		start := time.Now()
+		result.Err = step.run(stepCtx, result)
+		result.Duration = time.Since(start)
+		cancel()
+
+		if result.Err != nil {
+			failed = true
+		}
+	}
+
+	return c.report
+}
+
+func (c *checker) fail(s *StepResult, code int, hint string, err error) error {
+	s.ExitCode = code
+	s.Hint = hint
+	return err
+}
+
+func (c *checker) parseURL(ctx context.Context, s *StepResult) error {
+	if c.opts.ServerURL == "" {
+		return c.fail(s, ExitConfig, "使用 --server 参数、XUGOU_SERVER 环境变量或配置文件设置服务器地址", errors.New("未设置服务器地址"))
+	}
+	if c.opts.Tokens == nil || c.opts.Tokens.Token() == "" {
+		return c.fail(s, ExitConfig, "使用 XUGOU_TOKEN 环境变量、配置文件中的 token 或 token_file 设置 API 令牌", errors.New("未设置 API 令牌"))
+	}
+
+	u, err := url.Parse(utils.NormalizeURL(c.opts.ServerURL))
+	if err != nil {
+		return c.fail(s, ExitConfig, "检查服务器地址的格式，例如 https://api.xugou.mdzz.uk", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return c.fail(s, ExitConfig, "服务器地址必须以 http:// 或 https:// 开头", fmt.Errorf("不支持的协议 %q", u.Scheme))
+	}
+	if u.Hostname() == "" {
+		return c.fail(s, ExitConfig, "检查服务器地址的格式，例如 https://api.xugou.mdzz.uk", errors.New("服务器地址缺少主机名"))
+	}
+	c.server = u
+	s.Detail = append(s.Detail, fmt.Sprintf("服务器: %s://%s", u.Scheme, serverHostPort(u)))
+
+	p, err := proxy.New(proxy.Config{URL: c.opts.ProxyURL, NoProxy: c.opts.NoProxy})
+	if err != nil {
+		return c.fail(s, ExitConfig, "检查代理地址的格式，例如 http://proxy.example.com:8080 或 socks5h://proxy.example.com:1080", err)
+	}
+	c.proxy = p.ForURL(u)
+	switch {
+	case c.proxy != nil && p.FromEnvironment():
+		s.Detail = append(s.Detail, fmt.Sprintf("代理: %s（来自环境变量）", c.proxy.Redacted()))
+	case c.proxy != nil:
+		s.Detail = append(s.Detail, fmt.Sprintf("代理: %s", c.proxy.Redacted()))
+	case p.Bypassed(u):
+		s.Detail = append(s.Detail, "代理: 服务器地址匹配 NO_PROXY 规则，直接连接")
+	}
+	return nil
+}
+
+func (c *checker) resolve(ctx context.Context, s *StepResult) error {
+	host := c.server.Hostname()
+	if ip := net.ParseIP(host); ip != nil {
+		c.addrs = []string{ip.String()}
+		s.Detail = append(s.Detail, "服务器地址是 IP，无需解析")
+		return nil
+	}
+
+	addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+	if err != nil {
+		return c.fail(s, ExitDNS, "检查 /etc/resolv.conf 中的 DNS 服务器是否可用，以及域名是否拼写正确", err)
+	}
+	c.addrs = addrs
+	s.Detail = append(s.Detail, "解析结果: "+strings.Join(addrs, ", "))
+	return nil
+}
+
+func (c *checker) dialServer(ctx context.Context, s *StepResult) error {
+	port := serverPort(c.server)
+	var lastErr error
+	dialer := &net.Dialer{}
+	for _, addr := range c.addrs {
+		conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(addr, port))
+		if err != nil {
+			lastErr = err
+			s.Warnings = append(s.Warnings, fmt.Sprintf("%s 连接失败: %v", addr, err))
+			continue
+		}
+		c.conn = conn
+		s.Detail = append(s.Detail, "已连接: "+conn.RemoteAddr().String())
+		return nil
+	}
+	return c.fail(s, ExitTCP, "检查防火墙是否放行出站的 "+port+" 端口，或服务器是否在线", lastErr)
+}
+
+func (c *checker) dialProxy(ctx context.Context, s *StepResult) error {
+	dialer := &net.Dialer{}
+	if proxy.IsSOCKS(c.proxy) {
+		return c.dialSOCKS(ctx, s, dialer)
+	}
+
+	conn, err := dialer.DialContext(ctx, "tcp", proxy.HostPort(c.proxy))
+	if err != nil {
+		return c.fail(s, ExitProxy, "检查代理服务器地址和端口是否正确、代理是否在线", err)
+	}
+	s.Detail = append(s.Detail, "已连接代理: "+conn.RemoteAddr().String())
+
+	if c.proxy.Scheme == "https" {
+		// 与上报使用的 Transport 一致，HTTPS 代理的证书按 tls 配置校验，使用同样的 CA 和客户端证书
+		tlsConfig := &tls.Config{}
+		if c.opts.TLSConfig != nil {
+			tlsConfig = c.opts.TLSConfig.Clone()
+		}
+		tlsConfig.ServerName = c.proxy.Hostname()
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			hint := "与代理服务器的 TLS 握手失败，检查代理是否支持 HTTPS，或改用 http:// 代理地址"
+			var unknownAuthority x509.UnknownAuthorityError
+			if errors.As(err, &unknownAuthority) {
+				hint = "代理服务器证书不是由受信任的 CA 签发，使用 tls.ca_file 指定签发代理证书的 CA"
+			}
+			return c.fail(s, ExitProxy, hint, err)
+		}
+		conn = tlsConn
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	target := serverHostPort(c.server)
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: target},
+		Host:   target,
+		Header: make(http.Header),
+	}
+	if c.proxy.User != nil {
+		password, _ := c.proxy.User.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(c.proxy.User.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return c.fail(s, ExitProxy, "代理服务器断开了连接，检查代理是否允许 CONNECT 请求", err)
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		conn.Close()
+		return c.fail(s, ExitProxy, "代理服务器没有返回有效的响应，检查代理类型是否为 HTTP 代理，SOCKS5 代理请使用 socks5:// 或 socks5h:// 地址", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		hint := "检查代理是否允许访问服务器地址和端口"
+		if resp.StatusCode == http.StatusProxyAuthRequired {
+			hint = "代理需要认证，使用 http://用户名:密码@代理地址 的格式设置 --proxy"
+		}
+		return c.fail(s, ExitProxy, hint, fmt.Errorf("代理返回 %s", resp.Status))
+	}
+
+	conn.SetDeadline(time.Time{})
+	c.conn = conn
+	s.Detail = append(s.Detail, "CONNECT "+target+" 成功")
+	return nil
+}
+
+// dialSOCKS 通过 SOCKS5 代理连接服务器
+func (c *checker) dialSOCKS(ctx context.Context, s *StepResult, dialer *net.Dialer) error {
+	target := serverHostPort(c.server)
+	conn, err := proxy.NewSOCKS5(c.proxy, dialer.DialContext).DialContext(ctx, "tcp", target)
+	if err != nil {
+		hint := "检查代理服务器地址和端口是否正确、代理是否允许访问服务器地址"
+		switch {
+		case errors.Is(err, proxy.ErrSOCKSAuth):
+			hint = "检查代理的用户名和密码，使用 socks5://用户名:密码@代理地址 的格式设置 --proxy"
+		case c.proxy.Scheme == "socks5":
+			hint += "；socks5 在本机解析域名，如果只有代理能解析服务器域名，请改用 socks5h://"
+		}
+		return c.fail(s, ExitProxy, hint, err)
+	}
+	c.conn = conn
+	s.Detail = append(s.Detail, "已连接代理: "+proxy.HostPort(c.proxy))
+	s.Detail = append(s.Detail, "SOCKS5 连接 "+target+" 成功")
+	return nil
+}
+
+func (c *checker) handshake(ctx context.Context, s *StepResult) error {
+	tlsConfig := &tls.Config{}
+	if c.opts.TLSConfig != nil {
+		tlsConfig = c.opts.TLSConfig.Clone()
+	}
+	tlsConfig.ServerName = c.server.Hostname()
+
+	tlsConn := tls.Client(c.conn, tlsConfig)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		hint := "检查服务器证书是否有效，以及系统时间是否正确"
+		if errors.Is(err, tlsconfig.ErrPinMismatch) {
+			hint = "服务器证书已更换或连接被拦截，确认新证书可信后更新 tls.pins"
+		}
+		if isClientCertError(err) {
+			hint = clientCertHint
+		}
+		var unknownAuthority x509.UnknownAuthorityError
+		if errors.As(err, &unknownAuthority) {
+			hint = "服务器证书不是由受信任的 CA 签发，使用 tls.ca_file 指定内部 CA 证书"
+		}
+		var hostnameErr x509.HostnameError
+		if errors.As(err, &hostnameErr) {
+			hint = "证书中的域名与服务器地址不匹配，检查 --server 使用的域名"
+		}
+		return c.fail(s, ExitTLS, hint, err)
+	}
+	c.conn = tlsConn
+
+	state := tlsConn.ConnectionState()
+	s.Detail = append(s.Detail, "TLS 版本: "+tls.VersionName(state.Version))
+	s.Detail = append(s.Detail, "加密套件: "+tls.CipherSuiteName(state.CipherSuite))
+	if len(state.PeerCertificates) > 0 {
+		cert := state.PeerCertificates[0]
+		daysLeft := int(time.Until(cert.NotAfter).Hours() / 24)
+		s.Detail = append(s.Detail,
+			"证书主题: "+cert.Subject.String(),
+			"证书颁发者: "+cert.Issuer.String(),
+			"证书域名: "+strings.Join(cert.DNSNames, ", "),
+			"证书指纹: sha256/"+tlsconfig.SPKIPin(cert),
+			fmt.Sprintf("有效期至: %s（剩余 %d 天）", cert.NotAfter.Format("2006-01-02"), daysLeft),
+		)
+		if daysLeft < 14 {
+			s.Warnings = append(s.Warnings, fmt.Sprintf("服务器证书将在 %d 天后过期", daysLeft))
+		}
+	}
+	return nil
+}
+
+const clientCertHint = "服务器要求双向 TLS，检查 tls.cert_file 和 tls.key_file 是否为服务器信任的客户端证书"
+
+// 服务器拒绝客户端证书时发送的 TLS 告警（RFC 8446 第 6.2 节）
+var clientCertAlerts = map[uint8]bool{
+	42:  true, // bad_certificate
+	43:  true, // unsupported_certificate
+	44:  true, // certificate_revoked
+	45:  true, // certificate_expired
+	46:  true, // certificate_unknown
+	48:  true, // unknown_ca
+	116: true, // certificate_required
+}
+
+// isClientCertError 判断是否为服务器拒绝客户端证书的错误
+func isClientCertError(err error) bool {
+	// 本机校验服务器证书失败时发出的告警不是服务器拒绝了客户端证书
+	var verifyErr *tls.CertificateVerificationError
+	if errors.As(err, &verifyErr) {
+		return false
+	}
+	code, ok := remoteAlert(err)
+	return ok && clientCertAlerts[code]
+}
+
+// remoteAlert 返回服务器发送的 TLS 告警。QUIC 等实现返回 tls.AlertError；TCP 上的 TLS 连接返回
+// Op 为 "remote error" 的 *net.OpError，其中的告警是 crypto/tls 未导出的 uint8 类型
+func remoteAlert(err error) (uint8, bool) {
+	var alertErr tls.AlertError
+	if errors.As(err, &alertErr) {
+		return uint8(alertErr), true
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "remote error" && opErr.Err != nil {
+		if v := reflect.ValueOf(opErr.Err); v.Kind() == reflect.Uint8 {
+			return uint8(v.Uint()), true
+		}
+	}
+	return 0, false
+}
+
+func (c *checker) register(ctx context.Context, s *StepResult) error {
+	// 前面的步骤建立的连接只用于检查链路，注册请求使用上报时的客户端重新连接
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+
+	hostname, _ := os.Hostname()
+	payload := &model.RegisterPayload{
+		Name:        hostname,
+		Hostname:    hostname,
+		IPAddresses: utils.GetLocalIPs(),
+		OS:          runtime.GOOS,
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return c.fail(s, ExitInternal, "", err)
+	}
+
+	registerURL := c.server.String() + "/api/agents/register"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, registerURL, bytes.NewReader(data))
+	if err != nil {
+		return c.fail(s, ExitInternal, "", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	useragent.Apply(req, 0)
+
+	client := c.opts.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := c.signedDo(client, req, data, s)
+	if err != nil {
+		// TLS 1.3 中服务器在握手完成后才校验客户端证书，错误会出现在第一个请求上
+		if isClientCertError(err) {
+			return c.fail(s, ExitTLS, clientCertHint, err)
+		}
+		return c.fail(s, ExitServer, "前面的步骤都已通过，检查服务器地址的路径是否正确，或稍后重试", err)
+	}
+	defer resp.Body.Close()
+
+	s.Detail = append(s.Detail, "HTTP 状态: "+resp.Status)
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return c.fail(s, ExitServer, "服务器响应中断，稍后重试", err)
+	}
+
+	if resp.StatusCode == http.StatusUpgradeRequired {
+		return c.fail(s, ExitServer, "服务器不再支持当前版本，运行 xugou-agent update 升级后重试", fmt.Errorf("服务器拒绝了版本 %s", useragent.Version()))
+	}
+
+	var respData model.RegisterResponse
+	if err := json.Unmarshal(body, &respData); err != nil {
+		if resp.StatusCode >= 500 {
+			return c.fail(s, ExitServer, "服务器内部错误，请联系管理员查看服务端日志", fmt.Errorf("服务器返回 %s", resp.Status))
+		}
+		return c.fail(s, ExitServer, "服务器返回的不是 Xugou 的响应，检查 --server 是否指向 Xugou 后端", fmt.Errorf("无法解析响应: %w", err))
+	}
+
+	if !respData.Success {
+		if resp.StatusCode < 500 {
+			return c.fail(s, ExitAuth, "在控制台重新生成 API 令牌，并使用 --token 更新配置", fmt.Errorf("服务器拒绝了注册: %s", respData.Message))
+		}
+		return c.fail(s, ExitServer, "服务器内部错误，请联系管理员查看服务端日志", fmt.Errorf("服务器返回 %s: %s", resp.Status, respData.Message))
+	}
+
+	s.Detail = append(s.Detail, fmt.Sprintf("客户端 ID: %d", respData.Agent.ID))
+	return nil
+}
+
+// signedDo 签名并发送请求，服务器提示时间偏差过大时与上报时一样按服务器时间重新签名一次
+func (c *checker) signedDo(client *http.Client, req *http.Request, body []byte, s *StepResult) (*http.Response, error) {
+	signer := auth.NewSigner(c.opts.Tokens)
+	for skewRetried := false; ; skewRetried = true {
+		if err := signer.Sign(req, body); err != nil {
+			return nil, err
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		// 与上报时一样保存服务器轮换的令牌，否则之后的请求仍使用已被替换的旧令牌
+		c.opts.Tokens.HandleResponse(resp)
+		if skewRetried {
+			return resp, nil
+		}
+		offset, ok := signer.CheckSkew(resp)
+		if !ok {
+			return resp, nil
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		s.Warnings = append(s.Warnings, fmt.Sprintf("本机时间与服务器相差 %s，超过了允许的 %s，Agent 会按服务器时间签名，建议检查 NTP 时间同步", offset.Round(time.Second), auth.MaxSkew))
+	}
+}
+
+func serverPort(u *url.URL) string {
+	if port := u.Port(); port != "" {
+		return port
+	}
+	if u.Scheme == "https" {
+		return "443"
+	}
+	return "80"
+}
+
+func serverHostPort(u *url.URL) string {
+	return net.JoinHostPort(u.Hostname(), serverPort(u))
+}
@@ -0,0 +1,149 @@
+package diagnose
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xugou/agent/pkg/auth"
+)
+
+// newMTLSServer 启动要求客户端证书的 HTTPS 服务器
+func newMTLSServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: x509.NewCertPool()}
+	srv.Config.ErrorLog = log.New(io.Discard, "", 0)
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// selfSignedCert 生成服务器不信任的客户端证书
+func selfSignedCert(t *testing.T) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "agent"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestIsClientCertError(t *testing.T) {
+	srv := newMTLSServer(t)
+	request := func(config *tls.Config) error {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+		resp, err := client.Get(srv.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	// TLS 1.2 在握手时拒绝不受信任的证书（bad_certificate），TLS 1.3 在握手完成后的第一个请求上
+	// 拒绝没有证书的客户端（certificate_required）
+	untrusted := []tls.Certificate{selfSignedCert(t)}
+	for _, config := range []*tls.Config{
+		{InsecureSkipVerify: true, MaxVersion: tls.VersionTLS12, Certificates: untrusted},
+		{InsecureSkipVerify: true, MinVersion: tls.VersionTLS13, Certificates: untrusted},
+		{InsecureSkipVerify: true, MinVersion: tls.VersionTLS13},
+	} {
+		err := request(config)
+		if err == nil {
+			t.Fatal("request without a trusted client certificate succeeded")
+		}
+		if !isClientCertError(err) {
+			t.Errorf("isClientCertError(%v) = false", err)
+		}
+	}
+
+	// 本机不信任服务器证书，不是客户端证书的问题
+	err := request(&tls.Config{})
+	if err == nil {
+		t.Fatal("request with untrusted server certificate succeeded")
+	}
+	if isClientCertError(err) {
+		t.Errorf("isClientCertError(%v) = true for a server certificate error", err)
+	}
+
+	for _, err := range []error{
+		errors.New("remote error: tls: bad certificate"),
+		tls.AlertError(80), // internal_error
+	} {
+		if isClientCertError(err) {
+			t.Errorf("isClientCertError(%v) = true", err)
+		}
+	}
+	if !isClientCertError(tls.AlertError(116)) {
+		t.Error("isClientCertError(certificate_required) = false")
+	}
+}
+
+func TestRegisterUsesPersistedTokens(t *testing.T) {
+	for _, name := range []string{"HTTP_PROXY", "http_proxy", "HTTPS_PROXY", "https_proxy", "ALL_PROXY", "all_proxy"} {
+		t.Setenv(name, "")
+	}
+
+	var authorizations []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorizations = append(authorizations, r.Header.Get("Authorization"))
+		// 第一次请求提示时间偏差，按服务器时间重新签名后下发新令牌
+		if len(authorizations) == 1 {
+			w.Header().Set(auth.HeaderError, auth.ErrorClockSkew)
+			w.Header().Set(auth.HeaderServerTime, strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set(auth.HeaderRotateToken, "rotated-token")
+		io.WriteString(w, `{"success": true, "agent": {"id": 7}}`)
+	}))
+	defer srv.Close()
+
+	tokens, err := auth.NewTokens("configured-token", "", t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := Run(context.Background(), Options{ServerURL: srv.URL, Tokens: tokens, Client: srv.Client()})
+	if code := report.ExitCode(); code != ExitOK {
+		for _, s := range report.Steps {
+			t.Logf("%s: %v", s.Name, s.Err)
+		}
+		t.Fatalf("ExitCode() = %d", code)
+	}
+
+	if len(authorizations) != 2 || authorizations[1] != "Bearer configured-token" {
+		t.Errorf("Authorization headers = %v", authorizations)
+	}
+	register := report.Steps[len(report.Steps)-1]
+	if len(register.Warnings) != 1 || !strings.Contains(register.Warnings[0], "NTP") {
+		t.Errorf("register warnings = %v, want a clock skew warning", register.Warnings)
+	}
+	if got := tokens.Token(); got != "rotated-token" {
+		t.Errorf("token after rotation = %q, want rotated-token", got)
+	}
+}
@@ -0,0 +1,191 @@
+package labels
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// EnvPrefix 以该前缀开头的环境变量会作为标签，例如 XUGOU_LABELS_ROLE=db 对应标签 role=db。
+// 不使用 XUGOU_LABEL_，因为 XUGOU_LABEL_FILES 是 label_files 配置项对应的环境变量
+const EnvPrefix = "XUGOU_LABELS_"
+
+// 标签数量和长度限制，避免元数据文件中的大量内容被上报
+const (
+	MaxLabels      = 64
+	MaxValueLength = 256
+)
+
+var keyPattern = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+// Load 合并各来源的标签，优先级从低到高为：files 中的元数据文件（按顺序，后面的覆盖前面的）、
+// 配置文件中的 labels、XUGOU_LABELS_* 环境变量。无法读取的文件和无效的标签会跳过并返回错误
+func Load(configured map[string]string, files []string, environ []string) (map[string]string, []error) {
+	result := make(map[string]string)
+	var errs []error
+
+	for _, pattern := range files {
+		paths, err := filepath.Glob(pattern)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("无效的文件路径 %q: %w", pattern, err))
+			continue
+		}
+		sort.Strings(paths)
+		for _, path := range paths {
+			values, err := ParseFile(path)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			errs = append(errs, merge(result, values)...)
+		}
+	}
+
+	errs = append(errs, merge(result, configured)...)
+
+	fromEnv := make(map[string]string)
+	for _, kv := range environ {
+		name, value, ok := strings.Cut(kv, "=")
+		if ok && strings.HasPrefix(name, EnvPrefix) && len(name) > len(EnvPrefix) {
+			fromEnv[name[len(EnvPrefix):]] = value
+		}
+	}
+	errs = append(errs, merge(result, fromEnv)...)
+
+	if len(result) > MaxLabels {
+		keys := Keys(result)
+		for _, key := range keys[MaxLabels:] {
+			delete(result, key)
+		}
+		errs = append(errs, fmt.Errorf("标签超过 %d 个，已忽略 %s 等 %d 个标签", MaxLabels, keys[MaxLabels], len(keys)-MaxLabels))
+	}
+	return result, errs
+}
+
+// merge 规范化标签名后写入 dst
+func merge(dst, src map[string]string) []error {
+	var errs []error
+	for key, value := range src {
+		name := Normalize(key)
+		if !keyPattern.MatchString(name) {
+			errs = append(errs, fmt.Errorf("无效的标签名 %q", key))
+			continue
+		}
+		value = strings.TrimSpace(value)
+		if len([]rune(value)) > MaxValueLength {
+			errs = append(errs, fmt.Errorf("标签 %s 的值超过 %d 个字符，已截断", name, MaxValueLength))
+			value = string([]rune(value)[:MaxValueLength])
+		}
+		dst[name] = value
+	}
+	return errs
+}
+
+// Normalize 把标签名转换为小写，点、横线、斜杠等字符替换为下划线，例如 Cloud.Region 对应 cloud_region
+func Normalize(key string) string {
+	key = strings.ToLower(strings.TrimSpace(key))
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '_' {
+			return r
+		}
+		return '_'
+	}, key)
+}
+
+// Validate 校验配置文件中的 labels，标签名只能包含字母、数字和下划线且不能以数字开头
+func Validate(raw map[string]interface{}) error {
+	for key, value := range raw {
+		if !keyPattern.MatchString(strings.ToLower(key)) {
+			return fmt.Errorf("无效的标签名 %q，只能包含字母、数字和下划线且不能以数字开头", key)
+		}
+		switch value.(type) {
+		case string, int, int64, uint64, float64, bool:
+		default:
+			return fmt.Errorf("标签 %s 的值必须是字符串", key)
+		}
+	}
+	if len(raw) > MaxLabels {
+		return fmt.Errorf("标签不能超过 %d 个", MaxLabels)
+	}
+	return nil
+}
+
+// ParseFile 读取元数据文件。.json 文件需要是 JSON 对象，嵌套的对象按 父_子 展开，数组会被忽略；
+// 其他文件按 KEY=VALUE 格式逐行解析（与 /etc/os-release 和 systemd EnvironmentFile 相同），支持 # 注释和引号
+func ParseFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取标签文件失败: %w", err)
+	}
+
+	values := make(map[string]string)
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		var obj map[string]interface{}
+		if err := json.Unmarshal(data, &obj); err != nil {
+			return nil, fmt.Errorf("标签文件 %s 不是 JSON 对象: %w", path, err)
+		}
+		flatten("", obj, values)
+		return values, nil
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(strings.TrimPrefix(line, "export "), "=")
+		if !ok {
+			return nil, fmt.Errorf("标签文件 %s 第 %d 行不是 KEY=VALUE 格式", path, n)
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		values[strings.TrimSpace(key)] = value
+	}
+	return values, scanner.Err()
+}
+
+func flatten(prefix string, obj map[string]interface{}, out map[string]string) {
+	for key, value := range obj {
+		name := key
+		if prefix != "" {
+			name = prefix + "_" + key
+		}
+		switch v := value.(type) {
+		case map[string]interface{}:
+			flatten(name, v, out)
+		case []interface{}, nil:
+		case string:
+			out[name] = v
+		default:
+			out[name] = fmt.Sprint(v)
+		}
+	}
+}
+
+// Keys 返回排序后的标签名
+func Keys(labels map[string]string) []string {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Format 把标签格式化为 key=value 列表，用于表格输出和日志
+func Format(labels map[string]string) string {
+	parts := make([]string, 0, len(labels))
+	for _, key := range Keys(labels) {
+		parts = append(parts, key+"="+labels[key])
+	}
+	return strings.Join(parts, ", ")
+}
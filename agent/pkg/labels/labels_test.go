@@ -0,0 +1,46 @@
+package labels
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "10-cloud.conf"), []byte("# cloud\nREGION=\"cn-east-1\"\nexport Zone='a'\nrole=web\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "20-tags.json"), []byte(`{"team": "ops", "k8s": {"node.pool": "gpu"}, "ids": [1, 2]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, errs := Load(
+		map[string]string{"role": "db", "Env": "prod"},
+		[]string{filepath.Join(dir, "*")},
+		[]string{
+			"XUGOU_LABELS_ENV=staging",
+			"XUGOU_LABELS_Cloud.Tier=gold",
+			// label_files 配置项对应的环境变量，不是标签
+			"XUGOU_LABEL_FILES=/etc/xugou-agent/labels.d/*.conf",
+			"XUGOU_LABELS_=ignored",
+			"PATH=/usr/bin",
+		},
+	)
+	if len(errs) != 0 {
+		t.Fatalf("Load() errors = %v", errs)
+	}
+	want := map[string]string{
+		"region":        "cn-east-1",
+		"zone":          "a",
+		"role":          "db",
+		"team":          "ops",
+		"k8s_node_pool": "gpu",
+		"env":           "staging",
+		"cloud_tier":    "gold",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Load() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,140 @@
+package localserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/xugou/agent/pkg/logger"
+	"github.com/xugou/agent/pkg/model"
+	"github.com/xugou/agent/pkg/output"
+	"github.com/xugou/agent/pkg/telemetry"
+)
+
+// AgentOptions Agent 本地接口使用的参数
+type AgentOptions struct {
+	Recorder       *telemetry.Recorder
+	Version        string
+	ServerURL      string
+	ConfigDigest   string                     // 生效配置的摘要，用于确认多台主机的配置是否一致
+	Interval       func() time.Duration       // 返回当前的采集和上报间隔，间隔可能被远程配置修改
+	ReadyIntervals int                        // 超过多少个间隔没有成功上报视为未就绪
+	Pprof          bool                       // 是否开启 /debug/pprof/
+	Alerts         func() []model.AlertEvent  // 返回正在触发的本地告警，可以为空
+	Probes         func() []model.ProbeResult // 返回每个本地探测最近一次的结果，可以为空
+}
+
+// Status /status 接口返回的内容
+type Status struct {
+	Version       string              `json:"version"`
+	PID           int                 `json:"pid"`
+	UptimeSeconds float64             `json:"uptime_seconds"`
+	Server        string              `json:"server"`
+	ConfigDigest  string              `json:"config_digest"`
+	Healthy       bool                `json:"healthy"`
+	Ready         bool                `json:"ready"`
+	State         telemetry.State     `json:"state"`
+	Alerts        []model.AlertEvent  `json:"firing_alerts"`
+	Probes        []model.ProbeResult `json:"probes,omitempty"`
+}
+
+// RegisterAgentHandlers 注册 /metrics、/healthz、/readyz、/status，按需注册 /debug/pprof/
+func (s *Server) RegisterAgentHandlers(opts AgentOptions) {
+	if opts.ReadyIntervals <= 0 {
+		opts.ReadyIntervals = 3
+	}
+
+	s.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		families := output.AgentTelemetryFamilies(opts.Recorder.Snapshot())
+		if opts.Probes != nil {
+			families = append(families, output.ProbeFamilies(opts.Probes())...)
+		}
+		output.WritePromFamilies(w, families)
+	})
+
+	s.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		if err := opts.healthy(opts.Recorder.State(), time.Now()); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+
+	s.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if err := opts.ready(opts.Recorder.State(), time.Now()); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+
+	s.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		now := time.Now()
+		state := opts.Recorder.State()
+		status := Status{
+			Version:       opts.Version,
+			PID:           os.Getpid(),
+			UptimeSeconds: now.Sub(state.StartedAt).Seconds(),
+			Server:        opts.ServerURL,
+			ConfigDigest:  opts.ConfigDigest,
+			Healthy:       opts.healthy(state, now) == nil,
+			Ready:         opts.ready(state, now) == nil,
+			State:         state,
+			Alerts:        []model.AlertEvent{},
+		}
+		if opts.Alerts != nil {
+			if alerts := opts.Alerts(); alerts != nil {
+				status.Alerts = alerts
+			}
+		}
+		if opts.Probes != nil {
+			status.Probes = opts.Probes()
+		}
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(status)
+	})
+
+	if opts.Pprof {
+		s.HandleFunc("/debug/pprof/", pprof.Index)
+		s.HandleFunc("/debug/pprof/cmdline", redactedCmdline)
+		s.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		s.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		s.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	}
+}
+
+// healthy 调度器在两个间隔内执行过采集任务即视为存活
+func (o AgentOptions) healthy(state telemetry.State, now time.Time) error {
+	if state.LastTick.IsZero() {
+		return fmt.Errorf("调度器尚未运行")
+	}
+	if since := now.Sub(state.LastTick); since > 2*o.Interval() {
+		return fmt.Errorf("调度器已经 %s 没有执行采集任务", since.Round(time.Second))
+	}
+	return nil
+}
+
+// ready 最近 ReadyIntervals 个间隔内成功上报过数据即视为就绪
+func (o AgentOptions) ready(state telemetry.State, now time.Time) error {
+	if state.LastReport.IsZero() {
+		return fmt.Errorf("尚未成功上报数据")
+	}
+	if since := now.Sub(state.LastReport); since > time.Duration(o.ReadyIntervals)*o.Interval() {
+		return fmt.Errorf("已经 %s 没有成功上报数据", since.Round(time.Second))
+	}
+	return nil
+}
+
+// redactedCmdline 与 pprof.Cmdline 相同，但会移除命令行中的令牌等敏感信息
+func redactedCmdline(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprint(w, strings.Join(logger.RedactArgs(os.Args), "\x00"))
+}
@@ -0,0 +1,185 @@
+package localserver
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/xugou/agent/pkg/logger"
+	"github.com/xugou/agent/pkg/model"
+	"github.com/xugou/agent/pkg/telemetry"
+)
+
+// startAgentServer 注册 Agent 接口并启动测试服务器，interval 为当前的采集间隔
+func startAgentServer(t *testing.T, opts AgentOptions, interval *atomic.Int64) *httptest.Server {
+	t.Helper()
+	opts.Interval = func() time.Duration { return time.Duration(interval.Load()) }
+	s := New("127.0.0.1:0")
+	s.RegisterAgentHandlers(opts)
+	srv := httptest.NewServer(s.mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// get 请求 path，返回状态码和响应内容
+func get(t *testing.T, srv *httptest.Server, path string) (int, string) {
+	t.Helper()
+	resp, err := srv.Client().Get(srv.URL + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(body)
+}
+
+func TestHealthzAndReadyz(t *testing.T) {
+	rec := telemetry.New()
+	var interval atomic.Int64
+	interval.Store(int64(time.Hour))
+	srv := startAgentServer(t, AgentOptions{Recorder: rec, ReadyIntervals: 10}, &interval)
+
+	// 调度器运行并成功上报之前既不存活也不就绪
+	if code, body := get(t, srv, "/healthz"); code != http.StatusServiceUnavailable || !strings.Contains(body, "尚未运行") {
+		t.Errorf("/healthz before the first tick = %d %q", code, body)
+	}
+	if code, _ := get(t, srv, "/readyz"); code != http.StatusServiceUnavailable {
+		t.Errorf("/readyz before the first report = %d", code)
+	}
+
+	rec.Tick()
+	if code, body := get(t, srv, "/healthz"); code != http.StatusOK || body != "ok\n" {
+		t.Errorf("/healthz after a tick = %d %q", code, body)
+	}
+	if code, _ := get(t, srv, "/readyz"); code != http.StatusServiceUnavailable {
+		t.Errorf("/readyz before the first report = %d", code)
+	}
+	rec.ReportSucceeded()
+	if code, _ := get(t, srv, "/readyz"); code != http.StatusOK {
+		t.Errorf("/readyz after a report = %d", code)
+	}
+
+	// 超过两个间隔没有执行采集时不再存活，超过 ReadyIntervals 个间隔没有上报时才不再就绪
+	time.Sleep(100 * time.Millisecond)
+	interval.Store(int64(40 * time.Millisecond))
+	if code, body := get(t, srv, "/healthz"); code != http.StatusServiceUnavailable || !strings.Contains(body, "没有执行采集任务") {
+		t.Errorf("/healthz after 2 intervals = %d %q", code, body)
+	}
+	if code, _ := get(t, srv, "/readyz"); code != http.StatusOK {
+		t.Errorf("/readyz within 10 intervals = %d", code)
+	}
+	interval.Store(int64(time.Nanosecond))
+	if code, body := get(t, srv, "/readyz"); code != http.StatusServiceUnavailable || !strings.Contains(body, "没有成功上报数据") {
+		t.Errorf("/readyz after 10 intervals = %d %q", code, body)
+	}
+
+	// 间隔被远程配置延长后立即恢复
+	interval.Store(int64(time.Hour))
+	if code, _ := get(t, srv, "/healthz"); code != http.StatusOK {
+		t.Errorf("/healthz after the interval grew = %d", code)
+	}
+}
+
+func TestHealthyAndReadyBoundaries(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 0, 0, 0, time.UTC)
+	opts := AgentOptions{Interval: func() time.Duration { return time.Minute }, ReadyIntervals: 3}
+
+	tests := []struct {
+		since   time.Duration
+		healthy bool
+		ready   bool
+	}{
+		{0, true, true},
+		{2 * time.Minute, true, true},
+		{2*time.Minute + time.Second, false, true},
+		{3 * time.Minute, false, true},
+		{3*time.Minute + time.Second, false, false},
+	}
+	for _, tt := range tests {
+		state := telemetry.State{LastTick: now.Add(-tt.since), LastReport: now.Add(-tt.since)}
+		if err := opts.healthy(state, now); (err == nil) != tt.healthy {
+			t.Errorf("%s since the last tick: healthy() = %v", tt.since, err)
+		}
+		if err := opts.ready(state, now); (err == nil) != tt.ready {
+			t.Errorf("%s since the last report: ready() = %v", tt.since, err)
+		}
+	}
+}
+
+func TestStatus(t *testing.T) {
+	rec := telemetry.New()
+	rec.Tick()
+	rec.SetRegistered(true)
+	var interval atomic.Int64
+	interval.Store(int64(time.Minute))
+	probes := []model.ProbeResult{{Name: "web", Status: model.ProbeUp}}
+	srv := startAgentServer(t, AgentOptions{
+		Recorder:     rec,
+		Version:      "1.2.3",
+		ServerURL:    "https://xugou.example.com",
+		ConfigDigest: "abc",
+		Alerts:       func() []model.AlertEvent { return nil },
+		Probes:       func() []model.ProbeResult { return probes },
+	}, &interval)
+
+	code, body := get(t, srv, "/status")
+	if code != http.StatusOK {
+		t.Fatalf("/status = %d %q", code, body)
+	}
+	var status Status
+	if err := json.Unmarshal([]byte(body), &status); err != nil {
+		t.Fatal(err)
+	}
+	if status.Version != "1.2.3" || status.PID != os.Getpid() || status.ConfigDigest != "abc" || !status.Healthy || status.Ready || !status.State.Registered {
+		t.Errorf("status = %+v", status)
+	}
+	// 没有告警时返回空列表而不是 null
+	if !strings.Contains(body, `"firing_alerts": []`) || len(status.Probes) != 1 {
+		t.Errorf("/status = %s", body)
+	}
+
+	if code, body := get(t, srv, "/metrics"); code != http.StatusOK || !strings.Contains(body, "xugou_agent_") {
+		t.Errorf("/metrics = %d %q", code, body)
+	}
+}
+
+func TestPprof(t *testing.T) {
+	var interval atomic.Int64
+	interval.Store(int64(time.Minute))
+
+	// 默认不注册 pprof
+	srv := startAgentServer(t, AgentOptions{Recorder: telemetry.New()}, &interval)
+	for _, path := range []string{"/debug/pprof/", "/debug/pprof/cmdline", "/debug/pprof/heap"} {
+		if code, _ := get(t, srv, path); code != http.StatusNotFound {
+			t.Errorf("%s without pprof = %d, want 404", path, code)
+		}
+	}
+
+	srv = startAgentServer(t, AgentOptions{Recorder: telemetry.New(), Pprof: true}, &interval)
+	if code, body := get(t, srv, "/debug/pprof/"); code != http.StatusOK || !strings.Contains(body, "goroutine") {
+		t.Errorf("/debug/pprof/ = %d", code)
+	}
+	if code, _ := get(t, srv, "/debug/pprof/heap"); code != http.StatusOK {
+		t.Errorf("/debug/pprof/heap = %d", code)
+	}
+
+	// 命令行中的令牌和代理密码被移除
+	secret := "secret-" + time.Now().Format("150405.000000000")
+	args := os.Args
+	defer func() { os.Args = args }()
+	os.Args = []string{"xugou-agent", "start", "--token", secret, "--proxy=http://alice:" + secret + "@proxy:8080", "--interval", "60"}
+	code, body := get(t, srv, "/debug/pprof/cmdline")
+	want := strings.Join([]string{"xugou-agent", "start", "--token", logger.Redacted, "--proxy=http://alice:" + logger.Redacted + "@proxy:8080", "--interval", "60"}, "\x00")
+	if code != http.StatusOK || body != want {
+		t.Errorf("/debug/pprof/cmdline = %d %q, want %q", code, body, want)
+	}
+}
@@ -0,0 +1,105 @@
+package localserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Server Agent 的本地 HTTP 服务，用于暴露指标等只供本机使用的接口
+type Server struct {
+	addr     string
+	mux      *http.ServeMux
+	srv      *http.Server
+	listener net.Listener
+}
+
+// New 创建本地 HTTP 服务，addr 为监听地址，例如 127.0.0.1:9100 或 unix:/run/xugou-agent/agent.sock
+func New(addr string) *Server {
+	mux := http.NewServeMux()
+	return &Server{
+		addr: addr,
+		mux:  mux,
+		srv: &http.Server{
+			Handler:           mux,
+			ReadHeaderTimeout: 5 * time.Second,
+		},
+	}
+}
+
+// Handle 注册处理函数，需要在 Start 之前调用
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+// HandleFunc 注册处理函数，需要在 Start 之前调用
+func (s *Server) HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request)) {
+	s.mux.HandleFunc(pattern, handler)
+}
+
+// Start 开始监听并在后台处理请求，监听失败时直接返回错误
+func (s *Server) Start() error {
+	ln, err := listen(s.addr)
+	if err != nil {
+		return fmt.Errorf("本地服务监听 %s 失败: %w", s.addr, err)
+	}
+	s.listener = ln
+
+	go func() {
+		if err := s.srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("本地服务异常退出", "error", err)
+		}
+	}()
+	slog.Info("本地服务已启动", "listen", ln.Addr().String())
+	return nil
+}
+
+// listen 监听 TCP 地址或 unix:/path 形式的 unix socket
+func listen(addr string) (net.Listener, error) {
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		// 清理上次异常退出时残留的 socket 文件
+		if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+			os.Remove(path)
+		}
+		ln, err := net.Listen("unix", path)
+		if err != nil {
+			return nil, err
+		}
+		// 只允许运行用户和同组用户访问
+		if err := os.Chmod(path, 0660); err != nil {
+			ln.Close()
+			return nil, err
+		}
+		return ln, nil
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		if ip := net.ParseIP(host); (ip == nil && host != "localhost") || (ip != nil && !ip.IsLoopback()) {
+			slog.Warn("本地服务没有绑定到回环地址，其他主机也可以访问", "listen", ln.Addr().String())
+		}
+	}
+	return ln, nil
+}
+
+// ListenAddr 返回实际监听的地址，未启动时返回配置的地址
+func (s *Server) ListenAddr() string {
+	if s.listener != nil {
+		return s.listener.Addr().String()
+	}
+	return s.addr
+}
+
+// Shutdown 停止服务并等待正在处理的请求结束，unix socket 文件会被删除
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.srv.Shutdown(ctx)
+}
@@ -0,0 +1,71 @@
+package logger
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Options 日志配置
+type Options struct {
+	Level      string // debug、info、warn 或 error
+	Format     string // text 或 json
+	File       string // 日志文件路径，为空时输出到标准错误
+	MaxSizeMB  int    // 单个日志文件的最大大小（MB）
+	MaxBackups int    // 保留的历史日志文件数量
+}
+
+var closer io.Closer
+
+// Setup 按配置初始化全局日志，所有输出都会经过脱敏处理
+func Setup(opts Options) error {
+	level, err := ParseLevel(opts.Level)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stderr
+	if opts.File != "" {
+		f, err := openRotatingFile(opts.File, opts.MaxSizeMB, opts.MaxBackups)
+		if err != nil {
+			return err
+		}
+		if closer != nil {
+			closer.Close()
+		}
+		closer = f
+		w = f
+	}
+
+	handlerOpts := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	switch strings.ToLower(opts.Format) {
+	case "", "text":
+		handler = slog.NewTextHandler(w, handlerOpts)
+	case "json":
+		handler = slog.NewJSONHandler(w, handlerOpts)
+	default:
+		return fmt.Errorf("不支持的日志格式 %q，可选 text 或 json", opts.Format)
+	}
+
+	// SetDefault 同时会把标准库 log 的输出转到这个 handler，第三方库的日志也会被脱敏
+	slog.SetDefault(slog.New(&redactHandler{next: handler}))
+	return nil
+}
+
+// ParseLevel 解析日志级别
+func ParseLevel(level string) (slog.Level, error) {
+	switch strings.ToLower(level) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return slog.LevelInfo, fmt.Errorf("不支持的日志级别 %q，可选 debug、info、warn 或 error", level)
+}
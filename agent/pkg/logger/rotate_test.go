@@ -0,0 +1,85 @@
+package logger
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "agent.log")
+	r, err := openRotatingFile(path, 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	line := strings.Repeat("x", 400<<10) + "\n"
+	for i := 0; i < 8; i++ {
+		if _, err := r.Write([]byte(line)); err != nil {
+			t.Fatalf("write %d: %v", i, err)
+		}
+	}
+
+	// 每个文件最多容纳两行，超过保留数量的历史文件被删除
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		info, err := os.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() != int64(2*len(line)) {
+			t.Errorf("%s size = %d, want %d", name, info.Size(), 2*len(line))
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("%s.3 exists: %v", path, err)
+	}
+}
+
+func TestRotatingFileReopen(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "agent.log")
+	r, err := openRotatingFile(path, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if _, err := r.Write([]byte("first\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	// 日志路径被目录占用时轮转后无法打开新文件，写入返回错误而不是写入已关闭的文件
+	r.size = r.maxSize
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(path, "busy"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Write([]byte("lost\n")); err == nil {
+		t.Fatal("Write succeeded without a log file")
+	}
+	if _, err := r.Write([]byte("lost\n")); err == nil {
+		t.Fatal("Write succeeded without a log file")
+	}
+
+	// 问题解决后下次写入重新打开文件
+	if err := os.RemoveAll(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Write([]byte("second\n")); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "second\n" {
+		t.Errorf("log file = %q, want second", data)
+	}
+
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Write([]byte("closed\n")); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("Write after Close error = %v, want %v", err, os.ErrClosed)
+	}
+}
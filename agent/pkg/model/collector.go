@@ -1,22 +1,37 @@
 package model
 
-import "time"
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// SchemaVersion 上报数据的结构版本，字段发生不兼容的变化时递增
+const SchemaVersion = 1
 
 // SystemInfo 包含系统的各种信息
 type SystemInfo struct {
-	Token       string        `json:"token"`
-	Timestamp   time.Time     `json:"timestamp"`
-	Hostname    string        `json:"hostname"`
-	Platform    string        `json:"platform"`
-	OS          string        `json:"os"`
-	Version     string        `json:"version"`      // 操作系统版本
-	IPAddresses []string      `json:"ip_addresses"` // IP地址列表
-	Keepalive   int           `json:"keepalive"`
-	CPUInfo     CPUInfo       `json:"cpu"`
-	MemoryInfo  MemoryInfo    `json:"memory"`
-	DiskInfo    []DiskInfo    `json:"disks"`
-	NetworkInfo []NetworkInfo `json:"network"`
-	LoadInfo    LoadInfo      `json:"load"`
+	SchemaVersion int               `json:"schema_version"`   // 数据结构版本，见 SchemaVersion
+	Labels        map[string]string `json:"labels,omitempty"` // 用户定义的主机标签，例如 env=prod、role=db
+	Timestamp     time.Time         `json:"timestamp"`
+	Hostname      string            `json:"hostname"`
+	Platform      string            `json:"platform"`
+	OS            string            `json:"os"`
+	Version       string            `json:"version"`      // 操作系统版本
+	IPAddresses   []string          `json:"ip_addresses"` // IP地址列表
+	Keepalive     int               `json:"keepalive"`
+	ConfigVersion string            `json:"config_version,omitempty"` // 生效的远程配置版本，没有使用远程配置时为空
+	CPUInfo       CPUInfo           `json:"cpu"`
+	MemoryInfo    MemoryInfo        `json:"memory"`
+	DiskInfo      []DiskInfo        `json:"disks"`
+	NetworkInfo   []NetworkInfo     `json:"network"`
+	LoadInfo      LoadInfo          `json:"load"`
+	Agent         *AgentTelemetry   `json:"agent,omitempty"`          // Agent 自身的运行指标
+	Alerts        []AlertEvent      `json:"alerts,omitempty"`         // 本地规则产生的告警事件
+	Probes        []ProbeResult     `json:"probes,omitempty"`         // 本地探测的结果
+	CustomMetrics []CustomMetric    `json:"custom_metrics,omitempty"` // 其他程序提供的自定义指标
+	Textfiles     []TextfileStatus  `json:"textfiles,omitempty"`      // 自定义指标文本文件的读取结果
+	Statsd        *StatsdReport     `json:"statsd,omitempty"`         // 本地 StatsD 监听器在上报间隔内的聚合结果
 }
 
 // CPUInfo 包含CPU相关信息
@@ -31,6 +46,7 @@ type MemoryInfo struct {
 	Total     uint64  `json:"total"`
 	Used      uint64  `json:"used"`
 	Free      uint64  `json:"free"`
+	Available uint64  `json:"available"` // 可分配给新进程的内存，包括可回收的缓存
 	UsageRate float64 `json:"usage_rate"`
 }
 
@@ -60,3 +76,252 @@ type LoadInfo struct {
 	Load5  float64 `json:"load5"`
 	Load15 float64 `json:"load15"`
 }
+
+// 告警状态
+const (
+	AlertFiring   = "firing"
+	AlertResolved = "resolved"
+)
+
+// ProbeResult 一次本地探测的结果，status、response_time、status_code 和 error 与服务器的监控状态历史一致
+type ProbeResult struct {
+	MonitorID    int           `json:"monitor_id,omitempty"` // 对应的服务器监控 ID，未设置时为 0
+	Name         string        `json:"name"`
+	Type         string        `json:"type"`
+	Target       string        `json:"target"`
+	Status       string        `json:"status"` // up 或 down
+	Timestamp    time.Time     `json:"timestamp"`
+	ResponseTime int64         `json:"response_time"`         // 毫秒
+	StatusCode   int           `json:"status_code,omitempty"` // HTTP 状态码，没有收到响应时为 0
+	Error        string        `json:"error,omitempty"`
+	ErrorType    string        `json:"error_type,omitempty"` // 失败原因的分类，例如 timeout、refused、dns、status
+	Timings      *ProbeTimings `json:"timings,omitempty"`
+
+	// 证书检查的结果
+	Certificates []CertificateInfo `json:"certificates,omitempty"`
+	CertDaysLeft *float64          `json:"cert_days_left,omitempty"` // 检查的证书中最早到期的剩余天数
+
+	// ICMP 探测的统计
+	Ping *PingStats `json:"ping,omitempty"`
+
+	// Nagios 插件的执行结果
+	Plugin *PluginResult `json:"plugin,omitempty"`
+}
+
+// Nagios 插件的状态，与退出码 0 到 3 对应
+const (
+	PluginOK       = "OK"
+	PluginWarning  = "WARNING"
+	PluginCritical = "CRITICAL"
+	PluginUnknown  = "UNKNOWN"
+)
+
+// PluginResult Nagios 插件的执行结果
+type PluginResult struct {
+	ExitCode   int        `json:"exit_code"`
+	State      string     `json:"state"`                 // OK、WARNING、CRITICAL 或 UNKNOWN
+	Output     string     `json:"output"`                // 第一行输出，不包含性能数据
+	LongOutput string     `json:"long_output,omitempty"` // 其余行的输出
+	Perfdata   []PerfData `json:"perfdata,omitempty"`
+	Truncated  bool       `json:"truncated,omitempty"` // 输出超过 max_output 被截断
+}
+
+// PerfData 插件输出的一项性能数据，格式为 label=value[UOM];warn;crit;min;max
+type PerfData struct {
+	Label string   `json:"label"`
+	Value float64  `json:"value"`
+	UOM   string   `json:"uom,omitempty"`  // 单位，例如 s、ms、%、B、KB、c
+	Warn  string   `json:"warn,omitempty"` // 告警范围，例如 10、10:20、@5:10
+	Crit  string   `json:"crit,omitempty"`
+	Min   *float64 `json:"min,omitempty"`
+	Max   *float64 `json:"max,omitempty"`
+}
+
+// PingStats 一次 ICMP 探测中发送的所有包的统计，往返时间只统计收到回复的包
+type PingStats struct {
+	Sent     int     `json:"sent"`
+	Received int     `json:"received"`
+	Loss     float64 `json:"loss"` // 丢包率（%）
+	MinMs    float64 `json:"min_ms"`
+	AvgMs    float64 `json:"avg_ms"`
+	MaxMs    float64 `json:"max_ms"`
+	StddevMs float64 `json:"stddev_ms"`
+	JitterMs float64 `json:"jitter_ms"` // 相邻两个回复的往返时间之差的平均值
+}
+
+// CertificateInfo 一张证书的检查结果，链中的中间证书只用于校验，不单独列出
+type CertificateInfo struct {
+	Source             string    `json:"source"` // 证书来源：host:port 或文件路径
+	Subject            string    `json:"subject"`
+	Issuer             string    `json:"issuer"`
+	SANs               []string  `json:"sans,omitempty"`
+	SerialNumber       string    `json:"serial_number"`
+	NotBefore          time.Time `json:"not_before"`
+	NotAfter           time.Time `json:"not_after"`
+	DaysLeft           float64   `json:"days_left"` // 距离到期的天数，已过期时为负数
+	ChainValid         bool      `json:"chain_valid"`
+	ChainError         string    `json:"chain_error,omitempty"`
+	KeyAlgorithm       string    `json:"key_algorithm"`       // 例如 RSA-2048、ECDSA-P256、Ed25519
+	SignatureAlgorithm string    `json:"signature_algorithm"` // 例如 SHA256-RSA
+	Warnings           []string  `json:"warnings,omitempty"`  // 弱密钥、弱签名算法、即将到期等问题
+}
+
+// ProbeTimings 探测各阶段的耗时（毫秒），没有经过的阶段为 0
+type ProbeTimings struct {
+	DNSMs     float64 `json:"dns_ms"`
+	ConnectMs float64 `json:"connect_ms"`
+	TLSMs     float64 `json:"tls_ms"`
+	TTFBMs    float64 `json:"ttfb_ms"` // 从发出请求到收到第一个字节
+	TotalMs   float64 `json:"total_ms"`
+}
+
+// CustomMetric 其他程序提供的自定义指标，例如批处理任务写入文本文件的备份大小
+type CustomMetric struct {
+	Name   string            `json:"name"`
+	Type   string            `json:"type"` // counter、gauge、histogram、summary 或 untyped
+	Labels map[string]string `json:"labels,omitempty"`
+	Value  float64           `json:"value"`
+	Source string            `json:"source"` // 指标的来源，文本文件为文件名
+}
+
+// Series 返回指标名称和按名称排序的标签，例如 queue_length{queue=mail}，没有标签时只有名称
+func (m CustomMetric) Series() string {
+	if len(m.Labels) == 0 {
+		return m.Name
+	}
+	names := make([]string, 0, len(m.Labels))
+	for name := range m.Labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pairs := make([]string, 0, len(names))
+	for _, name := range names {
+		pairs = append(pairs, name+"="+m.Labels[name])
+	}
+	return m.Name + "{" + strings.Join(pairs, ",") + "}"
+}
+
+// TextfileStatus 一个自定义指标文本文件的读取结果
+type TextfileStatus struct {
+	File       string    `json:"file"`
+	ModTime    time.Time `json:"mtime"`
+	AgeSeconds float64   `json:"age_seconds"`     // 距离最后一次修改的秒数
+	Stale      bool      `json:"stale"`           // 超过 textfile.max_age 没有更新，其中的指标仍然上报
+	Metrics    int       `json:"metrics"`         // 读取到的指标数量
+	Error      string    `json:"error,omitempty"` // 读取或校验失败的原因，失败时文件中的指标都不上报
+}
+
+// StatsdReport 本地 StatsD 监听器在一个上报间隔内收到的指标的聚合结果
+type StatsdReport struct {
+	IntervalSeconds float64        `json:"interval_seconds"` // 聚合的时间长度
+	Packets         uint64         `json:"packets"`          // 收到的数据包数量
+	Samples         uint64         `json:"samples"`          // 解析成功的指标行数
+	Errors          uint64         `json:"errors"`           // 无法解析的行数
+	Dropped         uint64         `json:"dropped"`          // 序列数量达到 statsd.max_series 后被丢弃的行数
+	Metrics         []StatsdMetric `json:"metrics"`
+}
+
+// StatsdMetric 一个 StatsD 序列（名称、类型和标签相同的指标）的聚合结果
+type StatsdMetric struct {
+	Name    string            `json:"name"`
+	Type    string            `json:"type"` // counter、gauge、timer、histogram、distribution 或 set
+	Tags    map[string]string `json:"tags,omitempty"`
+	Value   float64           `json:"value"`             // counter 为累计值，gauge 为最后的值，set 为不同值的数量，其他类型为样本数量，均已按采样率换算
+	Rate    float64           `json:"rate,omitempty"`    // counter、timer、histogram 和 distribution 每秒的次数
+	Summary *StatsdSummary    `json:"summary,omitempty"` // timer、histogram 和 distribution 的统计值
+}
+
+// StatsdSummary 计时器等分布类指标的统计值，百分位根据最多 1000 个抽样计算
+type StatsdSummary struct {
+	Min         float64            `json:"min"`
+	Max         float64            `json:"max"`
+	Mean        float64            `json:"mean"`
+	Sum         float64            `json:"sum"`
+	Percentiles map[string]float64 `json:"percentiles,omitempty"` // 例如 p50、p99、p99_9
+	Buckets     map[string]float64 `json:"buckets,omitempty"`     // 不超过每个上界的累计样本数量，例如 le_100、le_0_5
+}
+
+// Series 返回指标名称和按名称排序的标签，例如 api.requests{route=/login}，没有值的标签只有名称
+func (m StatsdMetric) Series() string {
+	if len(m.Tags) == 0 {
+		return m.Name
+	}
+	names := make([]string, 0, len(m.Tags))
+	for name := range m.Tags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pairs := make([]string, 0, len(names))
+	for _, name := range names {
+		if value := m.Tags[name]; value != "" {
+			pairs = append(pairs, name+"="+value)
+		} else {
+			pairs = append(pairs, name)
+		}
+	}
+	return m.Name + "{" + strings.Join(pairs, ",") + "}"
+}
+
+// 探测状态
+const (
+	ProbeUp   = "up"
+	ProbeDown = "down"
+)
+
+// AlertEvent Agent 本地规则触发或恢复时产生的告警事件
+type AlertEvent struct {
+	Rule        string    `json:"rule"`
+	Instance    string    `json:"instance,omitempty"` // 通配规则匹配到的实例，例如挂载点或网卡名称
+	Severity    string    `json:"severity"`
+	State       string    `json:"state"` // firing 或 resolved
+	Expr        string    `json:"expr"`
+	Value       float64   `json:"value"`
+	Threshold   float64   `json:"threshold"`
+	Description string    `json:"description,omitempty"`
+	Since       time.Time `json:"since"` // 条件开始满足的时间
+	Time        time.Time `json:"time"`  // 事件产生的时间
+}
+
+// AgentTelemetry Agent 自身的运行指标
+type AgentTelemetry struct {
+	Version        string           `json:"version"`
+	UptimeSeconds  float64          `json:"uptime_seconds"`
+	Collectors     []CollectorStats `json:"collectors"`
+	Requests       []RequestStats   `json:"requests"`
+	InFlight       int64            `json:"reports_in_flight"` // 正在采集或上报、还没有完成的任务数
+	SpoolDepth     int64            `json:"spool_depth"`       // 缓存中等待上报（包括上报失败后等待重发）的探测结果数
+	DroppedSamples uint64           `json:"dropped_samples"`   // 上报失败被丢弃的采集数据条数
+	Process        ProcessStats     `json:"process"`
+}
+
+// CollectorStats 单个采集步骤的累计耗时和错误次数
+type CollectorStats struct {
+	Name           string  `json:"name"`
+	Runs           uint64  `json:"runs"`
+	Errors         uint64  `json:"errors"`
+	LastDurationMs float64 `json:"last_duration_ms"`
+	AvgDurationMs  float64 `json:"avg_duration_ms"`
+	LastError      string  `json:"last_error,omitempty"`
+}
+
+// RequestStats 发往服务器的某类请求的延迟、状态码和重试次数
+type RequestStats struct {
+	Endpoint      string            `json:"endpoint"`
+	Requests      uint64            `json:"requests"`
+	Errors        uint64            `json:"errors"`
+	Retries       uint64            `json:"retries"`
+	LastLatencyMs float64           `json:"last_latency_ms"`
+	AvgLatencyMs  float64           `json:"avg_latency_ms"`
+	StatusCodes   map[string]uint64 `json:"status_codes"` // 按状态码统计，网络错误记为 "error"
+	LastError     string            `json:"last_error,omitempty"`
+}
+
+// ProcessStats Agent 进程的资源占用
+type ProcessStats struct {
+	Goroutines     int     `json:"goroutines"`
+	RSSBytes       uint64  `json:"rss_bytes"`
+	HeapAllocBytes uint64  `json:"heap_alloc_bytes"`
+	HeapSysBytes   uint64  `json:"heap_sys_bytes"`
+	GCCount        uint32  `json:"gc_count"`
+	GCPauseTotalMs float64 `json:"gc_pause_total_ms"`
+}
@@ -2,6 +2,8 @@ package model
 
 import (
 	"net/http"
+
+	"github.com/xugou/agent/pkg/auth"
 )
 
 // HTTPReporter 是基于HTTP的数据上报器实现
@@ -10,12 +12,14 @@ type HTTPReporter struct {
 	ApiToken   string
 	ProxyURL   string
 	Client     *http.Client
+	Tokens     *auth.Tokens // 当前使用的令牌，服务器轮换令牌后自动切换
+	Signer     *auth.Signer // 为每个请求添加令牌和签名
 	Registered bool
+	AgentID    int // 注册后服务器分配的客户端 ID
 }
 
 // RegisterPayload 定义注册到后端的数据结构
 type RegisterPayload struct {
-	Token       string   `json:"token"`        // API令牌
 	Name        string   `json:"name"`         // 客户端名称
 	Hostname    string   `json:"hostname"`     // 主机名
 	IPAddresses []string `json:"ip_addresses"` // IP地址列表
@@ -31,3 +35,10 @@ type RegisterResponse struct {
 		ID int `json:"id"`
 	} `json:"agent"`
 }
+
+// RemoteConfig 服务器下发的远程配置
+type RemoteConfig struct {
+	Version string                 `json:"version"`        // 配置版本，会随上报数据发回服务器
+	Config  map[string]interface{} `json:"config"`         // 配置内容，键名与配置文件相同
+	ETag    string                 `json:"etag,omitempty"` // 响应的 ETag，下次拉取时通过 If-None-Match 发送
+}
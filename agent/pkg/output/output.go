@@ -0,0 +1,196 @@
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/xugou/agent/pkg/collector"
+	"github.com/xugou/agent/pkg/labels"
+	"github.com/xugou/agent/pkg/model"
+)
+
+// 支持的输出格式
+const (
+	FormatJSON       = "json"
+	FormatTable      = "table"
+	FormatPrometheus = "prometheus"
+)
+
+// Formats 所有支持的输出格式
+var Formats = []string{FormatJSON, FormatTable, FormatPrometheus}
+
+// StepReport 单个采集步骤的结果，用于 JSON 输出
+type StepReport struct {
+	Name       string  `json:"name"`
+	DurationMS float64 `json:"duration_ms"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// Write 按指定格式输出采集结果
+func Write(w io.Writer, format string, info *model.SystemInfo, results []collector.StepResult) error {
+	switch format {
+	case FormatJSON:
+		return WriteJSON(w, info, results)
+	case FormatTable:
+		return WriteTable(w, info, results)
+	case FormatPrometheus:
+		return WritePrometheus(w, info, results)
+	}
+	return fmt.Errorf("不支持的输出格式 %q，可选: %s", format, strings.Join(Formats, ", "))
+}
+
+// WriteJSON 以格式化的 JSON 输出系统信息和每个采集步骤的耗时
+func WriteJSON(w io.Writer, info *model.SystemInfo, results []collector.StepResult) error {
+	steps := make([]StepReport, 0, len(results))
+	for _, r := range results {
+		report := StepReport{
+			Name:       r.Name,
+			DurationMS: float64(r.Duration.Microseconds()) / 1000,
+		}
+		if r.Err != nil {
+			report.Error = r.Err.Error()
+		}
+		steps = append(steps, report)
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(struct {
+		SystemInfo *model.SystemInfo `json:"system_info"`
+		Collectors []StepReport      `json:"collectors"`
+	}{info, steps})
+}
+
+// WriteTable 以便于阅读的表格输出系统信息
+func WriteTable(w io.Writer, info *model.SystemInfo, results []collector.StepResult) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(tw, "== 主机 ==")
+	fmt.Fprintf(tw, "主机名\t%s\n", info.Hostname)
+	fmt.Fprintf(tw, "操作系统\t%s\n", info.OS)
+	fmt.Fprintf(tw, "版本\t%s\n", info.Version)
+	fmt.Fprintf(tw, "IP地址\t%s\n", strings.Join(info.IPAddresses, ", "))
+	fmt.Fprintf(tw, "采集时间\t%s\n", info.Timestamp.Format("2006-01-02 15:04:05"))
+	if len(info.Labels) > 0 {
+		fmt.Fprintf(tw, "标签\t%s\n", labels.Format(info.Labels))
+	}
+
+	fmt.Fprintln(tw, "\n== CPU / 内存 / 负载 ==")
+	fmt.Fprintf(tw, "CPU\t%.2f%%\t%d 核\t%s\n", info.CPUInfo.Usage, info.CPUInfo.Cores, info.CPUInfo.ModelName)
+	fmt.Fprintf(tw, "内存\t%.2f%%\t已用 %s / 共 %s\n", info.MemoryInfo.UsageRate, formatBytes(info.MemoryInfo.Used), formatBytes(info.MemoryInfo.Total))
+	fmt.Fprintf(tw, "负载\t%.2f\t%.2f\t%.2f\n", info.LoadInfo.Load1, info.LoadInfo.Load5, info.LoadInfo.Load15)
+
+	fmt.Fprintln(tw, "\n== 磁盘 ==")
+	fmt.Fprintln(tw, "设备\t挂载点\t文件系统\t使用率\t已用\t总量")
+	for _, d := range info.DiskInfo {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%.2f%%\t%s\t%s\n", d.Device, d.MountPoint, d.FSType, d.UsageRate, formatBytes(d.Used), formatBytes(d.Total))
+	}
+
+	fmt.Fprintln(tw, "\n== 网络 ==")
+	fmt.Fprintln(tw, "接口\t发送\t接收\t发送包\t接收包")
+	for _, n := range info.NetworkInfo {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\n", n.Interface, formatBytes(n.BytesSent), formatBytes(n.BytesRecv), n.PacketsSent, n.PacketsRecv)
+	}
+
+	writeCustomMetricTable(tw, info)
+
+	fmt.Fprintln(tw, "\n== 采集步骤 ==")
+	fmt.Fprintln(tw, "步骤\t耗时\t结果")
+	for _, r := range results {
+		status := "成功"
+		if r.Err != nil {
+			status = "失败: " + r.Err.Error()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", r.Name, r.Duration.Round(time.Microsecond), status)
+	}
+
+	return tw.Flush()
+}
+
+// WritePrometheus 以 Prometheus 文本格式输出系统信息
+func WritePrometheus(w io.Writer, info *model.SystemInfo, results []collector.StepResult) error {
+	return WritePromFamilies(w, SystemInfoFamilies(info, results))
+}
+
+// SystemInfoFamilies 把系统信息转换为 Prometheus 指标
+func SystemInfoFamilies(info *model.SystemInfo, results []collector.StepResult) []PromFamily {
+	families := []PromFamily{
+		Gauge("xugou_host_info", "主机信息", 1, map[string]string{
+			"hostname": info.Hostname,
+			"os":       info.OS,
+			"platform": info.Platform,
+			"version":  info.Version,
+		}),
+		Gauge("xugou_cpu_usage_percent", "CPU 使用率", info.CPUInfo.Usage, nil),
+		Gauge("xugou_cpu_cores", "CPU 核心数", float64(info.CPUInfo.Cores), nil),
+		Gauge("xugou_memory_total_bytes", "内存总量", float64(info.MemoryInfo.Total), nil),
+		Gauge("xugou_memory_used_bytes", "已用内存", float64(info.MemoryInfo.Used), nil),
+		Gauge("xugou_memory_free_bytes", "空闲内存", float64(info.MemoryInfo.Free), nil),
+		Gauge("xugou_memory_available_bytes", "可用内存", float64(info.MemoryInfo.Available), nil),
+		Gauge("xugou_memory_usage_percent", "内存使用率", info.MemoryInfo.UsageRate, nil),
+		Gauge("xugou_load1", "1 分钟平均负载", info.LoadInfo.Load1, nil),
+		Gauge("xugou_load5", "5 分钟平均负载", info.LoadInfo.Load5, nil),
+		Gauge("xugou_load15", "15 分钟平均负载", info.LoadInfo.Load15, nil),
+	}
+	if len(info.Labels) > 0 {
+		families = append(families, Gauge("xugou_host_labels", "用户定义的主机标签", 1, info.Labels))
+	}
+
+	diskTotal := PromFamily{Name: "xugou_disk_total_bytes", Type: "gauge", Help: "磁盘总量"}
+	diskUsed := PromFamily{Name: "xugou_disk_used_bytes", Type: "gauge", Help: "磁盘已用空间"}
+	diskFree := PromFamily{Name: "xugou_disk_free_bytes", Type: "gauge", Help: "磁盘可用空间"}
+	diskUsage := PromFamily{Name: "xugou_disk_usage_percent", Type: "gauge", Help: "磁盘使用率"}
+	for _, d := range info.DiskInfo {
+		labels := map[string]string{"device": d.Device, "mountpoint": d.MountPoint, "fstype": d.FSType}
+		diskTotal.Samples = append(diskTotal.Samples, PromSample{Labels: labels, Value: float64(d.Total)})
+		diskUsed.Samples = append(diskUsed.Samples, PromSample{Labels: labels, Value: float64(d.Used)})
+		diskFree.Samples = append(diskFree.Samples, PromSample{Labels: labels, Value: float64(d.Free)})
+		diskUsage.Samples = append(diskUsage.Samples, PromSample{Labels: labels, Value: d.UsageRate})
+	}
+
+	bytesSent := PromFamily{Name: "xugou_network_sent_bytes_total", Type: "counter", Help: "网络发送字节数"}
+	bytesRecv := PromFamily{Name: "xugou_network_received_bytes_total", Type: "counter", Help: "网络接收字节数"}
+	packetsSent := PromFamily{Name: "xugou_network_sent_packets_total", Type: "counter", Help: "网络发送包数"}
+	packetsRecv := PromFamily{Name: "xugou_network_received_packets_total", Type: "counter", Help: "网络接收包数"}
+	for _, n := range info.NetworkInfo {
+		labels := map[string]string{"interface": n.Interface}
+		bytesSent.Samples = append(bytesSent.Samples, PromSample{Labels: labels, Value: float64(n.BytesSent)})
+		bytesRecv.Samples = append(bytesRecv.Samples, PromSample{Labels: labels, Value: float64(n.BytesRecv)})
+		packetsSent.Samples = append(packetsSent.Samples, PromSample{Labels: labels, Value: float64(n.PacketsSent)})
+		packetsRecv.Samples = append(packetsRecv.Samples, PromSample{Labels: labels, Value: float64(n.PacketsRecv)})
+	}
+
+	stepDuration := PromFamily{Name: "xugou_collector_duration_seconds", Type: "gauge", Help: "采集步骤耗时"}
+	stepSuccess := PromFamily{Name: "xugou_collector_success", Type: "gauge", Help: "采集步骤是否成功"}
+	for _, r := range results {
+		labels := map[string]string{"collector": r.Name}
+		success := 1.0
+		if r.Err != nil {
+			success = 0
+		}
+		stepDuration.Samples = append(stepDuration.Samples, PromSample{Labels: labels, Value: r.Duration.Seconds()})
+		stepSuccess.Samples = append(stepSuccess.Samples, PromSample{Labels: labels, Value: success})
+	}
+
+	families = append(families, diskTotal, diskUsed, diskFree, diskUsage,
+		bytesSent, bytesRecv, packetsSent, packetsRecv, stepDuration, stepSuccess)
+	return append(families, CustomMetricFamilies(info)...)
+}
+
+// formatBytes 把字节数格式化为便于阅读的单位
+func formatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
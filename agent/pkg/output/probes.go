@@ -0,0 +1,205 @@
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/xugou/agent/pkg/model"
+)
+
+// WriteProbes 按指定格式输出探测结果
+func WriteProbes(w io.Writer, format string, results []model.ProbeResult) error {
+	switch format {
+	case FormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(results)
+	case FormatTable:
+		return writeProbeTable(w, results)
+	case FormatPrometheus:
+		return WritePromFamilies(w, ProbeFamilies(results))
+	}
+	return fmt.Errorf("不支持的输出格式 %q，可选: %s", format, strings.Join(Formats, ", "))
+}
+
+func writeProbeTable(w io.Writer, results []model.ProbeResult) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "名称\t类型\t目标\t状态\t响应时间\t状态码\tDNS\t连接\tTLS\tTTFB\t错误")
+	for _, r := range results {
+		code := "-"
+		if r.StatusCode != 0 {
+			code = strconv.Itoa(r.StatusCode)
+		}
+		dns, connect, tls, ttfb := "-", "-", "-", "-"
+		if t := r.Timings; t != nil {
+			dns, connect, tls, ttfb = formatMs(t.DNSMs), formatMs(t.ConnectMs), formatMs(t.TLSMs), formatMs(t.TTFBMs)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%dms\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			r.Name, r.Type, r.Target, r.Status, r.ResponseTime, code, dns, connect, tls, ttfb, r.Error)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if err := writePingTable(w, results); err != nil {
+		return err
+	}
+	if err := writePluginTable(w, results); err != nil {
+		return err
+	}
+	return writeCertificateTable(w, results)
+}
+
+// writePluginTable 在探测结果后面列出 Nagios 插件的输出和性能数据，没有插件时不输出
+func writePluginTable(w io.Writer, results []model.ProbeResult) error {
+	var tw *tabwriter.Writer
+	for _, r := range results {
+		p := r.Plugin
+		if p == nil {
+			continue
+		}
+		if tw == nil {
+			fmt.Fprintln(w)
+			tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(tw, "名称\t状态\t退出码\t输出\t性能数据")
+		}
+		perf := make([]string, 0, len(p.Perfdata))
+		for _, pd := range p.Perfdata {
+			perf = append(perf, pd.Label+"="+strconv.FormatFloat(pd.Value, 'f', -1, 64)+pd.UOM)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\n", r.Name, p.State, p.ExitCode, p.Output, strings.Join(perf, " "))
+	}
+	if tw == nil {
+		return nil
+	}
+	return tw.Flush()
+}
+
+// writePingTable 在探测结果后面列出 ICMP 探测的统计，没有 ICMP 探测时不输出
+func writePingTable(w io.Writer, results []model.ProbeResult) error {
+	var tw *tabwriter.Writer
+	for _, r := range results {
+		p := r.Ping
+		if p == nil {
+			continue
+		}
+		if tw == nil {
+			fmt.Fprintln(w)
+			tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(tw, "名称\t目标\t发送\t接收\t丢包率\t最小\t平均\t最大\t标准差\t抖动")
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%s%%\t%s\t%s\t%s\t%s\t%s\n",
+			r.Name, r.Target, p.Sent, p.Received, strconv.FormatFloat(p.Loss, 'f', -1, 64),
+			formatMs(p.MinMs), formatMs(p.AvgMs), formatMs(p.MaxMs), formatMs(p.StddevMs), formatMs(p.JitterMs))
+	}
+	if tw == nil {
+		return nil
+	}
+	return tw.Flush()
+}
+
+// writeCertificateTable 在探测结果后面列出证书检查的详细信息，没有证书时不输出
+func writeCertificateTable(w io.Writer, results []model.ProbeResult) error {
+	var tw *tabwriter.Writer
+	for _, r := range results {
+		for _, c := range r.Certificates {
+			if tw == nil {
+				fmt.Fprintln(w)
+				tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+				fmt.Fprintln(tw, "名称\t来源\t主题\tSAN\t颁发者\t到期时间\t剩余天数\t密钥\t证书链\t警告")
+			}
+			chain := "有效"
+			if !c.ChainValid {
+				chain = "无效"
+			}
+			sans := "-"
+			if len(c.SANs) > 0 {
+				sans = strings.Join(c.SANs, ",")
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%.1f\t%s\t%s\t%s\n",
+				r.Name, c.Source, c.Subject, sans, c.Issuer, c.NotAfter.Format("2006-01-02"), c.DaysLeft, c.KeyAlgorithm, chain, strings.Join(c.Warnings, "; "))
+		}
+	}
+	if tw == nil {
+		return nil
+	}
+	return tw.Flush()
+}
+
+func formatMs(ms float64) string {
+	return strconv.FormatFloat(ms, 'f', 1, 64) + "ms"
+}
+
+// ProbeFamilies 把探测结果转换为 Prometheus 指标
+func ProbeFamilies(results []model.ProbeResult) []PromFamily {
+	up := PromFamily{Name: "xugou_probe_up", Type: "gauge", Help: "探测是否成功"}
+	duration := PromFamily{Name: "xugou_probe_duration_seconds", Type: "gauge", Help: "探测的响应时间"}
+	phases := PromFamily{Name: "xugou_probe_phase_duration_seconds", Type: "gauge", Help: "探测各阶段的耗时"}
+	status := PromFamily{Name: "xugou_probe_status_code", Type: "gauge", Help: "HTTP 探测返回的状态码"}
+	daysLeft := PromFamily{Name: "xugou_probe_cert_days_left", Type: "gauge", Help: "证书距离到期的天数"}
+	chainValid := PromFamily{Name: "xugou_probe_cert_chain_valid", Type: "gauge", Help: "证书链是否校验通过"}
+	loss := PromFamily{Name: "xugou_probe_ping_loss_ratio", Type: "gauge", Help: "ICMP 探测的丢包率（0 到 1）"}
+	rtt := PromFamily{Name: "xugou_probe_ping_rtt_seconds", Type: "gauge", Help: "ICMP 探测的往返时间统计"}
+	pluginState := PromFamily{Name: "xugou_probe_plugin_exit_code", Type: "gauge", Help: "Nagios 插件的退出码，0 到 3 分别为 OK、WARNING、CRITICAL、UNKNOWN"}
+	perfdata := PromFamily{Name: "xugou_probe_perfdata", Type: "gauge", Help: "Nagios 插件输出的性能数据，单位见 uom 标签"}
+	for _, r := range results {
+		labels := map[string]string{"probe": r.Name, "type": r.Type, "target": r.Target}
+		value := 0.0
+		if r.Status == model.ProbeUp {
+			value = 1
+		}
+		up.Samples = append(up.Samples, PromSample{Labels: labels, Value: value})
+		duration.Samples = append(duration.Samples, PromSample{Labels: labels, Value: float64(r.ResponseTime) / 1000})
+		if r.StatusCode != 0 {
+			status.Samples = append(status.Samples, PromSample{Labels: labels, Value: float64(r.StatusCode)})
+		}
+		if t := r.Timings; t != nil {
+			for _, phase := range []struct {
+				name string
+				ms   float64
+			}{{"dns", t.DNSMs}, {"connect", t.ConnectMs}, {"tls", t.TLSMs}, {"ttfb", t.TTFBMs}} {
+				phases.Samples = append(phases.Samples, PromSample{
+					Labels: map[string]string{"probe": r.Name, "type": r.Type, "target": r.Target, "phase": phase.name},
+					Value:  phase.ms / 1000,
+				})
+			}
+		}
+		for _, c := range r.Certificates {
+			certLabels := map[string]string{"probe": r.Name, "type": r.Type, "target": r.Target, "source": c.Source}
+			valid := 0.0
+			if c.ChainValid {
+				valid = 1
+			}
+			daysLeft.Samples = append(daysLeft.Samples, PromSample{Labels: certLabels, Value: c.DaysLeft})
+			chainValid.Samples = append(chainValid.Samples, PromSample{Labels: certLabels, Value: valid})
+		}
+		if p := r.Ping; p != nil {
+			loss.Samples = append(loss.Samples, PromSample{Labels: labels, Value: p.Loss / 100})
+			if p.Received > 0 {
+				for _, stat := range []struct {
+					name string
+					ms   float64
+				}{{"min", p.MinMs}, {"avg", p.AvgMs}, {"max", p.MaxMs}, {"stddev", p.StddevMs}, {"jitter", p.JitterMs}} {
+					rtt.Samples = append(rtt.Samples, PromSample{
+						Labels: map[string]string{"probe": r.Name, "type": r.Type, "target": r.Target, "stat": stat.name},
+						Value:  stat.ms / 1000,
+					})
+				}
+			}
+		}
+		if p := r.Plugin; p != nil {
+			pluginState.Samples = append(pluginState.Samples, PromSample{Labels: labels, Value: float64(p.ExitCode)})
+			for _, pd := range p.Perfdata {
+				perfdata.Samples = append(perfdata.Samples, PromSample{
+					Labels: map[string]string{"probe": r.Name, "type": r.Type, "target": r.Target, "label": pd.Label, "uom": pd.UOM},
+					Value:  pd.Value,
+				})
+			}
+		}
+	}
+
+	return []PromFamily{up, duration, phases, status, daysLeft, chainValid, loss, rtt, pluginState, perfdata}
+}
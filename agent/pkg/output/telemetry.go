@@ -0,0 +1,67 @@
+package output
+
+import (
+	"io"
+	"sort"
+
+	"github.com/xugou/agent/pkg/model"
+)
+
+// WriteAgentTelemetry 以 Prometheus 文本格式输出 Agent 自身的运行指标
+func WriteAgentTelemetry(w io.Writer, t *model.AgentTelemetry) error {
+	return WritePromFamilies(w, AgentTelemetryFamilies(t))
+}
+
+// AgentTelemetryFamilies 把 Agent 自身的运行指标转换为 Prometheus 指标
+func AgentTelemetryFamilies(t *model.AgentTelemetry) []PromFamily {
+	families := []PromFamily{
+		Gauge("xugou_agent_info", "Agent 版本信息", 1, map[string]string{"version": t.Version}),
+		Gauge("xugou_agent_uptime_seconds", "Agent 运行时长", t.UptimeSeconds, nil),
+		Gauge("xugou_agent_reports_in_flight", "正在采集或上报、还没有完成的任务数", float64(t.InFlight), nil),
+		Gauge("xugou_agent_spool_depth", "缓存中等待上报的探测结果数", float64(t.SpoolDepth), nil),
+		{Name: "xugou_agent_dropped_samples_total", Type: "counter", Help: "上报失败被丢弃的采集数据条数",
+			Samples: []PromSample{{Value: float64(t.DroppedSamples)}}},
+		Gauge("xugou_agent_goroutines", "goroutine 数量", float64(t.Process.Goroutines), nil),
+		Gauge("xugou_agent_resident_memory_bytes", "进程常驻内存", float64(t.Process.RSSBytes), nil),
+		Gauge("xugou_agent_heap_alloc_bytes", "已分配的堆内存", float64(t.Process.HeapAllocBytes), nil),
+		Gauge("xugou_agent_heap_sys_bytes", "从系统申请的堆内存", float64(t.Process.HeapSysBytes), nil),
+		{Name: "xugou_agent_gc_total", Type: "counter", Help: "GC 次数",
+			Samples: []PromSample{{Value: float64(t.Process.GCCount)}}},
+		{Name: "xugou_agent_gc_pause_seconds_total", Type: "counter", Help: "GC 暂停总时长",
+			Samples: []PromSample{{Value: t.Process.GCPauseTotalMs / 1000}}},
+	}
+
+	runs := PromFamily{Name: "xugou_agent_collector_runs_total", Type: "counter", Help: "采集步骤执行次数"}
+	errs := PromFamily{Name: "xugou_agent_collector_errors_total", Type: "counter", Help: "采集步骤失败次数"}
+	duration := PromFamily{Name: "xugou_agent_collector_last_duration_seconds", Type: "gauge", Help: "采集步骤最近一次耗时"}
+	for _, c := range t.Collectors {
+		labels := map[string]string{"collector": c.Name}
+		runs.Samples = append(runs.Samples, PromSample{Labels: labels, Value: float64(c.Runs)})
+		errs.Samples = append(errs.Samples, PromSample{Labels: labels, Value: float64(c.Errors)})
+		duration.Samples = append(duration.Samples, PromSample{Labels: labels, Value: c.LastDurationMs / 1000})
+	}
+
+	requests := PromFamily{Name: "xugou_agent_requests_total", Type: "counter", Help: "发往服务器的请求数，按状态码统计"}
+	reqErrs := PromFamily{Name: "xugou_agent_request_errors_total", Type: "counter", Help: "失败的请求数"}
+	retries := PromFamily{Name: "xugou_agent_request_retries_total", Type: "counter", Help: "请求重试次数"}
+	latency := PromFamily{Name: "xugou_agent_request_last_latency_seconds", Type: "gauge", Help: "最近一次请求的往返时间"}
+	for _, r := range t.Requests {
+		labels := map[string]string{"endpoint": r.Endpoint}
+		codes := make([]string, 0, len(r.StatusCodes))
+		for code := range r.StatusCodes {
+			codes = append(codes, code)
+		}
+		sort.Strings(codes)
+		for _, code := range codes {
+			requests.Samples = append(requests.Samples, PromSample{
+				Labels: map[string]string{"endpoint": r.Endpoint, "code": code},
+				Value:  float64(r.StatusCodes[code]),
+			})
+		}
+		reqErrs.Samples = append(reqErrs.Samples, PromSample{Labels: labels, Value: float64(r.Errors)})
+		retries.Samples = append(retries.Samples, PromSample{Labels: labels, Value: float64(r.Retries)})
+		latency.Samples = append(latency.Samples, PromSample{Labels: labels, Value: r.LastLatencyMs / 1000})
+	}
+
+	return append(families, runs, errs, duration, requests, reqErrs, retries, latency)
+}
@@ -0,0 +1,78 @@
+package output
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/xugou/agent/pkg/model"
+)
+
+// writeCustomMetricTable 列出自定义指标和文本文件的读取结果，没有时不输出
+func writeCustomMetricTable(w io.Writer, info *model.SystemInfo) {
+	if len(info.CustomMetrics) > 0 {
+		fmt.Fprintln(w, "\n== 自定义指标 ==")
+		fmt.Fprintln(w, "指标\t类型\t值\t来源")
+		for _, m := range info.CustomMetrics {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", m.Series(), m.Type, strconv.FormatFloat(m.Value, 'f', -1, 64), m.Source)
+		}
+	}
+	if len(info.Textfiles) > 0 {
+		fmt.Fprintln(w, "\n== 自定义指标文件 ==")
+		fmt.Fprintln(w, "文件\t修改时间\t指标数\t状态")
+		for _, f := range info.Textfiles {
+			status := "正常"
+			switch {
+			case f.Error != "":
+				status = "错误: " + f.Error
+			case f.Stale:
+				status = fmt.Sprintf("过期（%.0f 秒没有更新）", f.AgeSeconds)
+			}
+			mtime := "-"
+			if !f.ModTime.IsZero() {
+				mtime = f.ModTime.Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(w, "%s\t%s\t%d\t%s\n", f.File, mtime, f.Metrics, status)
+		}
+	}
+}
+
+// CustomMetricFamilies 把自定义指标和文本文件的状态转换为 Prometheus 指标，自定义指标保持原来的名称，
+// histogram 和 summary 的各个样本按 untyped 输出
+func CustomMetricFamilies(info *model.SystemInfo) []PromFamily {
+	var families []PromFamily
+	index := make(map[string]int)
+	for _, m := range info.CustomMetrics {
+		i, ok := index[m.Name]
+		if !ok {
+			typ := m.Type
+			if typ != "counter" && typ != "gauge" {
+				typ = "untyped"
+			}
+			i = len(families)
+			index[m.Name] = i
+			families = append(families, PromFamily{Name: m.Name, Type: typ})
+		}
+		families[i].Samples = append(families[i].Samples, PromSample{Labels: m.Labels, Value: m.Value})
+	}
+
+	mtime := PromFamily{Name: "xugou_textfile_mtime_seconds", Type: "gauge", Help: "自定义指标文件的修改时间"}
+	failed := PromFamily{Name: "xugou_textfile_error", Type: "gauge", Help: "自定义指标文件是否读取或校验失败"}
+	stale := PromFamily{Name: "xugou_textfile_stale", Type: "gauge", Help: "自定义指标文件是否超过 textfile.max_age 没有更新"}
+	for _, f := range info.Textfiles {
+		labels := map[string]string{"file": f.File}
+		if !f.ModTime.IsZero() {
+			mtime.Samples = append(mtime.Samples, PromSample{Labels: labels, Value: float64(f.ModTime.UnixMilli()) / 1000})
+		}
+		failed.Samples = append(failed.Samples, PromSample{Labels: labels, Value: boolValue(f.Error != "")})
+		stale.Samples = append(stale.Samples, PromSample{Labels: labels, Value: boolValue(f.Stale)})
+	}
+	return append(families, mtime, failed, stale)
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,428 @@
+package probes
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"math"
+	"net"
+	"net/http/httptrace"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xugou/agent/pkg/model"
+	"github.com/xugou/agent/pkg/rules"
+)
+
+// 默认的证书到期告警阈值（天）
+const (
+	DefaultWarnDays     = 30
+	DefaultCriticalDays = 7
+)
+
+// certExtensions 检查目录时读取的证书文件扩展名
+var certExtensions = map[string]bool{".pem": true, ".crt": true, ".cer": true, ".cert": true}
+
+// weakSignatures 不再安全的签名算法
+var weakSignatures = map[x509.SignatureAlgorithm]bool{
+	x509.MD2WithRSA:    true,
+	x509.MD5WithRSA:    true,
+	x509.SHA1WithRSA:   true,
+	x509.DSAWithSHA1:   true,
+	x509.DSAWithSHA256: true,
+	x509.ECDSAWithSHA1: true,
+}
+
+// certChecker 校验证书链并检查有效期、密钥和签名算法
+type certChecker struct {
+	roots    *x509.CertPool // 为空时使用系统的 CA
+	warnDays int
+
+	mu         sync.Mutex
+	lastExpiry time.Time // 最近一次检查到的最早到期时间
+}
+
+func newCertChecker(c Config) (*certChecker, error) {
+	warnDays, criticalDays, err := c.expiryDays()
+	if err != nil {
+		return nil, err
+	}
+	if warnDays > 0 && criticalDays > warnDays {
+		return nil, fmt.Errorf("critical_days %d 不能大于 warn_days %d", criticalDays, warnDays)
+	}
+	checker := &certChecker{warnDays: warnDays}
+	if c.CAFile != "" {
+		data, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("读取 ca_file 失败: %w", err)
+		}
+		checker.roots = x509.NewCertPool()
+		if !checker.roots.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("ca_file %s 中没有有效的 PEM 证书", c.CAFile)
+		}
+	}
+	return checker, nil
+}
+
+// expiryDays 返回到期告警的阈值，设置为 0 表示不产生对应的告警
+func (c Config) expiryDays() (warn, critical int, err error) {
+	warn, critical = DefaultWarnDays, DefaultCriticalDays
+	if c.WarnDays != nil {
+		warn = *c.WarnDays
+	}
+	if c.CriticalDays != nil {
+		critical = *c.CriticalDays
+	}
+	if warn < 0 || critical < 0 {
+		return 0, 0, fmt.Errorf("warn_days 和 critical_days 不能为负数")
+	}
+	return warn, critical, nil
+}
+
+// inspect 检查证书链中的第一张证书，其余证书作为中间证书参与校验
+func (ck *certChecker) inspect(source string, chain []*x509.Certificate, dnsName string, usage x509.ExtKeyUsage, now time.Time) model.CertificateInfo {
+	leaf := chain[0]
+	info := model.CertificateInfo{
+		Source:             source,
+		Subject:            leaf.Subject.String(),
+		Issuer:             leaf.Issuer.String(),
+		SANs:               subjectAltNames(leaf),
+		SerialNumber:       leaf.SerialNumber.Text(16),
+		NotBefore:          leaf.NotBefore,
+		NotAfter:           leaf.NotAfter,
+		DaysLeft:           math.Round(leaf.NotAfter.Sub(now).Hours()/24*100) / 100,
+		SignatureAlgorithm: leaf.SignatureAlgorithm.String(),
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         ck.roots,
+		Intermediates: intermediates,
+		DNSName:       dnsName,
+		CurrentTime:   now,
+		KeyUsages:     []x509.ExtKeyUsage{usage},
+	})
+	info.ChainValid = err == nil
+	if err != nil {
+		info.ChainError = err.Error()
+	}
+
+	var weakKey bool
+	info.KeyAlgorithm, weakKey = keyAlgorithm(leaf)
+	if weakKey {
+		info.Warnings = append(info.Warnings, "弱密钥 "+info.KeyAlgorithm)
+	}
+	for i, cert := range chain {
+		// 链中的根证书是直接信任的，它的签名算法不影响安全性
+		if weakSignatures[cert.SignatureAlgorithm] && (i == 0 || !bytes.Equal(cert.RawIssuer, cert.RawSubject)) {
+			name := "证书"
+			if i > 0 {
+				name = "中间证书 " + cert.Subject.String()
+			}
+			info.Warnings = append(info.Warnings, fmt.Sprintf("%s使用弱签名算法 %s", name, cert.SignatureAlgorithm))
+		}
+	}
+	if info.DaysLeft >= 0 && ck.warnDays > 0 && info.DaysLeft < float64(ck.warnDays) {
+		info.Warnings = append(info.Warnings, fmt.Sprintf("证书将在 %.1f 天后到期", info.DaysLeft))
+	}
+	return info
+}
+
+// report 把证书检查结果写入探测结果，证书过期、尚未生效或证书链无效时探测失败
+func (ck *certChecker) report(result *model.ProbeResult, infos []model.CertificateInfo, now time.Time) {
+	result.Certificates = infos
+	if len(infos) == 0 {
+		return
+	}
+	daysLeft, expiry := infos[0].DaysLeft, infos[0].NotAfter
+	for _, info := range infos {
+		daysLeft = math.Min(daysLeft, info.DaysLeft)
+		if info.NotAfter.Before(expiry) {
+			expiry = info.NotAfter
+		}
+	}
+	result.CertDaysLeft = &daysLeft
+	ck.mu.Lock()
+	ck.lastExpiry = expiry
+	ck.mu.Unlock()
+
+	for _, info := range infos {
+		switch {
+		case now.After(info.NotAfter):
+			mismatch(result, ErrorCertificate, "%s 的证书已于 %s 过期", info.Source, info.NotAfter.Format(time.DateOnly))
+		case now.Before(info.NotBefore):
+			mismatch(result, ErrorCertificate, "%s 的证书在 %s 之后才生效", info.Source, info.NotBefore.Format(time.DateOnly))
+		case !info.ChainValid:
+			mismatch(result, ErrorCertificate, "%s 的证书链校验失败: %s", info.Source, info.ChainError)
+		default:
+			continue
+		}
+		return
+	}
+}
+
+// keepLastExpiry 无法连接或读取证书时按最近一次检查到的到期时间给出剩余天数，
+// 避免已经触发的证书到期告警因为检查失败而自动恢复
+func (ck *certChecker) keepLastExpiry(result *model.ProbeResult, now time.Time) {
+	ck.mu.Lock()
+	expiry := ck.lastExpiry
+	ck.mu.Unlock()
+	if result.CertDaysLeft != nil || expiry.IsZero() {
+		return
+	}
+	daysLeft := math.Round(expiry.Sub(now).Hours()/24*100) / 100
+	result.CertDaysLeft = &daysLeft
+}
+
+func subjectAltNames(cert *x509.Certificate) []string {
+	sans := append([]string{}, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	sans = append(sans, cert.EmailAddresses...)
+	for _, uri := range cert.URIs {
+		sans = append(sans, uri.String())
+	}
+	return sans
+}
+
+// keyAlgorithm 返回公钥的算法和长度，以及是否属于弱密钥（RSA 小于 2048 位、ECDSA 小于 256 位或 DSA）
+func keyAlgorithm(cert *x509.Certificate) (string, bool) {
+	switch key := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		bits := key.N.BitLen()
+		return "RSA-" + strconv.Itoa(bits), bits < 2048
+	case *ecdsa.PublicKey:
+		params := key.Curve.Params()
+		return "ECDSA-" + strings.ReplaceAll(params.Name, "-", ""), params.BitSize < 256
+	case ed25519.PublicKey:
+		return "Ed25519", false
+	}
+	if cert.PublicKeyAlgorithm == x509.DSA {
+		return "DSA", true
+	}
+	return cert.PublicKeyAlgorithm.String(), false
+}
+
+// tlsProber 连接 TLS 服务并检查服务器提供的证书链
+type tlsProber struct {
+	address    string
+	serverName string
+	checker    *certChecker
+}
+
+func newTLS(c Config, opts Options) (prober, error) {
+	if err := validateAddress(c.Address); err != nil {
+		return nil, err
+	}
+	checker, err := newCertChecker(c)
+	if err != nil {
+		return nil, err
+	}
+	serverName := c.ServerName
+	if serverName == "" {
+		serverName, _, _ = net.SplitHostPort(c.Address)
+	}
+	return &tlsProber{address: c.Address, serverName: serverName, checker: checker}, nil
+}
+
+func (p *tlsProber) Probe(ctx context.Context) (result model.ProbeResult) {
+	result = model.ProbeResult{Target: p.address}
+	timer := &timer{}
+	start := time.Now()
+	defer func() {
+		result.ResponseTime = time.Since(start).Milliseconds()
+		result.Timings = timer.timings(time.Since(start))
+		p.checker.keepLastExpiry(&result, time.Now())
+	}()
+
+	trace := timer.trace()
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(httptrace.WithClientTrace(ctx, trace), "tcp", p.address)
+	if err != nil {
+		fail(ctx, &result, err)
+		return result
+	}
+	defer conn.Close()
+
+	// 握手时不校验证书，由 checker 校验后给出完整的检查结果
+	tlsConn := tls.Client(conn, &tls.Config{
+		ServerName:         p.serverName,
+		InsecureSkipVerify: true,
+		MinVersion:         tls.VersionTLS10,
+	})
+	trace.TLSHandshakeStart()
+	err = tlsConn.HandshakeContext(ctx)
+	state := tlsConn.ConnectionState()
+	trace.TLSHandshakeDone(state, err)
+	if err != nil {
+		fail(ctx, &result, err)
+		return result
+	}
+	if len(state.PeerCertificates) == 0 {
+		mismatch(&result, ErrorCertificate, "服务器没有提供证书")
+		return result
+	}
+
+	now := time.Now()
+	info := p.checker.inspect(p.address, state.PeerCertificates, p.serverName, x509.ExtKeyUsageServerAuth, now)
+	if state.Version < tls.VersionTLS12 {
+		info.Warnings = append(info.Warnings, "服务器协商的 TLS 版本为 "+tls.VersionName(state.Version))
+	}
+	p.checker.report(&result, []model.CertificateInfo{info}, now)
+	return result
+}
+
+// certFileProber 检查本地的证书文件，path 为目录时检查其中所有证书文件（不包含子目录）
+type certFileProber struct {
+	path    string
+	checker *certChecker
+}
+
+func newCertFile(c Config, opts Options) (prober, error) {
+	if c.Path == "" {
+		return nil, fmt.Errorf("缺少 path")
+	}
+	checker, err := newCertChecker(c)
+	if err != nil {
+		return nil, err
+	}
+	return &certFileProber{path: c.Path, checker: checker}, nil
+}
+
+func (p *certFileProber) Probe(ctx context.Context) (result model.ProbeResult) {
+	result = model.ProbeResult{Target: p.path}
+	start := time.Now()
+	defer func() {
+		result.ResponseTime = time.Since(start).Milliseconds()
+		p.checker.keepLastExpiry(&result, time.Now())
+	}()
+
+	files, err := certFiles(p.path)
+	if err != nil {
+		mismatch(&result, ErrorFile, "%s", err)
+		return result
+	}
+
+	now := time.Now()
+	var infos []model.CertificateInfo
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			mismatch(&result, ErrorFile, "%s", err)
+			return result
+		}
+		// 一个文件中可以包含完整的证书链，第一张证书之后的证书作为中间证书
+		chain, err := parseCertificates(data)
+		if err != nil {
+			mismatch(&result, ErrorCertificate, "%s: %s", file, err)
+			return result
+		}
+		if len(chain) > 0 {
+			infos = append(infos, p.checker.inspect(file, chain, "", x509.ExtKeyUsageAny, now))
+		}
+	}
+	if len(infos) == 0 {
+		mismatch(&result, ErrorCertificate, "%s 中没有找到证书", p.path)
+		return result
+	}
+	p.checker.report(&result, infos, now)
+	return result
+}
+
+// certFiles 返回需要检查的文件，path 为目录时返回其中扩展名为 .pem、.crt、.cer 或 .cert 的文件
+func certFiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, entry := range entries {
+		file := filepath.Join(path, entry.Name())
+		if !certExtensions[strings.ToLower(filepath.Ext(file))] {
+			continue
+		}
+		if info, err := os.Stat(file); err == nil && !info.IsDir() {
+			files = append(files, file)
+		}
+	}
+	return files, nil
+}
+
+// parseCertificates 解析 PEM 文件中的所有证书，忽略私钥等其他内容
+func parseCertificates(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return certs, nil
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("解析证书失败: %w", err)
+		}
+		certs = append(certs, cert)
+	}
+}
+
+// AlertRules 为证书检查生成到期告警规则：最早到期的证书剩余天数低于 warn_days 时产生 warning 告警，
+// 低于 critical_days 时产生 critical 告警，规则名称为 <探测名称>-cert-expiry 和 <探测名称>-cert-expiry-critical
+func AlertRules(configs []Config) ([]*rules.Rule, error) {
+	var generated []*rules.Rule
+	for _, c := range configs {
+		if t := strings.ToLower(c.Type); t != "tls" && t != "certfile" {
+			continue
+		}
+		warn, critical, err := c.expiryDays()
+		if err != nil {
+			return nil, fmt.Errorf("探测 %s: %w", c.Name, err)
+		}
+		for _, threshold := range []struct {
+			suffix   string
+			days     int
+			severity string
+		}{
+			{"-cert-expiry", warn, rules.SeverityWarning},
+			{"-cert-expiry-critical", critical, rules.SeverityCritical},
+		} {
+			if threshold.days == 0 {
+				continue
+			}
+			rule, err := rules.Parse(rules.Config{
+				Name:        c.Name + threshold.suffix,
+				Expr:        fmt.Sprintf("probe[%s].cert_days_left < %d", strconv.Quote(c.Name), threshold.days),
+				Severity:    threshold.severity,
+				Description: fmt.Sprintf("%s 的证书将在 %d 天内到期", c.Name, threshold.days),
+			})
+			if err != nil {
+				return nil, fmt.Errorf("探测 %s: %w", c.Name, err)
+			}
+			generated = append(generated, rule)
+		}
+	}
+	return generated, nil
+}
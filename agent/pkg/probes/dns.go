@@ -0,0 +1,206 @@
+package probes
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/xugou/agent/pkg/model"
+)
+
+// resolvConf 读取系统 DNS 服务器的文件
+const resolvConf = "/etc/resolv.conf"
+
+// dnsProber 向指定的 DNS 服务器查询记录并检查应答，响应被截断时改用 TCP 重新查询
+type dnsProber struct {
+	query    string
+	qtype    uint16
+	server   string
+	expected []string
+	dnssec   bool
+}
+
+func newDNS(c Config, opts Options) (prober, error) {
+	if c.Query == "" {
+		return nil, fmt.Errorf("缺少 query")
+	}
+	recordType := strings.ToUpper(c.RecordType)
+	if recordType == "" {
+		recordType = "A"
+	}
+	qtype, ok := dnsTypes[recordType]
+	if !ok {
+		names := make([]string, 0, len(dnsTypes))
+		for name := range dnsTypes {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("不支持的 record_type %q，可选: %s", c.RecordType, strings.Join(names, ", "))
+	}
+	if _, err := buildDNSQuery(0, c.Query, qtype, false); err != nil {
+		return nil, err
+	}
+
+	server := c.Server
+	if server == "" {
+		var err error
+		if server, err = systemResolver(); err != nil {
+			return nil, fmt.Errorf("无法确定系统的 DNS 服务器，请设置 server: %w", err)
+		}
+	}
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(strings.Trim(server, "[]"), "53")
+	}
+
+	p := &dnsProber{query: c.Query, qtype: qtype, server: server, dnssec: c.DNSSEC}
+	for _, answer := range c.ExpectedAnswers {
+		p.expected = append(p.expected, normalizeAnswer(qtype, answer))
+	}
+	return p, nil
+}
+
+// systemResolver 返回 /etc/resolv.conf 中的第一个 DNS 服务器
+func systemResolver() (string, error) {
+	f, err := os.Open(resolvConf)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			return fields[1], nil
+		}
+	}
+	return "", fmt.Errorf("%s 中没有 nameserver", resolvConf)
+}
+
+// normalizeAnswer 把应答转换为便于比较的形式：IP 地址使用标准写法，域名忽略大小写和末尾的点，TXT 记录保持原样
+func normalizeAnswer(qtype uint16, answer string) string {
+	answer = strings.TrimSpace(answer)
+	if qtype == dnsTypes["TXT"] {
+		return answer
+	}
+	if ip := net.ParseIP(answer); ip != nil {
+		return ip.String()
+	}
+	fields := strings.Fields(strings.ToLower(answer))
+	for i, f := range fields {
+		fields[i] = strings.TrimSuffix(f, ".")
+	}
+	return strings.Join(fields, " ")
+}
+
+func (p *dnsProber) Probe(ctx context.Context) (result model.ProbeResult) {
+	result = model.ProbeResult{Target: fmt.Sprintf("%s %s @%s", p.query, dnsTypeName(p.qtype), p.server)}
+	start := time.Now()
+	defer func() {
+		result.ResponseTime = time.Since(start).Milliseconds()
+	}()
+
+	resp, err := p.exchange(ctx, "udp")
+	if err == nil && resp.Truncated {
+		resp, err = p.exchange(ctx, "tcp")
+	}
+	if err != nil {
+		fail(ctx, &result, err)
+		return result
+	}
+
+	if resp.Rcode != 0 {
+		mismatch(&result, ErrorStatus, "DNS 响应码为 %s", dnsRcodeName(resp.Rcode))
+		return result
+	}
+	if p.dnssec && !resp.AD {
+		mismatch(&result, ErrorAssertion, "应答没有通过 DNSSEC 验证（服务器没有设置 AD 标志）")
+		return result
+	}
+
+	var answers []string
+	for _, r := range resp.Answers {
+		if r.Type == p.qtype {
+			answers = append(answers, normalizeAnswer(p.qtype, r.Data))
+		}
+	}
+	if len(answers) == 0 {
+		mismatch(&result, ErrorAssertion, "没有 %s 记录", dnsTypeName(p.qtype))
+		return result
+	}
+	for _, want := range p.expected {
+		if !contains(answers, want) {
+			mismatch(&result, ErrorAssertion, "应答中缺少 %s，实际: %s", want, strings.Join(answers, ", "))
+			return result
+		}
+	}
+	return result
+}
+
+// exchange 发送一次查询并等待 ID 一致的响应
+func (p *dnsProber) exchange(ctx context.Context, network string) (*dnsResponse, error) {
+	id := uint16(rand.Uint32())
+	query, err := buildDNSQuery(id, p.query, p.qtype, p.dnssec)
+	if err != nil {
+		return nil, err
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, p.server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if network == "tcp" {
+		// TCP 报文前面有两个字节的长度
+		query = append(binary.BigEndian.AppendUint16(nil, uint16(len(query))), query...)
+		if _, err := conn.Write(query); err != nil {
+			return nil, err
+		}
+		var length [2]byte
+		if _, err := io.ReadFull(conn, length[:]); err != nil {
+			return nil, err
+		}
+		msg := make([]byte, binary.BigEndian.Uint16(length[:]))
+		if _, err := io.ReadFull(conn, msg); err != nil {
+			return nil, err
+		}
+		return parseDNSResponse(msg, id)
+	}
+
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 64<<10)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		// 忽略迟到的或伪造的响应，继续等待
+		if n >= 2 && binary.BigEndian.Uint16(buf) != id {
+			continue
+		}
+		return parseDNSResponse(buf[:n], id)
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
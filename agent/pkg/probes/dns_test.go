@@ -0,0 +1,191 @@
+package probes
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/xugou/agent/pkg/model"
+)
+
+// dnsHandler 根据查询返回响应，UDP 可以返回多个报文，TCP 只使用第一个
+type dnsHandler func(query []byte, name string, qtype uint16, tcp bool) [][]byte
+
+// startDNSServer 在本机同一个端口上监听 UDP 和 TCP，返回服务器地址
+func startDNSServer(t *testing.T, handle dnsHandler) string {
+	t.Helper()
+	var pc net.PacketConn
+	var ln net.Listener
+	for i := 0; ; i++ {
+		var err error
+		if pc, err = net.ListenPacket("udp", "127.0.0.1:0"); err != nil {
+			t.Fatal(err)
+		}
+		if ln, err = net.Listen("tcp", pc.LocalAddr().String()); err == nil {
+			break
+		}
+		pc.Close()
+		if i == 10 {
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(func() {
+		pc.Close()
+		ln.Close()
+	})
+
+	parse := func(query []byte) (string, uint16, bool) {
+		name, next, err := readDNSName(query, 12)
+		if err != nil || next+2 > len(query) {
+			return "", 0, false
+		}
+		return name, binary.BigEndian.Uint16(query[next:]), true
+	}
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			query := append([]byte{}, buf[:n]...)
+			if name, qtype, ok := parse(query); ok {
+				for _, msg := range handle(query, name, qtype, false) {
+					pc.WriteTo(msg, addr)
+				}
+			}
+		}
+	}()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				var length [2]byte
+				if _, err := io.ReadFull(conn, length[:]); err != nil {
+					return
+				}
+				query := make([]byte, binary.BigEndian.Uint16(length[:]))
+				if _, err := io.ReadFull(conn, query); err != nil {
+					return
+				}
+				if name, qtype, ok := parse(query); ok {
+					if msgs := handle(query, name, qtype, true); len(msgs) > 0 {
+						conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(msgs[0]))), msgs[0]...))
+					}
+				}
+			}()
+		}
+	}()
+	return pc.LocalAddr().String()
+}
+
+// testZone 测试服务器上的记录
+func testZone(query []byte, name string, qtype uint16, tcp bool) [][]byte {
+	a := func(ip ...byte) testRR { return testRR{dnsTypes["A"], ip} }
+	switch name {
+	case "ok.test":
+		return [][]byte{testResponse(query, 0, a(192, 0, 2, 1), a(192, 0, 2, 2))}
+	case "mx.test":
+		return [][]byte{testResponse(query, 0, testRR{dnsTypes["MX"], append([]byte{0, 10}, testName("Mail.Example.com")...)})}
+	case "alias.test":
+		return [][]byte{testResponse(query, 0, testRR{dnsTypes["CNAME"], testName("ok.test")})}
+	case "nx.test":
+		return [][]byte{testResponse(query, 3)}
+	case "fail.test":
+		return [][]byte{testResponse(query, 2)}
+	case "big.test":
+		// UDP 响应被截断，需要改用 TCP 重新查询
+		if !tcp {
+			return [][]byte{testResponse(query, dnsFlagTC)}
+		}
+		return [][]byte{testResponse(query, 0, a(192, 0, 2, 9))}
+	case "late.test":
+		// 先收到 ID 不一致的响应（例如之前查询迟到的响应），应忽略并继续等待
+		stale := testResponse(query, 0, a(203, 0, 113, 1))
+		binary.BigEndian.PutUint16(stale, binary.BigEndian.Uint16(query)+1)
+		return [][]byte{stale, testResponse(query, 0, a(192, 0, 2, 3))}
+	case "secure.test":
+		// 查询设置了 AD 标志时，递归服务器返回验证结果
+		flags := binary.BigEndian.Uint16(query[2:]) & dnsFlagAD
+		return [][]byte{testResponse(query, flags, a(192, 0, 2, 4))}
+	case "loop.test":
+		msg := testResponse(query, 0)
+		binary.BigEndian.PutUint16(msg[6:], 1)
+		return [][]byte{append(msg, 0xC0, byte(len(msg)))}
+	case "silent.test":
+		return nil
+	}
+	return [][]byte{testResponse(query, 3)}
+}
+
+func TestDNSProbe(t *testing.T) {
+	server := startDNSServer(t, testZone)
+	tests := []struct {
+		name      string
+		config    Config
+		errorType string
+		want      string
+	}{
+		{name: "ok", config: Config{Query: "ok.test", ExpectedAnswers: []string{"192.0.2.2"}}},
+		{name: "trailing dot and case", config: Config{Query: "mx.test.", RecordType: "mx", ExpectedAnswers: []string{"10 mail.example.com."}}},
+		{name: "missing answer", config: Config{Query: "ok.test", ExpectedAnswers: []string{"192.0.2.3"}}, errorType: ErrorAssertion, want: "缺少 192.0.2.3"},
+		{name: "no record of type", config: Config{Query: "alias.test"}, errorType: ErrorAssertion, want: "没有 A 记录"},
+		{name: "NXDOMAIN", config: Config{Query: "nx.test"}, errorType: ErrorStatus, want: "NXDOMAIN"},
+		{name: "SERVFAIL", config: Config{Query: "fail.test"}, errorType: ErrorStatus, want: "SERVFAIL"},
+		{name: "truncated over TCP", config: Config{Query: "big.test", ExpectedAnswers: []string{"192.0.2.9"}}},
+		{name: "stale response", config: Config{Query: "late.test", ExpectedAnswers: []string{"192.0.2.3"}}},
+		{name: "dnssec", config: Config{Query: "secure.test", DNSSEC: true}},
+		{name: "dnssec not validated", config: Config{Query: "ok.test", DNSSEC: true}, errorType: ErrorAssertion, want: "DNSSEC"},
+		{name: "pointer loop", config: Config{Query: "loop.test"}, errorType: ErrorNetwork, want: "压缩指针无效"},
+		{name: "timeout", config: Config{Query: "silent.test", Timeout: "200ms"}, errorType: ErrorTimeout},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.Name = tt.name
+			tt.config.Type = "dns"
+			tt.config.Server = server
+			p, err := New(tt.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			result := p.Run(context.Background())
+			wantStatus := model.ProbeUp
+			if tt.errorType != "" {
+				wantStatus = model.ProbeDown
+			}
+			if result.Status != wantStatus || result.ErrorType != tt.errorType || !strings.Contains(result.Error, tt.want) {
+				t.Errorf("Run() = status %s, error type %q, error %q; want %s, %q, containing %q",
+					result.Status, result.ErrorType, result.Error, wantStatus, tt.errorType, tt.want)
+			}
+		})
+	}
+}
+
+func TestDNSProbeInvalidConfig(t *testing.T) {
+	invalid := []Config{
+		{Name: "a", Type: "dns", Server: "127.0.0.1"},
+		{Name: "a", Type: "dns", Server: "127.0.0.1", Query: "a..b"},
+		{Name: "a", Type: "dns", Server: "127.0.0.1", Query: "example.com", RecordType: "ANY"},
+	}
+	for _, c := range invalid {
+		if _, err := New(c); err == nil {
+			t.Errorf("New(%+v) succeeded", c)
+		}
+	}
+
+	// 没有端口时使用 53
+	p, err := New(Config{Name: "a", Type: "dns", Server: "::1", Query: "example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if server := p.prober.(*dnsProber).server; server != "[::1]:53" {
+		t.Errorf("server = %q, want [::1]:53", server)
+	}
+}
@@ -0,0 +1,281 @@
+package probes
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// 只实现探测需要的部分 DNS 报文格式（RFC 1035、RFC 6891）：构造一个查询，解析响应头和应答记录
+
+// dnsTypes 支持查询的记录类型
+var dnsTypes = map[string]uint16{
+	"A":     1,
+	"NS":    2,
+	"CNAME": 5,
+	"SOA":   6,
+	"PTR":   12,
+	"MX":    15,
+	"TXT":   16,
+	"AAAA":  28,
+	"SRV":   33,
+	"CAA":   257,
+}
+
+// dnsRcodes 常见响应码的名称
+var dnsRcodes = map[int]string{
+	0: "NOERROR",
+	1: "FORMERR",
+	2: "SERVFAIL",
+	3: "NXDOMAIN",
+	4: "NOTIMP",
+	5: "REFUSED",
+}
+
+const (
+	dnsClassIN = 1
+	dnsTypeOPT = 41
+
+	dnsFlagQR = 1 << 15
+	dnsFlagTC = 1 << 9
+	dnsFlagRD = 1 << 8
+	dnsFlagAD = 1 << 5
+
+	// dnsUDPSize 通过 EDNS 声明的 UDP 响应大小，避免在常见网络中分片
+	dnsUDPSize = 1232
+)
+
+// dnsRecord 一条应答记录，Data 为便于比较的文本形式，例如 A 记录为 IP 地址，MX 记录为 "10 mail.example.com"
+type dnsRecord struct {
+	Type uint16
+	Data string
+}
+
+// dnsResponse 解析出的响应
+type dnsResponse struct {
+	Rcode     int
+	Truncated bool
+	AD        bool // 递归服务器已完成 DNSSEC 验证
+	Answers   []dnsRecord
+}
+
+// buildDNSQuery 构造查询报文，dnssec 为 true 时设置 EDNS 的 DO 位和 AD 位，要求服务器返回 DNSSEC 验证结果
+func buildDNSQuery(id uint16, name string, qtype uint16, dnssec bool) ([]byte, error) {
+	flags := uint16(dnsFlagRD)
+	if dnssec {
+		flags |= dnsFlagAD
+	}
+	msg := make([]byte, 12, 512)
+	binary.BigEndian.PutUint16(msg[0:], id)
+	binary.BigEndian.PutUint16(msg[2:], flags)
+	binary.BigEndian.PutUint16(msg[4:], 1)  // QDCOUNT
+	binary.BigEndian.PutUint16(msg[10:], 1) // ARCOUNT，EDNS 的 OPT 记录
+
+	msg, err := appendDNSName(msg, name)
+	if err != nil {
+		return nil, err
+	}
+	msg = binary.BigEndian.AppendUint16(msg, qtype)
+	msg = binary.BigEndian.AppendUint16(msg, dnsClassIN)
+
+	// OPT 记录：根域名、类型 41、CLASS 为 UDP 大小、TTL 的高位包含 DO 标志
+	var ttl uint32
+	if dnssec {
+		ttl = 1 << 15
+	}
+	msg = append(msg, 0)
+	msg = binary.BigEndian.AppendUint16(msg, dnsTypeOPT)
+	msg = binary.BigEndian.AppendUint16(msg, dnsUDPSize)
+	msg = binary.BigEndian.AppendUint32(msg, ttl)
+	msg = binary.BigEndian.AppendUint16(msg, 0)
+	return msg, nil
+}
+
+func appendDNSName(msg []byte, name string) ([]byte, error) {
+	name = strings.TrimSuffix(name, ".")
+	if len(name) > 253 {
+		return nil, fmt.Errorf("域名 %q 过长", name)
+	}
+	if name != "" {
+		for _, label := range strings.Split(name, ".") {
+			if label == "" || len(label) > 63 {
+				return nil, fmt.Errorf("无效的域名 %q", name)
+			}
+			msg = append(msg, byte(len(label)))
+			msg = append(msg, label...)
+		}
+	}
+	return append(msg, 0), nil
+}
+
+var errDNSShort = errors.New("DNS 响应不完整")
+
+// parseDNSResponse 解析响应报文，id 必须与查询一致
+func parseDNSResponse(msg []byte, id uint16) (*dnsResponse, error) {
+	if len(msg) < 12 {
+		return nil, errDNSShort
+	}
+	if binary.BigEndian.Uint16(msg[0:]) != id {
+		return nil, fmt.Errorf("DNS 响应的 ID 与查询不一致")
+	}
+	flags := binary.BigEndian.Uint16(msg[2:])
+	if flags&dnsFlagQR == 0 {
+		return nil, fmt.Errorf("收到的不是 DNS 响应")
+	}
+	resp := &dnsResponse{
+		Rcode:     int(flags & 0xF),
+		Truncated: flags&dnsFlagTC != 0,
+		AD:        flags&dnsFlagAD != 0,
+	}
+	qdcount := int(binary.BigEndian.Uint16(msg[4:]))
+	ancount := int(binary.BigEndian.Uint16(msg[6:]))
+
+	off := 12
+	for i := 0; i < qdcount; i++ {
+		_, next, err := readDNSName(msg, off)
+		if err != nil {
+			return nil, err
+		}
+		off = next + 4
+	}
+	for i := 0; i < ancount; i++ {
+		_, next, err := readDNSName(msg, off)
+		if err != nil {
+			return nil, err
+		}
+		if next+10 > len(msg) {
+			return nil, errDNSShort
+		}
+		rtype := binary.BigEndian.Uint16(msg[next:])
+		length := int(binary.BigEndian.Uint16(msg[next+8:]))
+		start := next + 10
+		if start+length > len(msg) {
+			return nil, errDNSShort
+		}
+		data, err := formatDNSData(msg, rtype, start, length)
+		if err != nil {
+			return nil, err
+		}
+		resp.Answers = append(resp.Answers, dnsRecord{Type: rtype, Data: data})
+		off = start + length
+	}
+	return resp, nil
+}
+
+// readDNSName 读取可能经过压缩的域名，返回域名和紧跟在域名之后的位置
+func readDNSName(msg []byte, off int) (string, int, error) {
+	var labels []string
+	next := -1
+	for jumps := 0; ; {
+		if off >= len(msg) {
+			return "", 0, errDNSShort
+		}
+		n := int(msg[off])
+		switch {
+		case n == 0:
+			if next < 0 {
+				next = off + 1
+			}
+			return strings.Join(labels, "."), next, nil
+		case n&0xC0 == 0xC0:
+			if off+1 >= len(msg) {
+				return "", 0, errDNSShort
+			}
+			if jumps++; jumps > 32 {
+				return "", 0, fmt.Errorf("DNS 响应中的域名压缩指针无效")
+			}
+			if next < 0 {
+				next = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(msg[off:]) & 0x3FFF)
+		default:
+			if off+1+n > len(msg) {
+				return "", 0, errDNSShort
+			}
+			labels = append(labels, string(msg[off+1:off+1+n]))
+			off += 1 + n
+		}
+	}
+}
+
+// formatDNSData 把记录内容转换为文本，不认识的类型使用十六进制
+func formatDNSData(msg []byte, rtype uint16, start, length int) (string, error) {
+	data := msg[start : start+length]
+	name := func(off int) (string, error) {
+		s, _, err := readDNSName(msg, off)
+		return s, err
+	}
+	switch rtype {
+	case dnsTypes["A"], dnsTypes["AAAA"]:
+		if len(data) != net.IPv4len && len(data) != net.IPv6len {
+			return "", errDNSShort
+		}
+		return net.IP(data).String(), nil
+	case dnsTypes["NS"], dnsTypes["CNAME"], dnsTypes["PTR"]:
+		return name(start)
+	case dnsTypes["MX"]:
+		if len(data) < 3 {
+			return "", errDNSShort
+		}
+		host, err := name(start + 2)
+		return strconv.Itoa(int(binary.BigEndian.Uint16(data))) + " " + host, err
+	case dnsTypes["SRV"]:
+		if len(data) < 7 {
+			return "", errDNSShort
+		}
+		host, err := name(start + 6)
+		return fmt.Sprintf("%d %d %d %s", binary.BigEndian.Uint16(data), binary.BigEndian.Uint16(data[2:]), binary.BigEndian.Uint16(data[4:]), host), err
+	case dnsTypes["TXT"]:
+		// 多段字符串直接拼接，与大多数服务使用 TXT 记录的方式一致
+		var sb strings.Builder
+		for i := 0; i < len(data); {
+			n := int(data[i])
+			if i+1+n > len(data) {
+				return "", errDNSShort
+			}
+			sb.Write(data[i+1 : i+1+n])
+			i += 1 + n
+		}
+		return sb.String(), nil
+	case dnsTypes["SOA"]:
+		mname, next, err := readDNSName(msg, start)
+		if err != nil {
+			return "", err
+		}
+		rname, next, err := readDNSName(msg, next)
+		if err != nil {
+			return "", err
+		}
+		if next+20 > start+length {
+			return "", errDNSShort
+		}
+		return fmt.Sprintf("%s %s %d", mname, rname, binary.BigEndian.Uint32(msg[next:])), nil
+	case dnsTypes["CAA"]:
+		if len(data) < 2 || 2+int(data[1]) > len(data) {
+			return "", errDNSShort
+		}
+		tagEnd := 2 + int(data[1])
+		return fmt.Sprintf("%d %s %s", data[0], data[2:tagEnd], data[tagEnd:]), nil
+	}
+	return hex.EncodeToString(data), nil
+}
+
+func dnsTypeName(t uint16) string {
+	for name, v := range dnsTypes {
+		if v == t {
+			return name
+		}
+	}
+	return "TYPE" + strconv.Itoa(int(t))
+}
+
+func dnsRcodeName(rcode int) string {
+	if name, ok := dnsRcodes[rcode]; ok {
+		return name
+	}
+	return "RCODE" + strconv.Itoa(rcode)
+}
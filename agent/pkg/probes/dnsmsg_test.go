@@ -0,0 +1,198 @@
+package probes
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testRR 测试响应中的一条应答记录，记录名称使用指向问题中域名的压缩指针
+type testRR struct {
+	rtype uint16
+	data  []byte
+}
+
+// testResponse 根据查询构造响应：保留问题，去掉 OPT 记录，追加应答
+func testResponse(query []byte, flags uint16, answers ...testRR) []byte {
+	msg := append([]byte{}, query[:len(query)-11]...) // OPT 记录固定 11 字节
+	binary.BigEndian.PutUint16(msg[2:], dnsFlagQR|flags)
+	binary.BigEndian.PutUint16(msg[6:], uint16(len(answers)))
+	binary.BigEndian.PutUint16(msg[10:], 0)
+	for _, rr := range answers {
+		msg = append(msg, 0xC0, 12)
+		msg = binary.BigEndian.AppendUint16(msg, rr.rtype)
+		msg = binary.BigEndian.AppendUint16(msg, dnsClassIN)
+		msg = binary.BigEndian.AppendUint32(msg, 300)
+		msg = binary.BigEndian.AppendUint16(msg, uint16(len(rr.data)))
+		msg = append(msg, rr.data...)
+	}
+	return msg
+}
+
+func testName(name string) []byte {
+	data, err := appendDNSName(nil, name)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
+func TestBuildDNSQuery(t *testing.T) {
+	msg, err := buildDNSQuery(0x1234, "www.example.com.", dnsTypes["AAAA"], true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{
+		0x12, 0x34, 0x01, 0x20, 0, 1, 0, 0, 0, 0, 0, 1, // ID、RD 和 AD、QDCOUNT 1、ARCOUNT 1
+		3, 'w', 'w', 'w', 7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0,
+		0, 28, 0, 1, // AAAA IN
+		0, 0, 41, 0x04, 0xD0, 0, 0, 0x80, 0, 0, 0, // OPT，UDP 大小 1232，DO 标志
+	}
+	if !bytes.Equal(msg, want) {
+		t.Errorf("buildDNSQuery() =\n%v\nwant\n%v", msg, want)
+	}
+
+	for _, name := range []string{"a..b", strings.Repeat("x", 64) + ".com", strings.Repeat("abcdefghi.", 26)} {
+		if _, err := buildDNSQuery(1, name, 1, false); err == nil {
+			t.Errorf("buildDNSQuery(%q) succeeded", name)
+		}
+	}
+}
+
+func TestParseDNSResponse(t *testing.T) {
+	query, err := buildDNSQuery(7, "example.com", dnsTypes["A"], false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mx := append([]byte{0, 10}, testName("mail.example.com")...)
+	srv := append([]byte{0, 1, 0, 2, 0x01, 0xBB}, 0xC0, 12) // 目标使用压缩指针
+	soa := append(append(testName("ns1.example.com"), testName("admin.example.com")...), make([]byte, 20)...)
+	binary.BigEndian.PutUint32(soa[len(soa)-20:], 2026010201)
+	msg := testResponse(query, dnsFlagAD,
+		testRR{dnsTypes["A"], []byte{192, 0, 2, 1}},
+		testRR{dnsTypes["AAAA"], []byte{0x20, 0x01, 0x0d, 0xb8, 15: 1}},
+		testRR{dnsTypes["CNAME"], []byte{3, 'w', 'w', 'w', 0xC0, 12}},
+		testRR{dnsTypes["MX"], mx},
+		testRR{dnsTypes["TXT"], []byte("\x05v=spf\x05 -all")},
+		testRR{dnsTypes["SRV"], srv},
+		testRR{dnsTypes["SOA"], soa},
+		testRR{dnsTypes["CAA"], []byte("\x00\x05issueletsencrypt.org")},
+		testRR{99, []byte{0xAB, 0xCD}},
+	)
+
+	resp, err := parseDNSResponse(msg, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"192.0.2.1",
+		"2001:db8::1",
+		"www.example.com",
+		"10 mail.example.com",
+		"v=spf -all",
+		"1 2 443 example.com",
+		"ns1.example.com admin.example.com 2026010201",
+		"0 issue letsencrypt.org",
+		"abcd",
+	}
+	var got []string
+	for _, r := range resp.Answers {
+		got = append(got, r.Data)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("answers =\n%q\nwant\n%q", got, want)
+	}
+	if resp.Rcode != 0 || resp.Truncated || !resp.AD {
+		t.Errorf("flags = %+v", resp)
+	}
+
+	// 任何位置截断的响应都返回错误而不是 panic
+	for n := 0; n < len(msg); n++ {
+		if _, err := parseDNSResponse(msg[:n], 7); err == nil {
+			t.Errorf("parseDNSResponse(msg[:%d]) succeeded", n)
+		}
+	}
+}
+
+func TestParseDNSResponseFlags(t *testing.T) {
+	query, _ := buildDNSQuery(7, "example.com", dnsTypes["A"], false)
+	tests := []struct {
+		name      string
+		flags     uint16
+		rcode     int
+		truncated bool
+	}{
+		{"NXDOMAIN", 3, 3, false},
+		{"SERVFAIL", 2, 2, false},
+		{"truncated", dnsFlagTC, 0, true},
+	}
+	for _, tt := range tests {
+		resp, err := parseDNSResponse(testResponse(query, tt.flags), 7)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if resp.Rcode != tt.rcode || resp.Truncated != tt.truncated || len(resp.Answers) != 0 {
+			t.Errorf("%s: response = %+v", tt.name, resp)
+		}
+	}
+	if got := dnsRcodeName(3) + " " + dnsRcodeName(11); got != "NXDOMAIN RCODE11" {
+		t.Errorf("dnsRcodeName() = %q", got)
+	}
+}
+
+func TestParseDNSResponseInvalid(t *testing.T) {
+	query, _ := buildDNSQuery(7, "example.com", dnsTypes["A"], false)
+	valid := testResponse(query, 0, testRR{dnsTypes["A"], []byte{192, 0, 2, 1}})
+
+	wrongID := append([]byte{}, valid...)
+	binary.BigEndian.PutUint16(wrongID, 8)
+
+	notResponse := append([]byte{}, valid...)
+	binary.BigEndian.PutUint16(notResponse[2:], 0)
+
+	// 问题中的域名是指向自己的压缩指针
+	selfLoop := append([]byte{}, valid[:12]...)
+	binary.BigEndian.PutUint16(selfLoop[6:], 0)
+	selfLoop = append(selfLoop, 0xC0, 12, 0, 1, 0, 1)
+
+	// 两个指针互相指向
+	mutualLoop := append([]byte{}, selfLoop[:12]...)
+	mutualLoop = append(mutualLoop, 0xC0, 14, 0xC0, 12, 0, 1, 0, 1)
+
+	// 指针越过报文末尾
+	outside := append([]byte{}, selfLoop[:12]...)
+	outside = append(outside, 0xC0, 0xFF, 0, 1, 0, 1)
+
+	// A 记录的长度不是 4 或 16
+	badA := testResponse(query, 0, testRR{dnsTypes["A"], []byte{192, 0, 2}})
+
+	// 记录长度超过报文
+	badLength := append([]byte{}, valid...)
+	binary.BigEndian.PutUint16(badLength[len(badLength)-6:], 100)
+
+	tests := []struct {
+		name string
+		msg  []byte
+		want string
+	}{
+		{"wrong id", wrongID, "ID 与查询不一致"},
+		{"not a response", notResponse, "不是 DNS 响应"},
+		{"pointer to itself", selfLoop, "压缩指针无效"},
+		{"pointer loop", mutualLoop, "压缩指针无效"},
+		{"pointer outside", outside, "不完整"},
+		{"bad A record", badA, "不完整"},
+		{"record length", badLength, "不完整"},
+	}
+	for _, tt := range tests {
+		_, err := parseDNSResponse(tt.msg, 7)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: parseDNSResponse() error = %v, want containing %q", tt.name, err, tt.want)
+		}
+	}
+	if _, err := parseDNSResponse(valid[:5], 7); !errors.Is(err, errDNSShort) {
+		t.Errorf("short header: error = %v, want errDNSShort", err)
+	}
+}
@@ -0,0 +1,87 @@
+package probes
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"syscall"
+
+	"github.com/xugou/agent/pkg/model"
+)
+
+// 失败原因的分类，写入结果的 error_type 字段，便于按原因统计和告警
+const (
+	ErrorTimeout     = "timeout"     // 超时
+	ErrorDNS         = "dns"         // 目标的域名解析失败
+	ErrorRefused     = "refused"     // 连接被拒绝，端口没有监听或 UDP 收到端口不可达
+	ErrorReset       = "reset"       // 连接被重置或被对方提前关闭
+	ErrorTLS         = "tls"         // TLS 握手或证书校验失败
+	ErrorNetwork     = "network"     // 其他网络错误，例如网络不可达
+	ErrorStatus      = "status"      // HTTP 状态码或 DNS 响应码不符合预期
+	ErrorAssertion   = "assertion"   // 响应内容不符合预期
+	ErrorCertificate = "certificate" // 证书过期、尚未生效或证书链校验失败
+	ErrorFile        = "file"        // 读取本地文件失败
+	ErrorPlugin      = "plugin"      // Nagios 插件返回 CRITICAL 或 UNKNOWN，或者无法运行
+)
+
+// fail 把错误及其分类写入结果
+func fail(ctx context.Context, result *model.ProbeResult, err error) {
+	result.ErrorType = classify(ctx, err)
+	result.Error = describe(ctx, err)
+}
+
+// mismatch 记录响应不符合预期
+func mismatch(result *model.ProbeResult, kind string, format string, args ...interface{}) {
+	result.ErrorType = kind
+	result.Error = fmt.Sprintf(format, args...)
+}
+
+// classify 返回错误的分类
+func classify(ctx context.Context, err error) string {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	var certErr *tls.CertificateVerificationError
+	var alertErr tls.AlertError
+	var recordErr tls.RecordHeaderError
+	var hostErr x509.HostnameError
+	var authorityErr x509.UnknownAuthorityError
+	var invalidErr x509.CertificateInvalidError
+
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded), errors.Is(err, os.ErrDeadlineExceeded):
+		return ErrorTimeout
+	case errors.As(err, &dnsErr):
+		if dnsErr.IsTimeout {
+			return ErrorTimeout
+		}
+		return ErrorDNS
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return ErrorRefused
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return ErrorReset
+	case errors.As(err, &certErr), errors.As(err, &alertErr), errors.As(err, &recordErr),
+		errors.As(err, &hostErr), errors.As(err, &authorityErr), errors.As(err, &invalidErr):
+		return ErrorTLS
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return ErrorTimeout
+	}
+	return ErrorNetwork
+}
+
+// describe 把错误转换为便于阅读的描述，超时的请求不再附带完整的地址
+func describe(ctx context.Context, err error) string {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) || errors.Is(err, os.ErrDeadlineExceeded) {
+		return "请求超时"
+	}
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		err = urlErr.Err
+	}
+	return err.Error()
+}
@@ -0,0 +1,192 @@
+package probes
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/xugou/agent/pkg/logger"
+	"github.com/xugou/agent/pkg/model"
+	"github.com/xugou/agent/pkg/useragent"
+)
+
+// DefaultMaxRedirects 默认最多跟随的重定向次数
+const DefaultMaxRedirects = 10
+
+// maxBodySize 读取响应内容的上限，超过部分不参与断言
+const maxBodySize = 1 << 20
+
+// httpProber 发送 HTTP 请求并检查状态码和响应内容
+type httpProber struct {
+	url        string
+	method     string
+	headers    map[string]string
+	body       string
+	expected   int
+	bodyRegex  *regexp.Regexp
+	assertions []jsonAssertion
+	client     *http.Client
+}
+
+func newHTTP(c Config, opts Options) (prober, error) {
+	u, err := url.Parse(c.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("无效的 url %q", c.URL)
+	}
+
+	for _, name := range c.SecretHeaders {
+		if _, ok := c.Headers[name]; !ok {
+			return nil, fmt.Errorf("secret_headers 中的 %s 不在 headers 中", name)
+		}
+	}
+
+	p := &httpProber{
+		url:      c.URL,
+		method:   strings.ToUpper(c.Method),
+		headers:  c.Headers,
+		body:     c.Body,
+		expected: c.ExpectedStatus,
+	}
+	if p.method == "" {
+		p.method = http.MethodGet
+	}
+	if p.expected == 0 {
+		p.expected = http.StatusOK
+	}
+	if !(p.expected >= 1 && p.expected <= 5) && !(p.expected >= 100 && p.expected <= 599) {
+		return nil, fmt.Errorf("无效的 expected_status %d，可以是 1 到 5 表示状态码类别或 100 到 599 的状态码", c.ExpectedStatus)
+	}
+	if c.BodyRegex != "" {
+		if p.bodyRegex, err = regexp.Compile(c.BodyRegex); err != nil {
+			return nil, fmt.Errorf("无效的 body_regex: %w", err)
+		}
+	}
+	for _, a := range c.JSON {
+		assertion, err := a.compile()
+		if err != nil {
+			return nil, err
+		}
+		p.assertions = append(p.assertions, assertion)
+	}
+
+	maxRedirects := DefaultMaxRedirects
+	if c.MaxRedirects != nil {
+		maxRedirects = *c.MaxRedirects
+	}
+	if maxRedirects < 0 {
+		return nil, fmt.Errorf("无效的 max_redirects %d", maxRedirects)
+	}
+
+	p.client = &http.Client{
+		Transport: &http.Transport{
+			// 探测的是内网服务，不经过上报使用的代理
+			Proxy:       nil,
+			DialContext: (&net.Dialer{Timeout: opts.Timeout}).DialContext,
+			TLSClientConfig: &tls.Config{
+				InsecureSkipVerify: c.TLSSkipVerify,
+			},
+			// 每次探测都重新建立连接，才能测出 DNS、连接和 TLS 的耗时
+			DisableKeepAlives: true,
+			ForceAttemptHTTP2: true,
+		},
+		// 超过重定向次数时直接检查最后一个响应，通常会因为 3xx 状态码不符合预期而失败
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > maxRedirects {
+				return http.ErrUseLastResponse
+			}
+			return nil
+		},
+	}
+	return p, nil
+}
+
+// addHTTPSecrets 登记请求头和地址中的凭据，使其不出现在日志中
+func addHTTPSecrets(c Config) {
+	logger.AddHeaderSecrets(c.Headers, c.SecretHeaders)
+	if u, err := url.Parse(c.URL); err == nil {
+		if password, ok := u.User.Password(); ok {
+			logger.AddSecret(password)
+		}
+	}
+}
+
+func (p *httpProber) Probe(ctx context.Context) model.ProbeResult {
+	result := model.ProbeResult{Target: p.url}
+
+	timer := &timer{}
+	ctx = httptrace.WithClientTrace(ctx, timer.trace())
+
+	var body io.Reader
+	if p.body != "" {
+		body = strings.NewReader(p.body)
+	}
+	req, err := http.NewRequestWithContext(ctx, p.method, p.url, body)
+	if err != nil {
+		fail(ctx, &result, err)
+		return result
+	}
+	req.Header.Set("User-Agent", useragent.String())
+	for k, v := range p.headers {
+		if strings.EqualFold(k, "Host") {
+			req.Host = v
+			continue
+		}
+		req.Header.Set(k, v)
+	}
+
+	start := time.Now()
+	resp, err := p.client.Do(req)
+	// 与服务器的监控一致，响应时间计算到收到响应头为止
+	result.ResponseTime = time.Since(start).Milliseconds()
+	if err != nil {
+		fail(ctx, &result, err)
+		result.Timings = timer.timings(time.Since(start))
+		return result
+	}
+	defer resp.Body.Close()
+	result.StatusCode = resp.StatusCode
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxBodySize))
+	result.Timings = timer.timings(time.Since(start))
+	if err != nil {
+		fail(ctx, &result, err)
+		result.Error = "读取响应失败: " + result.Error
+		return result
+	}
+
+	if !statusMatches(resp.StatusCode, p.expected) {
+		mismatch(&result, ErrorStatus, "状态码不符合预期: %d, 预期: %s", resp.StatusCode, formatExpected(p.expected))
+		return result
+	}
+	if p.bodyRegex != nil && !p.bodyRegex.Match(data) {
+		mismatch(&result, ErrorAssertion, "响应内容不匹配 body_regex %q", p.bodyRegex.String())
+		return result
+	}
+	if err := checkJSON(data, p.assertions); err != nil {
+		mismatch(&result, ErrorAssertion, "%s", err)
+	}
+	return result
+}
+
+// statusMatches 判断状态码是否符合预期，expected 为 1 到 5 时只比较状态码类别，与服务器的监控一致
+func statusMatches(code, expected int) bool {
+	if expected >= 1 && expected <= 5 {
+		return code/100 == expected
+	}
+	return code == expected
+}
+
+func formatExpected(expected int) string {
+	if expected >= 1 && expected <= 5 {
+		return fmt.Sprintf("%dxx", expected)
+	}
+	return fmt.Sprint(expected)
+}
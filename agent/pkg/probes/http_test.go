@@ -0,0 +1,211 @@
+package probes
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xugou/agent/pkg/logger"
+	"github.com/xugou/agent/pkg/model"
+)
+
+func newHTTPServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"status": "ok", "data": {"items": [{"id": 7, "tags": ["a", "b"]}]}, "ready": true}`)
+	})
+	mux.HandleFunc("/echo", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Method != "POST" || string(body) != `{"ping": true}` || r.Header.Get("Authorization") != "Bearer probe-token" || r.Host != "internal.example" {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	})
+	mux.HandleFunc("/redirect", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/health", http.StatusFound)
+	})
+	mux.HandleFunc("/broken", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "boom", http.StatusServiceUnavailable)
+	})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(5 * time.Second):
+		case <-r.Context().Done():
+		}
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestHTTPProbe(t *testing.T) {
+	srv := newHTTPServer(t)
+	zero := 0
+	no := false
+	tests := []struct {
+		name      string
+		config    Config
+		status    string
+		errorType string
+		want      string // 错误信息中应包含的内容
+	}{
+		{
+			name:   "ok",
+			config: Config{URL: srv.URL + "/health", BodyRegex: `"status":\s*"ok"`},
+			status: model.ProbeUp,
+		},
+		{
+			name: "method, body and headers",
+			config: Config{
+				URL:            srv.URL + "/echo",
+				Method:         "post",
+				Body:           `{"ping": true}`,
+				Headers:        map[string]string{"Authorization": "Bearer probe-token", "Host": "internal.example"},
+				ExpectedStatus: 2,
+			},
+			status: model.ProbeUp,
+		},
+		{
+			name:      "unexpected status",
+			config:    Config{URL: srv.URL + "/broken"},
+			status:    model.ProbeDown,
+			errorType: ErrorStatus,
+			want:      "503, 预期: 200",
+		},
+		{
+			name:      "status class",
+			config:    Config{URL: srv.URL + "/broken", ExpectedStatus: 2},
+			status:    model.ProbeDown,
+			errorType: ErrorStatus,
+			want:      "预期: 2xx",
+		},
+		{
+			name:   "follows redirects",
+			config: Config{URL: srv.URL + "/redirect"},
+			status: model.ProbeUp,
+		},
+		{
+			name:      "max_redirects 0",
+			config:    Config{URL: srv.URL + "/redirect", MaxRedirects: &zero},
+			status:    model.ProbeDown,
+			errorType: ErrorStatus,
+			want:      "302",
+		},
+		{
+			name:      "body_regex mismatch",
+			config:    Config{URL: srv.URL + "/health", BodyRegex: `"status":\s*"degraded"`},
+			status:    model.ProbeDown,
+			errorType: ErrorAssertion,
+			want:      "body_regex",
+		},
+		{
+			name: "json assertions",
+			config: Config{URL: srv.URL + "/health", JSON: []JSONAssertion{
+				{Path: "$.status", Equals: "ok"},
+				{Path: "data.items[0].id", Equals: 7},
+				{Path: "data.items[0].tags[1]"},
+				{Path: "ready", Equals: true},
+				{Path: "error", Exists: &no},
+			}},
+			status: model.ProbeUp,
+		},
+		{
+			name:      "json assertion fails",
+			config:    Config{URL: srv.URL + "/health", JSON: []JSONAssertion{{Path: "data.items[0].id", Equals: 8}}},
+			status:    model.ProbeDown,
+			errorType: ErrorAssertion,
+			want:      "data.items[0].id 为 7, 预期: 8",
+		},
+		{
+			name:      "timeout",
+			config:    Config{URL: srv.URL + "/slow", Timeout: "200ms"},
+			status:    model.ProbeDown,
+			errorType: ErrorTimeout,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.Name = tt.name
+			p, err := New(tt.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			result := p.Run(context.Background())
+			if result.Status != tt.status || result.ErrorType != tt.errorType || !strings.Contains(result.Error, tt.want) {
+				t.Errorf("Run() = status %s, error type %q, error %q; want %s, %q, containing %q",
+					result.Status, result.ErrorType, result.Error, tt.status, tt.errorType, tt.want)
+			}
+			if result.Type != "http" || result.Target != tt.config.URL {
+				t.Errorf("Run() type = %q, target = %q", result.Type, result.Target)
+			}
+		})
+	}
+}
+
+func TestHTTPProbeInvalidConfig(t *testing.T) {
+	negative := -1
+	invalid := []Config{
+		{Name: "a", URL: "ftp://example.com"},
+		{Name: "a", URL: "https://"},
+		{Name: "a", URL: "https://example.com", ExpectedStatus: 42},
+		{Name: "a", URL: "https://example.com", BodyRegex: "("},
+		{Name: "a", URL: "https://example.com", MaxRedirects: &negative},
+		{Name: "a", URL: "https://example.com", JSON: []JSONAssertion{{Path: "a[x]"}}},
+		{Name: "a", URL: "https://example.com", SecretHeaders: []string{"X-Key"}},
+	}
+	for _, c := range invalid {
+		if _, err := New(c); err == nil {
+			t.Errorf("New(%+v) succeeded", c)
+		}
+	}
+}
+
+func TestHTTPProbeSecrets(t *testing.T) {
+	// 登记的凭据是全局的，每次运行使用不同的值，避免 -count 多次运行时互相影响
+	run := strconv.FormatInt(time.Now().UnixNano(), 36)
+	token, sign, password := "probe-token-"+run, "probe-sign-"+run, "probe-pass-"+run
+	raw := []interface{}{map[string]interface{}{
+		"name": "api",
+		"url":  "https://user:" + password + "@api.example.com/health",
+		"headers": map[string]interface{}{
+			"Authorization": "Bearer " + token,
+			"X-Api-Sign":    sign,
+			"Content-Type":  "application/x-probe-1",
+			"Host":          "api.probe-1.example",
+		},
+		"secret_headers": []interface{}{"X-Api-Sign"},
+	}}
+
+	// 校验配置时不登记凭据
+	configs, err := ParseConfig(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{token, sign, password} {
+		if got := logger.Redact("value " + secret); !strings.Contains(got, secret) {
+			t.Errorf("secret %s registered during validation", secret)
+		}
+	}
+
+	if _, err := NewScheduler(configs); err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{token, sign, password} {
+		if got := logger.Redact("value " + secret); strings.Contains(got, secret) {
+			t.Errorf("secret %s not redacted: %q", secret, got)
+		}
+	}
+	for _, plain := range []string{"application/x-probe-1", "api.probe-1.example"} {
+		if got := logger.Redact("value " + plain); !strings.Contains(got, plain) {
+			t.Errorf("ordinary header value %s was redacted: %q", plain, got)
+		}
+	}
+}
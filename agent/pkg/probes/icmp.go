@@ -0,0 +1,448 @@
+package probes
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"math"
+	mathrand "math/rand/v2"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xugou/agent/pkg/model"
+)
+
+// ICMP 探测的默认值
+const (
+	DefaultPingCount    = 5
+	DefaultPingInterval = time.Second
+	MinPingInterval     = 10 * time.Millisecond
+
+	// pingPayloadSize 与 ping 命令默认的数据大小一致
+	pingPayloadSize = 56
+)
+
+const (
+	icmpEchoReply     = 0
+	icmpEchoRequest   = 8
+	icmpv6EchoRequest = 128
+	icmpv6EchoReply   = 129
+)
+
+// icmpProber 按固定间隔发送多个 ICMP Echo 请求，统计往返时间和丢包率
+type icmpProber struct {
+	host          string
+	count         int
+	spacing       time.Duration
+	packetTimeout time.Duration // 每个包等待回复的最长时间，超过后按丢包计算
+}
+
+func newICMP(c Config, opts Options) (prober, error) {
+	if len(c.Targets) == 0 {
+		return nil, fmt.Errorf("缺少 targets")
+	}
+	if len(c.Targets) > 1 {
+		return nil, fmt.Errorf("一个 ICMP 探测只能有一个目标")
+	}
+	host := strings.Trim(strings.TrimSpace(c.Targets[0]), "[]")
+	if host == "" || (net.ParseIP(host) == nil && strings.ContainsAny(host, " /:")) {
+		return nil, fmt.Errorf("无效的目标 %q", c.Targets[0])
+	}
+
+	p := &icmpProber{host: host, count: DefaultPingCount, spacing: DefaultPingInterval}
+	if c.Count != 0 {
+		if c.Count < 0 || c.Count > 1000 {
+			return nil, fmt.Errorf("无效的 count %d，范围为 1 到 1000", c.Count)
+		}
+		p.count = c.Count
+	}
+	if c.PacketInterval != "" {
+		d, err := time.ParseDuration(c.PacketInterval)
+		if err != nil || d < MinPingInterval {
+			return nil, fmt.Errorf("无效的 packet_interval %q，不能小于 %s", c.PacketInterval, MinPingInterval)
+		}
+		p.spacing = d
+	}
+	// 最后一个包发出后还要留出等待回复的时间
+	need := p.spacing * time.Duration(p.count-1)
+	if need >= opts.Timeout {
+		return nil, fmt.Errorf("按 packet_interval %s 发送 %d 个包需要 %s，超过了 timeout %s", p.spacing, p.count, need, opts.Timeout)
+	}
+	// 默认每个包的等待时间与最后一个包相同，即 timeout 中发送所有包之后剩余的时间
+	p.packetTimeout = opts.Timeout - need
+	if c.PacketTimeout != "" {
+		d, err := time.ParseDuration(c.PacketTimeout)
+		if err != nil || d <= 0 || d > p.packetTimeout {
+			return nil, fmt.Errorf("无效的 packet_timeout %q，不能超过 timeout 中发送所有包之后剩余的 %s", c.PacketTimeout, p.packetTimeout)
+		}
+		p.packetTimeout = d
+	}
+	return p, nil
+}
+
+// echoReply 收到的一个回复
+type echoReply struct {
+	seq int
+	at  time.Time
+}
+
+func (p *icmpProber) Probe(ctx context.Context) (result model.ProbeResult) {
+	result = model.ProbeResult{Target: p.host}
+
+	ip, err := resolveIP(ctx, p.host)
+	if err != nil {
+		fail(ctx, &result, err)
+		return result
+	}
+
+	// 数据中带有随机的标记，用于区分属于本次探测的回复
+	payload := make([]byte, pingPayloadSize)
+	rand.Read(payload[:8])
+	sess, err := openPing(ip, payload[:8], p.count)
+	if err != nil {
+		mismatch(&result, ErrorNetwork, "%s", err)
+		return result
+	}
+	defer sess.close()
+
+	sent := make([]time.Time, 0, p.count)
+	rtts := make([]time.Duration, p.count)
+	received := 0
+	var sendErr error
+	next := time.NewTimer(0)
+	defer next.Stop()
+	var last <-chan time.Time // 最后一个包的等待时间结束
+
+wait:
+	for received < p.count {
+		select {
+		case <-next.C:
+			seq := len(sent)
+			sent = append(sent, time.Now())
+			if err := sess.send(seq, payload); err != nil {
+				sendErr = err
+			}
+			if len(sent) < p.count {
+				next.Reset(p.spacing)
+			} else {
+				last = time.After(p.packetTimeout)
+			}
+		case r := <-sess.replies:
+			// 超过等待时间才收到的回复按丢包计算
+			if r.seq < len(sent) && rtts[r.seq] == 0 && r.at.Sub(sent[r.seq]) <= p.packetTimeout {
+				rtts[r.seq] = max(r.at.Sub(sent[r.seq]), time.Microsecond)
+				received++
+			}
+		case <-last:
+			break wait
+		case <-ctx.Done():
+			break wait
+		}
+	}
+
+	stats := pingStats(len(sent), rtts)
+	result.Ping = stats
+	result.ResponseTime = int64(math.Round(stats.AvgMs))
+	if received == 0 {
+		if sendErr != nil {
+			fail(ctx, &result, sendErr)
+		} else {
+			mismatch(&result, ErrorTimeout, "发送的 %d 个包全部丢失", len(sent))
+		}
+	}
+	return result
+}
+
+// pingStats 根据每个包的往返时间计算统计值，没有收到回复的包往返时间为 0
+func pingStats(sent int, rtts []time.Duration) *model.PingStats {
+	stats := &model.PingStats{Sent: sent}
+	if sent == 0 {
+		return stats
+	}
+	var values []float64
+	for _, rtt := range rtts {
+		if rtt > 0 {
+			values = append(values, milliseconds(rtt))
+		}
+	}
+	stats.Received = len(values)
+	stats.Loss = round3(float64(sent-len(values)) / float64(sent) * 100)
+	if len(values) == 0 {
+		return stats
+	}
+
+	stats.MinMs, stats.MaxMs = values[0], values[0]
+	var sum, jitter float64
+	for i, v := range values {
+		stats.MinMs = math.Min(stats.MinMs, v)
+		stats.MaxMs = math.Max(stats.MaxMs, v)
+		sum += v
+		if i > 0 {
+			jitter += math.Abs(v - values[i-1])
+		}
+	}
+	avg := sum / float64(len(values))
+	var variance float64
+	for _, v := range values {
+		variance += (v - avg) * (v - avg)
+	}
+	stats.AvgMs = round3(avg)
+	stats.StddevMs = round3(math.Sqrt(variance / float64(len(values))))
+	if len(values) > 1 {
+		stats.JitterMs = round3(jitter / float64(len(values)-1))
+	}
+	return stats
+}
+
+func round3(v float64) float64 {
+	return math.Round(v*1000) / 1000
+}
+
+// resolveIP 解析目标地址，同时有 IPv4 和 IPv6 地址时优先使用 IPv4
+func resolveIP(ctx context.Context, host string) (net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return ip, nil
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range addrs {
+		if addr.IP.To4() != nil {
+			return addr.IP, nil
+		}
+	}
+	return addrs[0].IP, nil
+}
+
+func addrIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return a.IP
+	case *net.IPAddr:
+		return a.IP
+	}
+	return nil
+}
+
+// icmpConn ICMP 套接字。datagram 为 true 时是非特权的数据报套接字，内核会改写请求的标识符，
+// 并且只把属于该套接字的回复交给它；否则是需要 root 或 CAP_NET_RAW 权限的原始套接字
+type icmpConn struct {
+	net.PacketConn
+	ipv6     bool
+	datagram bool
+}
+
+// pingSession 一次探测使用的套接字和收到的回复
+type pingSession struct {
+	conn    *icmpConn
+	id      uint16
+	ip      net.IP
+	token   []byte
+	replies chan echoReply
+	close   func()
+}
+
+// send 发送序号为 seq 的 Echo 请求
+func (s *pingSession) send(seq int, payload []byte) error {
+	_, err := s.conn.WriteTo(s.conn.echo(s.id, seq, payload), s.conn.addr(s.ip))
+	return err
+}
+
+// deliver 把来自目标并带有本次探测标记的回复交给探测，回复过多时丢弃
+func (s *pingSession) deliver(from net.Addr, seq int, data []byte, at time.Time) {
+	if !addrIP(from).Equal(s.ip) || !bytes.HasPrefix(data, s.token) {
+		return
+	}
+	select {
+	case s.replies <- echoReply{seq: seq, at: at}:
+	default:
+	}
+}
+
+// openPing 为一次探测打开套接字，优先使用非特权的数据报套接字，系统不允许时改用所有探测共用的原始套接字
+func openPing(ip net.IP, token []byte, count int) (*pingSession, error) {
+	ipv6 := ip.To4() == nil
+	pc, dgramErr := listenDatagram(ipv6)
+	if dgramErr != nil {
+		family := 0
+		if ipv6 {
+			family = 1
+		}
+		sess, err := rawSockets[family].open(ipv6, ip, token, count)
+		if err != nil {
+			return nil, fmt.Errorf("无法创建 ICMP 套接字（数据报套接字: %v；原始套接字: %v），需要把运行用户的组加入 net.ipv4.ping_group_range，或授予 CAP_NET_RAW 权限", dgramErr, err)
+		}
+		return sess, nil
+	}
+
+	conn := &icmpConn{PacketConn: pc, ipv6: ipv6, datagram: true}
+	sess := &pingSession{conn: conn, id: uint16(mathrand.Uint32()), ip: ip, token: token, replies: make(chan echoReply, count)}
+	sess.close = func() { conn.Close() }
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, from, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			at := time.Now()
+			// 内核已经按套接字分发回复，并改写了标识符，不需要检查
+			if _, seq, data, ok := conn.parseEcho(buf[:n]); ok {
+				sess.deliver(from, seq, data, at)
+			}
+		}
+	}()
+	return sess, nil
+}
+
+// rawSockets 所有探测共用的原始套接字，下标 0 为 IPv4，1 为 IPv6
+var rawSockets [2]rawSocket
+
+// rawSocket 所有探测共用的原始套接字。原始套接字会收到本机所有的 ICMP 报文，每个探测各打开一个时，
+// 每个报文都要复制给所有套接字；共用一个套接字后由一个读取协程按标识符分发给对应的探测。
+// 没有探测使用时关闭套接字
+type rawSocket struct {
+	mu       sync.Mutex
+	conn     *icmpConn
+	sessions map[uint16]*pingSession
+}
+
+// open 登记一次探测，为它分配一个未被使用的标识符，需要时打开套接字
+func (r *rawSocket) open(ipv6 bool, ip net.IP, token []byte, count int) (*pingSession, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.conn == nil {
+		network, address := "ip4:icmp", "0.0.0.0"
+		if ipv6 {
+			network, address = "ip6:ipv6-icmp", "::"
+		}
+		pc, err := net.ListenPacket(network, address)
+		if err != nil {
+			return nil, err
+		}
+		r.conn = &icmpConn{PacketConn: pc, ipv6: ipv6}
+		r.sessions = make(map[uint16]*pingSession)
+		go r.read(r.conn)
+	}
+	if len(r.sessions) > math.MaxUint16 {
+		return nil, fmt.Errorf("同时运行的 ICMP 探测过多")
+	}
+
+	sess := &pingSession{conn: r.conn, ip: ip, token: token, replies: make(chan echoReply, count)}
+	for {
+		sess.id = uint16(mathrand.Uint32())
+		if _, used := r.sessions[sess.id]; !used {
+			break
+		}
+	}
+	r.sessions[sess.id] = sess
+	sess.close = func() { r.release(sess) }
+	return sess, nil
+}
+
+// release 注销一次探测，没有探测使用时关闭套接字
+func (r *rawSocket) release(sess *pingSession) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.conn != sess.conn {
+		return
+	}
+	delete(r.sessions, sess.id)
+	if len(r.sessions) == 0 {
+		r.conn.Close()
+		r.conn = nil
+	}
+}
+
+// read 读取回复并按标识符分发，套接字出错时关闭它，下一次探测重新打开
+func (r *rawSocket) read(conn *icmpConn) {
+	buf := make([]byte, 1500)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			r.mu.Lock()
+			if r.conn == conn {
+				conn.Close()
+				r.conn = nil
+			}
+			r.mu.Unlock()
+			return
+		}
+		at := time.Now()
+		id, seq, data, ok := conn.parseEcho(buf[:n])
+		if !ok {
+			continue
+		}
+		r.mu.Lock()
+		sess := r.sessions[id]
+		if r.conn != conn {
+			sess = nil
+		}
+		r.mu.Unlock()
+		if sess != nil {
+			sess.deliver(from, seq, data, at)
+		}
+	}
+}
+
+func (c *icmpConn) addr(ip net.IP) net.Addr {
+	if c.datagram {
+		return &net.UDPAddr{IP: ip}
+	}
+	return &net.IPAddr{IP: ip}
+}
+
+// echo 构造 Echo 请求
+func (c *icmpConn) echo(id uint16, seq int, payload []byte) []byte {
+	msg := make([]byte, 8, 8+len(payload))
+	msg[0] = icmpEchoRequest
+	if c.ipv6 {
+		msg[0] = icmpv6EchoRequest
+	}
+	binary.BigEndian.PutUint16(msg[4:], id)
+	binary.BigEndian.PutUint16(msg[6:], uint16(seq))
+	msg = append(msg, payload...)
+	// ICMPv6 的校验和包含 IPv6 伪首部，由内核计算
+	if !c.ipv6 {
+		binary.BigEndian.PutUint16(msg[2:], icmpChecksum(msg))
+	}
+	return msg
+}
+
+// parseEcho 解析 Echo 回复，返回标识符、序号和数据，不是 Echo 回复时 ok 为 false
+func (c *icmpConn) parseEcho(msg []byte) (id uint16, seq int, data []byte, ok bool) {
+	// macOS 的数据报套接字收到的报文带有 IPv4 首部
+	if !c.ipv6 && len(msg) >= 20 && msg[0]>>4 == 4 {
+		msg = msg[int(msg[0]&0x0f)*4:]
+	}
+	if len(msg) < 8 {
+		return 0, 0, nil, false
+	}
+	want := byte(icmpEchoReply)
+	if c.ipv6 {
+		want = icmpv6EchoReply
+	}
+	if msg[0] != want {
+		return 0, 0, nil, false
+	}
+	return binary.BigEndian.Uint16(msg[4:]), int(binary.BigEndian.Uint16(msg[6:])), msg[8:], true
+}
+
+func icmpChecksum(msg []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(msg); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(msg[i:]))
+	}
+	if len(msg)%2 == 1 {
+		sum += uint32(msg[len(msg)-1]) << 8
+	}
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
@@ -0,0 +1,12 @@
+//go:build !linux && !darwin
+
+package probes
+
+import (
+	"errors"
+	"net"
+)
+
+func listenDatagram(ipv6 bool) (net.PacketConn, error) {
+	return nil, errors.New("当前系统不支持非特权 ICMP 套接字")
+}
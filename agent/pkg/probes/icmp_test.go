@@ -0,0 +1,162 @@
+package probes
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xugou/agent/pkg/model"
+)
+
+func TestICMPProbe(t *testing.T) {
+	// 同时运行多个探测，在没有数据报套接字权限时共用一个原始套接字
+	var wg sync.WaitGroup
+	results := make([]model.ProbeResult, 4)
+	for i := range results {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = runProbe(t, Config{Type: "icmp", Targets: []string{"127.0.0.1"}, Count: 3, PacketInterval: "20ms", Timeout: "2s"})
+		}()
+	}
+	wg.Wait()
+	for _, result := range results {
+		if result.ErrorType == ErrorNetwork && strings.Contains(result.Error, "无法创建 ICMP 套接字") {
+			t.Skip(result.Error)
+		}
+		if result.Status != model.ProbeUp || result.Ping == nil || result.Ping.Sent != 3 || result.Ping.Received != 3 {
+			t.Errorf("Run() = status %s, error %q, ping %+v", result.Status, result.Error, result.Ping)
+		}
+	}
+	for i := range rawSockets {
+		r := &rawSockets[i]
+		r.mu.Lock()
+		if r.conn != nil {
+			t.Error("raw socket still open after all probes finished")
+		}
+		r.mu.Unlock()
+	}
+}
+
+func TestICMPProbeInvalidConfig(t *testing.T) {
+	invalid := []Config{
+		{Name: "a", Type: "icmp"},
+		{Name: "a", Type: "icmp", Targets: []string{"a", "b"}},
+		{Name: "a", Type: "icmp", Targets: []string{"bad host"}},
+		{Name: "a", Type: "icmp", Targets: []string{"127.0.0.1"}, Count: 1001},
+		{Name: "a", Type: "icmp", Targets: []string{"127.0.0.1"}, PacketInterval: "1ms"},
+		{Name: "a", Type: "icmp", Targets: []string{"127.0.0.1"}, Count: 5, PacketInterval: "1s", Timeout: "4s"},
+		{Name: "a", Type: "icmp", Targets: []string{"127.0.0.1"}, PacketTimeout: "0s"},
+		// 5 个包间隔 1s，timeout 10s 时每个包最多等待 6s
+		{Name: "a", Type: "icmp", Targets: []string{"127.0.0.1"}, Count: 5, Timeout: "10s", PacketTimeout: "7s"},
+	}
+	for _, c := range invalid {
+		if _, err := New(c); err == nil {
+			t.Errorf("New(%+v) succeeded", c)
+		}
+	}
+
+	p, err := New(Config{Name: "a", Type: "icmp", Targets: []string{"127.0.0.1"}, Count: 5, Timeout: "10s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := p.prober.(*icmpProber).packetTimeout; d != 6*time.Second {
+		t.Errorf("default packet_timeout = %s, want 6s", d)
+	}
+}
+
+func TestRawSocketDemux(t *testing.T) {
+	// 用 UDP 套接字代替原始套接字，按标识符把回复分发给对应的探测
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sender, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+
+	var r rawSocket
+	conn := &icmpConn{PacketConn: pc}
+	r.conn = conn
+	r.sessions = make(map[uint16]*pingSession)
+	go r.read(conn)
+
+	ip := net.IPv4(127, 0, 0, 1)
+	a, err := r.open(false, ip, []byte("token-a"), 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := r.open(false, ip, []byte("token-b"), 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.id == b.id {
+		t.Fatalf("sessions share id %d", a.id)
+	}
+
+	reply := func(id uint16, seq int, data string) {
+		msg := conn.echo(id, seq, []byte(data))
+		msg[0] = icmpEchoReply
+		if _, err := sender.WriteTo(msg, pc.LocalAddr()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	other := a.id + 1
+	for other == a.id || other == b.id {
+		other++
+	}
+	reply(a.id, 0, "token-a")
+	reply(b.id, 1, "token-b")
+	reply(a.id, 2, "token-b")  // 标记不属于该探测
+	reply(other, 3, "token-a") // 标识符不属于任何探测
+	reply(a.id, 4, "token-a")
+
+	expect := func(s *pingSession, seqs ...int) {
+		t.Helper()
+		for _, seq := range seqs {
+			select {
+			case got := <-s.replies:
+				if got.seq != seq {
+					t.Errorf("session %d got seq %d, want %d", s.id, got.seq, seq)
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatalf("session %d did not receive seq %d", s.id, seq)
+			}
+		}
+	}
+	expect(a, 0, 4)
+	expect(b, 1)
+	select {
+	case got := <-b.replies:
+		t.Errorf("session %d got unexpected seq %d", b.id, got.seq)
+	default:
+	}
+
+	a.close()
+	if r.conn == nil {
+		t.Fatal("socket closed while a session is still open")
+	}
+	b.close()
+	if r.conn != nil || len(r.sessions) != 0 {
+		t.Error("socket not closed after the last session")
+	}
+}
+
+func TestPingStats(t *testing.T) {
+	ms := time.Millisecond
+	stats := pingStats(4, []time.Duration{10 * ms, 0, 20 * ms, 15 * ms})
+	want := model.PingStats{Sent: 4, Received: 3, Loss: 25, MinMs: 10, MaxMs: 20, AvgMs: 15, StddevMs: 4.082, JitterMs: 7.5}
+	if *stats != want {
+		t.Errorf("pingStats() = %+v, want %+v", *stats, want)
+	}
+	if stats := pingStats(3, make([]time.Duration, 3)); stats.Loss != 100 || stats.Received != 0 {
+		t.Errorf("pingStats() all lost = %+v", stats)
+	}
+	if stats := pingStats(0, nil); stats.Sent != 0 || stats.Loss != 0 {
+		t.Errorf("pingStats() nothing sent = %+v", stats)
+	}
+}
@@ -0,0 +1,29 @@
+//go:build linux || darwin
+
+package probes
+
+import (
+	"net"
+	"os"
+	"syscall"
+)
+
+// listenDatagram 创建非特权的 ICMP 数据报套接字，Linux 要求运行用户的组在 net.ipv4.ping_group_range 范围内
+func listenDatagram(ipv6 bool) (net.PacketConn, error) {
+	family, proto := syscall.AF_INET, syscall.IPPROTO_ICMP
+	var addr syscall.Sockaddr = &syscall.SockaddrInet4{}
+	if ipv6 {
+		family, proto, addr = syscall.AF_INET6, syscall.IPPROTO_ICMPV6, &syscall.SockaddrInet6{}
+	}
+	fd, err := syscall.Socket(family, syscall.SOCK_DGRAM, proto)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	if err := syscall.Bind(fd, addr); err != nil {
+		syscall.Close(fd)
+		return nil, os.NewSyscallError("bind", err)
+	}
+	f := os.NewFile(uintptr(fd), "icmp")
+	defer f.Close()
+	return net.FilePacketConn(f)
+}
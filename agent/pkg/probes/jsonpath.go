@@ -0,0 +1,126 @@
+package probes
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// JSONAssertion 对 JSON 响应中某个字段的断言。只设置 path 时要求字段存在
+type JSONAssertion struct {
+	Path   string      `json:"path"`   // 字段路径，例如 status、data.items[0].id，可以以 $. 开头
+	Equals interface{} `json:"equals"` // 字段的值必须等于该值，按 JSON 编码后比较
+	Exists *bool       `json:"exists"` // 为 false 时要求字段不存在
+}
+
+type jsonAssertion struct {
+	JSONAssertion
+	path   []interface{} // string 表示对象的字段，int 表示数组下标
+	equals []byte
+}
+
+func (a JSONAssertion) compile() (jsonAssertion, error) {
+	path, err := parsePath(a.Path)
+	if err != nil {
+		return jsonAssertion{}, fmt.Errorf("无效的 JSON 路径 %q: %w", a.Path, err)
+	}
+	compiled := jsonAssertion{JSONAssertion: a, path: path}
+	if a.Equals != nil {
+		if a.Exists != nil && !*a.Exists {
+			return compiled, fmt.Errorf("JSON 路径 %q 不能同时设置 equals 和 exists: false", a.Path)
+		}
+		if compiled.equals, err = json.Marshal(a.Equals); err != nil {
+			return compiled, fmt.Errorf("JSON 路径 %q 的 equals 无效: %w", a.Path, err)
+		}
+	}
+	return compiled, nil
+}
+
+// parsePath 解析 a.b[0].c 形式的路径
+func parsePath(p string) ([]interface{}, error) {
+	p = strings.TrimPrefix(strings.TrimPrefix(p, "$"), ".")
+	if p == "" {
+		return nil, fmt.Errorf("路径为空")
+	}
+	var path []interface{}
+	for _, part := range strings.Split(p, ".") {
+		name, rest, bracket := strings.Cut(part, "[")
+		if name != "" {
+			path = append(path, name)
+		} else if !bracket {
+			return nil, fmt.Errorf("字段名为空")
+		}
+		for bracket {
+			index, after, ok := strings.Cut(rest, "]")
+			n, err := strconv.Atoi(index)
+			if !ok || err != nil || n < 0 {
+				return nil, fmt.Errorf("无效的数组下标 [%s", rest)
+			}
+			path = append(path, n)
+			if after == "" {
+				break
+			}
+			if !strings.HasPrefix(after, "[") {
+				return nil, fmt.Errorf("下标后面缺少 .")
+			}
+			rest = after[1:]
+		}
+	}
+	return path, nil
+}
+
+// lookup 按路径查找字段
+func lookup(v interface{}, path []interface{}) (interface{}, bool) {
+	for _, key := range path {
+		switch key := key.(type) {
+		case string:
+			m, ok := v.(map[string]interface{})
+			if !ok {
+				return nil, false
+			}
+			if v, ok = m[key]; !ok {
+				return nil, false
+			}
+		case int:
+			list, ok := v.([]interface{})
+			if !ok || key >= len(list) {
+				return nil, false
+			}
+			v = list[key]
+		}
+	}
+	return v, true
+}
+
+// checkJSON 解析响应内容并检查所有断言，返回第一个失败的断言
+func checkJSON(data []byte, assertions []jsonAssertion) error {
+	if len(assertions) == 0 {
+		return nil
+	}
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("响应内容不是合法的 JSON: %w", err)
+	}
+	for _, a := range assertions {
+		value, found := lookup(doc, a.path)
+		if a.Exists != nil && !*a.Exists {
+			if found {
+				return fmt.Errorf("JSON 断言失败: %s 不应存在", a.Path)
+			}
+			continue
+		}
+		if !found {
+			return fmt.Errorf("JSON 断言失败: %s 不存在", a.Path)
+		}
+		if a.equals == nil {
+			continue
+		}
+		actual, _ := json.Marshal(value)
+		if !bytes.Equal(actual, a.equals) {
+			return fmt.Errorf("JSON 断言失败: %s 为 %s, 预期: %s", a.Path, actual, a.equals)
+		}
+	}
+	return nil
+}
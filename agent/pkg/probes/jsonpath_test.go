@@ -0,0 +1,98 @@
+package probes
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParsePath(t *testing.T) {
+	tests := []struct {
+		path string
+		want []interface{}
+	}{
+		{"status", []interface{}{"status"}},
+		{"$.status", []interface{}{"status"}},
+		{"$status", []interface{}{"status"}},
+		{"data.items[0].id", []interface{}{"data", "items", 0, "id"}},
+		{"matrix[1][2]", []interface{}{"matrix", 1, 2}},
+		{"$[0].name", []interface{}{0, "name"}},
+	}
+	for _, tt := range tests {
+		got, err := parsePath(tt.path)
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parsePath(%q) = %v, %v, want %v", tt.path, got, err, tt.want)
+		}
+	}
+
+	for _, path := range []string{"", "$", "a..b", "a[", "a[-1]", "a[x]", "a[0]b", "a.[0]x"} {
+		if got, err := parsePath(path); err == nil {
+			t.Errorf("parsePath(%q) = %v, want error", path, got)
+		}
+	}
+}
+
+func TestCheckJSON(t *testing.T) {
+	doc := []byte(`{"status": "ok", "count": 3, "ratio": 0.5, "ready": true, "none": null,
+		"data": {"items": [{"id": 7}, {"id": 8, "tags": ["x"]}], "meta": {"a": 1, "b": [1, 2]}}}`)
+	no := false
+	yes := true
+	tests := []struct {
+		name      string
+		assertion JSONAssertion
+		want      string // 为空表示断言通过
+	}{
+		{"exists", JSONAssertion{Path: "data.items[1].tags[0]"}, ""},
+		{"missing field", JSONAssertion{Path: "data.missing"}, "data.missing 不存在"},
+		{"index out of range", JSONAssertion{Path: "data.items[2]"}, "不存在"},
+		{"index on object", JSONAssertion{Path: "data[0]"}, "不存在"},
+		{"field on array", JSONAssertion{Path: "data.items.id"}, "不存在"},
+		{"null exists", JSONAssertion{Path: "none", Exists: &yes}, ""},
+		{"equals null", JSONAssertion{Path: "none", Equals: nil}, ""},
+		{"not exists", JSONAssertion{Path: "error", Exists: &no}, ""},
+		{"exists but should not", JSONAssertion{Path: "status", Exists: &no}, "status 不应存在"},
+		{"equals string", JSONAssertion{Path: "status", Equals: "ok"}, ""},
+		{"equals string mismatch", JSONAssertion{Path: "status", Equals: "down"}, `status 为 "ok", 预期: "down"`},
+		{"equals int", JSONAssertion{Path: "count", Equals: 3}, ""},
+		{"equals float", JSONAssertion{Path: "ratio", Equals: 0.5}, ""},
+		{"number is not string", JSONAssertion{Path: "count", Equals: "3"}, `count 为 3, 预期: "3"`},
+		{"equals bool", JSONAssertion{Path: "ready", Equals: true}, ""},
+		// 对象按键排序后编码，与配置中的键顺序无关
+		{"equals object", JSONAssertion{Path: "data.meta", Equals: map[string]interface{}{"b": []interface{}{1, 2}, "a": 1}}, ""},
+		{"equals array", JSONAssertion{Path: "data.meta.b", Equals: []interface{}{2, 1}}, "预期: [2,1]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			compiled, err := tt.assertion.compile()
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = checkJSON(doc, []jsonAssertion{compiled})
+			switch {
+			case tt.want == "" && err != nil:
+				t.Errorf("checkJSON() = %v, want nil", err)
+			case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+				t.Errorf("checkJSON() = %v, want containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckJSONInvalid(t *testing.T) {
+	compiled, err := JSONAssertion{Path: "status"}.compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := checkJSON([]byte("<html>"), []jsonAssertion{compiled}); err == nil || !strings.Contains(err.Error(), "不是合法的 JSON") {
+		t.Errorf("checkJSON(html) = %v", err)
+	}
+	// 没有断言时不要求响应是 JSON
+	if err := checkJSON([]byte("<html>"), nil); err != nil {
+		t.Errorf("checkJSON(html, nil) = %v", err)
+	}
+
+	no := false
+	if _, err := (JSONAssertion{Path: "a", Equals: 1, Exists: &no}).compile(); err == nil {
+		t.Error("compile() accepted equals together with exists: false")
+	}
+}
@@ -0,0 +1,196 @@
+package probes
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/xugou/agent/pkg/model"
+)
+
+// Nagios 插件的默认值
+const (
+	DefaultMaxOutput   = 4096 // 每个插件保留的输出字节数
+	DefaultMaxCommands = 4    // 同时运行的插件数量
+)
+
+// commandSlots 限制同时运行的插件数量，所有 nagios 探测共享
+var commandSlots atomic.Pointer[chan struct{}]
+
+func init() {
+	SetMaxCommands(DefaultMaxCommands)
+}
+
+// SetMaxCommands 设置同时运行的插件的最大数量，可以在探测运行时调用。
+// 已经在运行的插件结束后释放原来的位置，新的限制只对之后启动的插件生效
+func SetMaxCommands(n int) {
+	if n < 1 {
+		n = DefaultMaxCommands
+	}
+	slots := make(chan struct{}, n)
+	commandSlots.Store(&slots)
+}
+
+// pluginStates 退出码 0 到 3 对应的状态
+var pluginStates = []string{model.PluginOK, model.PluginWarning, model.PluginCritical, model.PluginUnknown}
+
+// nagiosProber 运行 Nagios 插件，按退出码判断状态并解析输出中的性能数据。
+// OK 和 WARNING 视为可用，CRITICAL、UNKNOWN、超时和无法运行视为失败
+type nagiosProber struct {
+	command   string
+	args      []string
+	dir       string
+	user      *user.User
+	maxOutput int
+}
+
+func newNagios(c Config, opts Options) (prober, error) {
+	if c.Command == "" {
+		return nil, fmt.Errorf("缺少 command")
+	}
+	p := &nagiosProber{command: c.Command, args: c.Args, dir: c.WorkingDir, maxOutput: DefaultMaxOutput}
+	if c.MaxOutput != 0 {
+		if c.MaxOutput < 0 {
+			return nil, fmt.Errorf("无效的 max_output %d", c.MaxOutput)
+		}
+		p.maxOutput = c.MaxOutput
+	}
+	if c.User != "" {
+		if !runAsSupported {
+			return nil, fmt.Errorf("当前系统不支持以其他用户身份运行插件")
+		}
+		u, err := user.Lookup(c.User)
+		if err != nil {
+			if u, err = user.LookupId(c.User); err != nil {
+				return nil, fmt.Errorf("找不到用户 %s", c.User)
+			}
+		}
+		p.user = u
+	}
+	return p, nil
+}
+
+func (p *nagiosProber) Probe(ctx context.Context) (result model.ProbeResult) {
+	// 命令参数中可能带有密码，目标只显示命令
+	result = model.ProbeResult{Target: p.command}
+	unknown := &model.PluginResult{ExitCode: 3, State: model.PluginUnknown}
+
+	// 等待空闲的位置也计入超时时间
+	slots := *commandSlots.Load()
+	select {
+	case slots <- struct{}{}:
+		defer func() { <-slots }()
+	case <-ctx.Done():
+		result.Plugin = unknown
+		mismatch(&result, ErrorTimeout, "等待其他插件运行结束时超时")
+		return result
+	}
+
+	cmd := exec.CommandContext(ctx, p.command, p.args...)
+	cmd.Dir = p.dir
+	cmd.Env = pluginEnv(p.user)
+	stdout := &limitedBuffer{limit: p.maxOutput}
+	stderr := &limitedBuffer{limit: p.maxOutput}
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	// 插件启动的后台进程继承了输出管道时，不等待它们退出
+	cmd.WaitDelay = time.Second
+	configureCommand(cmd, p.user)
+
+	start := time.Now()
+	err := cmd.Run()
+	result.ResponseTime = time.Since(start).Milliseconds()
+
+	// 标准输出为空时使用标准错误，便于看到插件无法运行的原因
+	output := stdout
+	if strings.TrimSpace(stdout.String()) == "" {
+		output = stderr
+	}
+	plugin := parsePluginOutput(output.String())
+	plugin.Truncated = output.truncated
+
+	switch {
+	case ctx.Err() != nil:
+		plugin.ExitCode, plugin.State = unknown.ExitCode, unknown.State
+		result.Plugin = plugin
+		mismatch(&result, ErrorTimeout, "插件运行超时")
+		return result
+	case cmd.ProcessState == nil:
+		result.Plugin = unknown
+		mismatch(&result, ErrorPlugin, "无法运行插件: %s", err)
+		return result
+	}
+
+	plugin.ExitCode = cmd.ProcessState.ExitCode()
+	plugin.State = model.PluginUnknown
+	if plugin.ExitCode >= 0 && plugin.ExitCode < len(pluginStates) {
+		plugin.State = pluginStates[plugin.ExitCode]
+	}
+	result.Plugin = plugin
+
+	switch {
+	case plugin.ExitCode < 0:
+		mismatch(&result, ErrorPlugin, "插件被信号终止: %s", cmd.ProcessState)
+	case plugin.State == model.PluginCritical || plugin.State == model.PluginUnknown:
+		text := plugin.Output
+		if text == "" {
+			text = "插件没有输出"
+		}
+		mismatch(&result, ErrorPlugin, "%s（退出码 %d）: %s", plugin.State, plugin.ExitCode, text)
+	}
+	return result
+}
+
+// pluginEnv 返回插件的环境变量，不传递 Agent 自己的 XUGOU_ 配置（其中可能有令牌），以其他用户运行时改为该用户的 HOME 等变量
+func pluginEnv(u *user.User) []string {
+	var env []string
+	for _, kv := range os.Environ() {
+		name, _, _ := strings.Cut(kv, "=")
+		if strings.HasPrefix(name, "XUGOU_") {
+			continue
+		}
+		if u != nil && (name == "HOME" || name == "USER" || name == "LOGNAME") {
+			continue
+		}
+		env = append(env, kv)
+	}
+	if u != nil {
+		env = append(env, "HOME="+u.HomeDir, "USER="+u.Username, "LOGNAME="+u.Username)
+	}
+	return env
+}
+
+// limitedBuffer 只保留前 limit 个字节，超出的内容丢弃，但不会让插件因为管道写满而阻塞
+type limitedBuffer struct {
+	buf       bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.buf.Len(); room < len(p) {
+		b.truncated = true
+		if room > 0 {
+			b.buf.Write(p[:room])
+		}
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+// String 返回保留的内容，截断时去掉末尾不完整的 UTF-8 字符
+func (b *limitedBuffer) String() string {
+	data := b.buf.Bytes()
+	if b.truncated {
+		for i := 0; i < utf8.UTFMax && len(data) > 0 && !utf8.Valid(data); i++ {
+			data = data[:len(data)-1]
+		}
+	}
+	return string(data)
+}
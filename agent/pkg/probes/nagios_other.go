@@ -0,0 +1,12 @@
+//go:build windows || plan9
+
+package probes
+
+import (
+	"os/exec"
+	"os/user"
+)
+
+const runAsSupported = false
+
+func configureCommand(cmd *exec.Cmd, u *user.User) {}
@@ -0,0 +1,198 @@
+//go:build !windows && !plan9
+
+package probes
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xugou/agent/pkg/model"
+)
+
+// shPlugin 返回通过 sh -c 运行 script 的插件配置
+func shPlugin(script string) Config {
+	return Config{Type: "nagios", Command: "sh", Args: []string{"-c", script}}
+}
+
+func TestNagiosExitCodes(t *testing.T) {
+	tests := []struct {
+		code      string
+		wantCode  int
+		state     string
+		status    string
+		errorType string
+	}{
+		{"0", 0, model.PluginOK, model.ProbeUp, ""},
+		{"1", 1, model.PluginWarning, model.ProbeUp, ""},
+		{"2", 2, model.PluginCritical, model.ProbeDown, ErrorPlugin},
+		{"3", 3, model.PluginUnknown, model.ProbeDown, ErrorPlugin},
+		// 超出 0 到 3 的退出码按 UNKNOWN 处理
+		{"5", 5, model.PluginUnknown, model.ProbeDown, ErrorPlugin},
+		{"255", 255, model.PluginUnknown, model.ProbeDown, ErrorPlugin},
+	}
+	for _, tt := range tests {
+		result := runProbe(t, shPlugin("echo 'CHECK - status "+tt.code+" | value="+tt.code+";1;2'; exit "+tt.code))
+		if result.Plugin == nil {
+			t.Fatalf("exit %s: no plugin result", tt.code)
+		}
+		if result.Plugin.ExitCode != tt.wantCode || result.Plugin.State != tt.state {
+			t.Errorf("exit %s: plugin = %d %s, want %d %s", tt.code, result.Plugin.ExitCode, result.Plugin.State, tt.wantCode, tt.state)
+		}
+		if result.Status != tt.status || result.ErrorType != tt.errorType {
+			t.Errorf("exit %s: result = %s %q (%s), want %s %q", tt.code, result.Status, result.ErrorType, result.Error, tt.status, tt.errorType)
+		}
+		if result.Plugin.Output != "CHECK - status "+tt.code || len(result.Plugin.Perfdata) != 1 {
+			t.Errorf("exit %s: output %q, perfdata %+v", tt.code, result.Plugin.Output, result.Plugin.Perfdata)
+		}
+		if tt.errorType != "" && !strings.Contains(result.Error, "CHECK - status "+tt.code) {
+			t.Errorf("exit %s: error %q does not contain the plugin output", tt.code, result.Error)
+		}
+	}
+}
+
+func TestNagiosOutput(t *testing.T) {
+	// 插件的多行输出和性能数据
+	result := runProbe(t, shPlugin(`printf 'DISK OK | /=10MB;20;30\n/ 10 MB\n/home 5 MB | /home=5MB\n'`))
+	plugin := result.Plugin
+	if result.Status != model.ProbeUp || plugin.Output != "DISK OK" || plugin.LongOutput != "/ 10 MB\n/home 5 MB" {
+		t.Errorf("result = %s, plugin = %+v", result.Status, plugin)
+	}
+	if len(plugin.Perfdata) != 2 || plugin.Perfdata[1].Label != "/home" || plugin.Perfdata[1].Value != 5 {
+		t.Errorf("perfdata = %+v", plugin.Perfdata)
+	}
+
+	// 标准输出为空时使用标准错误
+	result = runProbe(t, shPlugin("echo 'cannot open /dev/sda' >&2; exit 3"))
+	if result.Plugin.Output != "cannot open /dev/sda" || !strings.Contains(result.Error, "cannot open /dev/sda") {
+		t.Errorf("stderr fallback: output %q, error %q", result.Plugin.Output, result.Error)
+	}
+	result = runProbe(t, shPlugin("exit 2"))
+	if result.Plugin.Output != "" || !strings.Contains(result.Error, "插件没有输出") {
+		t.Errorf("no output: output %q, error %q", result.Plugin.Output, result.Error)
+	}
+
+	// 超过 max_output 的输出被截断，不会截断在多字节字符中间
+	c := shPlugin("printf 'OK 检查'; head -c 100000 /dev/zero | tr '\\0' x")
+	c.MaxOutput = 8
+	result = runProbe(t, c)
+	if result.Status != model.ProbeUp || result.Plugin.Output != "OK 检" || !result.Plugin.Truncated {
+		t.Errorf("truncated output = %q, truncated %v", result.Plugin.Output, result.Plugin.Truncated)
+	}
+	if result = runProbe(t, shPlugin("echo OK")); result.Plugin.Truncated {
+		t.Error("short output marked as truncated")
+	}
+}
+
+func TestNagiosTimeout(t *testing.T) {
+	c := shPlugin("echo 'still running'; sleep 5; echo OK")
+	c.Timeout = "200ms"
+	start := time.Now()
+	result := runProbe(t, c)
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("timed out plugin returned after %s", elapsed)
+	}
+	if result.Status != model.ProbeDown || result.ErrorType != ErrorTimeout {
+		t.Errorf("result = %s %q (%s)", result.Status, result.ErrorType, result.Error)
+	}
+	// 超时按 UNKNOWN 处理，保留插件已经输出的内容
+	if result.Plugin == nil || result.Plugin.ExitCode != 3 || result.Plugin.State != model.PluginUnknown || result.Plugin.Output != "still running" {
+		t.Errorf("plugin = %+v", result.Plugin)
+	}
+}
+
+func TestNagiosEnvironment(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "marker"), []byte("found"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("XUGOU_TOKEN", "secret-token")
+	t.Setenv("PLUGIN_TEST_VAR", "visible")
+
+	// 插件在 working_dir 中运行，可以看到普通的环境变量，看不到 Agent 的 XUGOU_ 配置
+	c := shPlugin(`echo "OK $(cat marker) ${PLUGIN_TEST_VAR} token=${XUGOU_TOKEN}"`)
+	c.WorkingDir = dir
+	result := runProbe(t, c)
+	if result.Plugin.Output != "OK found visible token=" {
+		t.Errorf("output = %q (%s)", result.Plugin.Output, result.Error)
+	}
+	// 目标只显示命令，不显示可能带有密码的参数
+	if result.Target != "sh" {
+		t.Errorf("target = %q, want sh", result.Target)
+	}
+
+	c.WorkingDir = filepath.Join(dir, "missing")
+	if result := runProbe(t, c); result.ErrorType != ErrorPlugin || result.Plugin.State != model.PluginUnknown {
+		t.Errorf("missing working dir: %s %q (%s)", result.Status, result.ErrorType, result.Error)
+	}
+}
+
+func TestNagiosInvalidPlugin(t *testing.T) {
+	result := runProbe(t, Config{Type: "nagios", Command: filepath.Join(t.TempDir(), "check_missing")})
+	if result.Status != model.ProbeDown || result.ErrorType != ErrorPlugin || result.Plugin == nil || result.Plugin.ExitCode != 3 {
+		t.Errorf("missing plugin: %s %q (%s), plugin %+v", result.Status, result.ErrorType, result.Error, result.Plugin)
+	}
+
+	// 被信号终止的插件
+	result = runProbe(t, shPlugin("echo started; kill -9 $$"))
+	if result.ErrorType != ErrorPlugin || result.Plugin.ExitCode >= 0 || result.Plugin.State != model.PluginUnknown {
+		t.Errorf("killed plugin: %q (%s), plugin %+v", result.ErrorType, result.Error, result.Plugin)
+	}
+
+	for _, c := range []Config{
+		{Type: "nagios"},
+		{Type: "nagios", Command: "sh", MaxOutput: -1},
+		{Type: "nagios", Command: "sh", User: "no-such-user-xugou"},
+	} {
+		c.Name = "invalid"
+		if _, err := New(c); err == nil {
+			t.Errorf("New(%+v) succeeded", c)
+		}
+	}
+}
+
+func TestSetMaxCommands(t *testing.T) {
+	t.Cleanup(func() { SetMaxCommands(DefaultMaxCommands) })
+	p, err := New(Config{Name: "sleep", Type: "nagios", Command: "sh", Args: []string{"-c", "sleep 0.2; echo OK"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 只有一个位置时，两个插件依次运行
+	SetMaxCommands(1)
+	start := time.Now()
+	var wg sync.WaitGroup
+	for range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if result := p.Run(context.Background()); result.Error != "" {
+				t.Errorf("Run() error = %q", result.Error)
+			}
+		}()
+	}
+	wg.Wait()
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Errorf("two plugins finished in %s with one slot", elapsed)
+	}
+
+	// 插件运行时修改限制
+	for i := range 8 {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			SetMaxCommands(i%3 + 1)
+		}()
+		go func() {
+			defer wg.Done()
+			if result := p.Run(context.Background()); result.Error != "" {
+				t.Errorf("Run() error = %q", result.Error)
+			}
+		}()
+	}
+	wg.Wait()
+}
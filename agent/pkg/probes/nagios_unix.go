@@ -0,0 +1,35 @@
+//go:build !windows && !plan9
+
+package probes
+
+import (
+	"os/exec"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// runAsSupported 是否支持以其他用户身份运行插件
+const runAsSupported = true
+
+// configureCommand 让插件在单独的进程组中运行，超时时结束整个进程组，设置了用户时以该用户身份运行
+func configureCommand(cmd *exec.Cmd, u *user.User) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	if u == nil {
+		return
+	}
+	uid, _ := strconv.ParseUint(u.Uid, 10, 32)
+	gid, _ := strconv.ParseUint(u.Gid, 10, 32)
+	credential := &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid)}
+	if ids, err := u.GroupIds(); err == nil {
+		for _, id := range ids {
+			if g, err := strconv.ParseUint(id, 10, 32); err == nil {
+				credential.Groups = append(credential.Groups, uint32(g))
+			}
+		}
+	}
+	cmd.SysProcAttr.Credential = credential
+}
@@ -0,0 +1,134 @@
+package probes
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/xugou/agent/pkg/model"
+)
+
+// perfValueRe 性能数据的数值和单位，部分插件按区域设置使用逗号作为小数点
+var perfValueRe = regexp.MustCompile(`^([-+]?(?:[0-9]+(?:[.,][0-9]*)?|[.,][0-9]+)(?:[eE][-+]?[0-9]+)?)([A-Za-z%]*)$`)
+
+// parsePluginOutput 按 Nagios 插件的输出格式解析：第一行是状态说明，| 之后是性能数据；
+// 其余行是详细输出，其中第一个 | 之后的所有内容都是性能数据
+func parsePluginOutput(output string) *model.PluginResult {
+	result := &model.PluginResult{}
+	lines := strings.Split(strings.TrimRight(output, "\r\n"), "\n")
+
+	var perf, long []string
+	text, first, ok := strings.Cut(lines[0], "|")
+	result.Output = strings.TrimSpace(text)
+	if ok {
+		perf = append(perf, first)
+	}
+	inPerf := false
+	for _, line := range lines[1:] {
+		line = strings.TrimRight(line, "\r")
+		if inPerf {
+			perf = append(perf, line)
+			continue
+		}
+		if text, rest, ok := strings.Cut(line, "|"); ok {
+			long = append(long, text)
+			perf = append(perf, rest)
+			inPerf = true
+			continue
+		}
+		long = append(long, line)
+	}
+	result.LongOutput = strings.TrimSpace(strings.Join(long, "\n"))
+	result.Perfdata = parsePerfdata(strings.Join(perf, " "))
+	return result
+}
+
+// parsePerfdata 解析以空白分隔的 label=value[UOM];warn;crit;min;max，
+// 包含空格的标签用单引号括起来，标签中的单引号写作两个单引号。无法解析的项和值为 U（无法确定）的项被忽略
+func parsePerfdata(s string) []model.PerfData {
+	var result []model.PerfData
+	skip := func() {
+		if i := strings.IndexAny(s, " \t\r\n"); i >= 0 {
+			s = s[i:]
+		} else {
+			s = ""
+		}
+	}
+
+	for s = strings.TrimSpace(s); s != ""; s = strings.TrimLeft(s, " \t\r\n") {
+		var label string
+		if s[0] == '\'' {
+			var b strings.Builder
+			end := 1
+			for {
+				i := strings.IndexByte(s[end:], '\'')
+				if i < 0 {
+					return result
+				}
+				b.WriteString(s[end : end+i])
+				end += i + 1
+				if end < len(s) && s[end] == '\'' {
+					b.WriteByte('\'')
+					end++
+					continue
+				}
+				break
+			}
+			label, s = b.String(), s[end:]
+			if !strings.HasPrefix(s, "=") {
+				skip()
+				continue
+			}
+			s = s[1:]
+		} else {
+			eq := strings.IndexByte(s, '=')
+			space := strings.IndexAny(s, " \t\r\n")
+			if eq <= 0 || (space >= 0 && space < eq) {
+				skip()
+				continue
+			}
+			label, s = s[:eq], s[eq+1:]
+		}
+
+		end := strings.IndexAny(s, " \t\r\n")
+		if end < 0 {
+			end = len(s)
+		}
+		if pd, ok := parsePerfValue(label, s[:end]); ok {
+			result = append(result, pd)
+		}
+		s = s[end:]
+	}
+	return result
+}
+
+func parsePerfValue(label, text string) (model.PerfData, bool) {
+	fields := strings.Split(text, ";")
+	m := perfValueRe.FindStringSubmatch(fields[0])
+	if m == nil {
+		return model.PerfData{}, false
+	}
+	value, err := strconv.ParseFloat(strings.Replace(m[1], ",", ".", 1), 64)
+	if err != nil {
+		return model.PerfData{}, false
+	}
+
+	pd := model.PerfData{Label: label, Value: value, UOM: m[2]}
+	field := func(i int) string {
+		if i < len(fields) {
+			return strings.TrimSpace(fields[i])
+		}
+		return ""
+	}
+	pd.Warn, pd.Crit = field(1), field(2)
+	pd.Min, pd.Max = perfLimit(field(3)), perfLimit(field(4))
+	return pd, true
+}
+
+func perfLimit(text string) *float64 {
+	v, err := strconv.ParseFloat(strings.Replace(text, ",", ".", 1), 64)
+	if err != nil {
+		return nil
+	}
+	return &v
+}
@@ -0,0 +1,164 @@
+package probes
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/xugou/agent/pkg/model"
+)
+
+func float(v float64) *float64 {
+	return &v
+}
+
+func TestParsePerfdata(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []model.PerfData
+	}{
+		{
+			name: "full fields",
+			text: "time=0.012s;1;2;0;10 size=512B;;;0",
+			want: []model.PerfData{
+				{Label: "time", Value: 0.012, UOM: "s", Warn: "1", Crit: "2", Min: float(0), Max: float(10)},
+				{Label: "size", Value: 512, UOM: "B", Min: float(0)},
+			},
+		},
+		{
+			name: "units and ranges",
+			text: "load1=0.50;@5:10;~:20 used=81%;80;90 bytes=1.5e3KB c=12c",
+			want: []model.PerfData{
+				{Label: "load1", Value: 0.5, Warn: "@5:10", Crit: "~:20"},
+				{Label: "used", Value: 81, UOM: "%", Warn: "80", Crit: "90"},
+				{Label: "bytes", Value: 1500, UOM: "KB"},
+				{Label: "c", Value: 12, UOM: "c"},
+			},
+		},
+		{
+			name: "quoted labels",
+			text: "'/var/lib data'=12GB;;;0;100 'it''s'=1 ''''=2",
+			want: []model.PerfData{
+				{Label: "/var/lib data", Value: 12, UOM: "GB", Min: float(0), Max: float(100)},
+				{Label: "it's", Value: 1},
+				{Label: "'", Value: 2},
+			},
+		},
+		{
+			name: "decimal comma and signs",
+			text: "temp=-3,5 delta=+.5 ratio=,25",
+			want: []model.PerfData{
+				{Label: "temp", Value: -3.5},
+				{Label: "delta", Value: 0.5},
+				{Label: "ratio", Value: 0.25},
+			},
+		},
+		{
+			// 值为 U 表示无法确定，无法解析的项被跳过，不影响之后的项
+			name: "undetermined and invalid items",
+			text: "a=U;1;2 b= c=abc noequals =5 d=7;;;x;y",
+			want: []model.PerfData{{Label: "d", Value: 7}},
+		},
+		{
+			name: "extra whitespace",
+			text: "  \ta=1 \t\n b=2  ",
+			want: []model.PerfData{{Label: "a", Value: 1}, {Label: "b", Value: 2}},
+		},
+		{
+			name: "unterminated quote",
+			text: "a=1 'broken=2",
+			want: []model.PerfData{{Label: "a", Value: 1}},
+		},
+		{
+			name: "quoted label without value",
+			text: "'label' b=2",
+			want: []model.PerfData{{Label: "b", Value: 2}},
+		},
+		{name: "empty", text: "   "},
+	}
+	for _, tt := range tests {
+		if got := parsePerfdata(tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: parsePerfdata(%q) =\n%+v\nwant\n%+v", tt.name, tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestParsePluginOutput(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   model.PluginResult
+	}{
+		{
+			name:   "status only",
+			output: "DISK OK - free space: / 3326 MB (56%)\n",
+			want:   model.PluginResult{Output: "DISK OK - free space: / 3326 MB (56%)"},
+		},
+		{
+			name:   "status and perfdata",
+			output: "PING OK - rta 0.5ms | rta=0.5ms;100;500;0 pl=0%;20;60;0\n",
+			want: model.PluginResult{
+				Output: "PING OK - rta 0.5ms",
+				Perfdata: []model.PerfData{
+					{Label: "rta", Value: 0.5, UOM: "ms", Warn: "100", Crit: "500", Min: float(0)},
+					{Label: "pl", Value: 0, UOM: "%", Warn: "20", Crit: "60", Min: float(0)},
+				},
+			},
+		},
+		{
+			// Nagios 插件开发指南中的多行示例：第一个 | 之后的所有行都是性能数据
+			name: "long output with perfdata",
+			output: "DISK OK - free space: / 3326 MB (56%); | /=2643MB;5948;5958;0;5968\r\n" +
+				"/ 15272 MB (77%);\n" +
+				"/boot 68 MB (69%);\n" +
+				"/home 69357 MB (27%);\n" +
+				"/var/log 819 MB (84%); | /boot=68MB;88;93;0;98\n" +
+				"/home=69357MB;253404;253409;0;253414\n" +
+				"'/var/log'=818MB;970;975;0;980\n",
+			want: model.PluginResult{
+				Output:     "DISK OK - free space: / 3326 MB (56%);",
+				LongOutput: "/ 15272 MB (77%);\n/boot 68 MB (69%);\n/home 69357 MB (27%);\n/var/log 819 MB (84%);",
+				Perfdata: []model.PerfData{
+					{Label: "/", Value: 2643, UOM: "MB", Warn: "5948", Crit: "5958", Min: float(0), Max: float(5968)},
+					{Label: "/boot", Value: 68, UOM: "MB", Warn: "88", Crit: "93", Min: float(0), Max: float(98)},
+					{Label: "/home", Value: 69357, UOM: "MB", Warn: "253404", Crit: "253409", Min: float(0), Max: float(253414)},
+					{Label: "/var/log", Value: 818, UOM: "MB", Warn: "970", Crit: "975", Min: float(0), Max: float(980)},
+				},
+			},
+		},
+		{
+			name:   "perfdata only in long output",
+			output: "OK\ndetails | a=1\nb=2\n",
+			want: model.PluginResult{
+				Output:     "OK",
+				LongOutput: "details",
+				Perfdata:   []model.PerfData{{Label: "a", Value: 1}, {Label: "b", Value: 2}},
+			},
+		},
+		{name: "empty", output: ""},
+	}
+	for _, tt := range tests {
+		if got := parsePluginOutput(tt.output); !reflect.DeepEqual(*got, tt.want) {
+			t.Errorf("%s: parsePluginOutput() =\n%+v\nwant\n%+v", tt.name, *got, tt.want)
+		}
+	}
+}
+
+func TestLimitedBuffer(t *testing.T) {
+	b := &limitedBuffer{limit: 8}
+	for _, chunk := range []string{"abc", "def", "指标"} {
+		if n, err := b.Write([]byte(chunk)); n != len(chunk) || err != nil {
+			t.Fatalf("Write(%q) = %d, %v", chunk, n, err)
+		}
+	}
+	// 超过限制的内容被丢弃，末尾不完整的多字节字符被去掉
+	if got := b.String(); got != "abcdef" || !b.truncated {
+		t.Errorf("String() = %q, truncated %v", got, b.truncated)
+	}
+
+	exact := &limitedBuffer{limit: 3}
+	exact.Write([]byte("abc"))
+	if exact.String() != "abc" || exact.truncated {
+		t.Errorf("String() = %q, truncated %v", exact.String(), exact.truncated)
+	}
+}
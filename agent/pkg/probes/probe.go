@@ -0,0 +1,251 @@
+package probes
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/xugou/agent/pkg/model"
+)
+
+// 默认值
+const (
+	DefaultInterval = 60 * time.Second
+	DefaultTimeout  = 10 * time.Second
+	MinInterval     = time.Second
+)
+
+// Types 支持的探测类型
+var Types = []string{"http", "tcp", "udp", "dns", "tls", "certfile", "icmp", "nagios"}
+
+// prober 具体的探测实现，只需要填写目标、状态、耗时、状态码和错误，其余字段由 Probe 填写
+type prober interface {
+	Probe(ctx context.Context) model.ProbeResult
+}
+
+// Config 配置文件中的一个探测
+type Config struct {
+	Type      string `json:"type"`       // 探测类型，默认为 http
+	Name      string `json:"name"`       // 探测名称，不能重复
+	MonitorID int    `json:"monitor_id"` // 对应的服务器监控 ID，结果会写入该监控的状态历史
+	Interval  string `json:"interval"`   // 探测间隔
+	Timeout   string `json:"timeout"`    // 单次探测的超时时间，不能超过探测间隔
+
+	// http
+	URL            string            `json:"url"`
+	Method         string            `json:"method"`
+	Headers        map[string]string `json:"headers"`
+	SecretHeaders  []string          `json:"secret_headers"` // 值需要从日志中移除的其他请求头，Authorization 等凭据头不需要列出
+	Body           string            `json:"body"`
+	ExpectedStatus int               `json:"expected_status"` // 1 到 5 表示状态码类别（2 即 2xx），其他值要求完全一致，默认 200
+	BodyRegex      string            `json:"body_regex"`      // 响应内容必须匹配的正则表达式
+	JSON           []JSONAssertion   `json:"json"`            // 对 JSON 响应的断言
+	MaxRedirects   *int              `json:"max_redirects"`   // 最多跟随的重定向次数，0 表示不跟随，默认 10
+	TLSSkipVerify  bool              `json:"tls_skip_verify"` // 不校验服务器证书
+
+	// tcp、udp、tls
+	Address   string `json:"address"`    // host:port
+	Send      string `json:"send"`       // 连接后发送的内容，UDP 探测必须设置 send 或 send_hex
+	SendHex   string `json:"send_hex"`   // 以十六进制表示的二进制内容，与 send 二选一
+	Expect    string `json:"expect"`     // 响应必须匹配的正则表达式
+	ExpectHex string `json:"expect_hex"` // 响应必须包含的字节（十六进制），与 expect 二选一
+
+	// dns
+	Query           string   `json:"query"`            // 查询的域名
+	RecordType      string   `json:"record_type"`      // 记录类型，默认为 A
+	Server          string   `json:"server"`           // DNS 服务器，默认使用 /etc/resolv.conf 中的第一个
+	ExpectedAnswers []string `json:"expected_answers"` // 应答中必须包含的值
+	DNSSEC          bool     `json:"dnssec"`           // 要求应答通过 DNSSEC 验证
+
+	// tls、certfile
+	ServerName   string `json:"server_name"`   // TLS 握手时使用的 SNI，同时用于校验证书的域名，默认为 address 中的主机
+	Path         string `json:"path"`          // 证书文件或包含证书文件的目录
+	CAFile       string `json:"ca_file"`       // 只使用该文件中的 CA 校验证书链，默认使用系统的 CA
+	WarnDays     *int   `json:"warn_days"`     // 证书剩余天数低于该值时产生 warning 告警，0 表示不告警，默认 30
+	CriticalDays *int   `json:"critical_days"` // 证书剩余天数低于该值时产生 critical 告警，0 表示不告警，默认 7
+
+	// icmp
+	Targets        []string `json:"targets"`         // 目标主机或 IP，有多个目标时每个目标单独探测，名称为 <名称>/<目标>
+	Count          int      `json:"count"`           // 每次探测发送的包数，默认 5
+	PacketInterval string   `json:"packet_interval"` // 发包间隔，默认 1s
+	PacketTimeout  string   `json:"packet_timeout"`  // 每个包等待回复的最长时间，超过后按丢包计算，默认为 timeout 中发送所有包之后剩余的时间
+
+	// nagios
+	Command    string   `json:"command"`     // 插件路径，不经过 shell 执行
+	Args       []string `json:"args"`        // 插件参数
+	User       string   `json:"user"`        // 以该用户身份运行插件，需要 Agent 以 root 运行
+	WorkingDir string   `json:"working_dir"` // 插件的工作目录
+	MaxOutput  int      `json:"max_output"`  // 保留的输出字节数，超过的部分被截断，默认 4096
+
+	group string // 展开前的探测名称
+}
+
+// Options 探测的公共参数
+type Options struct {
+	Name      string
+	Group     string // 有多个目标的探测展开前的名称，其他探测与 Name 相同
+	Type      string
+	MonitorID int
+	Interval  time.Duration
+	Timeout   time.Duration
+}
+
+// Probe 一个可以运行的探测
+type Probe struct {
+	Options
+	prober prober
+}
+
+// ParseConfig 解析配置文件中的探测列表，raw 为 viper 或 YAML 解析出的原始值
+func ParseConfig(raw interface{}) ([]Config, error) {
+	if raw == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return nil, fmt.Errorf("无法解析探测: %w", err)
+	}
+	var configs []Config
+	if err := json.Unmarshal(data, &configs); err != nil {
+		return nil, fmt.Errorf("探测必须是包含 name、type 等字段的列表: %w", err)
+	}
+
+	names := make(map[string]bool)
+	for i := range configs {
+		c := &configs[i]
+		if c.Name == "" {
+			return nil, fmt.Errorf("第 %d 个探测缺少 name", i+1)
+		}
+		for _, c := range c.expand() {
+			if _, err := New(c); err != nil {
+				return nil, fmt.Errorf("探测 %s: %w", c.Name, err)
+			}
+			if names[c.Name] {
+				return nil, fmt.Errorf("探测名称 %s 重复", c.Name)
+			}
+			names[c.Name] = true
+		}
+	}
+	return configs, nil
+}
+
+// expand 把有多个目标的 ICMP 探测展开为每个目标一个探测，名称为 <名称>/<目标>
+func (c Config) expand() []Config {
+	if strings.ToLower(c.Type) != "icmp" || len(c.Targets) <= 1 {
+		return []Config{c}
+	}
+	configs := make([]Config, 0, len(c.Targets))
+	for _, target := range c.Targets {
+		single := c
+		single.Name = c.Name + "/" + target
+		single.Targets = []string{target}
+		single.group = c.Name
+		configs = append(configs, single)
+	}
+	return configs
+}
+
+// New 根据配置创建探测
+func New(c Config) (*Probe, error) {
+	opts, err := c.options()
+	if err != nil {
+		return nil, err
+	}
+
+	var p prober
+	switch opts.Type {
+	case "http":
+		p, err = newHTTP(c, opts)
+	case "tcp":
+		p, err = newTCP(c, opts)
+	case "udp":
+		p, err = newUDP(c, opts)
+	case "dns":
+		p, err = newDNS(c, opts)
+	case "tls":
+		p, err = newTLS(c, opts)
+	case "certfile":
+		p, err = newCertFile(c, opts)
+	case "icmp":
+		p, err = newICMP(c, opts)
+	case "nagios":
+		p, err = newNagios(c, opts)
+	default:
+		return nil, fmt.Errorf("不支持的类型 %q，可选: %s", c.Type, strings.Join(Types, ", "))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Probe{Options: opts, prober: p}, nil
+}
+
+func (c Config) options() (Options, error) {
+	opts := Options{
+		Name:      c.Name,
+		Group:     c.group,
+		Type:      strings.ToLower(c.Type),
+		MonitorID: c.MonitorID,
+		Interval:  DefaultInterval,
+		Timeout:   DefaultTimeout,
+	}
+	if opts.Type == "" {
+		opts.Type = "http"
+	}
+	if opts.Group == "" {
+		opts.Group = c.Name
+	}
+	if c.MonitorID < 0 {
+		return opts, fmt.Errorf("无效的 monitor_id %d", c.MonitorID)
+	}
+	if c.Interval != "" {
+		d, err := time.ParseDuration(c.Interval)
+		if err != nil || d < MinInterval {
+			return opts, fmt.Errorf("无效的 interval %q，不能小于 %s", c.Interval, MinInterval)
+		}
+		opts.Interval = d
+	}
+	if c.Timeout != "" {
+		d, err := time.ParseDuration(c.Timeout)
+		if err != nil || d <= 0 {
+			return opts, fmt.Errorf("无效的 timeout %q", c.Timeout)
+		}
+		opts.Timeout = d
+	}
+	if opts.Timeout > opts.Interval {
+		// 只在没有显式设置超时时间时自动缩短，避免掩盖配置错误
+		if c.Timeout != "" {
+			return opts, fmt.Errorf("timeout %s 不能超过 interval %s", opts.Timeout, opts.Interval)
+		}
+		opts.Timeout = opts.Interval
+	}
+	return opts, nil
+}
+
+// Run 执行一次探测，超时时间由 Timeout 控制
+func (p *Probe) Run(ctx context.Context) model.ProbeResult {
+	ctx, cancel := context.WithTimeout(ctx, p.Timeout)
+	defer cancel()
+
+	now := time.Now()
+	result := p.prober.Probe(ctx)
+	result.MonitorID = p.MonitorID
+	result.Name = p.Name
+	result.Type = p.Type
+	result.Timestamp = now
+	if result.Error != "" {
+		result.Status = model.ProbeDown
+		if result.ErrorType == "" {
+			result.ErrorType = ErrorNetwork
+		}
+	} else {
+		result.Status = model.ProbeUp
+	}
+	return result
+}
+
+// milliseconds 把时长转换为毫秒，保留三位小数
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
@@ -0,0 +1,179 @@
+package probes
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/xugou/agent/pkg/model"
+	"github.com/xugou/agent/pkg/telemetry"
+)
+
+// maxPending 最多缓存的未上报结果数，超过后丢弃最早的结果
+const maxPending = 1000
+
+// Scheduler 按各自的间隔运行所有探测，结果缓存到下一次上报时取走
+type Scheduler struct {
+	probes []*Probe
+	wg     sync.WaitGroup // 后台运行的探测循环
+
+	mu      sync.Mutex
+	pending []model.ProbeResult
+	latest  map[string]model.ProbeResult
+}
+
+// NewScheduler 根据配置创建所有探测
+func NewScheduler(configs []Config) (*Scheduler, error) {
+	s := &Scheduler{latest: make(map[string]model.ProbeResult)}
+	for _, c := range configs {
+		for _, c := range c.expand() {
+			p, err := New(c)
+			if err != nil {
+				return nil, fmt.Errorf("探测 %s: %w", c.Name, err)
+			}
+			// 校验配置时不登记凭据，只有真正运行的探测才需要从日志中移除
+			if p.Type == "http" {
+				addHTTPSecrets(c)
+			}
+			s.probes = append(s.probes, p)
+		}
+	}
+	return s, nil
+}
+
+// Len 返回探测数量
+func (s *Scheduler) Len() int {
+	return len(s.probes)
+}
+
+// Start 在后台运行所有探测，直到 ctx 被取消。探测的第一次执行在各自的间隔内均匀错开，
+// 之后按自己的间隔执行，避免大量探测（例如几百个 ICMP 目标）同时发包
+func (s *Scheduler) Start(ctx context.Context) {
+	for i, p := range s.probes {
+		offset := p.Interval * time.Duration(i) / time.Duration(len(s.probes))
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.loop(ctx, p, offset)
+		}()
+	}
+}
+
+// Wait 等待 Start 启动的探测在 ctx 取消后全部退出
+func (s *Scheduler) Wait() {
+	s.wg.Wait()
+}
+
+func (s *Scheduler) loop(ctx context.Context, p *Probe, offset time.Duration) {
+	select {
+	case <-time.After(offset):
+	case <-ctx.Done():
+		return
+	}
+	ticker := time.NewTicker(p.Interval)
+	defer ticker.Stop()
+	for {
+		s.record(p.Run(ctx))
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// RunNow 立即执行指定名称的探测，name 为空时执行所有探测，有多个目标的探测可以使用展开前的名称执行所有目标。
+// 结果同样会在下一次上报时发送
+func (s *Scheduler) RunNow(ctx context.Context, name string) ([]model.ProbeResult, error) {
+	var selected []*Probe
+	for _, p := range s.probes {
+		if name == "" || p.Name == name || p.Group == name {
+			selected = append(selected, p)
+		}
+	}
+	if len(selected) == 0 {
+		if name == "" {
+			return nil, fmt.Errorf("没有配置探测")
+		}
+		return nil, fmt.Errorf("探测 %s 不存在", name)
+	}
+
+	results := make([]model.ProbeResult, len(selected))
+	var wg sync.WaitGroup
+	for i, p := range selected {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = p.Run(ctx)
+		}()
+	}
+	wg.Wait()
+
+	for _, r := range results {
+		s.record(r)
+	}
+	return results, nil
+}
+
+// record 缓存探测结果，状态变化时记录日志
+func (s *Scheduler) record(result model.ProbeResult) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	previous, seen := s.latest[result.Name]
+	switch {
+	case result.Status == model.ProbeDown && (!seen || previous.Status == model.ProbeUp):
+		slog.Warn("探测失败", "probe", result.Name, "target", result.Target, "error", result.Error)
+	case result.Status == model.ProbeUp && seen && previous.Status == model.ProbeDown:
+		slog.Info("探测恢复", "probe", result.Name, "target", result.Target, "response_time", result.ResponseTime)
+	default:
+		slog.Debug("探测完成", "probe", result.Name, "status", result.Status, "response_time", result.ResponseTime)
+	}
+	s.latest[result.Name] = result
+
+	s.pending = append(s.pending, result)
+	if n := len(s.pending) - maxPending; n > 0 {
+		s.pending = append(s.pending[:0], s.pending[n:]...)
+	}
+	telemetry.Default.SetSpoolDepth(len(s.pending))
+}
+
+// Drain 取走所有未上报的结果
+func (s *Scheduler) Drain() []model.ProbeResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pending := s.pending
+	s.pending = nil
+	telemetry.Default.SetSpoolDepth(0)
+	return pending
+}
+
+// Requeue 把上报失败的结果放回队列，下一次上报时重新发送
+func (s *Scheduler) Requeue(results []model.ProbeResult) {
+	if len(results) == 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pending := append(append([]model.ProbeResult{}, results...), s.pending...)
+	if n := len(pending) - maxPending; n > 0 {
+		pending = pending[n:]
+	}
+	s.pending = pending
+	telemetry.Default.SetSpoolDepth(len(pending))
+}
+
+// Latest 返回每个探测最近一次的结果，按名称排序
+func (s *Scheduler) Latest() []model.ProbeResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	results := make([]model.ProbeResult, 0, len(s.latest))
+	for _, r := range s.latest {
+		results = append(results, r)
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Name < results[j].Name })
+	return results
+}
@@ -0,0 +1,184 @@
+package probes
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http/httptrace"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/xugou/agent/pkg/model"
+)
+
+// maxBannerSize 等待 expect 匹配时最多读取的内容
+const maxBannerSize = 64 << 10
+
+// tcpProber 建立 TCP 连接，可选地发送请求并检查服务器返回的内容（例如 Redis 的 +PONG）
+type tcpProber struct {
+	address string
+	send    []byte
+	expect  *expectation
+}
+
+func newTCP(c Config, opts Options) (prober, error) {
+	if err := validateAddress(c.Address); err != nil {
+		return nil, err
+	}
+	send, err := payload(c)
+	if err != nil {
+		return nil, err
+	}
+	expect, err := newExpectation(c)
+	if err != nil {
+		return nil, err
+	}
+	return &tcpProber{address: c.Address, send: send, expect: expect}, nil
+}
+
+func (p *tcpProber) Probe(ctx context.Context) (result model.ProbeResult) {
+	result = model.ProbeResult{Target: p.address}
+	timer := &timer{}
+	start := time.Now()
+	defer func() {
+		result.ResponseTime = time.Since(start).Milliseconds()
+		result.Timings = timer.timings(time.Since(start))
+	}()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(httptrace.WithClientTrace(ctx, timer.trace()), "tcp", p.address)
+	if err != nil {
+		fail(ctx, &result, err)
+		return result
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	// 没有请求内容时从连接建立开始计算 TTFB，例如等待 SSH、SMTP 的欢迎信息
+	timer.wroteRequest()
+	if len(p.send) > 0 {
+		if _, err := conn.Write(p.send); err != nil {
+			fail(ctx, &result, err)
+			return result
+		}
+		timer.wroteRequest()
+	}
+	if p.expect == nil {
+		return result
+	}
+
+	// 持续读取直到内容匹配，服务器可能分多次发送
+	var received []byte
+	buf := make([]byte, 4096)
+	for {
+		n, err := conn.Read(buf)
+		if n > 0 {
+			if received == nil {
+				timer.firstByte()
+			}
+			received = append(received, buf[:n]...)
+			if p.expect.Match(received) {
+				return result
+			}
+			if len(received) >= maxBannerSize {
+				mismatch(&result, ErrorAssertion, "响应内容不匹配 %s，已收到: %s", p.expect, preview(received))
+				return result
+			}
+		}
+		if err != nil {
+			switch {
+			case len(received) > 0 && (err == io.EOF || errors.Is(err, os.ErrDeadlineExceeded)):
+				mismatch(&result, ErrorAssertion, "响应内容不匹配 %s，已收到: %s", p.expect, preview(received))
+			case err == io.EOF:
+				mismatch(&result, ErrorReset, "连接被对方关闭，没有收到任何内容")
+			default:
+				fail(ctx, &result, err)
+			}
+			return result
+		}
+	}
+}
+
+// validateAddress 检查 host:port 形式的地址
+func validateAddress(address string) error {
+	if address == "" {
+		return fmt.Errorf("缺少 address")
+	}
+	host, port, err := net.SplitHostPort(address)
+	if err != nil || host == "" || port == "" {
+		return fmt.Errorf("无效的 address %q，格式为 host:port", address)
+	}
+	return nil
+}
+
+// payload 返回 send 或 send_hex 设置的请求内容
+func payload(c Config) ([]byte, error) {
+	if c.Send != "" && c.SendHex != "" {
+		return nil, fmt.Errorf("send 和 send_hex 只能设置一个")
+	}
+	if c.SendHex != "" {
+		data, err := hex.DecodeString(strings.ReplaceAll(c.SendHex, " ", ""))
+		if err != nil {
+			return nil, fmt.Errorf("无效的 send_hex: %w", err)
+		}
+		return data, nil
+	}
+	return []byte(c.Send), nil
+}
+
+// expectation 对响应内容的检查，expect 为正则表达式，expect_hex 要求包含指定的字节，用于二进制协议
+type expectation struct {
+	re  *regexp.Regexp
+	hex []byte
+}
+
+func newExpectation(c Config) (*expectation, error) {
+	switch {
+	case c.Expect != "" && c.ExpectHex != "":
+		return nil, fmt.Errorf("expect 和 expect_hex 只能设置一个")
+	case c.Expect != "":
+		re, err := regexp.Compile(c.Expect)
+		if err != nil {
+			return nil, fmt.Errorf("无效的 expect: %w", err)
+		}
+		return &expectation{re: re}, nil
+	case c.ExpectHex != "":
+		data, err := hex.DecodeString(strings.ReplaceAll(c.ExpectHex, " ", ""))
+		if err != nil || len(data) == 0 {
+			return nil, fmt.Errorf("无效的 expect_hex %q", c.ExpectHex)
+		}
+		return &expectation{hex: data}, nil
+	}
+	return nil, nil
+}
+
+func (e *expectation) Match(data []byte) bool {
+	if e.re != nil {
+		return e.re.Match(data)
+	}
+	return bytes.Contains(data, e.hex)
+}
+
+func (e *expectation) String() string {
+	if e.re != nil {
+		return fmt.Sprintf("expect %q", e.re.String())
+	}
+	return "expect_hex " + hex.EncodeToString(e.hex)
+}
+
+// preview 截取收到的内容用于错误信息
+func preview(data []byte) string {
+	const max = 64
+	if len(data) > max {
+		return fmt.Sprintf("%q...", data[:max])
+	}
+	return fmt.Sprintf("%q", data)
+}
@@ -0,0 +1,141 @@
+package probes
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xugou/agent/pkg/model"
+)
+
+// startTCPServer 在本机监听 TCP，每个连接交给 serve 处理
+func startTCPServer(t *testing.T, serve func(conn net.Conn)) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				serve(conn)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// closedPort 返回一个没有监听的本机地址
+func closedPort(t *testing.T, network string) string {
+	t.Helper()
+	if network == "udp" {
+		pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		pc.Close()
+		return pc.LocalAddr().String()
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.Close()
+	return ln.Addr().String()
+}
+
+func runProbe(t *testing.T, c Config) model.ProbeResult {
+	t.Helper()
+	if c.Name == "" {
+		c.Name = "test"
+	}
+	p, err := New(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p.Run(context.Background())
+}
+
+func TestTCPProbe(t *testing.T) {
+	// 模拟 Redis：收到 PING 后分两次返回 +PONG
+	redis := startTCPServer(t, func(conn net.Conn) {
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		if err != nil {
+			return
+		}
+		if line != "PING\r\n" {
+			conn.Write([]byte("-ERR unknown command\r\n"))
+			return
+		}
+		conn.Write([]byte("+PO"))
+		time.Sleep(20 * time.Millisecond)
+		conn.Write([]byte("NG\r\n"))
+	})
+	banner := startTCPServer(t, func(conn net.Conn) {
+		conn.Write([]byte("SSH-2.0-OpenSSH_9.6\r\n"))
+	})
+	closing := startTCPServer(t, func(conn net.Conn) {})
+	silent := startTCPServer(t, func(conn net.Conn) {
+		time.Sleep(time.Second)
+	})
+
+	tests := []struct {
+		name      string
+		config    Config
+		errorType string
+		want      string
+	}{
+		{name: "connect only", config: Config{Address: closing}},
+		{name: "split response", config: Config{Address: redis, Send: "PING\r\n", Expect: `^\+PONG`}},
+		{name: "send_hex and expect_hex", config: Config{Address: redis, SendHex: "50 49 4e 47 0d 0a", ExpectHex: "2b504f4e47"}},
+		{name: "banner", config: Config{Address: banner, Expect: `^SSH-2\.0-`}},
+		{name: "mismatch", config: Config{Address: redis, Send: "HELLO\r\n", Expect: `^\+PONG`}, errorType: ErrorAssertion, want: `已收到: "-ERR unknown command\r\n"`},
+		{name: "closed without data", config: Config{Address: closing, Expect: "."}, errorType: ErrorReset, want: "没有收到任何内容"},
+		{name: "refused", config: Config{Address: closedPort(t, "tcp")}, errorType: ErrorRefused},
+		{name: "timeout", config: Config{Address: silent, Expect: ".", Timeout: "200ms"}, errorType: ErrorTimeout, want: "超时"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.Type = "tcp"
+			result := runProbe(t, tt.config)
+			wantStatus := model.ProbeUp
+			if tt.errorType != "" {
+				wantStatus = model.ProbeDown
+			}
+			if result.Status != wantStatus || result.ErrorType != tt.errorType || !strings.Contains(result.Error, tt.want) {
+				t.Errorf("Run() = status %s, error type %q, error %q; want %s, %q, containing %q",
+					result.Status, result.ErrorType, result.Error, wantStatus, tt.errorType, tt.want)
+			}
+			if result.Target != tt.config.Address {
+				t.Errorf("Run() target = %q", result.Target)
+			}
+		})
+	}
+}
+
+func TestTCPProbeInvalidConfig(t *testing.T) {
+	invalid := []Config{
+		{Name: "a", Type: "tcp"},
+		{Name: "a", Type: "tcp", Address: "localhost"},
+		{Name: "a", Type: "tcp", Address: ":80"},
+		{Name: "a", Type: "tcp", Address: "localhost:80", Send: "a", SendHex: "61"},
+		{Name: "a", Type: "tcp", Address: "localhost:80", SendHex: "zz"},
+		{Name: "a", Type: "tcp", Address: "localhost:80", Expect: "("},
+		{Name: "a", Type: "tcp", Address: "localhost:80", Expect: "a", ExpectHex: "61"},
+		{Name: "a", Type: "tcp", Address: "localhost:80", ExpectHex: " "},
+	}
+	for _, c := range invalid {
+		if _, err := New(c); err == nil {
+			t.Errorf("New(%+v) succeeded", c)
+		}
+	}
+}
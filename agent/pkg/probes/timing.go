@@ -0,0 +1,100 @@
+package probes
+
+import (
+	"crypto/tls"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"github.com/xugou/agent/pkg/model"
+)
+
+// timer 通过 httptrace 记录探测各阶段的耗时。net.Dialer 同样会调用其中的 DNS 和连接回调，
+// 所以 TCP 等探测也可以使用。跟随重定向时累加每一跳的 DNS、连接和 TLS 耗时，TTFB 取最后一跳从发出请求到收到第一个字节的时间
+type timer struct {
+	mu           sync.Mutex
+	dnsStart     time.Time
+	connectStart map[string]time.Time // 同时尝试 IPv4 和 IPv6 时会有多个连接
+	tlsStart     time.Time
+	wrote        time.Time
+	dns          time.Duration
+	connect      time.Duration
+	tls          time.Duration
+	ttfb         time.Duration
+}
+
+func (t *timer) trace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			t.mu.Lock()
+			t.dnsStart = time.Now()
+			t.mu.Unlock()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			t.mu.Lock()
+			t.dns += time.Since(t.dnsStart)
+			t.mu.Unlock()
+		},
+		ConnectStart: func(network, addr string) {
+			t.mu.Lock()
+			if t.connectStart == nil {
+				t.connectStart = make(map[string]time.Time)
+			}
+			t.connectStart[network+" "+addr] = time.Now()
+			t.mu.Unlock()
+		},
+		ConnectDone: func(network, addr string, err error) {
+			t.mu.Lock()
+			if err == nil {
+				t.connect += time.Since(t.connectStart[network+" "+addr])
+			}
+			t.mu.Unlock()
+		},
+		TLSHandshakeStart: func() {
+			t.mu.Lock()
+			t.tlsStart = time.Now()
+			t.mu.Unlock()
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			t.mu.Lock()
+			t.tls += time.Since(t.tlsStart)
+			t.mu.Unlock()
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			t.mu.Lock()
+			t.wrote = time.Now()
+			t.mu.Unlock()
+		},
+		GotFirstResponseByte: func() {
+			t.mu.Lock()
+			t.ttfb = time.Since(t.wrote)
+			t.mu.Unlock()
+		},
+	}
+}
+
+// wroteRequest 记录请求发送完成的时间，用于不经过 HTTP 的探测
+func (t *timer) wroteRequest() {
+	t.mu.Lock()
+	t.wrote = time.Now()
+	t.mu.Unlock()
+}
+
+// firstByte 记录收到第一个字节的时间，用于不经过 HTTP 的探测
+func (t *timer) firstByte() {
+	t.mu.Lock()
+	t.ttfb = time.Since(t.wrote)
+	t.mu.Unlock()
+}
+
+func (t *timer) timings(total time.Duration) *model.ProbeTimings {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return &model.ProbeTimings{
+		DNSMs:     milliseconds(t.dns),
+		ConnectMs: milliseconds(t.connect),
+		TLSMs:     milliseconds(t.tls),
+		TTFBMs:    milliseconds(t.ttfb),
+		TotalMs:   milliseconds(total),
+	}
+}
@@ -0,0 +1,76 @@
+package probes
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http/httptrace"
+	"time"
+
+	"github.com/xugou/agent/pkg/model"
+)
+
+// udpProber 发送一个 UDP 请求并等待响应，UDP 没有连接，只有收到响应才能确认服务可用
+type udpProber struct {
+	address string
+	send    []byte
+	expect  *expectation
+}
+
+func newUDP(c Config, opts Options) (prober, error) {
+	if err := validateAddress(c.Address); err != nil {
+		return nil, err
+	}
+	send, err := payload(c)
+	if err != nil {
+		return nil, err
+	}
+	if len(send) == 0 {
+		return nil, fmt.Errorf("UDP 探测需要设置 send 或 send_hex")
+	}
+	expect, err := newExpectation(c)
+	if err != nil {
+		return nil, err
+	}
+	return &udpProber{address: c.Address, send: send, expect: expect}, nil
+}
+
+func (p *udpProber) Probe(ctx context.Context) (result model.ProbeResult) {
+	result = model.ProbeResult{Target: p.address}
+	timer := &timer{}
+	start := time.Now()
+	defer func() {
+		result.ResponseTime = time.Since(start).Milliseconds()
+		result.Timings = timer.timings(time.Since(start))
+	}()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(httptrace.WithClientTrace(ctx, timer.trace()), "udp", p.address)
+	if err != nil {
+		fail(ctx, &result, err)
+		return result
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if _, err := conn.Write(p.send); err != nil {
+		fail(ctx, &result, err)
+		return result
+	}
+	timer.wroteRequest()
+
+	// 端口没有监听时，内核收到 ICMP 端口不可达后读取会返回 connection refused
+	buf := make([]byte, 64<<10)
+	n, err := conn.Read(buf)
+	if err != nil {
+		fail(ctx, &result, err)
+		return result
+	}
+	timer.firstByte()
+	if p.expect != nil && !p.expect.Match(buf[:n]) {
+		mismatch(&result, ErrorAssertion, "响应内容不匹配 %s，已收到: %s", p.expect, preview(buf[:n]))
+	}
+	return result
+}
@@ -0,0 +1,82 @@
+package probes
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/xugou/agent/pkg/model"
+)
+
+// startUDPServer 在本机监听 UDP，收到 PING 时回复 PONG，收到其他内容时原样返回，收到 DROP 时不回复
+func startUDPServer(t *testing.T) string {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			switch msg := buf[:n]; {
+			case bytes.Equal(msg, []byte("DROP")):
+			case bytes.Equal(msg, []byte("PING")):
+				pc.WriteTo([]byte("PONG"), addr)
+			default:
+				pc.WriteTo(msg, addr)
+			}
+		}
+	}()
+	return pc.LocalAddr().String()
+}
+
+func TestUDPProbe(t *testing.T) {
+	server := startUDPServer(t)
+	tests := []struct {
+		name      string
+		config    Config
+		errorType string
+		want      string
+	}{
+		{name: "any response", config: Config{Address: server, Send: "hello"}},
+		{name: "expect", config: Config{Address: server, Send: "PING", Expect: "^PONG$"}},
+		{name: "binary", config: Config{Address: server, SendHex: "00ff10", ExpectHex: "ff10"}},
+		{name: "mismatch", config: Config{Address: server, Send: "hello", Expect: "^PONG"}, errorType: ErrorAssertion, want: `已收到: "hello"`},
+		{name: "no response", config: Config{Address: server, Send: "DROP", Timeout: "200ms"}, errorType: ErrorTimeout},
+		// 内核收到 ICMP 端口不可达后，读取返回 connection refused
+		{name: "port unreachable", config: Config{Address: closedPort(t, "udp"), Send: "PING", Timeout: "2s"}, errorType: ErrorRefused},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.Type = "udp"
+			result := runProbe(t, tt.config)
+			wantStatus := model.ProbeUp
+			if tt.errorType != "" {
+				wantStatus = model.ProbeDown
+			}
+			if result.Status != wantStatus || result.ErrorType != tt.errorType || !strings.Contains(result.Error, tt.want) {
+				t.Errorf("Run() = status %s, error type %q, error %q; want %s, %q, containing %q",
+					result.Status, result.ErrorType, result.Error, wantStatus, tt.errorType, tt.want)
+			}
+		})
+	}
+}
+
+func TestUDPProbeInvalidConfig(t *testing.T) {
+	invalid := []Config{
+		{Name: "a", Type: "udp", Address: "127.0.0.1:53"},
+		{Name: "a", Type: "udp", Address: "127.0.0.1", Send: "x"},
+		{Name: "a", Type: "udp", Address: "127.0.0.1:53", Send: "x", Expect: "("},
+	}
+	for _, c := range invalid {
+		if _, err := New(c); err == nil {
+			t.Errorf("New(%+v) succeeded", c)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package proxy
+
+import (
+	"net"
+	"strings"
+)
+
+// bypass 解析后的 NO_PROXY 规则，语义与 curl 和 Go 标准库一致：
+//
+//   - "*" 表示所有地址都不使用代理
+//   - "example.com" 匹配 example.com 及其子域名，".example.com" 只匹配子域名
+//   - "example.com:8443" 只匹配该端口
+//   - "10.1.2.3" 匹配该 IP，"10.0.0.0/8" 匹配该网段内的 IP
+type bypass struct {
+	all     bool
+	ips     []net.IP
+	cidrs   []*net.IPNet
+	domains []domainRule
+}
+
+type domainRule struct {
+	name       string // 不含开头的点，小写
+	subdomains bool   // 以点开头时只匹配子域名
+	port       string // 为空表示任意端口
+}
+
+// parseBypass 解析逗号或空格分隔的 NO_PROXY 规则，无法识别的项会被忽略
+func parseBypass(rules string) *bypass {
+	b := &bypass{}
+	for _, entry := range strings.FieldsFunc(rules, func(r rune) bool { return r == ',' || r == ' ' }) {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+		if entry == "*" {
+			b.all = true
+			return b
+		}
+		if _, cidr, err := net.ParseCIDR(entry); err == nil {
+			b.cidrs = append(b.cidrs, cidr)
+			continue
+		}
+
+		host, port := entry, ""
+		if h, p, err := net.SplitHostPort(entry); err == nil {
+			host, port = h, p
+		}
+		if ip := net.ParseIP(strings.Trim(host, "[]")); ip != nil {
+			b.ips = append(b.ips, ip)
+			continue
+		}
+		rule := domainRule{name: strings.TrimPrefix(host, "*"), port: port}
+		if strings.HasPrefix(rule.name, ".") {
+			rule.name = rule.name[1:]
+			rule.subdomains = true
+		}
+		if rule.name != "" {
+			b.domains = append(b.domains, rule)
+		}
+	}
+	return b
+}
+
+// match 判断连接 host:port 时是否绕过代理
+func (b *bypass) match(host, port string) bool {
+	if b == nil {
+		return false
+	}
+	if b.all {
+		return true
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if ip := net.ParseIP(host); ip != nil {
+		for _, rule := range b.ips {
+			if rule.Equal(ip) {
+				return true
+			}
+		}
+		for _, cidr := range b.cidrs {
+			if cidr.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, rule := range b.domains {
+		if rule.port != "" && rule.port != port {
+			continue
+		}
+		if strings.HasSuffix(host, "."+rule.name) || (!rule.subdomains && host == rule.name) {
+			return true
+		}
+	}
+	return false
+}
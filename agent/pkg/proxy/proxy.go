@@ -0,0 +1,280 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xugou/agent/pkg/logger"
+)
+
+// Config 代理配置
+type Config struct {
+	URL     string // 代理地址，支持 http、https、socks5 和 socks5h，为空时使用环境变量中的代理
+	NoProxy string // 不使用代理的地址，为空时使用环境变量 NO_PROXY
+}
+
+// Proxy 根据目标地址选择代理
+type Proxy struct {
+	explicit *url.URL
+	env      map[string]*url.URL // 目标协议 -> 环境变量中的代理，"*" 对应 ALL_PROXY
+	bypass   *bypass
+
+	// socks 记录需要经过 SOCKS5 代理的目标地址 host:port，由 Transport.Proxy 写入、DialContext 读取
+	socksMu sync.Mutex
+	socks   map[string]socksRoute
+}
+
+// socksRoute 目标地址使用的 SOCKS5 代理和最近一次写入的时间
+type socksRoute struct {
+	proxy  *url.URL
+	stored time.Time
+}
+
+// Transport.Proxy 写入记录后 DialContext 立即读取，记录数超过 maxSocksRoutes 时
+// 先清除超过 socksRouteTTL 没有使用的记录，仍然超过时清除最早的记录
+const (
+	maxSocksRoutes = 256
+	socksRouteTTL  = time.Minute
+)
+
+// Schemes 支持的代理协议
+var Schemes = []string{"http", "https", "socks5", "socks5h"}
+
+// 环境变量，大写优先
+var envNames = map[string][]string{
+	"https": {"HTTPS_PROXY", "https_proxy"},
+	"http":  {"HTTP_PROXY", "http_proxy"},
+	"*":     {"ALL_PROXY", "all_proxy"},
+}
+
+// New 解析代理配置，没有设置代理地址时读取 HTTPS_PROXY、HTTP_PROXY 和 ALL_PROXY 环境变量
+func New(c Config) (*Proxy, error) {
+	p := &Proxy{env: make(map[string]*url.URL)}
+
+	noProxy := c.NoProxy
+	if noProxy == "" {
+		noProxy = lookupEnv("NO_PROXY", "no_proxy")
+	}
+	p.bypass = parseBypass(noProxy)
+
+	if c.URL != "" {
+		u, err := Parse(c.URL)
+		if err != nil {
+			return nil, err
+		}
+		p.explicit = u
+		return p, nil
+	}
+
+	for scheme, names := range envNames {
+		raw := lookupEnv(names...)
+		if raw == "" {
+			continue
+		}
+		// 与 curl 一致，环境变量中没有协议时按 HTTP 代理处理
+		if !strings.Contains(raw, "://") {
+			raw = "http://" + raw
+		}
+		u, err := Parse(raw)
+		if err != nil {
+			return nil, fmt.Errorf("环境变量 %s: %w", names[0], err)
+		}
+		if password, ok := u.User.Password(); ok {
+			logger.AddSecret(password)
+		}
+		p.env[scheme] = u
+		slog.Debug("使用环境变量中的代理", "variable", names[0], "proxy", u.Redacted())
+	}
+	return p, nil
+}
+
+// Parse 解析并校验代理地址
+func Parse(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("无效的代理地址: %w", err)
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	supported := false
+	for _, s := range Schemes {
+		if u.Scheme == s {
+			supported = true
+		}
+	}
+	if !supported {
+		return nil, fmt.Errorf("不支持的代理协议 %q，可选 %s", u.Scheme, strings.Join(Schemes, "、"))
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("代理地址 %s 缺少主机名", u.Redacted())
+	}
+	return u, nil
+}
+
+// Redacted 返回隐藏了密码的代理地址，用于日志输出
+func Redacted(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "(无效的代理地址)"
+	}
+	return u.Redacted()
+}
+
+// IsSOCKS 判断是否为 SOCKS5 代理
+func IsSOCKS(u *url.URL) bool {
+	return u.Scheme == "socks5" || u.Scheme == "socks5h"
+}
+
+// ForURL 返回访问 target 时使用的代理，不使用代理时返回 nil
+func (p *Proxy) ForURL(target *url.URL) *url.URL {
+	host, port := target.Hostname(), target.Port()
+	if port == "" {
+		port = defaultPort(target.Scheme)
+	}
+	if p.bypass.match(host, port) {
+		return nil
+	}
+	if p.explicit != nil {
+		return p.explicit
+	}
+	// 与 Go 标准库一致，环境变量中的代理不用于本机地址
+	if isLoopback(host) {
+		return nil
+	}
+	if u := p.env[target.Scheme]; u != nil {
+		return u
+	}
+	return p.env["*"]
+}
+
+// Bypassed 判断 target 是否因为 NO_PROXY 规则而直连
+func (p *Proxy) Bypassed(target *url.URL) bool {
+	port := target.Port()
+	if port == "" {
+		port = defaultPort(target.Scheme)
+	}
+	return p.bypass.match(target.Hostname(), port)
+}
+
+// FromEnvironment 判断代理是否来自环境变量
+func (p *Proxy) FromEnvironment() bool {
+	return p.explicit == nil
+}
+
+// Apply 让 transport 按本配置使用代理。HTTP 代理交给 transport 处理，
+// SOCKS5 代理在 DialContext 中处理，以便 socks5 在本地解析域名、socks5h 由代理解析
+func (p *Proxy) Apply(t *http.Transport) {
+	dial := t.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}).DialContext
+	}
+
+	t.Proxy = func(req *http.Request) (*url.URL, error) {
+		u := p.ForURL(req.URL)
+		if u == nil {
+			return nil, nil
+		}
+		if IsSOCKS(u) {
+			port := req.URL.Port()
+			if port == "" {
+				port = defaultPort(req.URL.Scheme)
+			}
+			p.storeSOCKS(net.JoinHostPort(req.URL.Hostname(), port), u)
+			return nil, nil
+		}
+		return u, nil
+	}
+	t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if u := p.loadSOCKS(addr); u != nil {
+			return NewSOCKS5(u, dial).DialContext(ctx, network, addr)
+		}
+		return dial(ctx, network, addr)
+	}
+}
+
+func (p *Proxy) storeSOCKS(addr string, u *url.URL) {
+	p.socksMu.Lock()
+	defer p.socksMu.Unlock()
+	now := time.Now()
+	if _, ok := p.socks[addr]; !ok && len(p.socks) >= maxSocksRoutes {
+		oldest := ""
+		for key, route := range p.socks {
+			if now.Sub(route.stored) > socksRouteTTL {
+				delete(p.socks, key)
+			} else if oldest == "" || route.stored.Before(p.socks[oldest].stored) {
+				oldest = key
+			}
+		}
+		if len(p.socks) >= maxSocksRoutes {
+			delete(p.socks, oldest)
+		}
+	}
+	if p.socks == nil {
+		p.socks = make(map[string]socksRoute)
+	}
+	p.socks[addr] = socksRoute{proxy: u, stored: now}
+}
+
+func (p *Proxy) loadSOCKS(addr string) *url.URL {
+	p.socksMu.Lock()
+	defer p.socksMu.Unlock()
+	return p.socks[addr].proxy
+}
+
+// NewSOCKS5 根据代理地址创建 SOCKS5 连接器，dial 用于连接代理服务器，可以为空
+func NewSOCKS5(u *url.URL, dial func(ctx context.Context, network, addr string) (net.Conn, error)) *SOCKS5 {
+	s := &SOCKS5{
+		Addr:      HostPort(u),
+		RemoteDNS: u.Scheme == "socks5h",
+		Dial:      dial,
+	}
+	if u.User != nil {
+		s.Username = u.User.Username()
+		s.Password, _ = u.User.Password()
+	}
+	return s
+}
+
+// HostPort 返回代理服务器的 host:port，没有端口时使用协议的默认端口
+func HostPort(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		port = defaultPort(u.Scheme)
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+func defaultPort(scheme string) string {
+	switch scheme {
+	case "https":
+		return "443"
+	case "socks5", "socks5h":
+		return "1080"
+	default:
+		return "80"
+	}
+}
+
+func isLoopback(host string) bool {
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func lookupEnv(names ...string) string {
+	for _, name := range names {
+		if v := os.Getenv(name); v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,189 @@
+package proxy
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// SOCKS5 通过 SOCKS5 代理建立 TCP 连接（RFC 1928），支持用户名密码认证（RFC 1929）
+type SOCKS5 struct {
+	Addr      string // 代理地址 host:port
+	Username  string
+	Password  string
+	RemoteDNS bool // 为 true 时由代理解析域名（socks5h），否则在本地解析后发送 IP（socks5）
+
+	// Dial 连接代理服务器使用的函数，为空时使用 net.Dialer
+	Dial func(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// SOCKS5 回复码对应的错误信息
+var socksReplies = map[byte]string{
+	0x01: "代理服务器内部错误",
+	0x02: "代理规则不允许该连接",
+	0x03: "网络不可达",
+	0x04: "主机不可达",
+	0x05: "目标拒绝连接",
+	0x06: "TTL 超时",
+	0x07: "代理不支持该命令",
+	0x08: "代理不支持该地址类型",
+}
+
+// ErrSOCKSAuth SOCKS5 代理拒绝了用户名密码
+var ErrSOCKSAuth = errors.New("SOCKS5 代理认证失败")
+
+// DialContext 通过代理连接 addr
+func (s *SOCKS5) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if network != "tcp" && network != "tcp4" && network != "tcp6" {
+		return nil, fmt.Errorf("SOCKS5 代理不支持 %s 连接", network)
+	}
+	host, portText, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portText)
+	if err != nil || port <= 0 || port > 65535 {
+		return nil, fmt.Errorf("无效的端口 %q", portText)
+	}
+
+	if !s.RemoteDNS && net.ParseIP(host) == nil {
+		ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		host = ips[0].IP.String()
+	}
+
+	dial := s.Dial
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	conn, err := dial(ctx, "tcp", s.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("连接 SOCKS5 代理失败: %w", err)
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if err := s.handshake(conn, host, port); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+func (s *SOCKS5) handshake(conn net.Conn, host string, port int) error {
+	// 协商认证方式
+	methods := []byte{0x00}
+	if s.Username != "" {
+		methods = []byte{0x00, 0x02}
+	}
+	if _, err := conn.Write(append([]byte{0x05, byte(len(methods))}, methods...)); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return fmt.Errorf("读取 SOCKS5 代理响应失败: %w", err)
+	}
+	if reply[0] != 0x05 {
+		return errors.New("代理服务器不是 SOCKS5 代理")
+	}
+	switch reply[1] {
+	case 0x00:
+	case 0x02:
+		if err := s.authenticate(conn); err != nil {
+			return err
+		}
+	case 0xFF:
+		if s.Username == "" {
+			return fmt.Errorf("%w: 代理需要用户名和密码", ErrSOCKSAuth)
+		}
+		return errors.New("SOCKS5 代理不支持用户名密码认证")
+	default:
+		return fmt.Errorf("SOCKS5 代理要求不支持的认证方式 %d", reply[1])
+	}
+
+	// 发送 CONNECT 请求
+	req := []byte{0x05, 0x01, 0x00}
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			req = append(req, 0x01)
+			req = append(req, ip4...)
+		} else {
+			req = append(req, 0x04)
+			req = append(req, ip.To16()...)
+		}
+	} else {
+		if len(host) > 255 {
+			return fmt.Errorf("域名过长: %s", host)
+		}
+		req = append(req, 0x03, byte(len(host)))
+		req = append(req, host...)
+	}
+	req = binary.BigEndian.AppendUint16(req, uint16(port))
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	head := make([]byte, 4)
+	if _, err := io.ReadFull(conn, head); err != nil {
+		return fmt.Errorf("读取 SOCKS5 代理响应失败: %w", err)
+	}
+	if head[1] != 0x00 {
+		msg, ok := socksReplies[head[1]]
+		if !ok {
+			msg = fmt.Sprintf("未知错误 %d", head[1])
+		}
+		return fmt.Errorf("SOCKS5 代理连接 %s 失败: %s", net.JoinHostPort(host, strconv.Itoa(port)), msg)
+	}
+
+	// 跳过代理返回的绑定地址
+	var skip int
+	switch head[3] {
+	case 0x01:
+		skip = net.IPv4len
+	case 0x04:
+		skip = net.IPv6len
+	case 0x03:
+		n := make([]byte, 1)
+		if _, err := io.ReadFull(conn, n); err != nil {
+			return err
+		}
+		skip = int(n[0])
+	default:
+		return fmt.Errorf("SOCKS5 代理返回了未知的地址类型 %d", head[3])
+	}
+	_, err := io.ReadFull(conn, make([]byte, skip+2))
+	return err
+}
+
+func (s *SOCKS5) authenticate(conn net.Conn) error {
+	if s.Username == "" {
+		return fmt.Errorf("%w: 代理需要用户名和密码", ErrSOCKSAuth)
+	}
+	if len(s.Username) > 255 || len(s.Password) > 255 {
+		return errors.New("SOCKS5 用户名或密码过长")
+	}
+	req := []byte{0x01, byte(len(s.Username))}
+	req = append(req, s.Username...)
+	req = append(req, byte(len(s.Password)))
+	req = append(req, s.Password...)
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return fmt.Errorf("读取 SOCKS5 认证响应失败: %w", err)
+	}
+	if reply[1] != 0x00 {
+		return ErrSOCKSAuth
+	}
+	return nil
+}
@@ -0,0 +1,233 @@
+package remoteconfig
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/xugou/agent/pkg/config"
+	"github.com/xugou/agent/pkg/model"
+	"github.com/xugou/agent/pkg/reporter"
+	"github.com/xugou/agent/pkg/telemetry"
+	"github.com/xugou/agent/pkg/utils"
+)
+
+// StateFile 状态目录中保存最近一次生效的远程配置的文件，服务器不可用时用它启动
+const StateFile = "remote-config.json"
+
+// Validate 校验远程配置，只允许设置 config.RemoteKeys 中的配置项，返回所有发现的问题
+func Validate(settings map[string]interface{}) error {
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var errs []error
+	for _, name := range names {
+		if !allowed(name) {
+			errs = append(errs, fmt.Errorf("%s: 不允许由远程配置设置", name))
+			continue
+		}
+		key, _ := config.LookupKey(name)
+		if err := config.ValidateValue(key, settings[name]); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func allowed(name string) bool {
+	for _, key := range config.RemoteKeys {
+		if key == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Merge 把远程配置合并到本地配置上，返回 config.RemoteKeys 中各配置项的值。
+// 优先级从高到低为：命令行参数和环境变量、远程配置、配置文件、默认值。
+// local 返回本地配置的值，pinned 判断配置项是否通过命令行参数或环境变量设置；labels 按标签名合并
+func Merge(local func(string) interface{}, remote map[string]interface{}, pinned func(string) bool) map[string]interface{} {
+	merged := make(map[string]interface{}, len(config.RemoteKeys))
+	for _, name := range config.RemoteKeys {
+		value, ok := remote[name]
+		if !ok || pinned(name) {
+			merged[name] = local(name)
+			continue
+		}
+		if name == "labels" {
+			value = mergeMaps(local(name), value)
+		}
+		merged[name] = value
+	}
+	return merged
+}
+
+func mergeMaps(base, overlay interface{}) map[string]interface{} {
+	out := make(map[string]interface{})
+	for _, m := range []interface{}{base, overlay} {
+		switch m := m.(type) {
+		case map[string]interface{}:
+			for k, v := range m {
+				out[k] = v
+			}
+		case map[string]string:
+			for k, v := range m {
+				out[k] = v
+			}
+		}
+	}
+	return out
+}
+
+// normalize 把 JSON 解析出的整数值从 float64 转换为 int，和配置文件解析的结果保持一致
+func normalize(value interface{}) interface{} {
+	switch v := value.(type) {
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			return int(v)
+		}
+	case map[string]interface{}:
+		for k, item := range v {
+			v[k] = normalize(item)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = normalize(item)
+		}
+	}
+	return value
+}
+
+// Load 读取状态目录中保存的远程配置，文件不存在时返回 nil
+func Load(stateDir string) (*model.RemoteConfig, error) {
+	data, err := os.ReadFile(filepath.Join(stateDir, StateFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var remote model.RemoteConfig
+	if err := json.Unmarshal(data, &remote); err != nil {
+		return nil, fmt.Errorf("%s 内容无效: %w", StateFile, err)
+	}
+	normalize(remote.Config)
+	return &remote, nil
+}
+
+// Save 把生效的远程配置原子地写入状态目录
+func Save(stateDir string, remote *model.RemoteConfig) error {
+	data, err := json.MarshalIndent(remote, "", "  ")
+	if err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(filepath.Join(stateDir, StateFile), data, 0600)
+}
+
+// Watcher 定期拉取远程配置，校验通过后交给 Apply 应用，并把生效的配置保存到状态目录
+type Watcher struct {
+	Fetcher  reporter.ConfigFetcher
+	StateDir string
+	Interval time.Duration
+	Apply    func(remote *model.RemoteConfig) error // 合并并应用远程配置，返回错误时继续使用之前的配置
+
+	mu      sync.Mutex
+	current *model.RemoteConfig
+}
+
+// LoadSaved 应用状态目录中保存的远程配置，让 Agent 在服务器不可用时也使用最近一次生效的配置启动
+func (w *Watcher) LoadSaved() {
+	remote, err := Load(w.StateDir)
+	if err != nil {
+		slog.Warn("读取保存的远程配置失败", "error", err)
+		return
+	}
+	if remote == nil {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.apply(remote); err != nil {
+		slog.Warn("保存的远程配置无效，使用本地配置", "version", remote.Version, "error", err)
+		return
+	}
+	w.current = remote
+	slog.Info("已应用保存的远程配置", "version", remote.Version)
+}
+
+// Run 立即拉取一次远程配置，之后按间隔拉取，直到 ctx 被取消
+func (w *Watcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+	for {
+		if err := w.Refresh(ctx); err != nil && ctx.Err() == nil {
+			slog.Warn("更新远程配置失败，继续使用当前配置", "error", err)
+			telemetry.Default.RecordError("remote-config", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Refresh 立即拉取并应用一次远程配置，失败时继续使用当前的配置
+func (w *Watcher) Refresh(ctx context.Context) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	etag := ""
+	if w.current != nil {
+		etag = w.current.ETag
+	}
+	remote, err := w.Fetcher.FetchConfig(ctx, etag)
+	if err != nil {
+		return fmt.Errorf("拉取远程配置失败: %w", err)
+	}
+	if remote == nil {
+		slog.Debug("远程配置没有变化")
+		return nil
+	}
+
+	normalize(remote.Config)
+	if err := w.apply(remote); err != nil {
+		return fmt.Errorf("远程配置 %s 无效: %w", remote.Version, err)
+	}
+	w.current = remote
+	slog.Info("已应用远程配置", "version", remote.Version)
+
+	if err := Save(w.StateDir, remote); err != nil {
+		slog.Warn("保存远程配置失败", "error", err)
+	}
+	return nil
+}
+
+// Version 返回当前生效的远程配置版本，没有生效的远程配置时返回空
+func (w *Watcher) Version() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.current == nil {
+		return ""
+	}
+	return w.current.Version
+}
+
+func (w *Watcher) apply(remote *model.RemoteConfig) error {
+	if err := Validate(remote.Config); err != nil {
+		return err
+	}
+	return w.Apply(remote)
+}
@@ -0,0 +1,169 @@
+package remoteconfig
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/xugou/agent/pkg/model"
+)
+
+func TestValidate(t *testing.T) {
+	valid := map[string]interface{}{
+		"interval":   30,
+		"collectors": []interface{}{"cpu", "memory"},
+		"labels":     map[string]interface{}{"env": "prod"},
+	}
+	if err := Validate(valid); err != nil {
+		t.Errorf("Validate(valid) = %v", err)
+	}
+
+	err := Validate(map[string]interface{}{
+		"server":     "https://evil.example.com",
+		"token":      "stolen",
+		"interval":   0,
+		"collectors": []interface{}{"nope"},
+	})
+	if err == nil {
+		t.Fatal("Validate accepted server, token and invalid values")
+	}
+	for _, want := range []string{"server: 不允许", "token: 不允许", "interval:", "collectors:"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Validate() error = %v, want containing %q", err, want)
+		}
+	}
+}
+
+func TestMerge(t *testing.T) {
+	local := map[string]interface{}{
+		"interval":   60,
+		"collectors": []string{},
+		"labels":     map[string]interface{}{"env": "prod", "role": "db"},
+	}
+	remote := map[string]interface{}{
+		"interval":   30,
+		"collectors": []interface{}{"cpu"},
+		"labels":     map[string]interface{}{"role": "cache", "team": "ops"},
+	}
+	// collectors 通过命令行参数或环境变量设置，远程配置不能覆盖
+	pinned := func(name string) bool { return name == "collectors" }
+
+	merged := Merge(func(name string) interface{} { return local[name] }, remote, pinned)
+	if merged["interval"] != 30 {
+		t.Errorf("interval = %v, want remote value 30", merged["interval"])
+	}
+	if !reflect.DeepEqual(merged["collectors"], []string{}) {
+		t.Errorf("collectors = %v, want the pinned local value", merged["collectors"])
+	}
+	wantLabels := map[string]interface{}{"env": "prod", "role": "cache", "team": "ops"}
+	if !reflect.DeepEqual(merged["labels"], wantLabels) {
+		t.Errorf("labels = %v, want %v", merged["labels"], wantLabels)
+	}
+	if merged["devices"] != nil {
+		t.Errorf("devices = %v, want the unset local value", merged["devices"])
+	}
+}
+
+func TestSaveAndLoad(t *testing.T) {
+	dir := t.TempDir()
+	if remote, err := Load(dir); remote != nil || err != nil {
+		t.Fatalf("Load() without a saved config = %v, %v", remote, err)
+	}
+
+	saved := &model.RemoteConfig{Version: "v3", ETag: `"abc"`, Config: map[string]interface{}{
+		"interval": 30,
+		"rules":    []interface{}{map[string]interface{}{"name": "cpu", "expr": "cpu.usage > 90.5"}},
+	}}
+	if err := Save(dir, saved); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Version != "v3" || loaded.ETag != `"abc"` {
+		t.Errorf("Load() = %+v", loaded)
+	}
+	// JSON 中的整数恢复为 int，与配置文件解析的结果一致
+	if loaded.Config["interval"] != 30 {
+		t.Errorf("interval = %#v, want int 30", loaded.Config["interval"])
+	}
+}
+
+// fakeFetcher 按顺序返回预设的远程配置，并记录每次请求的 ETag
+type fakeFetcher struct {
+	responses []*model.RemoteConfig
+	err       error
+	etags     []string
+}
+
+func (f *fakeFetcher) FetchConfig(ctx context.Context, etag string) (*model.RemoteConfig, error) {
+	f.etags = append(f.etags, etag)
+	if f.err != nil {
+		return nil, f.err
+	}
+	remote := f.responses[0]
+	f.responses = f.responses[1:]
+	return remote, nil
+}
+
+func TestWatcherRefresh(t *testing.T) {
+	fetcher := &fakeFetcher{responses: []*model.RemoteConfig{
+		{Version: "v1", ETag: `"1"`, Config: map[string]interface{}{"interval": float64(30)}},
+		nil, // 304，配置没有变化
+		{Version: "v2", ETag: `"2"`, Config: map[string]interface{}{"token": "x"}},
+		{Version: "v3", ETag: `"3"`, Config: map[string]interface{}{"interval": float64(20)}},
+	}}
+	var applied []*model.RemoteConfig
+	w := &Watcher{
+		Fetcher:  fetcher,
+		StateDir: t.TempDir(),
+		Apply: func(remote *model.RemoteConfig) error {
+			if remote.Config["interval"] == 20 {
+				return errors.New("rejected by Apply")
+			}
+			applied = append(applied, remote)
+			return nil
+		},
+	}
+	ctx := context.Background()
+
+	if err := w.Refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if w.Version() != "v1" || len(applied) != 1 || applied[0].Config["interval"] != 30 {
+		t.Fatalf("after v1: version = %q, applied = %+v", w.Version(), applied)
+	}
+	if err := w.Refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// 不允许的配置项和 Apply 返回的错误都不替换当前配置
+	if err := w.Refresh(ctx); err == nil || !strings.Contains(err.Error(), "v2") {
+		t.Errorf("Refresh(v2) error = %v, want validation error", err)
+	}
+	if err := w.Refresh(ctx); err == nil || !strings.Contains(err.Error(), "rejected") {
+		t.Errorf("Refresh(v3) error = %v, want Apply error", err)
+	}
+	if w.Version() != "v1" || len(applied) != 1 {
+		t.Errorf("version = %q, applied = %d, want v1 still in effect", w.Version(), len(applied))
+	}
+	if want := []string{"", `"1"`, `"1"`, `"1"`}; !reflect.DeepEqual(fetcher.etags, want) {
+		t.Errorf("etags = %q, want %q", fetcher.etags, want)
+	}
+
+	// 服务器不可用时重启，使用保存的配置
+	fetcher.err = errors.New("connection refused")
+	if err := w.Refresh(ctx); err == nil {
+		t.Error("Refresh succeeded while the server is down")
+	}
+	restarted := &Watcher{Fetcher: fetcher, StateDir: w.StateDir, Apply: func(remote *model.RemoteConfig) error {
+		applied = append(applied, remote)
+		return nil
+	}}
+	restarted.LoadSaved()
+	if restarted.Version() != "v1" || applied[len(applied)-1].Config["interval"] != 30 {
+		t.Errorf("LoadSaved: version = %q, applied = %+v", restarted.Version(), applied[len(applied)-1])
+	}
+}
@@ -7,21 +7,33 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
-	"net/url"
+	"strings"
 	"time"
 
+	"github.com/xugou/agent/pkg/auth"
 	"github.com/xugou/agent/pkg/config"
 	"github.com/xugou/agent/pkg/model"
+	"github.com/xugou/agent/pkg/proxy"
+	"github.com/xugou/agent/pkg/telemetry"
+	"github.com/xugou/agent/pkg/tlsconfig"
+	"github.com/xugou/agent/pkg/useragent"
 	"github.com/xugou/agent/pkg/utils"
 )
 
-// setDefaultHeaders 设置所有请求的通用头部
-func setDefaultHeaders(req *http.Request) {
+// setDefaultHeaders 设置所有请求的通用头部，包括 Agent 的版本、客户端 ID 和支持的功能
+func setDefaultHeaders(req *http.Request, agentID int) {
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/123.0.0.0 Safari/537.36")
-	req.Header.Set("Referer", "https://www.google.com/")
+	useragent.Apply(req, agentID)
+}
+
+// statusError 把服务器返回的错误状态码转换为错误，426 表示服务器不再支持当前版本
+func statusError(status int) error {
+	if status == http.StatusUpgradeRequired {
+		return fmt.Errorf("服务器不再支持当前版本 %s，请运行 xugou-agent update 升级", useragent.Version())
+	}
+	return fmt.Errorf("服务器返回状态码 %d", status)
 }
 
 // Reporter 定义数据上报器接口
@@ -30,33 +42,53 @@ type Reporter interface {
 	ReportBatch(ctx context.Context, infoList []*model.SystemInfo) error // 上报批量采集的系统信息
 }
 
+// ConfigFetcher 从服务器拉取远程配置
+type ConfigFetcher interface {
+	// FetchConfig 拉取远程配置，etag 为上次拉取到的 ETag，配置没有变化时返回 nil
+	FetchConfig(ctx context.Context, etag string) (*model.RemoteConfig, error)
+}
+
+// RequestSigner 为不经过上报器发送的请求（例如控制通道）设置通用头部和签名
+type RequestSigner interface {
+	SignRequest(req *http.Request, body []byte) error
+	// CheckResponse 保存服务器轮换的令牌，服务器提示时间偏差过大时校正签名时间并返回 true
+	CheckResponse(resp *http.Response) bool
+}
+
+// DiagnosticsUploader 把诊断信息上传到服务器
+type DiagnosticsUploader interface {
+	UploadDiagnostics(ctx context.Context, bundle interface{}) error
+}
+
 type DefaultReporter struct {
 	reporter *model.HTTPReporter
 }
 
-func NewReporter() Reporter {
-	return &DefaultReporter{
-		reporter: NewHTTPReporter(),
+func NewReporter() (Reporter, error) {
+	reporter, err := NewHTTPReporter()
+	if err != nil {
+		return nil, err
 	}
+	return &DefaultReporter{
+		reporter: reporter,
+	}, nil
 }
 
 // NewHTTPReporter 创建一个新的HTTP数据上报器
-func NewHTTPReporter() *model.HTTPReporter {
-	// 创建HTTP客户端
-	client := &http.Client{
-		Timeout: 10 * time.Second,
+func NewHTTPReporter() (*model.HTTPReporter, error) {
+	transport, err := NewTransport(config.TLS)
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := auth.NewTokens(config.Token, config.TokenFile, config.StateDir)
+	if err != nil {
+		return nil, err
 	}
 
-	// 如果设置了代理，配置代理
-	if config.ProxyURL != "" {
-		proxy, err := url.Parse(config.ProxyURL)
-		if err != nil {
-			fmt.Printf("警告: 代理URL解析失败: %v，将不使用代理\n", err)
-		} else {
-			client.Transport = &http.Transport{
-				Proxy: http.ProxyURL(proxy),
-			}
-		}
+	// 创建HTTP客户端
+	client := &http.Client{
+		Timeout:   10 * time.Second,
+		Transport: transport,
 	}
 
 	reporter := &model.HTTPReporter{
@@ -64,43 +96,75 @@ func NewHTTPReporter() *model.HTTPReporter {
 		ApiToken:   config.Token,
 		ProxyURL:   config.ProxyURL,
 		Client:     client,
+		Tokens:     tokens,
+		Signer:     auth.NewSigner(tokens),
 		Registered: false,
 	}
 
-	return reporter
+	return reporter, nil
+}
+
+// NewTransport 创建访问服务器使用的 Transport，保留默认 Transport 的超时和连接复用设置
+func NewTransport(tlsOpts tlsconfig.Options) (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	// 没有设置代理时使用环境变量中的代理，NO_PROXY 中的地址直连
+	p, err := proxy.New(proxy.Config{URL: config.ProxyURL, NoProxy: config.NoProxy})
+	if err != nil {
+		return nil, err
+	}
+	p.Apply(transport)
+
+	if tlsOpts.Enabled() {
+		loader, err := tlsconfig.NewLoader(tlsOpts)
+		if err != nil {
+			return nil, fmt.Errorf("TLS 配置无效: %w", err)
+		}
+		// 证书更新后关闭空闲连接，让新连接使用新的证书
+		loader.OnReload = transport.CloseIdleConnections
+		transport.TLSClientConfig = loader.Config()
+		if tlsOpts.InsecureSkipVerify {
+			slog.Warn("已关闭服务器证书校验，连接可能被中间人攻击，请勿在生产环境使用")
+		}
+	}
+	return transport, nil
 }
 
 func (r *DefaultReporter) Report(ctx context.Context, info *model.SystemInfo) error {
 	if !r.reporter.Registered {
 		// 客户端未注册，先注册
 		if err := r.register(ctx, info); err != nil {
-			log.Printf("注册客户端失败: %v", err)
+			slog.Error("注册客户端失败", "error", err)
 			return err
 		}
 	}
 	reportURL := fmt.Sprintf("%s/api/agents/status", r.reporter.ServerURL)
 	reportPaylod, err := json.Marshal(info)
-
-	log.Println("即将上报数据: ", info)
-
 	if err != nil {
-		log.Println("注册客户端失败: ", err)
+		slog.Error("序列化上报数据失败", "error", err)
 		return err
 	}
 
+	slog.Debug("即将上报数据", "hostname", info.Hostname, "bytes", len(reportPaylod))
+
 	req, err := http.NewRequestWithContext(ctx, "POST", reportURL, bytes.NewBuffer(reportPaylod))
 	if err != nil {
-		log.Println("创建请求失败：", err)
+		slog.Error("创建请求失败", "error", err)
 		return err
 	}
-	setDefaultHeaders(req)
+	setDefaultHeaders(req, r.reporter.AgentID)
 
-	resp, err := r.reporter.Client.Do(req)
+	resp, err := r.send(req, "status")
 	if err != nil {
-		log.Println("上报数据失败：", err)
+		slog.Error("上报数据失败", "error", err)
 		return err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		err := statusError(resp.StatusCode)
+		slog.Error("上报数据失败", "error", err)
+		return err
+	}
 	return nil
 }
 
@@ -108,41 +172,210 @@ func (r *DefaultReporter) Report(ctx context.Context, info *model.SystemInfo) er
 func (r *DefaultReporter) ReportBatch(ctx context.Context, infoList []*model.SystemInfo) error {
 	// 客户端未注册，先注册
 	if err := r.register(ctx, infoList[0]); err != nil {
-		log.Printf("注册客户端失败: %v", err)
+		slog.Error("注册客户端失败", "error", err)
 		return err
 	}
 
 	reportURL := fmt.Sprintf("%s/api/agents/status", r.reporter.ServerURL)
 	reportPaylod, err := json.Marshal(infoList)
-
 	if err != nil {
-		log.Println("注册客户端失败: ", err)
+		slog.Error("序列化上报数据失败", "error", err)
 		return err
 	}
 
+	slog.Debug("即将批量上报数据", "count", len(infoList), "bytes", len(reportPaylod))
+
 	req, err := http.NewRequestWithContext(ctx, "POST", reportURL, bytes.NewBuffer(reportPaylod))
 	if err != nil {
-		log.Println("创建请求失败：", err)
+		slog.Error("创建请求失败", "error", err)
 		return err
 	}
-	setDefaultHeaders(req)
+	setDefaultHeaders(req, r.reporter.AgentID)
 
-	resp, err := r.reporter.Client.Do(req)
+	resp, err := r.send(req, "status")
 	if err != nil {
-		log.Println("上报数据失败：", err)
+		slog.Error("上报数据失败", "error", err)
 		return err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		err := statusError(resp.StatusCode)
+		slog.Error("上报数据失败", "error", err)
+		return err
+	}
 	return nil
 }
 
+// maxRetries 网络错误或服务器错误时的最大重试次数
+const maxRetries = 2
+
+// send 发送请求并记录往返时间和状态码，网络错误、429 和 5xx 会按递增的间隔重试
+func (r *DefaultReporter) send(req *http.Request, endpoint string) (*http.Response, error) {
+	ctx := req.Context()
+	var body []byte
+	if req.GetBody != nil {
+		rc, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		body, err = io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			telemetry.Default.AddRetry(endpoint)
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(time.Duration(attempt) * time.Second):
+			}
+		}
+
+		resp, err := r.do(req, body, endpoint)
+		status := 0
+		if resp != nil {
+			status = resp.StatusCode
+		}
+
+		if attempt < maxRetries && ctx.Err() == nil && (err != nil || status == http.StatusTooManyRequests || status >= 500) {
+			if resp != nil {
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+			}
+			slog.Debug("请求失败，准备重试", "endpoint", endpoint, "status", status, "error", err, "attempt", attempt+1)
+			continue
+		}
+		return resp, err
+	}
+}
+
+// do 签名并发送一次请求，每次发送都使用新的随机数。服务器提示时间偏差过大时，
+// 按服务器时间重新签名后再发送一次
+func (r *DefaultReporter) do(req *http.Request, body []byte, endpoint string) (*http.Response, error) {
+	for skewRetried := false; ; skewRetried = true {
+		if err := r.reporter.Signer.Sign(req, body); err != nil {
+			return nil, err
+		}
+		req.Body = http.NoBody
+		if body != nil {
+			req.Body = io.NopCloser(bytes.NewReader(body))
+		}
+
+		start := time.Now()
+		resp, err := r.reporter.Client.Do(req)
+		status := 0
+		if resp != nil {
+			status = resp.StatusCode
+		}
+		telemetry.Default.ObserveRequest(endpoint, time.Since(start), status, err)
+
+		if err == nil {
+			r.reporter.Tokens.HandleResponse(resp)
+		}
+		if err == nil && !skewRetried {
+			if offset, ok := r.reporter.Signer.CheckSkew(resp); ok {
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+				slog.Warn("本机时间与服务器相差过大，已按服务器时间重新签名，请检查 NTP 时间同步", "offset", offset.Round(time.Second))
+				continue
+			}
+		}
+		return resp, err
+	}
+}
+
+// FetchConfig 通过 If-None-Match 拉取远程配置，服务器返回 304 时说明配置没有变化
+func (r *DefaultReporter) FetchConfig(ctx context.Context, etag string) (*model.RemoteConfig, error) {
+	configURL := fmt.Sprintf("%s/api/agents/config", r.reporter.ServerURL)
+	req, err := http.NewRequestWithContext(ctx, "GET", configURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	setDefaultHeaders(req, r.reporter.AgentID)
+	req.Header.Set("Accept", "application/json")
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+
+	resp, err := r.send(req, "config")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotModified:
+		return nil, nil
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, errors.New("服务器不支持远程配置")
+	case resp.StatusCode >= 300:
+		return nil, statusError(resp.StatusCode)
+	}
+
+	var remote model.RemoteConfig
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxConfigSize)).Decode(&remote); err != nil {
+		return nil, fmt.Errorf("解析远程配置失败: %w", err)
+	}
+	remote.ETag = resp.Header.Get("ETag")
+	if remote.Version == "" {
+		remote.Version = strings.Trim(strings.TrimPrefix(remote.ETag, "W/"), `"`)
+	}
+	return &remote, nil
+}
+
+// SignRequest 为请求设置 User-Agent、客户端 ID 等通用头部，并添加令牌和签名
+func (r *DefaultReporter) SignRequest(req *http.Request, body []byte) error {
+	setDefaultHeaders(req, r.reporter.AgentID)
+	return r.reporter.Signer.Sign(req, body)
+}
+
+// CheckResponse 保存服务器轮换的令牌，服务器提示时间偏差过大时按服务器时间校正之后的签名并返回 true，
+// 调用方应重新签名后再发送一次
+func (r *DefaultReporter) CheckResponse(resp *http.Response) bool {
+	r.reporter.Tokens.HandleResponse(resp)
+	offset, ok := r.reporter.Signer.CheckSkew(resp)
+	if ok {
+		slog.Warn("本机时间与服务器相差过大，已按服务器时间重新签名，请检查 NTP 时间同步", "offset", offset.Round(time.Second))
+	}
+	return ok
+}
+
+// UploadDiagnostics 把诊断信息上传到 /api/agents/diagnostics
+func (r *DefaultReporter) UploadDiagnostics(ctx context.Context, bundle interface{}) error {
+	data, err := json.Marshal(bundle)
+	if err != nil {
+		return err
+	}
+	uploadURL := fmt.Sprintf("%s/api/agents/diagnostics", r.reporter.ServerURL)
+	req, err := http.NewRequestWithContext(ctx, "POST", uploadURL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	setDefaultHeaders(req, r.reporter.AgentID)
+
+	resp, err := r.send(req, "diagnostics")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return statusError(resp.StatusCode)
+	}
+	return nil
+}
+
+// maxConfigSize 远程配置的最大大小
+const maxConfigSize = 1 << 20
+
 func (r *DefaultReporter) register(ctx context.Context, info *model.SystemInfo) error {
 
-	log.Println("开始检查是否客户端已经注册，未注册将会自动注册")
+	slog.Debug("开始检查是否客户端已经注册，未注册将会自动注册")
 
 	registerURL := fmt.Sprintf("%s/api/agents/register", r.reporter.ServerURL)
 	registerPaylod := &model.RegisterPayload{
-		Token:       config.Token,
 		Name:        info.Hostname,
 		Hostname:    info.Hostname,
 		IPAddresses: utils.GetLocalIPs(),
@@ -153,46 +386,53 @@ func (r *DefaultReporter) register(ctx context.Context, info *model.SystemInfo)
 	data, err := json.Marshal(registerPaylod)
 
 	if err != nil {
-		log.Println("注册客户端失败: ", err)
+		slog.Error("注册客户端失败", "error", err)
 		return err
 	}
 
 	req, err := http.NewRequestWithContext(ctx, "POST", registerURL, bytes.NewBuffer(data))
 	if err != nil {
-		log.Println("创建请求失败: ", err)
+		slog.Error("创建请求失败", "error", err)
 		return err
 	}
-	setDefaultHeaders(req)
+	setDefaultHeaders(req, r.reporter.AgentID)
 
-	resp, err := r.reporter.Client.Do(req)
+	resp, err := r.send(req, "register")
 	if err != nil {
-		log.Println("注册客户端失败: ", err)
+		slog.Error("注册客户端失败", "error", err)
 		return err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusUpgradeRequired {
+		err := statusError(resp.StatusCode)
+		slog.Error("注册客户端失败", "error", err)
+		return err
+	}
 
 	body, err := io.ReadAll(resp.Body)
 
 	if err != nil {
-		log.Println("注册客户端失败: ", err)
+		slog.Error("注册客户端失败", "error", err)
 		return err
 	}
 
 	var respData *model.RegisterResponse
 
 	if err := json.Unmarshal(body, &respData); err != nil {
-		log.Println("注册客户端失败: ", err)
+		slog.Error("注册客户端失败", "error", err)
 		return err
 	}
 
 	if !respData.Success {
-		log.Println("注册客户端失败: ", respData.Message)
+		slog.Error("注册客户端失败", "message", respData.Message)
 		return errors.New(respData.Message)
 	}
 
-	log.Printf("客户端 ID: %d", respData.Agent.ID)
+	slog.Info("客户端注册成功", "agent_id", respData.Agent.ID)
 
 	r.reporter.Registered = true
+	r.reporter.AgentID = respData.Agent.ID
+	telemetry.Default.SetRegistered(true)
 
 	return nil
 }
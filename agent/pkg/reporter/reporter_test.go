@@ -0,0 +1,154 @@
+package reporter
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xugou/agent/pkg/auth"
+	"github.com/xugou/agent/pkg/model"
+)
+
+const testToken = "xugou_reporter_test.0123456789abcdef"
+
+// signedRequest 服务器收到的请求
+type signedRequest struct {
+	path          string
+	authorization string
+	timestamp     int64
+	nonce         string
+	body          string
+}
+
+// newTestReporter 创建向 handler 上报的已注册上报器，返回服务器收到的请求
+func newTestReporter(t *testing.T, handler func(w http.ResponseWriter, req signedRequest)) (*DefaultReporter, func() []signedRequest) {
+	t.Helper()
+	var mu sync.Mutex
+	var received []signedRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		ts, _ := strconv.ParseInt(r.Header.Get(auth.HeaderTimestamp), 10, 64)
+		req := signedRequest{
+			path:          r.URL.Path,
+			authorization: r.Header.Get("Authorization"),
+			timestamp:     ts,
+			nonce:         r.Header.Get(auth.HeaderNonce),
+			body:          string(body),
+		}
+		mu.Lock()
+		received = append(received, req)
+		mu.Unlock()
+		handler(w, req)
+	}))
+	t.Cleanup(srv.Close)
+
+	tokens, err := auth.NewTokens(testToken, "", t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &DefaultReporter{reporter: &model.HTTPReporter{
+		ServerURL:  srv.URL,
+		Client:     srv.Client(),
+		Tokens:     tokens,
+		Signer:     auth.NewSigner(tokens),
+		Registered: true,
+	}}
+	return r, func() []signedRequest {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]signedRequest(nil), received...)
+	}
+}
+
+// rejectSkew 模拟服务器时间为 serverTime，签名时间相差超过 auth.MaxSkew 时返回 clock_skew
+func rejectSkew(serverTime time.Time) func(w http.ResponseWriter, req signedRequest) {
+	return func(w http.ResponseWriter, req signedRequest) {
+		if d := serverTime.Unix() - req.timestamp; d > int64(auth.MaxSkew/time.Second) || -d > int64(auth.MaxSkew/time.Second) {
+			w.Header().Set(auth.HeaderError, auth.ErrorClockSkew)
+			w.Header().Set(auth.HeaderServerTime, strconv.FormatInt(serverTime.Unix(), 10))
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}
+}
+
+func TestReportRetriesOnceAfterClockSkew(t *testing.T) {
+	serverTime := time.Now().Add(time.Hour)
+	r, received := newTestReporter(t, rejectSkew(serverTime))
+
+	if err := r.Report(context.Background(), &model.SystemInfo{Hostname: "web"}); err != nil {
+		t.Fatal(err)
+	}
+	reqs := received()
+	if len(reqs) != 2 {
+		t.Fatalf("server received %d requests, want 2", len(reqs))
+	}
+	// 重试按服务器时间重新签名，使用新的随机数，请求体不变
+	if reqs[0].nonce == reqs[1].nonce {
+		t.Error("retry reused the nonce")
+	}
+	if d := reqs[1].timestamp - serverTime.Unix(); d < -2 || d > 2 {
+		t.Errorf("retry timestamp differs from server time by %ds", d)
+	}
+	if reqs[0].body != reqs[1].body || reqs[1].body == "" {
+		t.Errorf("retry body = %q, want %q", reqs[1].body, reqs[0].body)
+	}
+
+	// 校正后之后的请求直接使用服务器时间
+	if err := r.Report(context.Background(), &model.SystemInfo{Hostname: "web"}); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(received()); n != 3 {
+		t.Errorf("server received %d requests, want 3", n)
+	}
+}
+
+func TestReportRetriesSkewOnlyOnce(t *testing.T) {
+	// 服务器每次都返回时间偏差时只重新签名一次，不会无限重试
+	r, received := newTestReporter(t, func(w http.ResponseWriter, req signedRequest) {
+		w.Header().Set(auth.HeaderError, auth.ErrorClockSkew)
+		w.Header().Set(auth.HeaderServerTime, strconv.FormatInt(req.timestamp+3600, 10))
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+
+	if err := r.Report(context.Background(), &model.SystemInfo{Hostname: "web"}); err == nil {
+		t.Fatal("Report succeeded")
+	}
+	if n := len(received()); n != 2 {
+		t.Errorf("server received %d requests, want 2", n)
+	}
+}
+
+func TestReportSendsTokenOnlyInHeader(t *testing.T) {
+	r, received := newTestReporter(t, func(w http.ResponseWriter, req signedRequest) {
+		if req.path == "/api/agents/register" {
+			io.WriteString(w, `{"success": true, "agent": {"id": 7}}`)
+		}
+	})
+
+	info := &model.SystemInfo{Hostname: "web", Labels: map[string]string{"env": "prod"}}
+	if err := r.Report(context.Background(), info); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.ReportBatch(context.Background(), []*model.SystemInfo{info, info}); err != nil {
+		t.Fatal(err)
+	}
+
+	reqs := received()
+	if len(reqs) != 3 || reqs[1].path != "/api/agents/register" {
+		t.Fatalf("server received %+v", reqs)
+	}
+	for _, req := range reqs {
+		if req.authorization != "Bearer "+testToken {
+			t.Errorf("Authorization = %q", req.authorization)
+		}
+		if strings.Contains(req.body, testToken) || strings.Contains(req.body, `"token"`) {
+			t.Errorf("request body contains the token: %s", req.body)
+		}
+	}
+}
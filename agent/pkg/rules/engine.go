@@ -0,0 +1,200 @@
+package rules
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/xugou/agent/pkg/model"
+)
+
+// alertState 单条规则在单个实例上的状态
+type alertState struct {
+	since  time.Time // 条件开始满足的时间
+	firing bool
+	value  float64
+}
+
+// Engine 在每次采集后计算规则，并在告警触发和恢复时产生事件
+type Engine struct {
+	mu      sync.Mutex
+	rules   []*Rule
+	removed []*Rule // SetRules 替换掉的规则，用于在下一次计算时为它们产生恢复事件
+	states  map[string]*alertState
+}
+
+// NewEngine 创建规则引擎
+func NewEngine(rules []*Rule) *Engine {
+	return &Engine{
+		rules:  rules,
+		states: make(map[string]*alertState),
+	}
+}
+
+// Rules 返回引擎使用的规则
+func (e *Engine) Rules() []*Rule {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.rules
+}
+
+// SetRules 替换引擎使用的规则，同名规则保留原来的告警状态，
+// 被删除的规则正在触发的告警会在下一次计算时恢复。两次计算之间多次替换时，
+// 之前替换掉的规则继续保留到下一次计算
+func (e *Engine) SetRules(rules []*Rule) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.removed = append(e.removed, e.rules...)
+	e.rules = rules
+}
+
+// Evaluate 用一次采集的数据计算所有规则，返回状态发生变化的告警事件
+func (e *Engine) Evaluate(info *model.SystemInfo, now time.Time) ([]model.AlertEvent, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if len(e.rules) == 0 && len(e.states) == 0 {
+		return nil, nil
+	}
+	fields, err := Fields(info)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []model.AlertEvent
+	seen := make(map[string]bool)
+	for _, rule := range e.rules {
+		values := rule.lookup(fields)
+		instances := make([]string, 0, len(values))
+		for instance := range values {
+			instances = append(instances, instance)
+		}
+		sort.Strings(instances)
+
+		for _, instance := range instances {
+			key := rule.Name + "\x00" + instance
+			seen[key] = true
+			if event, ok := e.step(rule, instance, key, values[instance], now); ok {
+				events = append(events, event)
+			}
+		}
+	}
+
+	// 字段消失（例如磁盘被卸载）时，正在触发的告警视为恢复
+	keys := make([]string, 0, len(e.states))
+	for key := range e.states {
+		if !seen[key] {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		state := e.states[key]
+		delete(e.states, key)
+		if state.firing {
+			rule, instance := e.ruleForKey(key)
+			if rule != nil {
+				events = append(events, rule.event(instance, model.AlertResolved, state.value, state.since, now))
+			}
+		}
+	}
+	e.removed = nil
+	return events, nil
+}
+
+// step 根据最新的值推进单个告警的状态
+func (e *Engine) step(rule *Rule, instance, key string, value float64, now time.Time) (model.AlertEvent, bool) {
+	state, ok := e.states[key]
+	if !ok {
+		state = &alertState{}
+	}
+	state.value = value
+
+	if state.firing {
+		// 已经触发的告警要越过恢复阈值才算恢复，避免在阈值附近反复触发
+		if rule.recovered(value) {
+			delete(e.states, key)
+			return rule.event(instance, model.AlertResolved, value, state.since, now), true
+		}
+		e.states[key] = state
+		return model.AlertEvent{}, false
+	}
+
+	if !match(rule.Op, value, rule.Threshold) {
+		delete(e.states, key)
+		return model.AlertEvent{}, false
+	}
+	if state.since.IsZero() {
+		state.since = now
+	}
+	e.states[key] = state
+	if now.Sub(state.since) >= rule.For {
+		state.firing = true
+		return rule.event(instance, model.AlertFiring, value, state.since, now), true
+	}
+	return model.AlertEvent{}, false
+}
+
+// Firing 返回当前正在触发的告警
+func (e *Engine) Firing() []model.AlertEvent {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var alerts []model.AlertEvent
+	for key, state := range e.states {
+		if !state.firing {
+			continue
+		}
+		if rule, instance := e.ruleForKey(key); rule != nil {
+			alerts = append(alerts, rule.event(instance, model.AlertFiring, state.value, state.since, state.since))
+		}
+	}
+	sort.Slice(alerts, func(i, j int) bool {
+		if alerts[i].Rule != alerts[j].Rule {
+			return alerts[i].Rule < alerts[j].Rule
+		}
+		return alerts[i].Instance < alerts[j].Instance
+	})
+	return alerts
+}
+
+func (e *Engine) ruleForKey(key string) (*Rule, string) {
+	for _, list := range [][]*Rule{e.rules, e.removed} {
+		for _, rule := range list {
+			prefix := rule.Name + "\x00"
+			if len(key) >= len(prefix) && key[:len(prefix)] == prefix {
+				return rule, key[len(prefix):]
+			}
+		}
+	}
+	return nil, ""
+}
+
+// recovered 判断正在触发的告警是否已经恢复
+func (r *Rule) recovered(value float64) bool {
+	if r.Clear == nil {
+		return !match(r.Op, value, r.Threshold)
+	}
+	switch r.Op {
+	case ">", ">=":
+		return value < *r.Clear
+	case "<", "<=":
+		return value > *r.Clear
+	}
+	return !match(r.Op, value, r.Threshold)
+}
+
+func (r *Rule) event(instance, state string, value float64, since, now time.Time) model.AlertEvent {
+	return model.AlertEvent{
+		Rule:        r.Name,
+		Instance:    instance,
+		Severity:    r.Severity,
+		State:       state,
+		Expr:        r.Expr,
+		Value:       value,
+		Threshold:   r.Threshold,
+		Description: r.Description,
+		Since:       since,
+		Time:        now,
+	}
+}
@@ -0,0 +1,213 @@
+package rules
+
+import (
+	"testing"
+	"time"
+
+	"github.com/xugou/agent/pkg/model"
+)
+
+func mustParse(t *testing.T, name, expr string) *Rule {
+	t.Helper()
+	rule, err := Parse(Config{Name: name, Expr: expr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rule
+}
+
+func cpuInfo(usage float64) *model.SystemInfo {
+	return &model.SystemInfo{CPUInfo: model.CPUInfo{Usage: usage}}
+}
+
+func TestEngineFiringAndResolved(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 0, 0, 0, time.UTC)
+	e := NewEngine([]*Rule{mustParse(t, "cpu_high", "cpu.usage > 90 for 2m")})
+
+	steps := []struct {
+		usage float64
+		after time.Duration
+		want  string // 期望的事件状态，为空表示没有事件
+	}{
+		{95, 0, ""},
+		{96, time.Minute, ""},
+		{97, 2 * time.Minute, model.AlertFiring},
+		{98, 3 * time.Minute, ""},
+		{50, 4 * time.Minute, model.AlertResolved},
+	}
+	for _, step := range steps {
+		events, err := e.Evaluate(cpuInfo(step.usage), now.Add(step.after))
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch {
+		case step.want == "" && len(events) != 0:
+			t.Errorf("after %s: events = %+v, want none", step.after, events)
+		case step.want != "" && (len(events) != 1 || events[0].State != step.want):
+			t.Errorf("after %s: events = %+v, want %s", step.after, events, step.want)
+		}
+	}
+}
+
+func TestEngineSetRulesTwice(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 0, 0, 0, time.UTC)
+	e := NewEngine([]*Rule{
+		mustParse(t, "cpu_high", "cpu.usage > 90"),
+		mustParse(t, "cpu_busy", "cpu.usage > 50"),
+	})
+	events, err := e.Evaluate(cpuInfo(95), now)
+	if err != nil || len(events) != 2 {
+		t.Fatalf("Evaluate() = %+v, %v, want two firing alerts", events, err)
+	}
+
+	// 两次计算之间连续替换规则，第一次替换掉的规则的告警也要恢复
+	e.SetRules([]*Rule{mustParse(t, "cpu_busy", "cpu.usage > 50")})
+	e.SetRules(nil)
+	events, err = e.Evaluate(cpuInfo(95), now.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolved := make(map[string]bool)
+	for _, event := range events {
+		if event.State != model.AlertResolved {
+			t.Errorf("event %+v, want resolved", event)
+		}
+		resolved[event.Rule] = true
+	}
+	if !resolved["cpu_high"] || !resolved["cpu_busy"] {
+		t.Errorf("resolved = %v, want cpu_high and cpu_busy", resolved)
+	}
+	if firing := e.Firing(); len(firing) != 0 {
+		t.Errorf("Firing() = %+v, want none", firing)
+	}
+}
+
+func TestEngineHysteresis(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 0, 0, 0, time.UTC)
+	rule, err := Parse(Config{Name: "cpu_high", Expr: "cpu.usage > 90", Clear: 80, Severity: "critical"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := NewEngine([]*Rule{rule})
+
+	steps := []struct {
+		usage  float64
+		want   string // 期望的事件状态，为空表示没有事件
+		firing bool   // 计算后告警是否处于触发状态
+	}{
+		{95, model.AlertFiring, true},
+		// 降到触发阈值以下但没有低于恢复阈值时继续触发
+		{85, "", true},
+		{90, "", true},
+		{80, "", true},
+		{79.9, model.AlertResolved, false},
+		// 恢复后在两个阈值之间不会再次触发
+		{85, "", false},
+		{91, model.AlertFiring, true},
+	}
+	for i, step := range steps {
+		events, err := e.Evaluate(cpuInfo(step.usage), now.Add(time.Duration(i)*time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch {
+		case step.want == "" && len(events) != 0:
+			t.Errorf("step %d (%v): events = %+v, want none", i, step.usage, events)
+		case step.want != "" && (len(events) != 1 || events[0].State != step.want || events[0].Value != step.usage):
+			t.Errorf("step %d (%v): events = %+v, want %s", i, step.usage, events, step.want)
+		}
+		firing := e.Firing()
+		if (len(firing) == 1) != step.firing {
+			t.Errorf("step %d (%v): Firing() = %+v, want firing %v", i, step.usage, firing, step.firing)
+		}
+		if len(firing) == 1 && firing[0].Value != step.usage {
+			t.Errorf("step %d: firing value = %v, want the latest value %v", i, firing[0].Value, step.usage)
+		}
+	}
+}
+
+func TestEngineHysteresisBelow(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 0, 0, 0, time.UTC)
+	rule, err := Parse(Config{Name: "memory_low", Expr: "memory.available < 100MB", Clear: "200MB"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := NewEngine([]*Rule{rule})
+
+	const mb = 1 << 20
+	steps := []struct {
+		available uint64
+		want      string
+	}{
+		{50 * mb, model.AlertFiring},
+		{150 * mb, ""},
+		{200 * mb, ""},
+		{201 * mb, model.AlertResolved},
+	}
+	for i, step := range steps {
+		info := &model.SystemInfo{MemoryInfo: model.MemoryInfo{Available: step.available}}
+		events, err := e.Evaluate(info, now.Add(time.Duration(i)*time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch {
+		case step.want == "" && len(events) != 0:
+			t.Errorf("step %d: events = %+v, want none", i, events)
+		case step.want != "" && (len(events) != 1 || events[0].State != step.want):
+			t.Errorf("step %d: events = %+v, want %s", i, events, step.want)
+		}
+	}
+}
+
+func TestRuleSeverityAndClear(t *testing.T) {
+	tests := []struct {
+		config   Config
+		severity string
+		clear    float64 // 为 0 表示没有恢复阈值
+	}{
+		{Config{Expr: "cpu.usage > 90"}, SeverityWarning, 0},
+		{Config{Expr: "cpu.usage > 90", Severity: "INFO"}, SeverityInfo, 0},
+		{Config{Expr: "cpu.usage > 90", Severity: "critical", Clear: 85}, SeverityCritical, 85},
+		{Config{Expr: "cpu.usage >= 90", Clear: "90"}, SeverityWarning, 90},
+		{Config{Expr: "memory.available <= 1GB", Clear: "1.5GB"}, SeverityWarning, 1.5 * (1 << 30)},
+	}
+	for _, tt := range tests {
+		tt.config.Name = "test"
+		rule, err := Parse(tt.config)
+		if err != nil {
+			t.Errorf("Parse(%+v) = %v", tt.config, err)
+			continue
+		}
+		if rule.Severity != tt.severity {
+			t.Errorf("Parse(%+v) severity = %q, want %q", tt.config, rule.Severity, tt.severity)
+		}
+		if (rule.Clear == nil) != (tt.clear == 0) || (rule.Clear != nil && *rule.Clear != tt.clear) {
+			t.Errorf("Parse(%+v) clear = %v, want %v", tt.config, rule.Clear, tt.clear)
+		}
+
+		// 告警事件带有规则的级别
+		e := NewEngine([]*Rule{rule})
+		events, err := e.Evaluate(&model.SystemInfo{CPUInfo: model.CPUInfo{Usage: 95}}, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, event := range events {
+			if event.Severity != tt.severity {
+				t.Errorf("event severity = %q, want %q", event.Severity, tt.severity)
+			}
+		}
+	}
+
+	for _, c := range []Config{
+		{Expr: "cpu.usage > 90", Severity: "fatal"},
+		{Expr: "cpu.usage > 90", Clear: 95},
+		{Expr: "memory.available < 1GB", Clear: "512MB"},
+		{Expr: "cpu.usage == 90", Clear: 80},
+		{Expr: "cpu.usage > 90", Clear: "soon"},
+	} {
+		c.Name = "invalid"
+		if _, err := Parse(c); err == nil {
+			t.Errorf("Parse(%+v) succeeded", c)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package rules
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/xugou/agent/pkg/model"
+)
+
+// ListKey 描述如何在规则中引用列表里的元素
+type ListKey struct {
+	Alias string // 规则中使用的名称，例如 disk
+	Key   string // 作为索引的字段，例如 mount_point
+}
+
+// ListKeys 采集数据中的列表字段，例如 disks 中的元素可以用 disk["/"] 引用。
+// 嵌套的列表按最后一级的名称查找，例如 probe["check_disk"].plugin.perfdata["/"]
+var ListKeys = map[string]ListKey{
+	"disks":          {Alias: "disk", Key: "mount_point"},
+	"network":        {Alias: "network", Key: "interface"},
+	"probes":         {Alias: "probe", Key: "name"},
+	"perfdata":       {Alias: "perfdata", Key: "label"},
+	"custom_metrics": {Alias: "metric", Key: "series"},
+	"textfiles":      {Alias: "textfile", Key: "file"},
+	"metrics":        {Alias: "metric", Key: "series"},
+}
+
+// skipFields 不参与规则计算的顶层字段
+var skipFields = map[string]bool{"agent": true, "alerts": true}
+
+// Fields 把采集数据展开为字段路径到数值的映射，例如 memory.available、disk["/"].usage_rate
+func Fields(info *model.SystemInfo) (map[string]float64, error) {
+	data, err := json.Marshal(info)
+	if err != nil {
+		return nil, err
+	}
+	var root map[string]interface{}
+	if err := json.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+	for name := range skipFields {
+		delete(root, name)
+	}
+	// 探测状态是字符串，转换为 probe["名称"].up，可用时为 1，否则为 0
+	if list, ok := root["probes"].([]interface{}); ok {
+		for _, item := range list {
+			if obj, ok := item.(map[string]interface{}); ok {
+				obj["up"] = obj["status"] == model.ProbeUp
+			}
+		}
+	}
+	// 自定义指标按序列名称引用，例如 metric["queue_length{queue=mail}"].value
+	if list, ok := root["custom_metrics"].([]interface{}); ok {
+		for i, item := range list {
+			if obj, ok := item.(map[string]interface{}); ok && i < len(info.CustomMetrics) {
+				obj["series"] = info.CustomMetrics[i].Series()
+			}
+		}
+	}
+	// StatsD 指标同样按序列名称引用，例如 statsd.metric["api.latency{route=/login}"].summary.percentiles.p99
+	if report, ok := root["statsd"].(map[string]interface{}); ok && info.Statsd != nil {
+		list, _ := report["metrics"].([]interface{})
+		for i, item := range list {
+			if obj, ok := item.(map[string]interface{}); ok && i < len(info.Statsd.Metrics) {
+				obj["series"] = info.Statsd.Metrics[i].Series()
+			}
+		}
+	}
+	// 文本文件是否读取成功，转换为 textfile["名称"].ok
+	if list, ok := root["textfiles"].([]interface{}); ok {
+		for _, item := range list {
+			if obj, ok := item.(map[string]interface{}); ok {
+				obj["ok"] = obj["error"] == nil
+			}
+		}
+	}
+
+	fields := make(map[string]float64)
+	flatten("", root, fields)
+	return fields, nil
+}
+
+func flatten(path string, value interface{}, fields map[string]float64) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for name, child := range v {
+			if path == "" {
+				flatten(name, child, fields)
+			} else {
+				flatten(path+"."+name, child, fields)
+			}
+		}
+	case []interface{}:
+		parent, name := "", path
+		if i := strings.LastIndexByte(path, '.'); i >= 0 {
+			parent, name = path[:i+1], path[i+1:]
+		}
+		lk, keyed := ListKeys[name]
+		for i, item := range v {
+			obj, ok := item.(map[string]interface{})
+			if keyed && ok {
+				if key, ok := obj[lk.Key].(string); ok {
+					flatten(fmt.Sprintf("%s%s[%s]", parent, lk.Alias, strconv.Quote(key)), obj, fields)
+					continue
+				}
+			}
+			flatten(fmt.Sprintf("%s[%s]", path, strconv.Quote(strconv.Itoa(i))), item, fields)
+		}
+	case float64:
+		fields[path] = v
+	case bool:
+		if v {
+			fields[path] = 1
+		} else {
+			fields[path] = 0
+		}
+	}
+}
+
+// lookup 查找规则引用的字段，返回实例名称到数值的映射，非通配规则的实例名称为空
+func (r *Rule) lookup(fields map[string]float64) map[string]float64 {
+	if r.pattern == nil {
+		if v, ok := fields[r.Path]; ok {
+			return map[string]float64{"": v}
+		}
+		return nil
+	}
+
+	values := make(map[string]float64)
+	for path, v := range fields {
+		m := r.pattern.FindStringSubmatch(path)
+		if m == nil {
+			continue
+		}
+		instance := ""
+		for i, quoted := range m[1:] {
+			key, err := strconv.Unquote(quoted)
+			if err != nil {
+				key = quoted
+			}
+			if i > 0 {
+				instance += ","
+			}
+			instance += key
+		}
+		values[instance] = v
+	}
+	return values
+}
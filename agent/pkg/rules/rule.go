@@ -0,0 +1,268 @@
+package rules
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 告警级别
+const (
+	SeverityInfo     = "info"
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
+)
+
+// Config 配置文件中的一条规则
+type Config struct {
+	Name        string      `json:"name"`
+	Expr        string      `json:"expr"`        // 例如 disk["/"].usage_rate > 90 for 5m
+	For         string      `json:"for"`         // 条件持续多久才触发，也可以写在 expr 末尾
+	Severity    string      `json:"severity"`    // info、warning 或 critical，默认 warning
+	Clear       interface{} `json:"clear"`       // 恢复阈值，用于避免在阈值附近反复触发，例如 85 或 300MB
+	Description string      `json:"description"` // 告警说明
+}
+
+// Rule 解析后的规则
+type Rule struct {
+	Name        string
+	Expr        string
+	Path        string // 规范化后的字段路径，例如 disk["/"].usage_rate
+	Op          string
+	Threshold   float64
+	Clear       *float64
+	For         time.Duration
+	Severity    string
+	Description string
+
+	pattern *regexp.Regexp // 字段路径包含 [*] 时用于匹配实例
+}
+
+var exprRe = regexp.MustCompile(`^\s*(.+?)\s*(>=|<=|==|!=|>|<)\s*([-+]?[0-9]*\.?[0-9]+)\s*([A-Za-z%]*)\s*(?:\bfor\s+(\S+))?\s*$`)
+
+// ParseConfig 解析配置文件中的规则列表，raw 为 viper 或 YAML 解析出的原始值
+func ParseConfig(raw interface{}) ([]*Rule, error) {
+	if raw == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return nil, fmt.Errorf("无法解析规则: %w", err)
+	}
+	var configs []Config
+	if err := json.Unmarshal(data, &configs); err != nil {
+		return nil, fmt.Errorf("规则必须是包含 name、expr 等字段的列表: %w", err)
+	}
+
+	rules := make([]*Rule, 0, len(configs))
+	names := make(map[string]bool)
+	for i, c := range configs {
+		rule, err := Parse(c)
+		if err != nil {
+			if c.Name != "" {
+				return nil, fmt.Errorf("规则 %s: %w", c.Name, err)
+			}
+			return nil, fmt.Errorf("第 %d 条规则: %w", i+1, err)
+		}
+		if names[rule.Name] {
+			return nil, fmt.Errorf("规则名称 %s 重复", rule.Name)
+		}
+		names[rule.Name] = true
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// Parse 解析单条规则
+func Parse(c Config) (*Rule, error) {
+	if c.Name == "" {
+		return nil, fmt.Errorf("缺少 name")
+	}
+	m := exprRe.FindStringSubmatch(c.Expr)
+	if m == nil {
+		return nil, fmt.Errorf("无法解析表达式 %q，格式为 <字段> <比较符> <数值>[单位] [for <时长>]", c.Expr)
+	}
+
+	path, err := parsePath(m[1])
+	if err != nil {
+		return nil, err
+	}
+	// 不存在的字段永远不会触发告警，解析时直接报错
+	if err := checkPath(path); err != nil {
+		return nil, err
+	}
+	threshold, err := parseNumber(m[3], m[4])
+	if err != nil {
+		return nil, err
+	}
+
+	rule := &Rule{
+		Name:        c.Name,
+		Expr:        strings.TrimSpace(c.Expr),
+		Path:        path,
+		Op:          m[2],
+		Threshold:   threshold,
+		Severity:    strings.ToLower(c.Severity),
+		Description: c.Description,
+	}
+
+	forText := c.For
+	if m[5] != "" {
+		if forText != "" {
+			return nil, fmt.Errorf("for 不能同时写在 expr 和 for 中")
+		}
+		forText = m[5]
+	}
+	if forText != "" {
+		if rule.For, err = time.ParseDuration(forText); err != nil || rule.For < 0 {
+			return nil, fmt.Errorf("无效的持续时间 %q", forText)
+		}
+	}
+
+	switch rule.Severity {
+	case "":
+		rule.Severity = SeverityWarning
+	case SeverityInfo, SeverityWarning, SeverityCritical:
+	default:
+		return nil, fmt.Errorf("无效的告警级别 %q，可选 info、warning 或 critical", c.Severity)
+	}
+
+	if c.Clear != nil {
+		clear, err := parseValue(fmt.Sprint(c.Clear))
+		if err != nil {
+			return nil, fmt.Errorf("无效的恢复阈值: %w", err)
+		}
+		switch rule.Op {
+		case ">", ">=":
+			if clear > threshold {
+				return nil, fmt.Errorf("恢复阈值 %v 不能大于触发阈值 %v", clear, threshold)
+			}
+		case "<", "<=":
+			if clear < threshold {
+				return nil, fmt.Errorf("恢复阈值 %v 不能小于触发阈值 %v", clear, threshold)
+			}
+		default:
+			return nil, fmt.Errorf("%s 比较不支持恢复阈值", rule.Op)
+		}
+		rule.Clear = &clear
+	}
+
+	if strings.Contains(path, "[*]") {
+		pattern := regexp.QuoteMeta(path)
+		pattern = strings.ReplaceAll(pattern, `\[\*\]`, `\[("(?:[^"\\]|\\.)*")\]`)
+		rule.pattern = regexp.MustCompile("^" + pattern + "$")
+	}
+	return rule, nil
+}
+
+// parsePath 解析并规范化字段路径，支持 a.b、a["key"].b 和 a[*].b
+func parsePath(text string) (string, error) {
+	var b strings.Builder
+	s := strings.TrimSpace(text)
+	expectIdent := true
+	for len(s) > 0 {
+		switch {
+		case s[0] == '.':
+			if expectIdent {
+				return "", fmt.Errorf("无效的字段 %q", text)
+			}
+			s = s[1:]
+			expectIdent = true
+		case s[0] == '[':
+			if expectIdent && b.Len() == 0 {
+				return "", fmt.Errorf("无效的字段 %q", text)
+			}
+			end := strings.IndexByte(s, ']')
+			if end < 0 {
+				return "", fmt.Errorf("无效的字段 %q，缺少 ]", text)
+			}
+			inner := strings.TrimSpace(s[1:end])
+			if inner == "*" {
+				b.WriteString("[*]")
+			} else {
+				key, err := strconv.Unquote(inner)
+				if err != nil {
+					return "", fmt.Errorf("无效的字段 %q，索引需要使用双引号，例如 disk[\"/\"]", text)
+				}
+				b.WriteString("[" + strconv.Quote(key) + "]")
+			}
+			s = s[end+1:]
+			expectIdent = false
+		default:
+			if !expectIdent {
+				return "", fmt.Errorf("无效的字段 %q", text)
+			}
+			n := 0
+			for n < len(s) && (s[n] == '_' || s[n] >= 'a' && s[n] <= 'z' || s[n] >= 'A' && s[n] <= 'Z' || s[n] >= '0' && s[n] <= '9') {
+				n++
+			}
+			if n == 0 {
+				return "", fmt.Errorf("无效的字段 %q", text)
+			}
+			if b.Len() > 0 {
+				b.WriteByte('.')
+			}
+			b.WriteString(s[:n])
+			s = s[n:]
+			expectIdent = false
+		}
+	}
+	if expectIdent {
+		return "", fmt.Errorf("无效的字段 %q", text)
+	}
+	return b.String(), nil
+}
+
+var valueRe = regexp.MustCompile(`^\s*([-+]?[0-9]*\.?[0-9]+)\s*([A-Za-z%]*)\s*$`)
+
+// parseValue 解析带单位的数值，例如 90、90%、200MB
+func parseValue(text string) (float64, error) {
+	m := valueRe.FindStringSubmatch(text)
+	if m == nil {
+		return 0, fmt.Errorf("无效的数值 %q", text)
+	}
+	return parseNumber(m[1], m[2])
+}
+
+// parseNumber 按单位换算数值，容量单位按 1024 进位
+func parseNumber(number, unit string) (float64, error) {
+	v, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return 0, fmt.Errorf("无效的数值 %q", number)
+	}
+	switch strings.ToUpper(unit) {
+	case "", "%", "B":
+		return v, nil
+	case "K", "KB", "KIB":
+		return v * (1 << 10), nil
+	case "M", "MB", "MIB":
+		return v * (1 << 20), nil
+	case "G", "GB", "GIB":
+		return v * (1 << 30), nil
+	case "T", "TB", "TIB":
+		return v * (1 << 40), nil
+	}
+	return 0, fmt.Errorf("不支持的单位 %q", unit)
+}
+
+// match 比较字段值和阈值
+func match(op string, value, threshold float64) bool {
+	switch op {
+	case ">":
+		return value > threshold
+	case ">=":
+		return value >= threshold
+	case "<":
+		return value < threshold
+	case "<=":
+		return value <= threshold
+	case "==":
+		return value == threshold
+	case "!=":
+		return value != threshold
+	}
+	return false
+}
@@ -0,0 +1,72 @@
+package rules
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseFieldPaths(t *testing.T) {
+	valid := []string{
+		`disk["/"].usage_rate > 90 for 5m`,
+		`memory.available < 200MB`,
+		`disk[*].usage_rate > 95`,
+		`disks["0"].usage_rate > 95`,
+		`network["eth0"].bytes_recv > 1GB`,
+		`cpu.usage > 80`,
+		`load.load1 > 4`,
+		`probe["api"].up < 1`,
+		`probe["nginx-certs"].cert_days_left < 45`,
+		`probe["gateways/10.0.0.1"].ping.loss > 20`,
+		`probe["check_disk"].plugin.perfdata["/"].value > 50000`,
+		`probe[*].plugin.exit_code >= 1`,
+		`probe["api.example.com"].timings.dns_ms > 100`,
+		`metric["queue_length"].value > 100`,
+		`metric["jobs_total{queue=mail}"].value > 1000`,
+		`textfile["backup.prom"].stale == 1`,
+		`textfile[*].ok == 0`,
+		`statsd.dropped > 0`,
+		`statsd.metric["api.latency{route=/login}"].summary.percentiles.p95 > 100`,
+		`statsd.metric[*].summary.percentiles.p99_9 > 100`,
+		`statsd.metric["api.latency"].summary.buckets.le_0_5 < 10`,
+	}
+	for _, expr := range valid {
+		if _, err := Parse(Config{Name: "test", Expr: expr}); err != nil {
+			t.Errorf("Parse(%q): %v", expr, err)
+		}
+	}
+
+	invalid := []struct {
+		expr string
+		want string
+	}{
+		{`memory.avail < 200MB`, "未知的字段 memory.avail"},
+		{`mem.available < 200MB`, "未知的字段 mem"},
+		{`disk["/"].usage > 90`, "未知的字段 disk[\"/\"].usage"},
+		{`probe["api"].upp < 1`, "未知的字段"},
+		{`cpu > 80`, "需要指定下级字段"},
+		{`hostname > 1`, "不是数值"},
+		{`disk[*].mount_point > 1`, "不是数值"},
+		{`disks.usage_rate > 90`, "需要使用索引"},
+		{`disks > 1`, "是列表"},
+		{`memory["0"].total > 1`, "不是列表"},
+		{`cpu.usage.max > 1`, "没有下级字段"},
+		{`agent.uptime_seconds > 1`, "未知的字段 agent"},
+		{`alerts[*].value > 1`, "未知的字段 alerts"},
+		{`statsd.metric[*].summary.mean.x > 1`, "没有下级字段"},
+	}
+	for _, tt := range invalid {
+		_, err := Parse(Config{Name: "test", Expr: tt.expr})
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Parse(%q) error = %v, want containing %q", tt.expr, err, tt.want)
+		}
+	}
+}
+
+func TestParseConfigReportsRuleName(t *testing.T) {
+	_, err := ParseConfig([]interface{}{
+		map[string]interface{}{"name": "mem_low", "expr": "memory.free_bytes < 100MB"},
+	})
+	if err == nil || !strings.Contains(err.Error(), "规则 mem_low") {
+		t.Errorf("ParseConfig error = %v, want the rule name", err)
+	}
+}
@@ -0,0 +1,188 @@
+package rules
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/xugou/agent/pkg/model"
+)
+
+// nodeKind 采集数据中一个位置的类型
+type nodeKind int
+
+const (
+	kindValue  nodeKind = iota // 数值或布尔值，可以在规则中比较
+	kindText                   // 字符串和时间，不能比较
+	kindStruct                 // 对象，下级字段固定
+	kindList                   // 列表，需要使用索引
+	kindMap                    // 键名不固定的对象，例如 StatsD 计时器的百分位
+	kindAny                    // 内容不固定，不做检查
+)
+
+// node 采集数据结构中的一个位置，用于在解析规则时检查字段路径
+type node struct {
+	kind     nodeKind
+	children map[string]*node // 对象的下级字段，带索引的列表同时以原名和别名出现
+	elem     *node            // 列表的元素或 map 的值
+}
+
+// extraFields 由 Fields 额外生成的字段，按所在列表的名称查找
+var extraFields = map[string]map[string]nodeKind{
+	"probes":         {"up": kindValue},
+	"textfiles":      {"ok": kindValue},
+	"custom_metrics": {"series": kindText},
+	"metrics":        {"series": kindText},
+}
+
+// schema 采集数据的结构，由 model.SystemInfo 生成
+var schema = func() *node {
+	root := schemaOf(reflect.TypeOf(model.SystemInfo{}))
+	for name := range skipFields {
+		delete(root.children, name)
+	}
+	return root
+}()
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func schemaOf(t reflect.Type) *node {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Float32, reflect.Float64:
+		return &node{kind: kindValue}
+	case reflect.String:
+		return &node{kind: kindText}
+	case reflect.Slice, reflect.Array:
+		return &node{kind: kindList, elem: schemaOf(t.Elem())}
+	case reflect.Map:
+		return &node{kind: kindMap, elem: schemaOf(t.Elem())}
+	case reflect.Struct:
+		if t == timeType {
+			return &node{kind: kindText}
+		}
+		n := &node{kind: kindStruct, children: make(map[string]*node)}
+		addFields(n, t)
+		return n
+	}
+	return &node{kind: kindAny}
+}
+
+// addFields 按 JSON 编码的规则添加结构体的字段，匿名嵌入的结构体的字段提升到上一级
+func addFields(n *node, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" || !f.IsExported() && !f.Anonymous {
+			continue
+		}
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			addFields(n, f.Type)
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		child := schemaOf(f.Type)
+		n.children[name] = child
+		if child.kind != kindList || child.elem.kind != kindStruct {
+			continue
+		}
+		for extra, kind := range extraFields[name] {
+			child.elem.children[extra] = &node{kind: kind}
+		}
+		if lk, ok := ListKeys[name]; ok {
+			n.children[lk.Alias] = child
+		}
+	}
+}
+
+// checkPath 检查规范化后的字段路径是否存在于采集数据中，并且指向数值
+func checkPath(path string) error {
+	n := schema
+	walked := ""
+	for rest := path; rest != ""; {
+		if rest[0] == '[' {
+			end := indexEnd(rest)
+			if n.kind == kindAny {
+				return nil
+			}
+			if n.kind != kindList {
+				return fmt.Errorf("字段 %s 不是列表，不能使用索引", walked)
+			}
+			n, walked, rest = n.elem, walked+rest[:end], rest[end:]
+			continue
+		}
+
+		rest = strings.TrimPrefix(rest, ".")
+		end := strings.IndexAny(rest, ".[")
+		if end < 0 {
+			end = len(rest)
+		}
+		name := rest[:end]
+		full := name
+		if walked != "" {
+			full = walked + "." + name
+		}
+
+		switch n.kind {
+		case kindAny:
+			return nil
+		case kindStruct:
+			child, ok := n.children[name]
+			if !ok {
+				return fmt.Errorf("未知的字段 %s，可用的字段: %s", full, strings.Join(n.names(), ", "))
+			}
+			n = child
+		case kindMap:
+			n = n.elem
+		case kindList:
+			return fmt.Errorf("字段 %s 是列表，需要使用索引，例如 %s[*]", walked, walked)
+		default:
+			return fmt.Errorf("字段 %s 没有下级字段 %s", walked, name)
+		}
+		walked, rest = full, rest[end:]
+	}
+
+	switch n.kind {
+	case kindValue, kindAny:
+		return nil
+	case kindText:
+		return fmt.Errorf("字段 %s 不是数值，不能在规则中比较", path)
+	case kindList:
+		return fmt.Errorf("字段 %s 是列表，需要使用索引和下级字段，例如 %s[*]", path, path)
+	default:
+		return fmt.Errorf("字段 %s 不是数值，需要指定下级字段: %s", path, strings.Join(n.names(), ", "))
+	}
+}
+
+// names 返回对象的字段名称，用于错误提示
+func (n *node) names() []string {
+	names := make([]string, 0, len(n.children))
+	for name := range n.children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// indexEnd 返回以 [ 开头的索引（["key"] 或 [*]）的结束位置，键名中可以包含转义的引号和 ]
+func indexEnd(s string) int {
+	for i, quoted := 1, false; i < len(s); i++ {
+		switch {
+		case quoted && s[i] == '\\':
+			i++
+		case s[i] == '"':
+			quoted = !quoted
+		case !quoted && s[i] == ']':
+			return i + 1
+		}
+	}
+	return len(s)
+}
@@ -0,0 +1,553 @@
+package service
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/xugou/agent/pkg/utils"
+)
+
+// 支持的初始化系统
+const (
+	InitAuto    = "auto"
+	InitSystemd = "systemd"
+	InitOpenRC  = "openrc"
+	InitSysV    = "sysv"
+)
+
+// Options 服务安装参数
+type Options struct {
+	Name       string // 服务名称
+	Root       string // 安装根目录，默认为 /，指定其他目录时只写文件不执行系统命令（用于打包或测试）
+	InitSystem string // 初始化系统: auto、systemd、openrc 或 sysv
+	BinaryPath string // Agent 二进制文件安装路径
+	ConfigPath string // 配置文件路径
+	StateDir   string // 状态目录，需要对运行服务的用户可写
+	User       string // 运行服务的用户，为空表示以 root 运行
+	Config     []byte // 写入配置文件的内容
+	Overwrite  bool   // 配置文件已存在时覆盖，默认保留已有的配置文件
+	NoStart    bool   // 安装后不启动服务
+	AutoUpdate bool   // 安装每天运行一次的自动更新任务
+	Purge      bool   // 卸载时同时删除配置文件和二进制文件
+	Runner     Runner // 执行系统命令，为空时使用 ExecRunner
+	Out        io.Writer
+}
+
+// Runner 执行系统命令的接口，便于在测试中替换
+type Runner interface {
+	Run(name string, args ...string) (string, error)
+}
+
+// ExecRunner 直接执行系统命令
+type ExecRunner struct{}
+
+// Run 执行命令并返回合并后的输出
+func (ExecRunner) Run(name string, args ...string) (string, error) {
+	out, err := exec.Command(name, args...).CombinedOutput()
+	return strings.TrimSpace(string(out)), err
+}
+
+// dryRunner 安装到非根目录时使用，只打印将要执行的命令
+type dryRunner struct {
+	out io.Writer
+}
+
+func (r dryRunner) Run(name string, args ...string) (string, error) {
+	fmt.Fprintf(r.out, "跳过命令: %s %s\n", name, strings.Join(args, " "))
+	return "", nil
+}
+
+// DefaultOptions 返回默认的服务安装参数
+func DefaultOptions() Options {
+	return Options{
+		Name:       "xugou-agent",
+		Root:       "/",
+		InitSystem: InitAuto,
+		BinaryPath: "/usr/local/bin/xugou-agent",
+		ConfigPath: "/etc/xugou-agent/config.yaml",
+		StateDir:   "/var/lib/xugou-agent",
+		User:       "xugou",
+		Out:        io.Discard,
+	}
+}
+
+// prepare 补全参数并检测初始化系统
+func (o *Options) prepare() error {
+	if o.Root == "" {
+		o.Root = "/"
+	}
+	if o.Out == nil {
+		o.Out = io.Discard
+	}
+	if o.Runner == nil {
+		if o.isRealRoot() {
+			o.Runner = ExecRunner{}
+		} else {
+			o.Runner = dryRunner{out: o.Out}
+		}
+	}
+	if o.InitSystem == "" || o.InitSystem == InitAuto {
+		o.InitSystem = DetectInitSystem(o.Root)
+		if o.InitSystem == "" {
+			return errors.New("无法识别初始化系统，请使用 --init 指定 systemd、openrc 或 sysv")
+		}
+	}
+	switch o.InitSystem {
+	case InitSystemd, InitOpenRC, InitSysV:
+	default:
+		return fmt.Errorf("不支持的初始化系统 %q", o.InitSystem)
+	}
+	return nil
+}
+
+func (o *Options) isRealRoot() bool {
+	return filepath.Clean(o.Root) == "/"
+}
+
+// path 把绝对路径转换为安装根目录下的路径
+func (o *Options) path(p string) string {
+	return filepath.Join(o.Root, p)
+}
+
+// ConfigExists 判断服务使用的配置文件是否已存在
+func (o *Options) ConfigExists() bool {
+	_, err := os.Stat(o.path(o.ConfigPath))
+	return err == nil
+}
+
+// ServiceFile 返回服务文件的路径（不含安装根目录）
+func (o *Options) ServiceFile() string {
+	if o.InitSystem == InitSystemd {
+		return "/etc/systemd/system/" + o.Name + ".service"
+	}
+	return "/etc/init.d/" + o.Name
+}
+
+// DetectInitSystem 检测安装根目录下使用的初始化系统
+func DetectInitSystem(root string) string {
+	exists := func(p string) bool {
+		_, err := os.Stat(filepath.Join(root, p))
+		return err == nil
+	}
+	switch {
+	case exists("/run/systemd/system"):
+		return InitSystemd
+	case exists("/sbin/openrc-run"), exists("/sbin/openrc"):
+		return InitOpenRC
+	case exists("/etc/init.d"):
+		return InitSysV
+	}
+	return ""
+}
+
+// Install 创建用户、安装二进制文件和配置文件、写入服务文件并启用服务
+func Install(opts Options) error {
+	if err := opts.prepare(); err != nil {
+		return err
+	}
+	fmt.Fprintf(opts.Out, "初始化系统: %s\n", opts.InitSystem)
+
+	if opts.User != "" {
+		if err := ensureUser(&opts); err != nil {
+			return err
+		}
+	}
+
+	if err := installBinary(&opts); err != nil {
+		return err
+	}
+
+	if err := writeConfig(&opts); err != nil {
+		return err
+	}
+
+	if err := ensureStateDir(&opts); err != nil {
+		return err
+	}
+
+	content, err := Render(opts)
+	if err != nil {
+		return fmt.Errorf("生成服务文件失败: %w", err)
+	}
+	mode := os.FileMode(0644)
+	if opts.InitSystem != InitSystemd {
+		mode = 0755
+	}
+	serviceFile := opts.path(opts.ServiceFile())
+	if err := utils.WriteFileAtomic(serviceFile, content, mode); err != nil {
+		return fmt.Errorf("写入服务文件失败: %w", err)
+	}
+	fmt.Fprintf(opts.Out, "已写入服务文件: %s\n", serviceFile)
+
+	if err := enable(&opts); err != nil {
+		return err
+	}
+
+	if opts.AutoUpdate {
+		if err := installAutoUpdate(&opts); err != nil {
+			return err
+		}
+	}
+
+	if opts.NoStart {
+		return nil
+	}
+	return Control(opts, "restart")
+}
+
+// Uninstall 停止并移除服务，Purge 为 true 时同时删除配置文件和二进制文件
+func Uninstall(opts Options) error {
+	if err := opts.prepare(); err != nil {
+		return err
+	}
+
+	// 服务可能没有运行，停止失败不影响卸载
+	if err := Control(opts, "stop"); err != nil {
+		fmt.Fprintf(opts.Out, "警告: %v\n", err)
+	}
+	if err := disable(&opts); err != nil {
+		fmt.Fprintf(opts.Out, "警告: %v\n", err)
+	}
+
+	serviceFile := opts.path(opts.ServiceFile())
+	if err := os.Remove(serviceFile); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("删除服务文件失败: %w", err)
+	}
+	fmt.Fprintf(opts.Out, "已删除服务文件: %s\n", serviceFile)
+
+	if err := removeAutoUpdate(&opts); err != nil {
+		return err
+	}
+
+	if opts.InitSystem == InitSystemd {
+		if _, err := opts.Runner.Run("systemctl", "daemon-reload"); err != nil {
+			return fmt.Errorf("重新加载 systemd 配置失败: %w", err)
+		}
+	}
+
+	if opts.Purge {
+		for _, p := range []string{opts.ConfigPath, opts.BinaryPath} {
+			if err := os.Remove(opts.path(p)); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("删除 %s 失败: %w", p, err)
+			}
+			fmt.Fprintf(opts.Out, "已删除: %s\n", opts.path(p))
+		}
+	}
+	return nil
+}
+
+// Control 启动、停止或重启服务
+func Control(opts Options, action string) error {
+	if err := opts.prepare(); err != nil {
+		return err
+	}
+
+	var out string
+	var err error
+	switch opts.InitSystem {
+	case InitSystemd:
+		out, err = opts.Runner.Run("systemctl", action, opts.Name)
+	case InitOpenRC:
+		out, err = opts.Runner.Run("rc-service", opts.Name, action)
+	case InitSysV:
+		out, err = opts.Runner.Run(opts.ServiceFile(), action)
+	}
+	if err != nil {
+		return fmt.Errorf("%s 服务失败: %v %s", action, err, out)
+	}
+	fmt.Fprintf(opts.Out, "服务 %s 已执行 %s\n", opts.Name, action)
+	return nil
+}
+
+// StatusInfo 服务状态
+type StatusInfo struct {
+	InitSystem  string
+	ServiceFile string
+	Installed   bool
+	Enabled     bool
+	Active      bool
+	Detail      string
+}
+
+// Status 查询服务的安装、启用和运行状态
+func Status(opts Options) (*StatusInfo, error) {
+	if err := opts.prepare(); err != nil {
+		return nil, err
+	}
+
+	status := &StatusInfo{
+		InitSystem:  opts.InitSystem,
+		ServiceFile: opts.path(opts.ServiceFile()),
+	}
+	if _, err := os.Stat(status.ServiceFile); err == nil {
+		status.Installed = true
+	}
+
+	// 安装到非根目录时不能查询服务管理器，只根据启用服务时创建的链接判断，运行状态视为未运行
+	if _, dry := opts.Runner.(dryRunner); dry {
+		status.Enabled = enabledLinkExists(&opts)
+		status.Detail = fmt.Sprintf("安装根目录为 %s，无法查询服务的运行状态", opts.Root)
+		return status, nil
+	}
+
+	switch opts.InitSystem {
+	case InitSystemd:
+		_, err := opts.Runner.Run("systemctl", "is-enabled", "--quiet", opts.Name)
+		status.Enabled = err == nil
+		_, err = opts.Runner.Run("systemctl", "is-active", "--quiet", opts.Name)
+		status.Active = err == nil
+		status.Detail, _ = opts.Runner.Run("systemctl", "status", "--no-pager", "--lines=5", opts.Name)
+	case InitOpenRC:
+		out, _ := opts.Runner.Run("rc-update", "show", "default")
+		status.Enabled = strings.Contains(out, opts.Name)
+		out, err := opts.Runner.Run("rc-service", opts.Name, "status")
+		status.Active = err == nil
+		status.Detail = out
+	case InitSysV:
+		status.Enabled = enabledLinkExists(&opts)
+		out, err := opts.Runner.Run(opts.ServiceFile(), "status")
+		status.Active = err == nil
+		status.Detail = out
+	}
+	return status, nil
+}
+
+// enabledLinkExists 检查安装根目录下是否有启用服务时创建的链接
+func enabledLinkExists(opts *Options) bool {
+	var pattern string
+	switch opts.InitSystem {
+	case InitSystemd:
+		pattern = "/etc/systemd/system/*.wants/" + opts.Name + ".service"
+	case InitOpenRC:
+		pattern = "/etc/runlevels/*/" + opts.Name
+	default:
+		pattern = "/etc/rc2.d/S*" + opts.Name
+	}
+	matches, _ := filepath.Glob(opts.path(pattern))
+	return len(matches) > 0
+}
+
+func enable(opts *Options) error {
+	var err error
+	var out string
+	switch opts.InitSystem {
+	case InitSystemd:
+		if out, err = opts.Runner.Run("systemctl", "daemon-reload"); err == nil {
+			out, err = opts.Runner.Run("systemctl", "enable", opts.Name)
+		}
+	case InitOpenRC:
+		out, err = opts.Runner.Run("rc-update", "add", opts.Name, "default")
+	case InitSysV:
+		if _, lookErr := exec.LookPath("update-rc.d"); lookErr == nil || !opts.isRealRoot() {
+			out, err = opts.Runner.Run("update-rc.d", opts.Name, "defaults")
+		} else {
+			out, err = opts.Runner.Run("chkconfig", "--add", opts.Name)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("启用服务失败: %v %s", err, out)
+	}
+	return nil
+}
+
+func disable(opts *Options) error {
+	var err error
+	var out string
+	switch opts.InitSystem {
+	case InitSystemd:
+		out, err = opts.Runner.Run("systemctl", "disable", opts.Name)
+	case InitOpenRC:
+		out, err = opts.Runner.Run("rc-update", "del", opts.Name, "default")
+	case InitSysV:
+		if _, lookErr := exec.LookPath("update-rc.d"); lookErr == nil || !opts.isRealRoot() {
+			out, err = opts.Runner.Run("update-rc.d", "-f", opts.Name, "remove")
+		} else {
+			out, err = opts.Runner.Run("chkconfig", "--del", opts.Name)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("禁用服务失败: %v %s", err, out)
+	}
+	return nil
+}
+
+// ensureUser 创建运行服务的系统用户，用户已存在时跳过
+func ensureUser(opts *Options) error {
+	if _, _, ok := lookupUser(opts, opts.User); ok {
+		return nil
+	}
+
+	var out string
+	var err error
+	if _, lookErr := exec.LookPath("useradd"); lookErr == nil || !opts.isRealRoot() {
+		out, err = opts.Runner.Run("useradd", "--system", "--no-create-home", "--shell", "/usr/sbin/nologin", opts.User)
+	} else {
+		// Alpine 等发行版只有 busybox 的 adduser
+		out, err = opts.Runner.Run("adduser", "-S", "-D", "-H", "-s", "/sbin/nologin", opts.User)
+	}
+	if err != nil {
+		return fmt.Errorf("创建用户 %s 失败: %v %s", opts.User, err, out)
+	}
+	fmt.Fprintf(opts.Out, "已创建用户: %s\n", opts.User)
+	return nil
+}
+
+// lookupUser 查找用户的 uid 和 gid，安装到非根目录时读取该目录下的 /etc/passwd
+func lookupUser(opts *Options, name string) (int, int, bool) {
+	if opts.isRealRoot() {
+		u, err := user.Lookup(name)
+		if err != nil {
+			return 0, 0, false
+		}
+		uid, _ := strconv.Atoi(u.Uid)
+		gid, _ := strconv.Atoi(u.Gid)
+		return uid, gid, true
+	}
+
+	f, err := os.Open(opts.path("/etc/passwd"))
+	if err != nil {
+		return 0, 0, false
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), ":")
+		if len(fields) < 4 || fields[0] != name {
+			continue
+		}
+		uid, _ := strconv.Atoi(fields[2])
+		gid, _ := strconv.Atoi(fields[3])
+		return uid, gid, true
+	}
+	return 0, 0, false
+}
+
+// installBinary 把当前运行的二进制文件复制到安装路径
+func installBinary(opts *Options) error {
+	self, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("获取当前程序路径失败: %w", err)
+	}
+	self, _ = filepath.EvalSymlinks(self)
+
+	target := opts.path(opts.BinaryPath)
+	if resolved, err := filepath.EvalSymlinks(target); err == nil && resolved == self {
+		return nil
+	}
+
+	data, err := os.ReadFile(self)
+	if err != nil {
+		return fmt.Errorf("读取当前程序失败: %w", err)
+	}
+	if err := utils.WriteFileAtomic(target, data, 0755); err != nil {
+		return fmt.Errorf("安装二进制文件失败: %w", err)
+	}
+	fmt.Fprintf(opts.Out, "已安装二进制文件: %s\n", target)
+	return nil
+}
+
+// writeConfig 写入配置文件，权限为 0600 并归属运行服务的用户
+func writeConfig(opts *Options) error {
+	if len(opts.Config) == 0 {
+		return nil
+	}
+
+	target := opts.path(opts.ConfigPath)
+	// 重新安装或更新时保留已有的配置，其中的规则、探测和通知渠道等无法从命令行参数还原
+	if _, err := os.Stat(target); err == nil && !opts.Overwrite {
+		fmt.Fprintf(opts.Out, "配置文件 %s 已存在，保留原有配置\n", target)
+		return nil
+	}
+	if err := utils.WriteFileAtomic(target, opts.Config, 0600); err != nil {
+		return fmt.Errorf("写入配置文件失败: %w", err)
+	}
+
+	if opts.User != "" && opts.isRealRoot() {
+		uid, gid, ok := lookupUser(opts, opts.User)
+		if !ok {
+			return fmt.Errorf("找不到用户 %s", opts.User)
+		}
+		if err := os.Chown(target, uid, gid); err != nil {
+			return fmt.Errorf("修改配置文件所有者失败: %w", err)
+		}
+	}
+	fmt.Fprintf(opts.Out, "已写入配置文件: %s\n", target)
+	return nil
+}
+
+// ensureStateDir 创建状态目录并归属运行服务的用户
+func ensureStateDir(opts *Options) error {
+	if opts.StateDir == "" {
+		return nil
+	}
+	dir := opts.path(opts.StateDir)
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return fmt.Errorf("创建状态目录失败: %w", err)
+	}
+	if opts.User != "" && opts.isRealRoot() {
+		uid, gid, ok := lookupUser(opts, opts.User)
+		if !ok {
+			return fmt.Errorf("找不到用户 %s", opts.User)
+		}
+		if err := os.Chown(dir, uid, gid); err != nil {
+			return fmt.Errorf("修改状态目录所有者失败: %w", err)
+		}
+	}
+	return nil
+}
+
+// installAutoUpdate 安装每天以 root 身份运行一次的自动更新任务
+func installAutoUpdate(opts *Options) error {
+	files, err := RenderUpdateFiles(*opts)
+	if err != nil {
+		return fmt.Errorf("生成自动更新任务失败: %w", err)
+	}
+	for path, content := range files {
+		mode := os.FileMode(0644)
+		if strings.HasPrefix(path, "/etc/cron.") {
+			mode = 0755
+		}
+		if err := utils.WriteFileAtomic(opts.path(path), content, mode); err != nil {
+			return fmt.Errorf("写入自动更新任务失败: %w", err)
+		}
+		fmt.Fprintf(opts.Out, "已写入自动更新任务: %s\n", opts.path(path))
+	}
+
+	if opts.InitSystem == InitSystemd {
+		if out, err := opts.Runner.Run("systemctl", "daemon-reload"); err != nil {
+			return fmt.Errorf("重新加载 systemd 配置失败: %v %s", err, out)
+		}
+		if out, err := opts.Runner.Run("systemctl", "enable", "--now", opts.Name+"-update.timer"); err != nil {
+			return fmt.Errorf("启用自动更新定时器失败: %v %s", err, out)
+		}
+	}
+	return nil
+}
+
+// removeAutoUpdate 删除自动更新任务，任务不存在时忽略
+func removeAutoUpdate(opts *Options) error {
+	files, err := RenderUpdateFiles(*opts)
+	if err != nil {
+		return err
+	}
+	if opts.InitSystem == InitSystemd {
+		if _, err := os.Stat(opts.path("/etc/systemd/system/" + opts.Name + "-update.timer")); err == nil {
+			opts.Runner.Run("systemctl", "disable", "--now", opts.Name+"-update.timer")
+		}
+	}
+	for path := range files {
+		if err := os.Remove(opts.path(path)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("删除自动更新任务失败: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,301 @@
+package service
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// recordRunner 记录执行的命令，failing 中的命令返回错误
+type recordRunner struct {
+	commands []string
+	failing  map[string]bool
+}
+
+func (r *recordRunner) Run(name string, args ...string) (string, error) {
+	cmd := strings.Join(append([]string{name}, args...), " ")
+	r.commands = append(r.commands, cmd)
+	if r.failing[cmd] {
+		return "failed", errors.New("exit status 3")
+	}
+	return "", nil
+}
+
+// newRoot 创建带有初始化系统标记目录的安装根目录
+func newRoot(t *testing.T, marker string) string {
+	t.Helper()
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, marker), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+func testOptions(root string, runner Runner) Options {
+	opts := DefaultOptions()
+	opts.Root = root
+	opts.User = ""
+	opts.Config = []byte("server: https://example.com\n")
+	opts.Runner = runner
+	return opts
+}
+
+func TestDetectInitSystem(t *testing.T) {
+	tests := []struct {
+		marker string
+		want   string
+	}{
+		{"/run/systemd/system", InitSystemd},
+		{"/sbin/openrc-run", InitOpenRC},
+		{"/etc/init.d", InitSysV},
+		{"/tmp", ""},
+	}
+	for _, tt := range tests {
+		if got := DetectInitSystem(newRoot(t, tt.marker)); got != tt.want {
+			t.Errorf("DetectInitSystem(%s) = %q, want %q", tt.marker, got, tt.want)
+		}
+	}
+}
+
+func TestInstallSystemd(t *testing.T) {
+	root := newRoot(t, "/run/systemd/system")
+	runner := &recordRunner{}
+	opts := testOptions(root, runner)
+	opts.AutoUpdate = true
+	if err := Install(opts); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(filepath.Join(root, "/etc/systemd/system/xugou-agent.service"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0644 {
+		t.Errorf("service file mode = %v, want 0644", info.Mode().Perm())
+	}
+	info, err = os.Stat(filepath.Join(root, opts.ConfigPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("config file mode = %v, want 0600", info.Mode().Perm())
+	}
+	for _, p := range []string{opts.BinaryPath, opts.StateDir, "/etc/systemd/system/xugou-agent-update.timer"} {
+		if _, err := os.Stat(filepath.Join(root, p)); err != nil {
+			t.Errorf("%s not created: %v", p, err)
+		}
+	}
+
+	want := []string{
+		"systemctl daemon-reload",
+		"systemctl enable xugou-agent",
+		"systemctl daemon-reload",
+		"systemctl enable --now xugou-agent-update.timer",
+		"systemctl restart xugou-agent",
+	}
+	if strings.Join(runner.commands, "\n") != strings.Join(want, "\n") {
+		t.Errorf("commands = %q, want %q", runner.commands, want)
+	}
+}
+
+func TestInstallNoStart(t *testing.T) {
+	root := newRoot(t, "/sbin/openrc-run")
+	runner := &recordRunner{}
+	opts := testOptions(root, runner)
+	opts.NoStart = true
+	if err := Install(opts); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(filepath.Join(root, "/etc/init.d/xugou-agent"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0755 {
+		t.Errorf("init script mode = %v, want 0755", info.Mode().Perm())
+	}
+	want := []string{"rc-update add xugou-agent default"}
+	if strings.Join(runner.commands, "\n") != strings.Join(want, "\n") {
+		t.Errorf("commands = %q, want %q", runner.commands, want)
+	}
+}
+
+func TestInstallExistingUser(t *testing.T) {
+	root := newRoot(t, "/etc/init.d")
+	passwd := "root:x:0:0:root:/root:/bin/sh\nxugou:x:990:990::/nonexistent:/usr/sbin/nologin\n"
+	if err := os.WriteFile(filepath.Join(root, "/etc/passwd"), []byte(passwd), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	runner := &recordRunner{}
+	opts := testOptions(root, runner)
+	opts.User = "xugou"
+	opts.NoStart = true
+	if err := Install(opts); err != nil {
+		t.Fatal(err)
+	}
+	for _, cmd := range runner.commands {
+		if strings.HasPrefix(cmd, "useradd") || strings.HasPrefix(cmd, "adduser") {
+			t.Errorf("user already exists but %q was run", cmd)
+		}
+	}
+
+	runner = &recordRunner{}
+	opts = testOptions(root, runner)
+	opts.User = "other"
+	opts.NoStart = true
+	if err := Install(opts); err != nil {
+		t.Fatal(err)
+	}
+	if len(runner.commands) == 0 || !strings.HasPrefix(runner.commands[0], "useradd --system") {
+		t.Errorf("commands = %q, want useradd first", runner.commands)
+	}
+}
+
+func TestInstallKeepsExistingConfig(t *testing.T) {
+	root := newRoot(t, "/run/systemd/system")
+	opts := testOptions(root, &recordRunner{})
+	existing := "server: https://example.com\nrules:\n  - name: disk\n    expr: disk.used_percent > 90\n"
+	path := filepath.Join(root, opts.ConfigPath)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(existing), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if !opts.ConfigExists() {
+		t.Fatal("ConfigExists() = false")
+	}
+
+	// 重新安装时保留已有的配置
+	opts.Config = []byte("server: https://other.example.com\n")
+	if err := Install(opts); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != existing {
+		t.Errorf("config = %q, want the existing config kept", data)
+	}
+
+	opts.Overwrite = true
+	if err := Install(opts); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != string(opts.Config) {
+		t.Errorf("config = %q, want it overwritten", data)
+	}
+}
+
+func TestInstallEnableFailure(t *testing.T) {
+	root := newRoot(t, "/run/systemd/system")
+	runner := &recordRunner{failing: map[string]bool{"systemctl enable xugou-agent": true}}
+	if err := Install(testOptions(root, runner)); err == nil {
+		t.Fatal("Install succeeded although enabling the service failed")
+	}
+	for _, cmd := range runner.commands {
+		if cmd == "systemctl restart xugou-agent" {
+			t.Error("service was started although enabling it failed")
+		}
+	}
+}
+
+func TestUninstall(t *testing.T) {
+	for _, purge := range []bool{false, true} {
+		root := newRoot(t, "/run/systemd/system")
+		opts := testOptions(root, &recordRunner{})
+		opts.AutoUpdate = true
+		opts.NoStart = true
+		if err := Install(opts); err != nil {
+			t.Fatal(err)
+		}
+
+		// 服务没有运行时停止失败，卸载仍然继续
+		runner := &recordRunner{failing: map[string]bool{"systemctl stop xugou-agent": true}}
+		opts = testOptions(root, runner)
+		opts.Purge = purge
+		if err := Uninstall(opts); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, p := range []string{"/etc/systemd/system/xugou-agent.service", "/etc/systemd/system/xugou-agent-update.timer", "/etc/systemd/system/xugou-agent-update.service"} {
+			if _, err := os.Stat(filepath.Join(root, p)); !os.IsNotExist(err) {
+				t.Errorf("purge=%v: %s was not removed", purge, p)
+			}
+		}
+		for _, p := range []string{opts.ConfigPath, opts.BinaryPath} {
+			_, err := os.Stat(filepath.Join(root, p))
+			if removed := os.IsNotExist(err); removed != purge {
+				t.Errorf("purge=%v: %s removed = %v", purge, p, removed)
+			}
+		}
+		want := []string{
+			"systemctl stop xugou-agent",
+			"systemctl disable xugou-agent",
+			"systemctl disable --now xugou-agent-update.timer",
+			"systemctl daemon-reload",
+		}
+		if strings.Join(runner.commands, "\n") != strings.Join(want, "\n") {
+			t.Errorf("purge=%v: commands = %q, want %q", purge, runner.commands, want)
+		}
+	}
+}
+
+func TestStatus(t *testing.T) {
+	root := newRoot(t, "/run/systemd/system")
+	if err := Install(testOptions(root, &recordRunner{})); err != nil {
+		t.Fatal(err)
+	}
+
+	status, err := Status(testOptions(root, &recordRunner{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !status.Installed || !status.Enabled || !status.Active {
+		t.Errorf("status = %+v, want installed, enabled and active", status)
+	}
+
+	runner := &recordRunner{failing: map[string]bool{"systemctl is-active --quiet xugou-agent": true}}
+	status, err = Status(testOptions(root, runner))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !status.Enabled || status.Active {
+		t.Errorf("status = %+v, want enabled and not active", status)
+	}
+}
+
+func TestStatusDryRun(t *testing.T) {
+	root := newRoot(t, "/run/systemd/system")
+	opts := testOptions(root, nil)
+	if err := Install(opts); err != nil {
+		t.Fatal(err)
+	}
+
+	status, err := Status(testOptions(root, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !status.Installed {
+		t.Error("service file was written but status is not installed")
+	}
+	// 空运行时没有真正启用和启动服务
+	if status.Enabled || status.Active {
+		t.Errorf("status = %+v, dry run must not report enabled or active", status)
+	}
+
+	link := filepath.Join(root, "/etc/systemd/system/multi-user.target.wants/xugou-agent.service")
+	if err := os.MkdirAll(filepath.Dir(link), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("../xugou-agent.service", link); err != nil {
+		t.Fatal(err)
+	}
+	status, err = Status(testOptions(root, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !status.Enabled || status.Active {
+		t.Errorf("status = %+v, want enabled from the wants link and not active", status)
+	}
+}
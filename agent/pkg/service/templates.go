@@ -0,0 +1,177 @@
+package service
+
+import (
+	"bytes"
+	"text/template"
+)
+
+var systemdTemplate = template.Must(template.New("systemd").Parse(`[Unit]
+Description=Xugou Agent - 系统监控客户端
+Documentation=https://github.com/zaunist/xugou
+After=network-online.target
+Wants=network-online.target
+
+[Service]
+Type=simple
+{{- if .User}}
+User={{.User}}
+Group={{.User}}
+{{- end}}
+ExecStart={{.BinaryPath}} start --config {{.ConfigPath}}
+Restart=on-failure
+RestartSec=10
+StandardOutput=journal
+StandardError=journal
+NoNewPrivileges=true
+ProtectSystem=full
+ProtectHome=read-only
+PrivateTmp=true
+
+[Install]
+WantedBy=multi-user.target
+`))
+
+var openrcTemplate = template.Must(template.New("openrc").Parse(`#!/sbin/openrc-run
+
+name="{{.Name}}"
+description="Xugou Agent - 系统监控客户端"
+command="{{.BinaryPath}}"
+command_args="start --config {{.ConfigPath}}"
+command_background=true
+{{- if .User}}
+command_user="{{.User}}:{{.User}}"
+{{- end}}
+pidfile="/run/${RC_SVCNAME}.pid"
+output_log="/var/log/{{.Name}}.log"
+error_log="/var/log/{{.Name}}.log"
+
+depend() {
+	need net
+	after firewall
+}
+`))
+
+var sysvTemplate = template.Must(template.New("sysv").Parse(`#!/bin/sh
+### BEGIN INIT INFO
+# Provides:          {{.Name}}
+# Required-Start:    $network $remote_fs
+# Required-Stop:     $network $remote_fs
+# Default-Start:     2 3 4 5
+# Default-Stop:      0 1 6
+# Short-Description: Xugou Agent - 系统监控客户端
+### END INIT INFO
+
+NAME="{{.Name}}"
+DAEMON="{{.BinaryPath}}"
+DAEMON_ARGS="start --config {{.ConfigPath}}"
+RUN_AS="{{if .User}}{{.User}}{{else}}root{{end}}"
+PIDFILE="/var/run/$NAME.pid"
+LOGFILE="/var/log/$NAME.log"
+
+is_running() {
+	[ -f "$PIDFILE" ] && kill -0 "$(cat "$PIDFILE")" 2>/dev/null
+}
+
+case "$1" in
+	start)
+		if is_running; then
+			echo "$NAME 已在运行"
+			exit 0
+		fi
+		echo "启动 $NAME"
+		touch "$LOGFILE" && chown "$RUN_AS" "$LOGFILE"
+		su -s /bin/sh -c "$DAEMON $DAEMON_ARGS >> $LOGFILE 2>&1 & echo \$!" "$RUN_AS" > "$PIDFILE"
+		;;
+	stop)
+		if is_running; then
+			echo "停止 $NAME"
+			kill "$(cat "$PIDFILE")"
+		fi
+		rm -f "$PIDFILE"
+		;;
+	restart)
+		$0 stop
+		sleep 1
+		$0 start
+		;;
+	status)
+		if is_running; then
+			echo "$NAME 正在运行 (pid $(cat "$PIDFILE"))"
+			exit 0
+		fi
+		echo "$NAME 未运行"
+		exit 3
+		;;
+	*)
+		echo "用法: $0 {start|stop|restart|status}"
+		exit 1
+		;;
+esac
+`))
+
+var updateServiceTemplate = template.Must(template.New("update-service").Parse(`[Unit]
+Description=Xugou Agent 自动更新
+After=network-online.target
+Wants=network-online.target
+
+[Service]
+Type=oneshot
+ExecStart={{.BinaryPath}} update --yes --config {{.ConfigPath}} --service-name {{.Name}}
+`))
+
+var updateTimerTemplate = template.Must(template.New("update-timer").Parse(`[Unit]
+Description=每天检查 Xugou Agent 更新
+
+[Timer]
+OnCalendar=daily
+RandomizedDelaySec=6h
+Persistent=true
+
+[Install]
+WantedBy=timers.target
+`))
+
+var updateCronTemplate = template.Must(template.New("update-cron").Parse(`#!/bin/sh
+# 每天检查 Xugou Agent 更新，由 xugou-agent service install --auto-update 生成
+exec {{.BinaryPath}} update --yes --config {{.ConfigPath}} --service-name {{.Name}} >> /var/log/{{.Name}}-update.log 2>&1
+`))
+
+// Render 渲染指定初始化系统的服务文件
+func Render(opts Options) ([]byte, error) {
+	tmpl := systemdTemplate
+	switch opts.InitSystem {
+	case InitOpenRC:
+		tmpl = openrcTemplate
+	case InitSysV:
+		tmpl = sysvTemplate
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, opts); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// RenderUpdateFiles 渲染自动更新使用的文件，返回文件路径（不含安装根目录）到内容的映射
+func RenderUpdateFiles(opts Options) (map[string][]byte, error) {
+	templates := map[string]*template.Template{
+		"/etc/cron.daily/" + opts.Name + "-update": updateCronTemplate,
+	}
+	if opts.InitSystem == InitSystemd {
+		templates = map[string]*template.Template{
+			"/etc/systemd/system/" + opts.Name + "-update.service": updateServiceTemplate,
+			"/etc/systemd/system/" + opts.Name + "-update.timer":   updateTimerTemplate,
+		}
+	}
+
+	files := make(map[string][]byte, len(templates))
+	for path, tmpl := range templates {
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, opts); err != nil {
+			return nil, err
+		}
+		files[path] = buf.Bytes()
+	}
+	return files, nil
+}
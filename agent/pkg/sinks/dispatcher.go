@@ -0,0 +1,77 @@
+package sinks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"github.com/xugou/agent/pkg/model"
+)
+
+// Dispatcher 把告警事件发送到所有通知渠道
+type Dispatcher struct {
+	sinks   []*Limited
+	OnError func(sink string, err error) // 发送失败时调用，可以为空
+}
+
+// NewDispatcher 根据配置创建所有通知渠道
+func NewDispatcher(configs []Config) (*Dispatcher, error) {
+	d := &Dispatcher{}
+	for _, c := range configs {
+		sink, err := New(c)
+		if err != nil {
+			return nil, fmt.Errorf("通知渠道 %s: %w", c.Name, err)
+		}
+		// 校验配置时不登记凭据，只有真正使用的通知渠道才需要从日志中移除
+		if strings.EqualFold(c.Type, "webhook") {
+			addWebhookSecrets(c)
+		}
+		d.sinks = append(d.sinks, sink)
+	}
+	return d, nil
+}
+
+// Len 返回通知渠道数量
+func (d *Dispatcher) Len() int {
+	return len(d.sinks)
+}
+
+// Notify 依次把告警事件发送到每个通知渠道，各渠道之间并行发送
+func (d *Dispatcher) Notify(ctx context.Context, hostname string, events []model.AlertEvent) {
+	if len(d.sinks) == 0 || len(events) == 0 {
+		return
+	}
+	for _, sink := range d.sinks {
+		go func(sink *Limited) {
+			for _, event := range events {
+				err := sink.Send(ctx, Alert{AlertEvent: event, Hostname: hostname})
+				switch {
+				case err == nil:
+					slog.Debug("告警通知已发送", "sink", sink.Name(), "rule", event.Rule, "state", event.State)
+				case errors.Is(err, ErrSuppressed):
+				default:
+					slog.Warn("发送告警通知失败", "sink", sink.Name(), "rule", event.Rule, "error", err)
+					if d.OnError != nil {
+						d.OnError(sink.Name(), err)
+					}
+				}
+			}
+		}(sink)
+	}
+}
+
+// formatMessage 把告警格式化为一行文本
+func formatMessage(alert Alert) string {
+	msg := fmt.Sprintf("[%s] %s %s", alert.Severity, alert.Rule, alert.State)
+	if alert.Instance != "" {
+		msg += " instance=" + alert.Instance
+	}
+	msg += " value=" + strconv.FormatFloat(alert.Value, 'f', -1, 64) + " expr=" + strconv.Quote(alert.Expr)
+	if alert.Description != "" {
+		msg += " " + alert.Description
+	}
+	return msg
+}
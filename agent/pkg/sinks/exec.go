@@ -0,0 +1,64 @@
+package sinks
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// execSink 运行本地命令，告警内容通过环境变量传递
+type execSink struct {
+	name    string
+	command string
+	args    []string
+}
+
+func newExec(c Config, opts Options) (Sink, error) {
+	if c.Command == "" {
+		return nil, fmt.Errorf("缺少 command")
+	}
+	return &execSink{name: opts.Name, command: c.Command, args: c.Args}, nil
+}
+
+func (e *execSink) Name() string {
+	return e.name
+}
+
+func (e *execSink) Send(ctx context.Context, alert Alert) error {
+	// 不经过 shell 执行，避免告警内容被当作命令解析
+	cmd := exec.CommandContext(ctx, e.command, e.args...)
+	cmd.Env = append(os.Environ(), alertEnv(alert)...)
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	if err := cmd.Run(); err != nil {
+		if out := strings.TrimSpace(output.String()); out != "" {
+			return fmt.Errorf("%w: %s", err, out)
+		}
+		return err
+	}
+	return nil
+}
+
+// alertEnv 把告警内容转换为 XUGOU_ALERT_* 环境变量
+func alertEnv(alert Alert) []string {
+	return []string{
+		"XUGOU_ALERT_RULE=" + alert.Rule,
+		"XUGOU_ALERT_INSTANCE=" + alert.Instance,
+		"XUGOU_ALERT_SEVERITY=" + alert.Severity,
+		"XUGOU_ALERT_STATE=" + alert.State,
+		"XUGOU_ALERT_EXPR=" + alert.Expr,
+		"XUGOU_ALERT_VALUE=" + strconv.FormatFloat(alert.Value, 'f', -1, 64),
+		"XUGOU_ALERT_THRESHOLD=" + strconv.FormatFloat(alert.Threshold, 'f', -1, 64),
+		"XUGOU_ALERT_DESCRIPTION=" + alert.Description,
+		"XUGOU_ALERT_SINCE=" + alert.Since.Format(time.RFC3339),
+		"XUGOU_ALERT_TIME=" + alert.Time.Format(time.RFC3339),
+		"XUGOU_ALERT_HOSTNAME=" + alert.Hostname,
+	}
+}
@@ -0,0 +1,81 @@
+//go:build !windows && !plan9
+
+package sinks
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExecSend(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "env")
+	sink, err := New(Config{Type: "exec", Command: "sh", Args: []string{"-c", `env | grep '^XUGOU_ALERT_' | sort > "$0"`, out}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	alert := testAlert()
+	alert.Expr = "disk.used_percent > 90"
+	alert.Description = "磁盘空间不足; rm -rf /"
+	alert.Since = time.Date(2026, 1, 2, 3, 0, 0, 0, time.UTC)
+	if err := sink.Send(context.Background(), alert); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 告警内容只通过环境变量传递，不经过 shell 解析
+	want := []string{
+		"XUGOU_ALERT_DESCRIPTION=磁盘空间不足; rm -rf /",
+		"XUGOU_ALERT_EXPR=disk.used_percent > 90",
+		`XUGOU_ALERT_HOSTNAME=web-"1"`,
+		"XUGOU_ALERT_INSTANCE=/",
+		"XUGOU_ALERT_RULE=disk_full",
+		"XUGOU_ALERT_SEVERITY=critical",
+		"XUGOU_ALERT_SINCE=2026-01-02T03:00:00Z",
+		"XUGOU_ALERT_STATE=firing",
+		"XUGOU_ALERT_THRESHOLD=90",
+		"XUGOU_ALERT_TIME=2026-01-02T03:04:05Z",
+		"XUGOU_ALERT_VALUE=95.5",
+	}
+	if got := strings.Split(strings.TrimSpace(string(data)), "\n"); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("environment =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestExecErrors(t *testing.T) {
+	// 命令失败时返回它的输出
+	sink, err := New(Config{Type: "exec", Command: "sh", Args: []string{"-c", "echo 'no route to pager' >&2; exit 1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Send(context.Background(), testAlert()); err == nil || !strings.Contains(err.Error(), "no route to pager") {
+		t.Errorf("Send() = %v", err)
+	}
+
+	sink, err = New(Config{Type: "exec", Command: filepath.Join(t.TempDir(), "missing")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Send(context.Background(), testAlert()); err == nil {
+		t.Error("Send() with a missing command succeeded")
+	}
+
+	// 超过 timeout 时结束命令
+	sink, err = New(Config{Type: "exec", Command: "sleep", Args: []string{"5"}, Timeout: "100ms"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if err := sink.Send(context.Background(), testAlert()); err == nil {
+		t.Error("Send() with a slow command succeeded")
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("slow command stopped after %s", elapsed)
+	}
+}
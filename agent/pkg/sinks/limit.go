@@ -0,0 +1,91 @@
+package sinks
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Limited 为通知渠道增加级别过滤、去重和限速
+type Limited struct {
+	sink Sink
+	opts Options
+
+	mu       sync.Mutex
+	tokens   float64
+	lastFill time.Time
+	sent     map[string]time.Time // 去重键到上次发送时间
+	now      func() time.Time
+}
+
+func newLimited(sink Sink, opts Options) *Limited {
+	return &Limited{
+		sink:   sink,
+		opts:   opts,
+		tokens: float64(opts.RateLimit),
+		sent:   make(map[string]time.Time),
+		now:    time.Now,
+	}
+}
+
+// Name 返回渠道名称
+func (l *Limited) Name() string {
+	return l.sink.Name()
+}
+
+// ErrSuppressed 通知因去重或级别过滤被跳过
+var ErrSuppressed = errors.New("通知已被过滤")
+
+// ErrRateLimited 通知超过发送频率限制被丢弃
+var ErrRateLimited = errors.New("超过发送频率限制")
+
+// Send 过滤、去重和限速后发送通知
+func (l *Limited) Send(ctx context.Context, alert Alert) error {
+	if err := l.admit(alert); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, l.opts.Timeout)
+	defer cancel()
+	return l.sink.Send(ctx, alert)
+}
+
+func (l *Limited) admit(alert Alert) error {
+	if l.opts.MinSeverity != "" && severityRank[alert.Severity] < severityRank[l.opts.MinSeverity] {
+		return ErrSuppressed
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+
+	key := alert.Rule + "\x00" + alert.Instance + "\x00" + alert.State
+	if l.opts.Dedup > 0 {
+		for k, t := range l.sent {
+			if now.Sub(t) >= l.opts.Dedup {
+				delete(l.sent, k)
+			}
+		}
+		if _, ok := l.sent[key]; ok {
+			return ErrSuppressed
+		}
+	}
+
+	// 令牌桶：每分钟补充 RateLimit 个令牌，最多累积 RateLimit 个
+	if !l.lastFill.IsZero() {
+		l.tokens += now.Sub(l.lastFill).Minutes() * float64(l.opts.RateLimit)
+		if l.tokens > float64(l.opts.RateLimit) {
+			l.tokens = float64(l.opts.RateLimit)
+		}
+	}
+	l.lastFill = now
+	if l.tokens < 1 {
+		return ErrRateLimited
+	}
+	l.tokens--
+
+	if l.opts.Dedup > 0 {
+		l.sent[key] = now
+	}
+	return nil
+}
@@ -0,0 +1,230 @@
+package sinks
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/xugou/agent/pkg/model"
+	"github.com/xugou/agent/pkg/rules"
+)
+
+// recordSink 记录收到的告警
+type recordSink struct {
+	alerts   []Alert
+	deadline bool
+}
+
+func (r *recordSink) Name() string {
+	return "record"
+}
+
+func (r *recordSink) Send(ctx context.Context, alert Alert) error {
+	_, r.deadline = ctx.Deadline()
+	r.alerts = append(r.alerts, alert)
+	return nil
+}
+
+// fakeClock 可以手动前进的时钟
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+	return c.t
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.t = c.t.Add(d)
+}
+
+func newTestLimited(opts Options) (*Limited, *recordSink, *fakeClock) {
+	if opts.Timeout == 0 {
+		opts.Timeout = DefaultTimeout
+	}
+	sink := &recordSink{}
+	clock := &fakeClock{t: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)}
+	l := newLimited(sink, opts)
+	l.now = clock.now
+	return l, sink, clock
+}
+
+func alertFor(rule, instance, state, severity string) Alert {
+	return Alert{AlertEvent: model.AlertEvent{Rule: rule, Instance: instance, State: state, Severity: severity}}
+}
+
+func TestLimitedRateLimit(t *testing.T) {
+	l, sink, clock := newTestLimited(Options{RateLimit: 2})
+	send := func(rule string) error {
+		return l.Send(context.Background(), alertFor(rule, "", model.AlertFiring, rules.SeverityCritical))
+	}
+
+	// 开始时桶是满的，可以连续发送 RateLimit 个
+	if err := send("a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := send("b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := send("c"); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("third Send() = %v, want ErrRateLimited", err)
+	}
+
+	// 每分钟补充 RateLimit 个令牌，半分钟补充一个
+	clock.advance(20 * time.Second)
+	if err := send("c"); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("Send() after 20s = %v, want ErrRateLimited", err)
+	}
+	clock.advance(10 * time.Second)
+	if err := send("c"); err != nil {
+		t.Errorf("Send() after 30s = %v", err)
+	}
+	if err := send("d"); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("Send() = %v, want ErrRateLimited", err)
+	}
+
+	// 长时间空闲后最多累积 RateLimit 个令牌
+	clock.advance(time.Hour)
+	for i, rule := range []string{"e", "f", "g"} {
+		err := send(rule)
+		if want := i < 2; (err == nil) != want {
+			t.Errorf("Send(%s) after an hour = %v", rule, err)
+		}
+	}
+
+	if len(sink.alerts) != 5 {
+		t.Errorf("sink received %d alerts, want 5", len(sink.alerts))
+	}
+	if !sink.deadline {
+		t.Error("Send() did not apply the timeout")
+	}
+}
+
+func TestLimitedDedup(t *testing.T) {
+	l, sink, clock := newTestLimited(Options{RateLimit: 100, Dedup: 10 * time.Minute})
+	firing := alertFor("disk_full", "/", model.AlertFiring, rules.SeverityCritical)
+	if err := l.Send(context.Background(), firing); err != nil {
+		t.Fatal(err)
+	}
+
+	// 窗口内同一告警的同一状态只通知一次，其他实例和状态不受影响
+	clock.advance(9 * time.Minute)
+	if err := l.Send(context.Background(), firing); !errors.Is(err, ErrSuppressed) {
+		t.Errorf("duplicate Send() = %v, want ErrSuppressed", err)
+	}
+	for _, alert := range []Alert{
+		alertFor("disk_full", "/home", model.AlertFiring, rules.SeverityCritical),
+		alertFor("disk_full", "/", model.AlertResolved, rules.SeverityCritical),
+		alertFor("cpu_high", "/", model.AlertFiring, rules.SeverityCritical),
+	} {
+		if err := l.Send(context.Background(), alert); err != nil {
+			t.Errorf("Send(%s %s %s) = %v", alert.Rule, alert.Instance, alert.State, err)
+		}
+	}
+
+	// 窗口从第一次发送开始计算，重复的通知不会延长窗口
+	clock.advance(time.Minute)
+	if err := l.Send(context.Background(), firing); err != nil {
+		t.Errorf("Send() after the dedup window = %v", err)
+	}
+	if len(sink.alerts) != 5 {
+		t.Errorf("sink received %d alerts, want 5", len(sink.alerts))
+	}
+
+	// 过期的记录被清理
+	clock.advance(time.Hour)
+	if err := l.Send(context.Background(), firing); err != nil {
+		t.Fatal(err)
+	}
+	if len(l.sent) != 1 {
+		t.Errorf("%d dedup entries kept, want 1", len(l.sent))
+	}
+}
+
+func TestLimitedDedupDisabled(t *testing.T) {
+	l, sink, _ := newTestLimited(Options{RateLimit: 100})
+	alert := alertFor("disk_full", "/", model.AlertFiring, rules.SeverityCritical)
+	for range 3 {
+		if err := l.Send(context.Background(), alert); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(sink.alerts) != 3 || len(l.sent) != 0 {
+		t.Errorf("sink received %d alerts, %d dedup entries", len(sink.alerts), len(l.sent))
+	}
+}
+
+func TestLimitedRateLimitedNotDeduped(t *testing.T) {
+	// 因限速被丢弃的通知没有发送，补充令牌后再次触发时不会被当作重复
+	l, sink, clock := newTestLimited(Options{RateLimit: 1, Dedup: 10 * time.Minute})
+	if err := l.Send(context.Background(), alertFor("a", "", model.AlertFiring, rules.SeverityCritical)); err != nil {
+		t.Fatal(err)
+	}
+	dropped := alertFor("b", "", model.AlertFiring, rules.SeverityCritical)
+	if err := l.Send(context.Background(), dropped); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("Send() = %v, want ErrRateLimited", err)
+	}
+	clock.advance(time.Minute)
+	if err := l.Send(context.Background(), dropped); err != nil {
+		t.Errorf("Send() after refill = %v", err)
+	}
+	if len(sink.alerts) != 2 {
+		t.Errorf("sink received %d alerts, want 2", len(sink.alerts))
+	}
+}
+
+func TestLimitedMinSeverity(t *testing.T) {
+	l, sink, _ := newTestLimited(Options{RateLimit: 1, MinSeverity: rules.SeverityWarning})
+
+	// 被过滤的通知不消耗令牌
+	for _, severity := range []string{rules.SeverityInfo, "", "debug"} {
+		if err := l.Send(context.Background(), alertFor("a", "", model.AlertFiring, severity)); !errors.Is(err, ErrSuppressed) {
+			t.Errorf("Send(%q) = %v, want ErrSuppressed", severity, err)
+		}
+	}
+	if err := l.Send(context.Background(), alertFor("a", "", model.AlertFiring, rules.SeverityWarning)); err != nil {
+		t.Errorf("Send(warning) = %v", err)
+	}
+
+	l, sink, _ = newTestLimited(Options{RateLimit: 10, MinSeverity: rules.SeverityCritical})
+	for _, severity := range []string{rules.SeverityInfo, rules.SeverityWarning, rules.SeverityCritical} {
+		err := l.Send(context.Background(), alertFor("a", severity, model.AlertFiring, severity))
+		if want := severity == rules.SeverityCritical; (err == nil) != want {
+			t.Errorf("Send(%s) with min_severity critical = %v", severity, err)
+		}
+	}
+	if len(sink.alerts) != 1 || sink.alerts[0].Severity != rules.SeverityCritical {
+		t.Errorf("sink received %+v", sink.alerts)
+	}
+}
+
+func TestConfigOptions(t *testing.T) {
+	l, err := New(Config{Type: "exec", Command: "true", MinSeverity: "Warning", Dedup: "0", Timeout: "3s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.Name() != "exec" || l.opts.MinSeverity != rules.SeverityWarning || l.opts.Dedup != 0 || l.opts.Timeout != 3*time.Second || l.opts.RateLimit != DefaultRateLimit {
+		t.Errorf("options = %+v", l.opts)
+	}
+
+	for _, c := range []Config{
+		{},
+		{Type: "email"},
+		{Type: "exec"},
+		{Type: "exec", Command: "true", MinSeverity: "fatal"},
+		{Type: "exec", Command: "true", Dedup: "-1m"},
+		{Type: "exec", Command: "true", Timeout: "0"},
+	} {
+		if _, err := New(c); err == nil {
+			t.Errorf("New(%+v) succeeded", c)
+		}
+	}
+
+	if _, err := ParseConfig([]interface{}{
+		map[string]interface{}{"type": "exec", "command": "true"},
+		map[string]interface{}{"type": "exec", "command": "false"},
+	}); err == nil {
+		t.Error("ParseConfig() accepted duplicate names")
+	}
+}
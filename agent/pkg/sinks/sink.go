@@ -0,0 +1,172 @@
+package sinks
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/xugou/agent/pkg/model"
+	"github.com/xugou/agent/pkg/rules"
+)
+
+// Alert 发送给通知渠道的告警
+type Alert struct {
+	model.AlertEvent
+	Hostname string `json:"hostname"`
+}
+
+// Sink 告警通知渠道
+type Sink interface {
+	Name() string
+	Send(ctx context.Context, alert Alert) error
+}
+
+// 默认值
+const (
+	DefaultRateLimit = 30               // 每分钟最多发送的通知数
+	DefaultDedup     = 10 * time.Minute // 同一告警的同一状态在这段时间内只通知一次
+	DefaultTimeout   = 10 * time.Second
+)
+
+// Config 配置文件中的一个通知渠道
+type Config struct {
+	Type        string `json:"type"`         // webhook、syslog 或 exec
+	Name        string `json:"name"`         // 渠道名称，默认为类型
+	MinSeverity string `json:"min_severity"` // 只通知不低于该级别的告警，默认全部通知
+	RateLimit   int    `json:"rate_limit"`   // 每分钟最多发送的通知数
+	Dedup       string `json:"dedup"`        // 去重时间窗口，设置为 0 表示不去重
+	Timeout     string `json:"timeout"`      // 单次发送的超时时间
+
+	// webhook
+	URL           string            `json:"url"`
+	Method        string            `json:"method"`
+	Headers       map[string]string `json:"headers"`
+	SecretHeaders []string          `json:"secret_headers"` // 值需要从日志中移除的其他请求头，Authorization 等凭据头不需要列出
+	Body          string            `json:"body"`           // 请求体模板（text/template），留空时发送告警的 JSON
+
+	// syslog
+	Network  string `json:"network"` // 留空时写入本机 syslog（/dev/log，journald 也会接收）
+	Address  string `json:"address"`
+	Tag      string `json:"tag"`
+	Facility string `json:"facility"`
+
+	// exec
+	Command string   `json:"command"`
+	Args    []string `json:"args"`
+}
+
+// Options 通知渠道的公共参数
+type Options struct {
+	Name        string
+	MinSeverity string
+	RateLimit   int
+	Dedup       time.Duration
+	Timeout     time.Duration
+}
+
+// ParseConfig 解析配置文件中的通知渠道列表，raw 为 viper 或 YAML 解析出的原始值
+func ParseConfig(raw interface{}) ([]Config, error) {
+	if raw == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return nil, fmt.Errorf("无法解析通知渠道: %w", err)
+	}
+	var configs []Config
+	if err := json.Unmarshal(data, &configs); err != nil {
+		return nil, fmt.Errorf("通知渠道必须是包含 type 等字段的列表: %w", err)
+	}
+
+	names := make(map[string]bool)
+	for i := range configs {
+		c := &configs[i]
+		if c.Name == "" {
+			c.Name = c.Type
+		}
+		if _, err := New(*c); err != nil {
+			return nil, fmt.Errorf("通知渠道 %s: %w", c.Name, err)
+		}
+		if names[c.Name] {
+			return nil, fmt.Errorf("通知渠道名称 %s 重复，请使用 name 区分", c.Name)
+		}
+		names[c.Name] = true
+	}
+	return configs, nil
+}
+
+// New 根据配置创建通知渠道
+func New(c Config) (*Limited, error) {
+	opts, err := c.options()
+	if err != nil {
+		return nil, err
+	}
+
+	var sink Sink
+	switch strings.ToLower(c.Type) {
+	case "webhook":
+		sink, err = newWebhook(c, opts)
+	case "syslog":
+		sink, err = newSyslog(c, opts)
+	case "exec":
+		sink, err = newExec(c, opts)
+	case "":
+		return nil, fmt.Errorf("缺少 type")
+	default:
+		return nil, fmt.Errorf("不支持的类型 %q，可选 webhook、syslog 或 exec", c.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newLimited(sink, opts), nil
+}
+
+func (c Config) options() (Options, error) {
+	opts := Options{
+		Name:        c.Name,
+		MinSeverity: strings.ToLower(c.MinSeverity),
+		RateLimit:   c.RateLimit,
+		Dedup:       DefaultDedup,
+		Timeout:     DefaultTimeout,
+	}
+	if opts.Name == "" {
+		opts.Name = c.Type
+	}
+	if opts.RateLimit <= 0 {
+		opts.RateLimit = DefaultRateLimit
+	}
+	if _, ok := severityRank[opts.MinSeverity]; !ok && opts.MinSeverity != "" {
+		return opts, fmt.Errorf("无效的 min_severity %q，可选 info、warning 或 critical", c.MinSeverity)
+	}
+	if c.Dedup != "" {
+		d, err := parseDuration(c.Dedup)
+		if err != nil {
+			return opts, fmt.Errorf("无效的 dedup: %w", err)
+		}
+		opts.Dedup = d
+	}
+	if c.Timeout != "" {
+		d, err := parseDuration(c.Timeout)
+		if err != nil || d <= 0 {
+			return opts, fmt.Errorf("无效的 timeout %q", c.Timeout)
+		}
+		opts.Timeout = d
+	}
+	return opts, nil
+}
+
+// parseDuration 与 time.ParseDuration 相同，但允许直接写 0
+func parseDuration(s string) (time.Duration, error) {
+	if s == "0" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("无效的时长 %q", s)
+	}
+	return d, nil
+}
+
+var severityRank = map[string]int{rules.SeverityInfo: 0, rules.SeverityWarning: 1, rules.SeverityCritical: 2}
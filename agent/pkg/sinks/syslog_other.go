@@ -0,0 +1,9 @@
+//go:build windows || plan9
+
+package sinks
+
+import "errors"
+
+func newSyslog(c Config, opts Options) (Sink, error) {
+	return nil, errors.New("当前系统不支持 syslog")
+}
@@ -0,0 +1,76 @@
+//go:build !windows && !plan9
+
+package sinks
+
+import (
+	"context"
+	"fmt"
+	"log/syslog"
+	"strings"
+
+	"github.com/xugou/agent/pkg/model"
+	"github.com/xugou/agent/pkg/rules"
+)
+
+// syslogSink 把告警写入 syslog，本机的 journald 也会通过 /dev/log 接收
+type syslogSink struct {
+	name     string
+	network  string
+	address  string
+	tag      string
+	facility syslog.Priority
+}
+
+var facilities = map[string]syslog.Priority{
+	"":       syslog.LOG_DAEMON,
+	"daemon": syslog.LOG_DAEMON,
+	"user":   syslog.LOG_USER,
+	"local0": syslog.LOG_LOCAL0,
+	"local1": syslog.LOG_LOCAL1,
+	"local2": syslog.LOG_LOCAL2,
+	"local3": syslog.LOG_LOCAL3,
+	"local4": syslog.LOG_LOCAL4,
+	"local5": syslog.LOG_LOCAL5,
+	"local6": syslog.LOG_LOCAL6,
+	"local7": syslog.LOG_LOCAL7,
+}
+
+func newSyslog(c Config, opts Options) (Sink, error) {
+	facility, ok := facilities[strings.ToLower(c.Facility)]
+	if !ok {
+		return nil, fmt.Errorf("不支持的 facility %q", c.Facility)
+	}
+	if (c.Network == "") != (c.Address == "") {
+		return nil, fmt.Errorf("network 和 address 需要同时设置")
+	}
+	tag := c.Tag
+	if tag == "" {
+		tag = "xugou-agent"
+	}
+	return &syslogSink{name: opts.Name, network: c.Network, address: c.Address, tag: tag, facility: facility}, nil
+}
+
+func (s *syslogSink) Name() string {
+	return s.name
+}
+
+func (s *syslogSink) Send(ctx context.Context, alert Alert) error {
+	// 每次发送重新连接，避免 syslog 服务重启后连接失效
+	w, err := syslog.Dial(s.network, s.address, s.facility|syslog.LOG_WARNING, s.tag)
+	if err != nil {
+		return fmt.Errorf("连接 syslog 失败: %w", err)
+	}
+	defer w.Close()
+
+	msg := formatMessage(alert)
+	switch {
+	case alert.State == model.AlertResolved:
+		return w.Notice(msg)
+	case alert.Severity == rules.SeverityCritical:
+		return w.Crit(msg)
+	case alert.Severity == rules.SeverityWarning:
+		return w.Warning(msg)
+	default:
+		return w.Info(msg)
+	}
+}
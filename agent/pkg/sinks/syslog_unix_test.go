@@ -0,0 +1,101 @@
+//go:build !windows && !plan9
+
+package sinks
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xugou/agent/pkg/model"
+)
+
+// startSyslogServer 启动接收 syslog 消息的 UDP 服务器
+func startSyslogServer(t *testing.T) (string, <-chan string) {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	messages := make(chan string, 10)
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			messages <- string(buf[:n])
+		}
+	}()
+	return conn.LocalAddr().String(), messages
+}
+
+func receive(t *testing.T, messages <-chan string) string {
+	t.Helper()
+	select {
+	case msg := <-messages:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("no syslog message received")
+		return ""
+	}
+}
+
+func TestSyslogSend(t *testing.T) {
+	addr, messages := startSyslogServer(t)
+	sink, err := New(Config{Type: "syslog", Network: "udp", Address: addr, Tag: "alerts", Facility: "local3", Dedup: "0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 优先级为 facility*8+severity：local3 为 19，critical 为 2，warning 为 4，notice 为 5，info 为 6
+	tests := []struct {
+		severity string
+		state    string
+		priority string
+	}{
+		{"critical", model.AlertFiring, "<154>"},
+		{"warning", model.AlertFiring, "<156>"},
+		{"info", model.AlertFiring, "<158>"},
+		{"critical", model.AlertResolved, "<157>"},
+	}
+	for _, tt := range tests {
+		alert := testAlert()
+		alert.Severity, alert.State = tt.severity, tt.state
+		if err := sink.Send(context.Background(), alert); err != nil {
+			t.Fatal(err)
+		}
+		msg := receive(t, messages)
+		if !strings.HasPrefix(msg, tt.priority) || !strings.Contains(msg, " alerts[") {
+			t.Errorf("%s %s: message %q, want priority %s and tag alerts", tt.severity, tt.state, msg, tt.priority)
+		}
+		if want := "[" + tt.severity + "] disk_full " + tt.state + " instance=/ value=95.5"; !strings.Contains(msg, want) {
+			t.Errorf("message %q does not contain %q", msg, want)
+		}
+	}
+}
+
+func TestSyslogConfig(t *testing.T) {
+	for _, c := range []Config{
+		{Type: "syslog", Facility: "kern"},
+		{Type: "syslog", Network: "udp"},
+		{Type: "syslog", Address: "127.0.0.1:514"},
+	} {
+		if _, err := New(c); err == nil {
+			t.Errorf("New(%+v) succeeded", c)
+		}
+	}
+
+	// 服务器无法连接时返回错误
+	sink, err := New(Config{Type: "syslog", Network: "tcp", Address: "127.0.0.1:1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Send(context.Background(), testAlert()); err == nil {
+		t.Error("Send() to a closed port succeeded")
+	}
+}
@@ -0,0 +1,124 @@
+package sinks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
+
+	"github.com/xugou/agent/pkg/logger"
+	"github.com/xugou/agent/pkg/useragent"
+)
+
+// webhook 把告警以 HTTP 请求发送到指定地址
+type webhook struct {
+	name    string
+	url     string
+	method  string
+	headers map[string]string
+	body    *template.Template
+	client  *http.Client
+}
+
+// templateFuncs 请求体模板可以使用的函数
+var templateFuncs = template.FuncMap{
+	// json 把值编码为 JSON，用于在模板中安全地嵌入字符串，例如 {"text": {{json .Description}}}
+	"json": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+	"upper": strings.ToUpper,
+}
+
+func newWebhook(c Config, opts Options) (Sink, error) {
+	u, err := url.Parse(c.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("无效的 url %q", c.URL)
+	}
+
+	for _, name := range c.SecretHeaders {
+		if _, ok := c.Headers[name]; !ok {
+			return nil, fmt.Errorf("secret_headers 中的 %s 不在 headers 中", name)
+		}
+	}
+
+	w := &webhook{
+		name:    opts.Name,
+		url:     c.URL,
+		method:  strings.ToUpper(c.Method),
+		headers: c.Headers,
+		client:  &http.Client{},
+	}
+	if w.method == "" {
+		w.method = http.MethodPost
+	}
+	if c.Body != "" {
+		if w.body, err = template.New(opts.Name).Funcs(templateFuncs).Option("missingkey=error").Parse(c.Body); err != nil {
+			return nil, fmt.Errorf("无效的 body 模板: %w", err)
+		}
+	}
+	return w, nil
+}
+
+// addWebhookSecrets 登记请求头和地址中的凭据，使其不出现在日志中
+func addWebhookSecrets(c Config) {
+	logger.AddHeaderSecrets(c.Headers, c.SecretHeaders)
+	if u, err := url.Parse(c.URL); err == nil {
+		if password, ok := u.User.Password(); ok {
+			logger.AddSecret(password)
+		}
+		logger.AddSecret(u.RawQuery)
+	}
+}
+
+func (w *webhook) Name() string {
+	return w.name
+}
+
+func (w *webhook) Send(ctx context.Context, alert Alert) error {
+	body, err := w.render(alert)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, w.method, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", useragent.String())
+	for k, v := range w.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook 返回状态码 %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// render 生成请求体，使用模板时要求结果是合法的 JSON
+func (w *webhook) render(alert Alert) ([]byte, error) {
+	if w.body == nil {
+		return json.Marshal(alert)
+	}
+	var buf bytes.Buffer
+	if err := w.body.Execute(&buf, alert); err != nil {
+		return nil, fmt.Errorf("渲染 body 模板失败: %w", err)
+	}
+	if !json.Valid(buf.Bytes()) {
+		return nil, fmt.Errorf("body 模板生成的内容不是合法的 JSON: %s", buf.String())
+	}
+	return buf.Bytes(), nil
+}
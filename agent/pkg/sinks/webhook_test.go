@@ -0,0 +1,184 @@
+package sinks
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xugou/agent/pkg/logger"
+	"github.com/xugou/agent/pkg/model"
+)
+
+// webhookRequest 测试服务器收到的请求
+type webhookRequest struct {
+	method string
+	header http.Header
+	body   string
+}
+
+func newWebhookServer(t *testing.T, status int) (*httptest.Server, <-chan webhookRequest) {
+	t.Helper()
+	requests := make(chan webhookRequest, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- webhookRequest{method: r.Method, header: r.Header, body: string(body)}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, requests
+}
+
+func testAlert() Alert {
+	return Alert{
+		AlertEvent: model.AlertEvent{
+			Rule:      "disk_full",
+			Instance:  "/",
+			Severity:  "critical",
+			State:     "firing",
+			Value:     95.5,
+			Threshold: 90,
+			Time:      time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+		},
+		Hostname: `web-"1"`,
+	}
+}
+
+func TestWebhookSend(t *testing.T) {
+	srv, requests := newWebhookServer(t, http.StatusNoContent)
+	sink, err := New(Config{
+		Type:    "webhook",
+		URL:     srv.URL + "/hook",
+		Headers: map[string]string{"Authorization": "Bearer abc123", "X-Team": "ops"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Send(context.Background(), testAlert()); err != nil {
+		t.Fatal(err)
+	}
+
+	req := <-requests
+	if req.method != http.MethodPost {
+		t.Errorf("method = %s, want POST", req.method)
+	}
+	if got := req.header.Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q", got)
+	}
+	if req.header.Get("Authorization") != "Bearer abc123" || req.header.Get("X-Team") != "ops" {
+		t.Errorf("headers = %v", req.header)
+	}
+	var got Alert
+	if err := json.Unmarshal([]byte(req.body), &got); err != nil {
+		t.Fatalf("body is not JSON: %v: %s", err, req.body)
+	}
+	if got.Rule != "disk_full" || got.Hostname != `web-"1"` || got.Value != 95.5 {
+		t.Errorf("body = %+v", got)
+	}
+}
+
+func TestWebhookTemplate(t *testing.T) {
+	srv, requests := newWebhookServer(t, http.StatusOK)
+	sink, err := New(Config{
+		Type:   "webhook",
+		URL:    srv.URL,
+		Method: "put",
+		Body:   `{"text": {{json .Hostname}}, "state": {{json (upper .State)}}, "value": {{.Value}}}`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Send(context.Background(), testAlert()); err != nil {
+		t.Fatal(err)
+	}
+	req := <-requests
+	if req.method != http.MethodPut {
+		t.Errorf("method = %s, want PUT", req.method)
+	}
+	if want := `{"text": "web-\"1\"", "state": "FIRING", "value": 95.5}`; req.body != want {
+		t.Errorf("body = %s, want %s", req.body, want)
+	}
+}
+
+func TestWebhookErrors(t *testing.T) {
+	srv, _ := newWebhookServer(t, http.StatusInternalServerError)
+	sink, err := New(Config{Type: "webhook", URL: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Send(context.Background(), testAlert()); err == nil || !strings.Contains(err.Error(), "500") {
+		t.Errorf("Send() error = %v, want status 500", err)
+	}
+
+	// 模板生成的内容不是 JSON 时不发送
+	sink, err = New(Config{Type: "webhook", URL: srv.URL, Body: `{"text": {{.Hostname}}}`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Send(context.Background(), testAlert()); err == nil || !strings.Contains(err.Error(), "JSON") {
+		t.Errorf("Send() error = %v, want invalid JSON", err)
+	}
+}
+
+func TestWebhookConfig(t *testing.T) {
+	invalid := []Config{
+		{Type: "webhook", URL: "ftp://example.com"},
+		{Type: "webhook", URL: "https://"},
+		{Type: "webhook", URL: "https://example.com", Body: "{{.Missing"},
+		{Type: "webhook", URL: "https://example.com", SecretHeaders: []string{"X-Key"}},
+	}
+	for _, c := range invalid {
+		if _, err := New(c); err == nil {
+			t.Errorf("New(%+v) succeeded", c)
+		}
+	}
+}
+
+func TestWebhookSecrets(t *testing.T) {
+	// 登记的凭据是全局的，每次运行使用不同的值，避免 -count 多次运行时互相影响
+	run := strconv.FormatInt(time.Now().UnixNano(), 36)
+	token, custom, auth, password := "webhook-token-"+run, "webhook-custom-"+run, "webhook-auth-"+run, "webhook-pass-"+run
+	raw := []interface{}{map[string]interface{}{
+		"type": "webhook",
+		"url":  "https://user:" + password + "@hooks.example.com/alert",
+		"headers": map[string]interface{}{
+			"Authorization":  "Bearer " + token,
+			"X-Custom-Key":   custom,
+			"X-Other":        "webhook-plain-1",
+			"Content-Type":   "application/x-webhook-1",
+			"X-Auth-Token":   auth,
+			"X-Request-Host": "hooks.webhook-1.example",
+		},
+		"secret_headers": []interface{}{"X-Custom-Key"},
+	}}
+
+	// 校验配置时不登记凭据
+	configs, err := ParseConfig(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{custom, auth} {
+		if got := logger.Redact("value " + secret); !strings.Contains(got, secret) {
+			t.Errorf("secret %s registered during validation", secret)
+		}
+	}
+
+	if _, err := NewDispatcher(configs); err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{token, custom, auth, password} {
+		if got := logger.Redact("value " + secret); strings.Contains(got, secret) {
+			t.Errorf("secret %s not redacted: %q", secret, got)
+		}
+	}
+	for _, plain := range []string{"webhook-plain-1", "application/x-webhook-1", "hooks.webhook-1.example"} {
+		if got := logger.Redact("value " + plain); !strings.Contains(got, plain) {
+			t.Errorf("ordinary header value %s was redacted: %q", plain, got)
+		}
+	}
+}
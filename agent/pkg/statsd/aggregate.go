@@ -0,0 +1,269 @@
+package statsd
+
+import (
+	"math"
+	"math/rand/v2"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xugou/agent/pkg/model"
+)
+
+// 聚合的内存限制，序列数量由 statsd.max_series 限制
+const (
+	DefaultMaxSeries = 10000
+	MaxTimerSamples  = 1000   // 每个计时器保留的抽样数量，用于计算百分位
+	MaxSetMembers    = 10000  // 每个 set 记录的不同值的数量，超过后计数不再增加
+	MaxStoredValues  = 100000 // 所有计时器抽样和 set 成员合计的数量
+	MaxBuckets       = 64     // statsd.buckets 最多的分桶数量
+)
+
+// DefaultPercentiles 计时器默认输出的百分位
+var DefaultPercentiles = []float64{50, 90, 95, 99}
+
+// series 一个序列在当前间隔内的聚合状态
+type series struct {
+	name string
+	typ  string
+	tags map[string]string
+
+	value    float64 // counter 的累计值或 gauge 最后的值
+	count    float64 // 计时器按采样率换算后的样本数量
+	received int     // 计时器实际收到的样本数量
+	min, max float64
+	sum      float64 // 计时器实际收到的样本之和
+	samples  []float64
+	buckets  []float64 // 每个分桶中按采样率换算的样本数量，不累计
+	members  map[string]struct{}
+}
+
+// aggregator 按序列聚合一个间隔内的指标，调用方负责加锁
+type aggregator struct {
+	series    map[string]*series
+	maxSeries int
+	buckets   []float64          // 分桶的上界，从小到大
+	stored    int                // 已保存的计时器抽样和 set 成员的数量
+	gauges    map[string]float64 // 之前的间隔中 gauge 最后的值，带符号的值在此基础上增减
+	start     time.Time
+
+	packets, samples, errors, dropped uint64
+}
+
+func newAggregator(maxSeries int, buckets []float64, start time.Time, gauges map[string]float64) *aggregator {
+	return &aggregator{series: make(map[string]*series), maxSeries: maxSeries, buckets: buckets, gauges: gauges, start: start}
+}
+
+// add 把一行指标合并到对应的序列，序列数量已满时丢弃新的序列
+func (a *aggregator) add(s sample) {
+	key := seriesKey(s)
+	sr, ok := a.series[key]
+	if !ok {
+		if len(a.series) >= a.maxSeries {
+			a.dropped++
+			return
+		}
+		sr = &series{name: s.name, typ: s.typ, tags: s.tags}
+		a.series[key] = sr
+	}
+	a.samples++
+
+	switch s.typ {
+	case TypeCounter:
+		for _, v := range s.values {
+			sr.value += v / s.rate
+		}
+	case TypeGauge:
+		if !ok {
+			sr.value = a.gauges[key]
+		}
+		for i, v := range s.values {
+			if s.deltas[i] {
+				sr.value += v
+			} else {
+				sr.value = v
+			}
+		}
+	case TypeSet:
+		if sr.members == nil {
+			sr.members = make(map[string]struct{})
+		}
+		for _, m := range s.members {
+			if _, ok := sr.members[m]; ok || len(sr.members) >= MaxSetMembers || a.stored >= MaxStoredValues {
+				continue
+			}
+			sr.members[m] = struct{}{}
+			a.stored++
+		}
+	default:
+		for _, v := range s.values {
+			a.observe(sr, v, s.rate)
+		}
+	}
+}
+
+// lastGauges 返回每个 gauge 最后的值，用于下一个间隔中带符号的值，最多保留 maxSeries 个，优先保留本间隔收到的
+func (a *aggregator) lastGauges() map[string]float64 {
+	gauges := make(map[string]float64)
+	for key, sr := range a.series {
+		if sr.typ == TypeGauge {
+			gauges[key] = sr.value
+		}
+	}
+	for key, v := range a.gauges {
+		if len(gauges) >= a.maxSeries {
+			break
+		}
+		if _, ok := gauges[key]; !ok {
+			gauges[key] = v
+		}
+	}
+	return gauges
+}
+
+// observe 记录计时器的一个样本，抽样已满时按水塘抽样替换，保证每个样本被保留的概率相同
+func (a *aggregator) observe(sr *series, v, rate float64) {
+	if sr.received == 0 || v < sr.min {
+		sr.min = v
+	}
+	if sr.received == 0 || v > sr.max {
+		sr.max = v
+	}
+	sr.received++
+	sr.count += 1 / rate
+	sr.sum += v
+
+	// 分桶计数来自所有样本而不是抽样，大于最大上界的样本只计入总数
+	if len(a.buckets) > 0 {
+		if sr.buckets == nil {
+			sr.buckets = make([]float64, len(a.buckets))
+		}
+		if i := sort.SearchFloat64s(a.buckets, v); i < len(a.buckets) {
+			sr.buckets[i] += 1 / rate
+		}
+	}
+
+	if len(sr.samples) < MaxTimerSamples {
+		if a.stored < MaxStoredValues {
+			sr.samples = append(sr.samples, v)
+			a.stored++
+		}
+	} else if i := rand.IntN(sr.received); i < len(sr.samples) {
+		sr.samples[i] = v
+	}
+}
+
+// report 生成聚合结果，序列按名称和标签排序
+func (a *aggregator) report(now time.Time, percentiles []float64) *model.StatsdReport {
+	elapsed := now.Sub(a.start).Seconds()
+	report := &model.StatsdReport{
+		IntervalSeconds: round3(elapsed),
+		Packets:         a.packets,
+		Samples:         a.samples,
+		Errors:          a.errors,
+		Dropped:         a.dropped,
+		Metrics:         make([]model.StatsdMetric, 0, len(a.series)),
+	}
+	rate := func(count float64) float64 {
+		if elapsed <= 0 {
+			return 0
+		}
+		return round3(count / elapsed)
+	}
+
+	for _, sr := range a.series {
+		m := model.StatsdMetric{Name: sr.name, Type: sr.typ, Tags: sr.tags}
+		switch sr.typ {
+		case TypeCounter:
+			m.Value = sr.value
+			m.Rate = rate(sr.value)
+		case TypeGauge:
+			m.Value = sr.value
+		case TypeSet:
+			m.Value = float64(len(sr.members))
+		default:
+			m.Value = sr.count
+			m.Rate = rate(sr.count)
+			m.Summary = summarize(sr, percentiles, a.buckets)
+		}
+		report.Metrics = append(report.Metrics, m)
+	}
+	sort.Slice(report.Metrics, func(i, j int) bool {
+		x, y := report.Metrics[i], report.Metrics[j]
+		if x.Name != y.Name {
+			return x.Name < y.Name
+		}
+		if sx, sy := x.Series(), y.Series(); sx != sy {
+			return sx < sy
+		}
+		return x.Type < y.Type
+	})
+	return report
+}
+
+// summarize 计算计时器的统计值，按采样率换算的总和为平均值乘以换算后的样本数量，分桶为不超过每个上界的累计数量
+func summarize(sr *series, percentiles, buckets []float64) *model.StatsdSummary {
+	mean := sr.sum / float64(sr.received)
+	summary := &model.StatsdSummary{
+		Min:  sr.min,
+		Max:  sr.max,
+		Mean: round3(mean),
+		Sum:  round3(mean * sr.count),
+	}
+	if len(sr.buckets) > 0 {
+		summary.Buckets = make(map[string]float64, len(buckets))
+		cumulative := 0.0
+		for i, bound := range buckets {
+			cumulative += sr.buckets[i]
+			summary.Buckets[BucketName(bound)] = round3(cumulative)
+		}
+	}
+	if len(sr.samples) == 0 || len(percentiles) == 0 {
+		return summary
+	}
+	sort.Float64s(sr.samples)
+	summary.Percentiles = make(map[string]float64, len(percentiles))
+	for _, p := range percentiles {
+		// 最近秩法，与 StatsD 的 upper_N 一致
+		i := int(math.Ceil(p/100*float64(len(sr.samples)))) - 1
+		summary.Percentiles[PercentileName(p)] = sr.samples[max(i, 0)]
+	}
+	return summary
+}
+
+// PercentileName 返回百分位在结果中的名称，例如 99 为 p99，99.9 为 p99_9
+func PercentileName(p float64) string {
+	return "p" + strings.ReplaceAll(strconv.FormatFloat(p, 'f', -1, 64), ".", "_")
+}
+
+// BucketName 返回分桶在结果中的名称，例如上界 100 为 le_100，0.5 为 le_0_5
+func BucketName(bound float64) string {
+	return "le_" + strings.ReplaceAll(strconv.FormatFloat(bound, 'f', -1, 64), ".", "_")
+}
+
+// seriesKey 由类型、名称和排序后的标签组成，同名但类型不同的指标是不同的序列
+func seriesKey(s sample) string {
+	var b strings.Builder
+	b.WriteString(s.typ)
+	b.WriteByte('|')
+	b.WriteString(s.name)
+	if len(s.tags) > 0 {
+		names := make([]string, 0, len(s.tags))
+		for name := range s.tags {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			b.WriteByte('|')
+			b.WriteString(name)
+			b.WriteByte(':')
+			b.WriteString(s.tags[name])
+		}
+	}
+	return b.String()
+}
+
+func round3(v float64) float64 {
+	return math.Round(v*1000) / 1000
+}
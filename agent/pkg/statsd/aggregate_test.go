@@ -0,0 +1,212 @@
+package statsd
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/xugou/agent/pkg/model"
+)
+
+// addLines 解析并聚合多行指标
+func addLines(t *testing.T, a *aggregator, lines ...string) {
+	t.Helper()
+	for _, line := range lines {
+		s, err := parseLine(line)
+		if err != nil {
+			t.Fatalf("parseLine(%q): %v", line, err)
+		}
+		a.add(s)
+	}
+}
+
+func TestAggregatorReport(t *testing.T) {
+	start := time.Now()
+	a := newAggregator(DefaultMaxSeries, nil, start, nil)
+	addLines(t, a,
+		"requests:1|c",
+		"requests:2|c|@0.5",
+		"requests:1|c|#route:/login",
+		"queue:5|g",
+		"queue:7|g",
+		"users:alice:bob|s",
+		"users:alice|s",
+		"latency:10:20:30:40|ms",
+		"latency:50|ms|@0.5",
+		"requests:3|g", // 同名但类型不同的指标是不同的序列
+	)
+
+	report := a.report(start.Add(2*time.Second), []float64{50, 99.9})
+	want := []model.StatsdMetric{
+		{Name: "latency", Type: TypeTimer, Value: 6, Rate: 3, Summary: &model.StatsdSummary{
+			Min: 10, Max: 50, Mean: 30, Sum: 180,
+			Percentiles: map[string]float64{"p50": 30, "p99_9": 50},
+		}},
+		{Name: "queue", Type: TypeGauge, Value: 7},
+		{Name: "requests", Type: TypeCounter, Value: 5, Rate: 2.5},
+		{Name: "requests", Type: TypeGauge, Value: 3},
+		{Name: "requests", Type: TypeCounter, Tags: map[string]string{"route": "/login"}, Value: 1, Rate: 0.5},
+		{Name: "users", Type: TypeSet, Value: 2},
+	}
+	if !reflect.DeepEqual(report.Metrics, want) {
+		t.Errorf("metrics =\n%+v\nwant\n%+v", report.Metrics, want)
+	}
+	if report.IntervalSeconds != 2 || report.Samples != 10 {
+		t.Errorf("report = %+v", report)
+	}
+}
+
+func TestAggregatorGaugeDelta(t *testing.T) {
+	a := newAggregator(DefaultMaxSeries, nil, time.Now(), nil)
+	addLines(t, a, "queue:-3|g", "queue:10|g", "queue:+5:-2|g", "other:4|g")
+	if got := a.report(time.Now(), nil).Metrics[1].Value; got != 13 {
+		t.Errorf("queue = %g, want 13", got)
+	}
+
+	// 下一个间隔中带符号的值在上一个间隔最后的值上增减
+	next := newAggregator(DefaultMaxSeries, nil, time.Now(), a.lastGauges())
+	addLines(t, next, "queue:-20|g", "new:+1|g")
+	var got []string
+	for _, m := range next.report(time.Now(), nil).Metrics {
+		got = append(got, fmt.Sprintf("%s=%g", m.Name, m.Value))
+	}
+	// 没有收到的 gauge 不上报
+	if want := []string{"new=1", "queue=-7"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("metrics = %v, want %v", got, want)
+	}
+	if gauges := next.lastGauges(); len(gauges) != 3 || gauges[seriesKey(sample{name: "other", typ: TypeGauge})] != 4 {
+		t.Errorf("lastGauges() = %v", gauges)
+	}
+
+	// 保留的值不超过序列数量的限制，优先保留本间隔收到的
+	small := newAggregator(1, nil, time.Now(), next.lastGauges())
+	addLines(t, small, "fresh:1|g")
+	if gauges := small.lastGauges(); len(gauges) != 1 || gauges[seriesKey(sample{name: "fresh", typ: TypeGauge})] != 1 {
+		t.Errorf("lastGauges() = %v", gauges)
+	}
+}
+
+func TestAggregatorMaxSeries(t *testing.T) {
+	a := newAggregator(3, nil, time.Now(), nil)
+	for i := range 5 {
+		addLines(t, a, fmt.Sprintf("requests:1|c|#id:%d", i))
+	}
+	// 已有的序列仍然可以更新
+	addLines(t, a, "requests:1|c|#id:0")
+	report := a.report(time.Now(), nil)
+	if len(report.Metrics) != 3 || report.Dropped != 2 || report.Samples != 4 {
+		t.Errorf("report = %d metrics, %d dropped, %d samples", len(report.Metrics), report.Dropped, report.Samples)
+	}
+	if report.Metrics[0].Value != 2 {
+		t.Errorf("requests{id=0} = %g, want 2", report.Metrics[0].Value)
+	}
+}
+
+func TestAggregatorMaxStoredValues(t *testing.T) {
+	a := newAggregator(DefaultMaxSeries, nil, time.Now(), nil)
+	full := MaxStoredValues / MaxTimerSamples
+	for i := range full {
+		for v := range MaxTimerSamples {
+			a.add(sample{name: fmt.Sprintf("t%03d", i), typ: TypeTimer, values: []float64{float64(v)}, rate: 1})
+		}
+	}
+	if a.stored != MaxStoredValues {
+		t.Fatalf("stored = %d, want %d", a.stored, MaxStoredValues)
+	}
+
+	// 达到合计的限制后新的计时器没有抽样，但统计值仍然准确
+	addLines(t, a, "late:5:15|ms", "members:a:b|s")
+	report := a.report(time.Now(), []float64{50})
+	late := report.Metrics[0]
+	if late.Name != "late" || late.Value != 2 || late.Summary.Mean != 10 || late.Summary.Percentiles != nil {
+		t.Errorf("late = %+v, summary %+v", late, late.Summary)
+	}
+	if members := report.Metrics[1]; members.Name != "members" || members.Value != 0 {
+		t.Errorf("members = %+v", members)
+	}
+	if a.stored != MaxStoredValues {
+		t.Errorf("stored = %d, want %d", a.stored, MaxStoredValues)
+	}
+}
+
+func TestAggregatorSetMembers(t *testing.T) {
+	a := newAggregator(DefaultMaxSeries, nil, time.Now(), nil)
+	for i := range MaxSetMembers + 10 {
+		a.add(sample{name: "users", typ: TypeSet, members: []string{fmt.Sprint(i)}, rate: 1})
+	}
+	if got := a.report(time.Now(), nil).Metrics[0].Value; got != MaxSetMembers {
+		t.Errorf("users = %g, want %d", got, MaxSetMembers)
+	}
+}
+
+func TestAggregatorReservoir(t *testing.T) {
+	a := newAggregator(DefaultMaxSeries, nil, time.Now(), nil)
+	const n = 20 * MaxTimerSamples
+	for v := 1; v <= n; v++ {
+		a.add(sample{name: "latency", typ: TypeTimer, values: []float64{float64(v)}, rate: 1})
+	}
+	sr := a.series[seriesKey(sample{name: "latency", typ: TypeTimer})]
+	if len(sr.samples) != MaxTimerSamples || a.stored != MaxTimerSamples {
+		t.Fatalf("%d samples, %d stored, want %d", len(sr.samples), a.stored, MaxTimerSamples)
+	}
+
+	m := a.report(time.Now(), []float64{50}).Metrics[0]
+	if m.Value != n || m.Summary.Min != 1 || m.Summary.Max != n || m.Summary.Mean != (n+1)/2.0 {
+		t.Errorf("latency = %+v, summary %+v", m, m.Summary)
+	}
+	// 每个样本被保留的概率相同，抽样的中位数接近所有样本的中位数，几乎不可能都来自开头的样本
+	if p50 := m.Summary.Percentiles["p50"]; p50 < 0.4*n || p50 > 0.6*n {
+		t.Errorf("p50 = %g, want close to %d", p50, n/2)
+	}
+}
+
+func TestAggregatorBuckets(t *testing.T) {
+	a := newAggregator(DefaultMaxSeries, []float64{10, 50, 100}, time.Now(), nil)
+	addLines(t, a,
+		"latency:5:10:11|ms",
+		"latency:50|ms|@0.5", // 按采样率换算为 2 个样本
+		"latency:99:250|ms",
+		"size:1|h|#route:/login",
+		"size:500|h|#route:/login",
+		"requests:1|c",
+	)
+
+	report := a.report(time.Now(), nil)
+	want := map[string]map[string]float64{
+		// 分桶为累计数量，超过最大上界的样本只计入总数
+		"latency":            {"le_10": 2, "le_50": 5, "le_100": 6},
+		"size{route=/login}": {"le_10": 1, "le_50": 1, "le_100": 1},
+	}
+	for _, m := range report.Metrics {
+		if m.Summary == nil {
+			continue
+		}
+		if !reflect.DeepEqual(m.Summary.Buckets, want[m.Series()]) {
+			t.Errorf("%s buckets = %v, want %v", m.Series(), m.Summary.Buckets, want[m.Series()])
+		}
+		delete(want, m.Series())
+	}
+	if len(want) != 0 {
+		t.Errorf("missing summaries for %v", want)
+	}
+	if m := report.Metrics[0]; m.Name != "latency" || m.Value != 7 {
+		t.Errorf("latency = %+v, want 7 samples", m)
+	}
+
+	// 分桶数量来自所有样本，不受抽样数量的限制
+	a = newAggregator(DefaultMaxSeries, []float64{1000}, time.Now(), nil)
+	for i := range 3 * MaxTimerSamples {
+		addLines(t, a, fmt.Sprintf("latency:%d|ms", i))
+	}
+	if got := a.report(time.Now(), nil).Metrics[0].Summary.Buckets["le_1000"]; got != 1001 {
+		t.Errorf("le_1000 = %v, want 1001", got)
+	}
+
+	// 没有设置分桶时不输出
+	a = newAggregator(DefaultMaxSeries, nil, time.Now(), nil)
+	addLines(t, a, "latency:5|ms")
+	if got := a.report(time.Now(), nil).Metrics[0].Summary.Buckets; got != nil {
+		t.Errorf("buckets = %v without statsd.buckets", got)
+	}
+}
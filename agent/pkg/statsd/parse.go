@@ -0,0 +1,155 @@
+package statsd
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// 指标类型
+const (
+	TypeCounter      = "counter"
+	TypeGauge        = "gauge"
+	TypeTimer        = "timer"
+	TypeHistogram    = "histogram"
+	TypeDistribution = "distribution"
+	TypeSet          = "set"
+)
+
+// typeCodes StatsD 和 DogStatsD 的类型代码
+var typeCodes = map[string]string{
+	"c":  TypeCounter,
+	"g":  TypeGauge,
+	"ms": TypeTimer,
+	"h":  TypeHistogram,
+	"d":  TypeDistribution,
+	"s":  TypeSet,
+}
+
+// 单行指标的限制
+const (
+	MaxNameLength  = 200 // 指标名称的最大长度
+	MaxTags        = 32  // 标签的最大数量
+	MaxTagLength   = 200 // 单个标签（名称和值）的最大长度
+	MaxValueLength = 100 // set 成员的最大长度
+)
+
+// sample 一行指标，例如 api.latency:12.5|ms|@0.5|#route:/login,env:prod
+type sample struct {
+	name    string
+	typ     string
+	values  []float64 // set 以外的类型的值，DogStatsD 允许一行有多个值
+	deltas  []bool    // gauge 的每个值是否带有 + 或 - 号，带符号的值是对当前值的增减
+	members []string  // set 的成员
+	rate    float64   // 采样率，范围为 (0, 1]
+	tags    map[string]string
+}
+
+// ignored 判断是否是 DogStatsD 的事件或服务检查，这些内容不是指标，直接忽略
+func ignored(line string) bool {
+	return strings.HasPrefix(line, "_e{") || strings.HasPrefix(line, "_sc|")
+}
+
+// parseLine 解析一行指标：<名称>:<值>[:<值>...]|<类型>[|@<采样率>][|#<标签>,...]，
+// DogStatsD 的其他扩展字段（例如容器 ID 和时间戳）被忽略
+func parseLine(line string) (sample, error) {
+	s := sample{rate: 1}
+	if !utf8.ValidString(line) {
+		return s, fmt.Errorf("不是有效的 UTF-8 文本")
+	}
+	colon := strings.IndexByte(line, ':')
+	if colon <= 0 {
+		return s, fmt.Errorf("缺少指标名称或值")
+	}
+	s.name = line[:colon]
+	if len(s.name) > MaxNameLength || strings.ContainsAny(s.name, "|# \t") {
+		return s, fmt.Errorf("无效的指标名称 %q", truncate(s.name))
+	}
+
+	parts := strings.Split(line[colon+1:], "|")
+	if len(parts) < 2 {
+		return s, fmt.Errorf("指标 %s 缺少类型", s.name)
+	}
+	typ, ok := typeCodes[parts[1]]
+	if !ok {
+		return s, fmt.Errorf("指标 %s 的类型 %q 无效", s.name, truncate(parts[1]))
+	}
+	s.typ = typ
+
+	for _, part := range parts[2:] {
+		switch {
+		case strings.HasPrefix(part, "@"):
+			rate, err := strconv.ParseFloat(part[1:], 64)
+			if err != nil || rate <= 0 || rate > 1 {
+				return s, fmt.Errorf("指标 %s 的采样率 %q 无效", s.name, truncate(part[1:]))
+			}
+			s.rate = rate
+		case strings.HasPrefix(part, "#"):
+			tags, err := parseTags(part[1:])
+			if err != nil {
+				return s, fmt.Errorf("指标 %s: %w", s.name, err)
+			}
+			s.tags = tags
+		}
+	}
+
+	for _, text := range strings.Split(parts[0], ":") {
+		if typ == TypeSet {
+			if text == "" || len(text) > MaxValueLength {
+				return s, fmt.Errorf("指标 %s 的值 %q 无效", s.name, truncate(text))
+			}
+			s.members = append(s.members, text)
+			continue
+		}
+		value, err := strconv.ParseFloat(text, 64)
+		if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+			return s, fmt.Errorf("指标 %s 的值 %q 不是有效的数字", s.name, truncate(text))
+		}
+		s.values = append(s.values, value)
+		if typ == TypeGauge {
+			s.deltas = append(s.deltas, text[0] == '+' || text[0] == '-')
+		}
+	}
+	return s, nil
+}
+
+// parseTags 解析 DogStatsD 标签 name:value,name2:value2,flag，没有值的标签的值为空，同名标签以最后一个为准
+func parseTags(text string) (map[string]string, error) {
+	tags := make(map[string]string)
+	for _, tag := range strings.Split(text, ",") {
+		if tag == "" {
+			continue
+		}
+		if len(tag) > MaxTagLength {
+			return nil, fmt.Errorf("标签 %q 超过 %d 个字符", truncate(tag), MaxTagLength)
+		}
+		name, value, _ := strings.Cut(tag, ":")
+		if name == "" {
+			return nil, fmt.Errorf("标签 %q 缺少名称", truncate(tag))
+		}
+		tags[name] = value
+		if len(tags) > MaxTags {
+			return nil, fmt.Errorf("标签超过 %d 个", MaxTags)
+		}
+	}
+	if len(tags) == 0 {
+		return nil, nil
+	}
+	return tags, nil
+}
+
+// truncate 截断错误信息中来自网络的内容
+func truncate(s string) string {
+	const limit = 64
+	if len(s) <= limit {
+		return s
+	}
+	for i := limit; i > 0; i-- {
+		if utf8.RuneStart(s[i]) {
+			return s[:i] + "..."
+		}
+	}
+	return s[:limit] + "..."
+}
@@ -0,0 +1,85 @@
+package statsd
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseLine(t *testing.T) {
+	tests := []struct {
+		line string
+		want sample
+	}{
+		{"requests:1|c", sample{name: "requests", typ: TypeCounter, values: []float64{1}, rate: 1}},
+		{"requests:2|c|@0.5", sample{name: "requests", typ: TypeCounter, values: []float64{2}, rate: 0.5}},
+		{"queue:12|g", sample{name: "queue", typ: TypeGauge, values: []float64{12}, deltas: []bool{false}, rate: 1}},
+		{"queue:+3:-1.5:7|g", sample{name: "queue", typ: TypeGauge, values: []float64{3, -1.5, 7}, deltas: []bool{true, true, false}, rate: 1}},
+		{
+			"api.latency:12.5|ms|@0.25|#route:/login,env:prod,canary",
+			sample{name: "api.latency", typ: TypeTimer, values: []float64{12.5}, rate: 0.25, tags: map[string]string{"route": "/login", "env": "prod", "canary": ""}},
+		},
+		{"size:10:20:30|h", sample{name: "size", typ: TypeHistogram, values: []float64{10, 20, 30}, rate: 1}},
+		{"payload:1e3|d", sample{name: "payload", typ: TypeDistribution, values: []float64{1000}, rate: 1}},
+		{"users:alice:bob|s", sample{name: "users", typ: TypeSet, members: []string{"alice", "bob"}, rate: 1}},
+		// 同名标签以最后一个为准，空标签被忽略，未知的扩展字段被忽略
+		{"hits:1|c|#a:1,,a:2|c:container|T1700000000", sample{name: "hits", typ: TypeCounter, values: []float64{1}, rate: 1, tags: map[string]string{"a": "2"}}},
+		{"hits:1|c|#", sample{name: "hits", typ: TypeCounter, values: []float64{1}, rate: 1}},
+	}
+	for _, tt := range tests {
+		got, err := parseLine(tt.line)
+		if err != nil {
+			t.Errorf("parseLine(%q) error = %v", tt.line, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseLine(%q) =\n%+v\nwant\n%+v", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestParseLineInvalid(t *testing.T) {
+	manyTags := make([]string, MaxTags+1)
+	for i := range manyTags {
+		manyTags[i] = "t" + strings.Repeat("x", i)
+	}
+	tests := []struct {
+		line string
+		want string
+	}{
+		{"requests", "缺少指标名称或值"},
+		{":1|c", "缺少指标名称或值"},
+		{"bad name:1|c", "无效的指标名称"},
+		{strings.Repeat("x", MaxNameLength+1) + ":1|c", "无效的指标名称"},
+		{"requests:1", "缺少类型"},
+		{"requests:1|x", `类型 "x" 无效`},
+		{"requests:1|c|@0", "采样率"},
+		{"requests:1|c|@1.5", "采样率"},
+		{"requests:one|c", "不是有效的数字"},
+		{"requests:NaN|g", "不是有效的数字"},
+		{"requests:1::2|c", "不是有效的数字"},
+		{"users:|s", `值 "" 无效`},
+		{"users:" + strings.Repeat("x", MaxValueLength+1) + "|s", "无效"},
+		{"hits:1|c|#:value", "缺少名称"},
+		{"hits:1|c|#" + strings.Repeat("x", MaxTagLength+1), "超过 200 个字符"},
+		{"hits:1|c|#" + strings.Join(manyTags, ","), "标签超过 32 个"},
+		{"hits:\xff|c", "UTF-8"},
+	}
+	for _, tt := range tests {
+		_, err := parseLine(tt.line)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("parseLine(%q) error = %v, want containing %q", truncate(tt.line), err, tt.want)
+		}
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	if got := truncate("short"); got != "short" {
+		t.Errorf("truncate() = %q", got)
+	}
+	// 不在多字节字符中间截断
+	got := truncate(strings.Repeat("a", 63) + strings.Repeat("指", 10))
+	if got != strings.Repeat("a", 63)+"..." {
+		t.Errorf("truncate() = %q", got)
+	}
+}
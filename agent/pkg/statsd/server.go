@@ -0,0 +1,254 @@
+package statsd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xugou/agent/pkg/model"
+)
+
+const (
+	maxPacketSize  = 65535   // UDP 数据报的最大长度
+	readBufferSize = 4 << 20 // 套接字的接收缓冲区，应用短时间内发送大量指标时减少内核丢弃的数据包，实际大小受系统设置限制
+)
+
+// Options StatsD 监听器的设置
+type Options struct {
+	Listen      []string  // UDP 地址（例如 127.0.0.1:8125）或 unix:/path 形式的 unix 数据报套接字，为空表示不启用
+	Percentiles []float64 // 计时器输出的百分位
+	Buckets     []float64 // 计时器和直方图的分桶上界，从小到大，为空表示不分桶
+	MaxSeries   int       // 每个上报间隔最多聚合的序列数量
+}
+
+// Server 接收 StatsD 和 DogStatsD 格式的指标，按上报间隔聚合
+type Server struct {
+	opts  Options
+	conns []net.PacketConn
+
+	mu  sync.Mutex
+	agg *aggregator
+}
+
+// New 校验设置并创建监听器，需要调用 Start 开始接收
+func New(opts Options) (*Server, error) {
+	for _, addr := range opts.Listen {
+		if err := ValidateAddress(addr); err != nil {
+			return nil, err
+		}
+	}
+	if err := validatePercentiles(opts.Percentiles); err != nil {
+		return nil, err
+	}
+	if err := validateBuckets(opts.Buckets); err != nil {
+		return nil, err
+	}
+	if opts.MaxSeries < 1 {
+		opts.MaxSeries = DefaultMaxSeries
+	}
+	return &Server{opts: opts, agg: newAggregator(opts.MaxSeries, opts.Buckets, time.Now(), nil)}, nil
+}
+
+// Enabled 判断是否配置了监听地址
+func (s *Server) Enabled() bool {
+	return len(s.opts.Listen) > 0
+}
+
+// Start 监听所有地址并在后台接收指标
+func (s *Server) Start() error {
+	for _, addr := range s.opts.Listen {
+		conn, err := listen(addr)
+		if err != nil {
+			for _, c := range s.conns {
+				c.Close()
+			}
+			return fmt.Errorf("监听 %s 失败: %w", addr, err)
+		}
+		if c, ok := conn.(interface{ SetReadBuffer(int) error }); ok {
+			c.SetReadBuffer(readBufferSize)
+		}
+		s.conns = append(s.conns, conn)
+	}
+
+	s.mu.Lock()
+	s.agg = newAggregator(s.opts.MaxSeries, s.opts.Buckets, time.Now(), nil)
+	s.mu.Unlock()
+
+	for _, conn := range s.conns {
+		go s.serve(conn)
+	}
+	return nil
+}
+
+// Close 停止接收，unix 套接字文件会被删除
+func (s *Server) Close() {
+	for _, conn := range s.conns {
+		conn.Close()
+		if addr, ok := conn.LocalAddr().(*net.UnixAddr); ok {
+			os.Remove(addr.Name)
+		}
+	}
+}
+
+func (s *Server) serve(conn net.PacketConn) {
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			slog.Debug("接收 StatsD 数据失败", "error", err)
+			continue
+		}
+		s.handle(string(buf[:n]))
+	}
+}
+
+// handle 解析一个数据包中的所有行，数据包可以包含多行指标
+func (s *Server) handle(packet string) {
+	samples := make([]sample, 0, strings.Count(packet, "\n")+1)
+	errs := 0
+	for _, line := range strings.Split(packet, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || ignored(line) {
+			continue
+		}
+		m, err := parseLine(line)
+		if err != nil {
+			errs++
+			slog.Debug("无法解析 StatsD 指标", "line", truncate(line), "error", err)
+			continue
+		}
+		samples = append(samples, m)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.agg.packets++
+	s.agg.errors += uint64(errs)
+	for _, m := range samples {
+		s.agg.add(m)
+	}
+}
+
+// Flush 返回上次调用以来的聚合结果并开始新的间隔，没有启用时返回 nil
+func (s *Server) Flush() *model.StatsdReport {
+	if !s.Enabled() {
+		return nil
+	}
+	now := time.Now()
+	s.mu.Lock()
+	agg := s.agg
+	s.agg = newAggregator(s.opts.MaxSeries, s.opts.Buckets, now, agg.lastGauges())
+	s.mu.Unlock()
+
+	report := agg.report(now, s.opts.Percentiles)
+	if agg.dropped > 0 {
+		slog.Warn("StatsD 序列数量超过限制，部分指标被丢弃", "max_series", s.opts.MaxSeries, "dropped", agg.dropped)
+	}
+	return report
+}
+
+// ValidateAddress 校验监听地址，UDP 地址需要包含端口，unix 套接字使用 unix:/path
+func ValidateAddress(addr string) error {
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		if path == "" {
+			return fmt.Errorf("无效的监听地址 %q，unix 套接字需要指定路径", addr)
+		}
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return fmt.Errorf("无效的监听地址 %q，需要包含端口（例如 127.0.0.1:8125）", addr)
+	}
+	return nil
+}
+
+// listen 监听 UDP 地址或 unix 数据报套接字
+func listen(addr string) (net.PacketConn, error) {
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		// 清理上次异常退出时残留的 socket 文件
+		if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+			os.Remove(path)
+		}
+		conn, err := net.ListenPacket("unixgram", path)
+		if err != nil {
+			return nil, err
+		}
+		// 与 UDP 端口一样允许本机的所有用户发送指标
+		if err := os.Chmod(path, 0666); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return conn, nil
+	}
+	return net.ListenPacket("udp", addr)
+}
+
+// ParsePercentiles 解析配置中的百分位列表，raw 为 nil 时返回默认值
+func ParsePercentiles(raw interface{}) ([]float64, error) {
+	if raw == nil {
+		return DefaultPercentiles, nil
+	}
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	var percentiles []float64
+	if err := json.Unmarshal(data, &percentiles); err != nil {
+		return nil, fmt.Errorf("必须是数字列表（例如 [50, 90, 99]）")
+	}
+	if err := validatePercentiles(percentiles); err != nil {
+		return nil, err
+	}
+	return percentiles, nil
+}
+
+func validatePercentiles(percentiles []float64) error {
+	for _, p := range percentiles {
+		if p <= 0 || p > 100 {
+			return fmt.Errorf("无效的百分位 %v，范围为 (0, 100]", p)
+		}
+	}
+	return nil
+}
+
+// ParseBuckets 解析配置中的分桶上界列表，raw 为 nil 或空列表时不分桶
+func ParseBuckets(raw interface{}) ([]float64, error) {
+	if raw == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	var buckets []float64
+	if err := json.Unmarshal(data, &buckets); err != nil {
+		return nil, fmt.Errorf("必须是数字列表（例如 [10, 50, 100, 500]）")
+	}
+	if err := validateBuckets(buckets); err != nil {
+		return nil, err
+	}
+	if len(buckets) == 0 {
+		return nil, nil
+	}
+	return buckets, nil
+}
+
+func validateBuckets(buckets []float64) error {
+	if len(buckets) > MaxBuckets {
+		return fmt.Errorf("分桶数量 %d 超过上限 %d", len(buckets), MaxBuckets)
+	}
+	for i := 1; i < len(buckets); i++ {
+		if buckets[i] <= buckets[i-1] {
+			return fmt.Errorf("分桶上界需要从小到大排列且不能重复，%v 不能排在 %v 之后", buckets[i], buckets[i-1])
+		}
+	}
+	return nil
+}
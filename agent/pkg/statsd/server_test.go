@@ -0,0 +1,197 @@
+package statsd
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// waitPackets 等待监听器在当前间隔内处理完指定数量的数据包
+func waitPackets(t *testing.T, s *Server, packets uint64) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		s.mu.Lock()
+		n := s.agg.packets
+		s.mu.Unlock()
+		if n >= packets {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("received %d packets, want %d", n, packets)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestServer(t *testing.T) {
+	// 先占用一个端口得到可用的 UDP 地址
+	probe, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	udpAddr := probe.LocalAddr().String()
+	probe.Close()
+	sock := filepath.Join(t.TempDir(), "statsd.sock")
+
+	s, err := New(Options{Listen: []string{udpAddr, "unix:" + sock}, Percentiles: []float64{50}, Buckets: []float64{20}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	info, err := os.Stat(sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0666 {
+		t.Errorf("socket mode = %s, want 0666", info.Mode().Perm())
+	}
+
+	udp, err := net.Dial("udp", udpAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udp.Close()
+	unix, err := net.Dial("unixgram", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unix.Close()
+
+	// 一个数据包可以包含多行，事件、服务检查和空行被忽略。等待第一个数据包处理完再发送第二个，保证 gauge 的顺序
+	udp.Write([]byte("requests:1|c\nqueue:5|g\n\n_e{5,4}:title|text\n_sc|check|0\nbad line\n"))
+	waitPackets(t, s, 1)
+	unix.Write([]byte("requests:2|c|#via:unix\nqueue:+2|g\nlatency:10:30|ms"))
+	waitPackets(t, s, 2)
+
+	report := s.Flush()
+	var got []string
+	for _, m := range report.Metrics {
+		got = append(got, m.Series()+" "+m.Type)
+	}
+	want := []string{"latency timer", "queue gauge", "requests counter", "requests{via=unix} counter"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("metrics = %v, want %v", got, want)
+	}
+	if report.Packets != 2 || report.Samples != 5 || report.Errors != 1 {
+		t.Errorf("report = %d packets, %d samples, %d errors", report.Packets, report.Samples, report.Errors)
+	}
+	if queue := report.Metrics[1]; queue.Value != 7 {
+		t.Errorf("queue = %g, want 7", queue.Value)
+	}
+	if p50 := report.Metrics[0].Summary.Percentiles["p50"]; p50 != 10 {
+		t.Errorf("latency p50 = %g, want 10", p50)
+	}
+	if buckets := report.Metrics[0].Summary.Buckets; !reflect.DeepEqual(buckets, map[string]float64{"le_20": 1}) {
+		t.Errorf("latency buckets = %v, want le_20 1", buckets)
+	}
+
+	// Flush 后重新开始聚合，gauge 的增减基于上一个间隔的值
+	unix.Write([]byte("queue:-1|g"))
+	waitPackets(t, s, 1)
+	report = s.Flush()
+	if len(report.Metrics) != 1 || report.Metrics[0].Value != 6 {
+		t.Errorf("metrics after flush = %+v", report.Metrics)
+	}
+
+	s.Close()
+	if _, err := os.Stat(sock); !os.IsNotExist(err) {
+		t.Errorf("socket file not removed: %v", err)
+	}
+}
+
+func TestServerStaleSocket(t *testing.T) {
+	// 上次异常退出时残留的 socket 文件被清理
+	sock := filepath.Join(t.TempDir(), "statsd.sock")
+	old, err := net.ListenPacket("unixgram", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old.Close()
+	if _, err := os.Stat(sock); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := New(Options{Listen: []string{"unix:" + sock}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+}
+
+func TestServerDisabled(t *testing.T) {
+	s, err := New(Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Enabled() || s.Flush() != nil {
+		t.Error("server without listen addresses is enabled")
+	}
+}
+
+func TestServerInvalidOptions(t *testing.T) {
+	invalid := []Options{
+		{Listen: []string{"127.0.0.1"}},
+		{Listen: []string{"unix:"}},
+		{Listen: []string{"127.0.0.1:8125"}, Percentiles: []float64{0}},
+		{Listen: []string{"127.0.0.1:8125"}, Percentiles: []float64{100.5}},
+		{Listen: []string{"127.0.0.1:8125"}, Buckets: []float64{10, 10}},
+	}
+	for _, opts := range invalid {
+		if _, err := New(opts); err == nil {
+			t.Errorf("New(%+v) succeeded", opts)
+		}
+	}
+}
+
+func TestParsePercentiles(t *testing.T) {
+	got, err := ParsePercentiles([]interface{}{50, 99.9})
+	if err != nil || !reflect.DeepEqual(got, []float64{50, 99.9}) {
+		t.Errorf("ParsePercentiles() = %v, %v", got, err)
+	}
+	if got, _ := ParsePercentiles(nil); !reflect.DeepEqual(got, DefaultPercentiles) {
+		t.Errorf("ParsePercentiles(nil) = %v", got)
+	}
+	for _, raw := range []interface{}{"p99", []interface{}{"50"}, []interface{}{101}} {
+		if _, err := ParsePercentiles(raw); err == nil {
+			t.Errorf("ParsePercentiles(%v) succeeded", raw)
+		}
+	}
+	if got := PercentileName(99.9) + " " + PercentileName(50); got != "p99_9 p50" {
+		t.Errorf("PercentileName() = %q", got)
+	}
+}
+
+func TestParseBuckets(t *testing.T) {
+	got, err := ParseBuckets([]interface{}{-1, 0.5, 10, 1000})
+	if err != nil || !reflect.DeepEqual(got, []float64{-1, 0.5, 10, 1000}) {
+		t.Errorf("ParseBuckets() = %v, %v", got, err)
+	}
+	for _, raw := range []interface{}{nil, []interface{}{}} {
+		if got, err := ParseBuckets(raw); got != nil || err != nil {
+			t.Errorf("ParseBuckets(%v) = %v, %v, want no buckets", raw, got, err)
+		}
+	}
+	tooMany := make([]interface{}, MaxBuckets+1)
+	for i := range tooMany {
+		tooMany[i] = i
+	}
+	for _, raw := range []interface{}{"10,100", []interface{}{"10"}, []interface{}{100, 10}, []interface{}{1, 1}, tooMany} {
+		if _, err := ParseBuckets(raw); err == nil {
+			t.Errorf("ParseBuckets(%v) succeeded", raw)
+		}
+	}
+	if got := BucketName(100) + " " + BucketName(0.25) + " " + BucketName(-5); got != "le_100 le_0_25 le_-5" {
+		t.Errorf("BucketName() = %q", got)
+	}
+}
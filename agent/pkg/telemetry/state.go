@@ -0,0 +1,87 @@
+package telemetry
+
+import "time"
+
+// maxRecentErrors 保留的最近错误条数
+const maxRecentErrors = 10
+
+// ErrorEvent 一条最近发生的错误
+type ErrorEvent struct {
+	Time    time.Time `json:"time"`
+	Source  string    `json:"source"` // 出错的环节，例如 collect、report
+	Message string    `json:"message"`
+}
+
+// State Agent 的运行状态，用于健康检查和状态查询
+type State struct {
+	StartedAt     time.Time    `json:"started_at"`
+	LastTick      time.Time    `json:"last_tick"`
+	LastReport    time.Time    `json:"last_report"`
+	Registered    bool         `json:"registered"`
+	ConfigVersion string       `json:"config_version,omitempty"` // 生效的远程配置版本
+	Control       string       `json:"control,omitempty"`        // 控制通道状态: websocket、longpoll 或 disconnected
+	RecentErrors  []ErrorEvent `json:"recent_errors"`
+}
+
+// Tick 记录调度器执行了一次采集任务
+func (r *Recorder) Tick() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lastTick = time.Now()
+}
+
+// ReportSucceeded 记录一次成功的上报
+func (r *Recorder) ReportSucceeded() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lastReport = time.Now()
+}
+
+// SetRegistered 记录客户端是否已经注册
+func (r *Recorder) SetRegistered(registered bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.registered = registered
+}
+
+// SetConfigVersion 记录生效的远程配置版本
+func (r *Recorder) SetConfigVersion(version string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.configVersion = version
+}
+
+// SetControl 记录控制通道的连接状态
+func (r *Recorder) SetControl(state string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.control = state
+}
+
+// RecordError 记录一条错误，只保留最近的若干条
+func (r *Recorder) RecordError(source string, err error) {
+	if err == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.recentErrors = append(r.recentErrors, ErrorEvent{Time: time.Now(), Source: source, Message: err.Error()})
+	if len(r.recentErrors) > maxRecentErrors {
+		r.recentErrors = r.recentErrors[len(r.recentErrors)-maxRecentErrors:]
+	}
+}
+
+// State 返回当前运行状态的快照
+func (r *Recorder) State() State {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return State{
+		StartedAt:     r.startedAt,
+		LastTick:      r.lastTick,
+		LastReport:    r.lastReport,
+		Registered:    r.registered,
+		ConfigVersion: r.configVersion,
+		Control:       r.control,
+		RecentErrors:  append([]ErrorEvent{}, r.recentErrors...),
+	}
+}
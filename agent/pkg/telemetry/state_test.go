@@ -0,0 +1,52 @@
+package telemetry
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRecentErrors(t *testing.T) {
+	r := New()
+	r.RecordError("report", nil)
+	if errs := r.State().RecentErrors; len(errs) != 0 {
+		t.Fatalf("recent errors = %+v after a nil error", errs)
+	}
+
+	for i := range maxRecentErrors + 5 {
+		r.RecordError("collect", fmt.Errorf("error %d", i))
+	}
+	errs := r.State().RecentErrors
+	if len(errs) != maxRecentErrors {
+		t.Fatalf("%d recent errors kept, want %d", len(errs), maxRecentErrors)
+	}
+	// 只保留最近的错误，按发生顺序排列
+	if errs[0].Message != "error 5" || errs[len(errs)-1].Message != fmt.Sprintf("error %d", maxRecentErrors+4) || errs[0].Source != "collect" {
+		t.Errorf("recent errors = %+v", errs)
+	}
+
+	// 返回的是副本
+	errs[0].Message = "changed"
+	if r.State().RecentErrors[0].Message != "error 5" {
+		t.Error("modifying the state changed the recorder")
+	}
+}
+
+func TestState(t *testing.T) {
+	r := New()
+	state := r.State()
+	if !state.LastTick.IsZero() || !state.LastReport.IsZero() || state.Registered || state.StartedAt.IsZero() {
+		t.Errorf("initial state = %+v", state)
+	}
+
+	before := time.Now()
+	r.Tick()
+	r.ReportSucceeded()
+	r.SetRegistered(true)
+	r.SetConfigVersion("v7")
+	r.SetControl("websocket")
+	state = r.State()
+	if state.LastTick.Before(before) || state.LastReport.Before(before) || !state.Registered || state.ConfigVersion != "v7" || state.Control != "websocket" {
+		t.Errorf("state = %+v", state)
+	}
+}
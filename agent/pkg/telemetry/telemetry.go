@@ -0,0 +1,224 @@
+package telemetry
+
+import (
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/process"
+	"github.com/xugou/agent/pkg/model"
+)
+
+// Recorder 记录 Agent 自身的运行指标，可以在多个 goroutine 中并发使用
+type Recorder struct {
+	mu         sync.Mutex
+	version    string
+	startedAt  time.Time
+	collectors map[string]*collectorStats
+	requests   map[string]*requestStats
+	inFlight   atomic.Int64
+	spoolDepth atomic.Int64
+	dropped    atomic.Uint64
+
+	lastTick      time.Time
+	lastReport    time.Time
+	registered    bool
+	configVersion string
+	control       string
+	recentErrors  []ErrorEvent
+}
+
+type collectorStats struct {
+	runs      uint64
+	errors    uint64
+	last      time.Duration
+	total     time.Duration
+	lastError string
+}
+
+type requestStats struct {
+	requests    uint64
+	errors      uint64
+	retries     uint64
+	last        time.Duration
+	total       time.Duration
+	statusCodes map[string]uint64
+	lastError   string
+}
+
+// Default 全局指标记录器
+var Default = New()
+
+// New 创建一个新的指标记录器
+func New() *Recorder {
+	return &Recorder{
+		startedAt:  time.Now(),
+		collectors: make(map[string]*collectorStats),
+		requests:   make(map[string]*requestStats),
+	}
+}
+
+// SetVersion 设置上报的 Agent 版本
+func (r *Recorder) SetVersion(version string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.version = version
+}
+
+// ObserveCollector 记录一次采集步骤的耗时和结果
+func (r *Recorder) ObserveCollector(name string, d time.Duration, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s, ok := r.collectors[name]
+	if !ok {
+		s = &collectorStats{}
+		r.collectors[name] = s
+	}
+	s.runs++
+	s.last = d
+	s.total += d
+	if err != nil {
+		s.errors++
+		s.lastError = err.Error()
+	}
+}
+
+// ObserveRequest 记录一次发往服务器的请求，status 为 0 表示请求没有收到响应
+func (r *Recorder) ObserveRequest(endpoint string, d time.Duration, status int, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s := r.request(endpoint)
+	s.requests++
+	s.last = d
+	s.total += d
+
+	code := "error"
+	if status > 0 {
+		code = strconv.Itoa(status)
+	}
+	s.statusCodes[code]++
+
+	if err != nil {
+		s.errors++
+		s.lastError = err.Error()
+	} else if status >= 400 {
+		s.errors++
+		s.lastError = "HTTP " + code
+	}
+}
+
+// AddRetry 记录一次请求重试
+func (r *Recorder) AddRetry(endpoint string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.request(endpoint).retries++
+}
+
+func (r *Recorder) request(endpoint string) *requestStats {
+	s, ok := r.requests[endpoint]
+	if !ok {
+		s = &requestStats{statusCodes: make(map[string]uint64)}
+		r.requests[endpoint] = s
+	}
+	return s
+}
+
+// InFlightAdd 调整正在采集或上报的任务数
+func (r *Recorder) InFlightAdd(delta int64) {
+	r.inFlight.Add(delta)
+}
+
+// SetSpoolDepth 记录缓存中等待上报的探测结果数
+func (r *Recorder) SetSpoolDepth(n int) {
+	r.spoolDepth.Store(int64(n))
+}
+
+// AddDropped 记录因上报失败被丢弃的采集数据条数
+func (r *Recorder) AddDropped(n int) {
+	r.dropped.Add(uint64(n))
+}
+
+// Snapshot 返回当前所有指标的快照
+func (r *Recorder) Snapshot() *model.AgentTelemetry {
+	r.mu.Lock()
+	t := &model.AgentTelemetry{
+		Version:        r.version,
+		UptimeSeconds:  time.Since(r.startedAt).Seconds(),
+		Collectors:     make([]model.CollectorStats, 0, len(r.collectors)),
+		Requests:       make([]model.RequestStats, 0, len(r.requests)),
+		InFlight:       r.inFlight.Load(),
+		SpoolDepth:     r.spoolDepth.Load(),
+		DroppedSamples: r.dropped.Load(),
+	}
+	for name, s := range r.collectors {
+		t.Collectors = append(t.Collectors, model.CollectorStats{
+			Name:           name,
+			Runs:           s.runs,
+			Errors:         s.errors,
+			LastDurationMs: milliseconds(s.last),
+			AvgDurationMs:  average(s.total, s.runs),
+			LastError:      s.lastError,
+		})
+	}
+	for endpoint, s := range r.requests {
+		codes := make(map[string]uint64, len(s.statusCodes))
+		for code, n := range s.statusCodes {
+			codes[code] = n
+		}
+		t.Requests = append(t.Requests, model.RequestStats{
+			Endpoint:      endpoint,
+			Requests:      s.requests,
+			Errors:        s.errors,
+			Retries:       s.retries,
+			LastLatencyMs: milliseconds(s.last),
+			AvgLatencyMs:  average(s.total, s.requests),
+			StatusCodes:   codes,
+			LastError:     s.lastError,
+		})
+	}
+	r.mu.Unlock()
+
+	sort.Slice(t.Collectors, func(i, j int) bool { return t.Collectors[i].Name < t.Collectors[j].Name })
+	sort.Slice(t.Requests, func(i, j int) bool { return t.Requests[i].Endpoint < t.Requests[j].Endpoint })
+	t.Process = processStats()
+	return t
+}
+
+// processStats 读取当前进程的 goroutine 数、内存占用和 GC 统计
+func processStats() model.ProcessStats {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+
+	stats := model.ProcessStats{
+		Goroutines:     runtime.NumGoroutine(),
+		HeapAllocBytes: m.HeapAlloc,
+		HeapSysBytes:   m.HeapSys,
+		GCCount:        m.NumGC,
+		GCPauseTotalMs: float64(m.PauseTotalNs) / 1e6,
+	}
+
+	// RSS 读取失败时（例如受限的容器环境）保留为 0
+	if p, err := process.NewProcess(int32(os.Getpid())); err == nil {
+		if mem, err := p.MemoryInfo(); err == nil {
+			stats.RSSBytes = mem.RSS
+		}
+	}
+	return stats
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func average(total time.Duration, n uint64) float64 {
+	if n == 0 {
+		return 0
+	}
+	return milliseconds(total) / float64(n)
+}
@@ -0,0 +1,110 @@
+package telemetry
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSnapshotRequests(t *testing.T) {
+	r := New()
+	r.SetVersion("1.2.3")
+	r.ObserveRequest("status", 10*time.Millisecond, 200, nil)
+	r.ObserveRequest("status", 30*time.Millisecond, 200, nil)
+	r.ObserveRequest("status", 20*time.Millisecond, 503, nil)
+	r.AddRetry("status")
+	r.ObserveRequest("status", 40*time.Millisecond, 0, errors.New("connection refused"))
+	r.ObserveRequest("register", 5*time.Millisecond, 201, nil)
+
+	snap := r.Snapshot()
+	if snap.Version != "1.2.3" || snap.UptimeSeconds < 0 {
+		t.Errorf("version %q, uptime %v", snap.Version, snap.UptimeSeconds)
+	}
+	if len(snap.Requests) != 2 || snap.Requests[0].Endpoint != "register" || snap.Requests[1].Endpoint != "status" {
+		t.Fatalf("requests = %+v, want register and status sorted by endpoint", snap.Requests)
+	}
+
+	status := snap.Requests[1]
+	// 没有收到响应的请求记为 error，4xx 和 5xx 响应也计为错误
+	if want := map[string]uint64{"200": 2, "503": 1, "error": 1}; !reflect.DeepEqual(status.StatusCodes, want) {
+		t.Errorf("status codes = %v, want %v", status.StatusCodes, want)
+	}
+	if status.Requests != 4 || status.Errors != 2 || status.Retries != 1 {
+		t.Errorf("requests %d, errors %d, retries %d, want 4, 2, 1", status.Requests, status.Errors, status.Retries)
+	}
+	if status.LastLatencyMs != 40 || status.AvgLatencyMs != 25 {
+		t.Errorf("last latency %v, average %v, want 40 and 25", status.LastLatencyMs, status.AvgLatencyMs)
+	}
+	if status.LastError != "connection refused" {
+		t.Errorf("last error = %q", status.LastError)
+	}
+
+	r.ObserveRequest("status", time.Millisecond, 404, nil)
+	if got := r.Snapshot().Requests[1].LastError; got != "HTTP 404" {
+		t.Errorf("last error = %q, want HTTP 404", got)
+	}
+
+	// 快照中的状态码是副本，之后的请求不会修改已经返回的快照
+	status.StatusCodes["200"] = 100
+	if got := r.Snapshot().Requests[1].StatusCodes["200"]; got != 2 {
+		t.Errorf("status code 200 count = %d after modifying a snapshot, want 2", got)
+	}
+
+	// 只有重试没有请求时平均值为 0
+	r.AddRetry("config")
+	if config := r.Snapshot().Requests[0]; config.Endpoint != "config" || config.Retries != 1 || config.AvgLatencyMs != 0 {
+		t.Errorf("config = %+v", config)
+	}
+}
+
+func TestSnapshotCollectors(t *testing.T) {
+	r := New()
+	r.ObserveCollector("disk", 4*time.Millisecond, nil)
+	r.ObserveCollector("cpu", time.Millisecond, nil)
+	r.ObserveCollector("disk", 2*time.Millisecond, errors.New("permission denied"))
+	r.ObserveCollector("disk", 3*time.Millisecond, nil)
+	r.InFlightAdd(2)
+	r.InFlightAdd(-1)
+	r.SetSpoolDepth(7)
+	r.AddDropped(3)
+	r.AddDropped(2)
+
+	snap := r.Snapshot()
+	if len(snap.Collectors) != 2 || snap.Collectors[0].Name != "cpu" {
+		t.Fatalf("collectors = %+v", snap.Collectors)
+	}
+	disk := snap.Collectors[1]
+	// 成功的采集不清除之前的错误
+	if disk.Runs != 3 || disk.Errors != 1 || disk.LastDurationMs != 3 || disk.AvgDurationMs != 3 || disk.LastError != "permission denied" {
+		t.Errorf("disk = %+v", disk)
+	}
+	if snap.InFlight != 1 || snap.SpoolDepth != 7 || snap.DroppedSamples != 5 {
+		t.Errorf("in flight %d, spool depth %d, dropped %d", snap.InFlight, snap.SpoolDepth, snap.DroppedSamples)
+	}
+	if snap.Process.Goroutines == 0 || snap.Process.HeapAllocBytes == 0 {
+		t.Errorf("process = %+v", snap.Process)
+	}
+}
+
+func TestRecorderConcurrent(t *testing.T) {
+	r := New()
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 100 {
+				r.ObserveRequest("status", time.Millisecond, 200, nil)
+				r.ObserveCollector("cpu", time.Millisecond, nil)
+				r.AddRetry("status")
+				r.Snapshot()
+			}
+		}()
+	}
+	wg.Wait()
+	if snap := r.Snapshot(); snap.Requests[0].Requests != 800 || snap.Requests[0].Retries != 800 || snap.Collectors[0].Runs != 800 {
+		t.Errorf("requests %d, collector runs %d, want 800", snap.Requests[0].Requests, snap.Collectors[0].Runs)
+	}
+}
@@ -0,0 +1,75 @@
+package textfile
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/xugou/agent/pkg/model"
+)
+
+// jsonMetric JSON 文件中的一条指标
+type jsonMetric struct {
+	Name   string            `json:"name"`
+	Type   string            `json:"type"`
+	Labels map[string]string `json:"labels"`
+	Value  *float64          `json:"value"`
+}
+
+// parseJSON 解析 JSON 文件，支持两种格式：指标数组 [{"name": ..., "value": ..., "labels": {...}, "type": ...}]，
+// 或者名称到数值的对象 {"名称": 数值}。没有指定类型的指标为 gauge
+func parseJSON(data []byte) ([]model.CustomMetric, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return nil, nil
+	}
+
+	var metrics []model.CustomMetric
+	switch data[0] {
+	case '[':
+		var list []jsonMetric
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&list); err != nil {
+			return nil, fmt.Errorf("无效的 JSON: %w", err)
+		}
+		for i, item := range list {
+			if item.Value == nil {
+				return nil, fmt.Errorf("第 %d 条指标 %s 缺少 value", i+1, item.Name)
+			}
+			typ := item.Type
+			if typ == "" {
+				typ = TypeGauge
+			} else if !types[typ] {
+				return nil, fmt.Errorf("第 %d 条指标 %s 的类型 %q 无效，可选: counter、gauge、histogram、summary、untyped", i+1, item.Name, typ)
+			}
+			if len(item.Labels) == 0 {
+				item.Labels = nil
+			}
+			metrics = append(metrics, model.CustomMetric{Name: item.Name, Type: typ, Labels: item.Labels, Value: *item.Value})
+		}
+	case '{':
+		var values map[string]float64
+		if err := json.Unmarshal(data, &values); err != nil {
+			return nil, fmt.Errorf("无效的 JSON，对象中的值必须是数字: %w", err)
+		}
+		names := make([]string, 0, len(values))
+		for name := range values {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			metrics = append(metrics, model.CustomMetric{Name: name, Type: TypeGauge, Value: values[name]})
+		}
+	default:
+		return nil, fmt.Errorf("JSON 文件必须是指标数组或者名称到数值的对象")
+	}
+
+	for _, m := range metrics {
+		if err := checkMetric(m); err != nil {
+			return nil, err
+		}
+	}
+	return metrics, nil
+}
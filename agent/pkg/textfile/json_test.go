@@ -0,0 +1,75 @@
+package textfile
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/xugou/agent/pkg/model"
+)
+
+func TestParseJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []model.CustomMetric
+	}{
+		{
+			name: "list",
+			data: `[
+				{"name": "backup_size_bytes", "value": 1024},
+				{"name": "jobs_total", "type": "counter", "labels": {"job": "backup"}, "value": 3},
+				{"name": "empty_labels", "labels": {}, "value": 0}
+			]`,
+			want: []model.CustomMetric{
+				{Name: "backup_size_bytes", Type: TypeGauge, Value: 1024},
+				{Name: "jobs_total", Type: TypeCounter, Labels: map[string]string{"job": "backup"}, Value: 3},
+				{Name: "empty_labels", Type: TypeGauge, Value: 0},
+			},
+		},
+		{
+			name: "object sorted by name",
+			data: `{"queue_b": 2, "queue_a": 1.5}`,
+			want: []model.CustomMetric{
+				{Name: "queue_a", Type: TypeGauge, Value: 1.5},
+				{Name: "queue_b", Type: TypeGauge, Value: 2},
+			},
+		},
+		{name: "empty", data: " \n"},
+		{name: "empty list", data: "[]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseJSON([]byte(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseJSON() =\n%+v\nwant\n%+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseJSONInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"not list or object", `"up"`, "必须是指标数组"},
+		{"syntax", `[{"name": "up", "value": 1}`, "无效的 JSON"},
+		{"unknown field", `[{"name": "up", "value": 1, "help": "x"}]`, "无效的 JSON"},
+		{"missing value", `[{"name": "up"}]`, "第 1 条指标 up 缺少 value"},
+		{"invalid type", `[{"name": "up", "type": "bool", "value": 1}]`, "类型 \"bool\" 无效"},
+		{"non-numeric object value", `{"up": "1"}`, "值必须是数字"},
+		{"invalid name", `{"up-time": 1}`, "无效的指标名称"},
+		{"invalid label", `[{"name": "up", "labels": {"a-b": "1"}, "value": 1}]`, "标签名称"},
+	}
+	for _, tt := range tests {
+		_, err := parseJSON([]byte(tt.data))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: parseJSON() error = %v, want containing %q", tt.name, err, tt.want)
+		}
+	}
+}
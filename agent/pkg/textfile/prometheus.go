@@ -0,0 +1,171 @@
+package textfile
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/xugou/agent/pkg/model"
+)
+
+// parsePrometheus 解析 Prometheus 文本格式：# TYPE 声明指标类型，# HELP 和其他注释被忽略，
+// 每个样本为 name{label="value",...} value。与 node_exporter 的 textfile 一样不支持时间戳
+func parsePrometheus(data []byte) ([]model.CustomMetric, error) {
+	declared := make(map[string]string)
+	var metrics []model.CustomMetric
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if line[0] == '#' {
+			if err := parseComment(line[1:], declared); err != nil {
+				return nil, fmt.Errorf("第 %d 行: %w", i+1, err)
+			}
+			continue
+		}
+
+		m, err := parseSample(line)
+		if err == nil {
+			m.Type = sampleType(m.Name, declared)
+			err = checkMetric(m)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("第 %d 行: %w", i+1, err)
+		}
+		metrics = append(metrics, m)
+	}
+	return metrics, nil
+}
+
+// parseComment 处理 # TYPE 声明，其他注释被忽略
+func parseComment(text string, declared map[string]string) error {
+	fields := strings.Fields(text)
+	if len(fields) == 0 || fields[0] != "TYPE" {
+		return nil
+	}
+	if len(fields) != 3 || !nameRe.MatchString(fields[1]) || !types[fields[2]] {
+		return fmt.Errorf("无效的 TYPE 声明，格式为 # TYPE <名称> counter|gauge|histogram|summary|untyped")
+	}
+	if _, ok := declared[fields[1]]; ok {
+		return fmt.Errorf("指标 %s 的 TYPE 重复声明", fields[1])
+	}
+	declared[fields[1]] = fields[2]
+	return nil
+}
+
+// sampleType 返回样本的类型，histogram 和 summary 的 _bucket、_sum、_count 样本使用所属指标的类型
+func sampleType(name string, declared map[string]string) string {
+	if typ, ok := declared[name]; ok {
+		return typ
+	}
+	for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+		base, ok := strings.CutSuffix(name, suffix)
+		if !ok {
+			continue
+		}
+		if typ := declared[base]; typ == TypeHistogram || (typ == TypeSummary && suffix != "_bucket") {
+			return typ
+		}
+	}
+	return TypeUntyped
+}
+
+// parseSample 解析一行样本
+func parseSample(line string) (model.CustomMetric, error) {
+	var m model.CustomMetric
+	end := strings.IndexAny(line, "{ \t")
+	if end < 0 {
+		return m, fmt.Errorf("缺少指标的值")
+	}
+	m.Name, line = line[:end], line[end:]
+	if line[0] == '{' {
+		labels, rest, err := parseLabels(line[1:])
+		if err != nil {
+			return m, fmt.Errorf("指标 %s: %w", m.Name, err)
+		}
+		m.Labels, line = labels, rest
+	}
+
+	fields := strings.Fields(line)
+	switch len(fields) {
+	case 0:
+		return m, fmt.Errorf("指标 %s 缺少值", m.Name)
+	case 1:
+	case 2:
+		return m, fmt.Errorf("指标 %s 带有时间戳，文本文件中的指标不支持时间戳", m.Name)
+	default:
+		return m, fmt.Errorf("指标 %s 的值后面有多余的内容", m.Name)
+	}
+	value, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return m, fmt.Errorf("指标 %s 的值 %q 不是数字", m.Name, fields[0])
+	}
+	m.Value = value
+	return m, nil
+}
+
+// parseLabels 解析 { 之后的标签，返回标签和 } 之后的内容
+func parseLabels(s string) (map[string]string, string, error) {
+	labels := make(map[string]string)
+	for {
+		s = strings.TrimLeft(s, " \t")
+		if strings.HasPrefix(s, "}") {
+			if len(labels) == 0 {
+				labels = nil
+			}
+			return labels, s[1:], nil
+		}
+		eq := strings.IndexByte(s, '=')
+		if eq < 0 {
+			return nil, "", fmt.Errorf("标签不完整")
+		}
+		name := strings.TrimSpace(s[:eq])
+		s = strings.TrimLeft(s[eq+1:], " \t")
+		if !strings.HasPrefix(s, `"`) {
+			return nil, "", fmt.Errorf("标签 %s 的值需要使用双引号", name)
+		}
+		value, rest, err := parseLabelValue(s[1:])
+		if err != nil {
+			return nil, "", fmt.Errorf("标签 %s: %w", name, err)
+		}
+		if _, ok := labels[name]; ok {
+			return nil, "", fmt.Errorf("标签 %s 重复", name)
+		}
+		labels[name] = value
+
+		s = strings.TrimLeft(rest, " \t")
+		switch {
+		case strings.HasPrefix(s, ","):
+			s = s[1:]
+		case !strings.HasPrefix(s, "}"):
+			return nil, "", fmt.Errorf("标签之间需要使用逗号分隔")
+		}
+	}
+}
+
+// parseLabelValue 解析开头的双引号之后的标签值，支持 \\、\" 和 \n 转义，返回值和结束的双引号之后的内容
+func parseLabelValue(s string) (string, string, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"':
+			return b.String(), s[i+1:], nil
+		case '\\':
+			if i++; i == len(s) {
+				return "", "", fmt.Errorf("值缺少结束的双引号")
+			}
+			switch s[i] {
+			case '\\', '"':
+				b.WriteByte(s[i])
+			case 'n':
+				b.WriteByte('\n')
+			default:
+				return "", "", fmt.Errorf("无效的转义 \\%c", s[i])
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", "", fmt.Errorf("值缺少结束的双引号")
+}
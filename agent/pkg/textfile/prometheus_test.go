@@ -0,0 +1,121 @@
+package textfile
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/xugou/agent/pkg/model"
+)
+
+func TestParsePrometheus(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []model.CustomMetric
+	}{
+		{
+			name: "types and comments",
+			data: `# HELP backup_size_bytes 最近一次备份的大小
+# TYPE backup_size_bytes gauge
+backup_size_bytes 1.5e+09
+# TYPE jobs_total counter
+jobs_total{job="backup"} 12
+other_value -3
+`,
+			want: []model.CustomMetric{
+				{Name: "backup_size_bytes", Type: TypeGauge, Value: 1.5e9},
+				{Name: "jobs_total", Type: TypeCounter, Labels: map[string]string{"job": "backup"}, Value: 12},
+				{Name: "other_value", Type: TypeUntyped, Value: -3},
+			},
+		},
+		{
+			name: "histogram and summary samples",
+			data: `# TYPE latency histogram
+latency_bucket{le="0.1"} 3
+latency_bucket{le="+Inf"} 5
+latency_sum 0.7
+latency_count 5
+# TYPE size summary
+size{quantile="0.5"} 10
+size_sum 30
+size_count 2
+size_bucket 1
+`,
+			want: []model.CustomMetric{
+				{Name: "latency_bucket", Type: TypeHistogram, Labels: map[string]string{"le": "0.1"}, Value: 3},
+				{Name: "latency_bucket", Type: TypeHistogram, Labels: map[string]string{"le": "+Inf"}, Value: 5},
+				{Name: "latency_sum", Type: TypeHistogram, Value: 0.7},
+				{Name: "latency_count", Type: TypeHistogram, Value: 5},
+				{Name: "size", Type: TypeSummary, Labels: map[string]string{"quantile": "0.5"}, Value: 10},
+				{Name: "size_sum", Type: TypeSummary, Value: 30},
+				{Name: "size_count", Type: TypeSummary, Value: 2},
+				// summary 没有 _bucket 样本
+				{Name: "size_bucket", Type: TypeUntyped, Value: 1},
+			},
+		},
+		{
+			name: "label escapes and spacing",
+			data: "queue_length{ queue = \"mail\\\\in\" , note=\"say \\\"hi\\\"\\nbye\",} \t7\n\n",
+			want: []model.CustomMetric{
+				{Name: "queue_length", Type: TypeUntyped, Labels: map[string]string{"queue": `mail\in`, "note": "say \"hi\"\nbye"}, Value: 7},
+			},
+		},
+		{
+			name: "empty labels",
+			data: "up{} 1\n",
+			want: []model.CustomMetric{{Name: "up", Type: TypeUntyped, Value: 1}},
+		},
+		{
+			name: "empty file",
+			data: "\n# only a comment\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parsePrometheus([]byte(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parsePrometheus() =\n%+v\nwant\n%+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePrometheusInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"missing value", "up\n", "缺少指标的值"},
+		{"timestamp", "up 1 1700000000000\n", "时间戳"},
+		{"extra fields", "up 1 2 3\n", "多余的内容"},
+		{"not a number", "up one\n", "不是数字"},
+		{"NaN", "up NaN\n", "NaN 或 Inf"},
+		{"invalid name", "1up 1\n", "无效的指标名称"},
+		{"reserved prefix", "xugou_up 1\n", "保留的前缀"},
+		{"invalid label name", `up{__name="a"} 1` + "\n", "标签名称"},
+		{"unquoted label", "up{a=b} 1\n", "双引号"},
+		{"unterminated label", `up{a="b} 1` + "\n", "结束的双引号"},
+		{"bad escape", `up{a="\t"} 1` + "\n", "无效的转义"},
+		{"duplicate label", `up{a="1",a="2"} 1` + "\n", "标签 a 重复"},
+		{"missing comma", `up{a="1" b="2"} 1` + "\n", "逗号"},
+		{"incomplete labels", `up{a` + "\n", "标签不完整"},
+		{"long label value", `up{a="` + strings.Repeat("x", MaxValueLength+1) + `"} 1` + "\n", "超过 256 个字符"},
+		{"bad TYPE", "# TYPE up boolean\n", "无效的 TYPE 声明"},
+		{"duplicate TYPE", "# TYPE ok counter\n", "重复声明"},
+	}
+	for _, tt := range tests {
+		_, err := parsePrometheus([]byte("# TYPE ok gauge\nok 1\n" + tt.data))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: parsePrometheus() error = %v, want containing %q", tt.name, err, tt.want)
+		}
+		// 错误信息带有行号
+		if err != nil && !strings.HasPrefix(err.Error(), "第 3 行") {
+			t.Errorf("%s: error %q does not start with the line number", tt.name, err)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package textfile
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/xugou/agent/pkg/model"
+)
+
+// DefaultMaxAge 文件超过多长时间没有更新时标记为过期
+const DefaultMaxAge = time.Hour
+
+// 文本文件的限制，避免异常的文件占用过多内存或让上报的数据过大
+const (
+	MaxFileSize    = 1 << 20 // 单个文件的最大字节数
+	MaxMetrics     = 10000   // 所有文件合计的最大指标数量
+	MaxLabels      = 32      // 单条指标的最大标签数量
+	MaxValueLength = 256     // 标签值的最大长度
+)
+
+// 指标类型
+const (
+	TypeCounter   = "counter"
+	TypeGauge     = "gauge"
+	TypeHistogram = "histogram"
+	TypeSummary   = "summary"
+	TypeUntyped   = "untyped"
+)
+
+var types = map[string]bool{TypeCounter: true, TypeGauge: true, TypeHistogram: true, TypeSummary: true, TypeUntyped: true}
+
+var (
+	nameRe  = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	labelRe = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+// Read 读取目录中的 *.prom（Prometheus 文本格式）和 *.json 文件，不包括子目录和以点开头的文件，
+// 返回所有文件中的指标和每个文件的状态。文件有任何错误时其中的指标都不返回，错误记录在文件的状态中；
+// 超过 maxAge 没有修改的文件标记为过期，其中的指标仍然返回，maxAge 为 0 时不检查。只有目录无法读取时才返回错误
+func Read(dir string, maxAge time.Duration) ([]model.CustomMetric, []model.TextfileStatus, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, nil, fmt.Errorf("读取文本文件目录失败: %w", err)
+	}
+
+	now := time.Now()
+	set := newMetricSet()
+	var statuses []model.TextfileStatus
+	for _, entry := range entries {
+		name := entry.Name()
+		ext := filepath.Ext(name)
+		if entry.IsDir() || strings.HasPrefix(name, ".") || (ext != ".prom" && ext != ".json") {
+			continue
+		}
+
+		status := model.TextfileStatus{File: name}
+		metrics, modTime, err := readFile(filepath.Join(dir, name), ext)
+		if !modTime.IsZero() {
+			age := now.Sub(modTime)
+			status.ModTime = modTime
+			status.AgeSeconds = math.Max(math.Round(age.Seconds()), 0)
+			status.Stale = maxAge > 0 && age > maxAge
+		}
+		if err == nil {
+			for i := range metrics {
+				metrics[i].Source = name
+			}
+			err = set.add(metrics)
+		}
+		if err != nil {
+			status.Error = err.Error()
+		} else {
+			status.Metrics = len(metrics)
+		}
+		statuses = append(statuses, status)
+	}
+	return set.metrics, statuses, nil
+}
+
+// readFile 读取并解析一个文件，返回其中的指标和修改时间
+func readFile(path, ext string) ([]model.CustomMetric, time.Time, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	if !stat.Mode().IsRegular() {
+		return nil, stat.ModTime(), fmt.Errorf("不是普通文件")
+	}
+	data, err := io.ReadAll(io.LimitReader(f, MaxFileSize+1))
+	if err != nil {
+		return nil, stat.ModTime(), err
+	}
+	if len(data) > MaxFileSize {
+		return nil, stat.ModTime(), fmt.Errorf("文件超过 %d 字节", MaxFileSize)
+	}
+	if !utf8.Valid(data) {
+		return nil, stat.ModTime(), fmt.Errorf("文件不是有效的 UTF-8 文本")
+	}
+
+	var metrics []model.CustomMetric
+	if ext == ".json" {
+		metrics, err = parseJSON(data)
+	} else {
+		metrics, err = parsePrometheus(data)
+	}
+	return metrics, stat.ModTime(), err
+}
+
+// checkMetric 校验指标名称和标签
+func checkMetric(m model.CustomMetric) error {
+	if !nameRe.MatchString(m.Name) {
+		return fmt.Errorf("无效的指标名称 %q", m.Name)
+	}
+	if strings.HasPrefix(m.Name, "xugou_") {
+		return fmt.Errorf("指标名称 %s 使用了 Agent 保留的前缀 xugou_", m.Name)
+	}
+	if len(m.Labels) > MaxLabels {
+		return fmt.Errorf("指标 %s 的标签超过 %d 个", m.Name, MaxLabels)
+	}
+	for name, value := range m.Labels {
+		if !labelRe.MatchString(name) || strings.HasPrefix(name, "__") {
+			return fmt.Errorf("指标 %s 的标签名称 %q 无效", m.Name, name)
+		}
+		if len(value) > MaxValueLength {
+			return fmt.Errorf("指标 %s 的标签 %s 超过 %d 个字符", m.Name, name, MaxValueLength)
+		}
+	}
+	if math.IsNaN(m.Value) || math.IsInf(m.Value, 0) {
+		return fmt.Errorf("指标 %s 的值不能是 NaN 或 Inf", m.Name)
+	}
+	return nil
+}
+
+// metricSet 合并各文件中的指标，检查序列重复、类型冲突和数量限制
+type metricSet struct {
+	metrics []model.CustomMetric
+	series  map[string]string // 序列名称到来源文件
+	types   map[string]string // 指标名称到类型
+}
+
+func newMetricSet() *metricSet {
+	return &metricSet{series: make(map[string]string), types: make(map[string]string)}
+}
+
+// add 加入一个文件中的指标，有错误时整个文件的指标都不加入
+func (s *metricSet) add(metrics []model.CustomMetric) error {
+	if len(s.metrics)+len(metrics) > MaxMetrics {
+		return fmt.Errorf("所有文件合计超过 %d 条指标", MaxMetrics)
+	}
+	seen := make(map[string]bool, len(metrics))
+	fileTypes := make(map[string]string)
+	for _, m := range metrics {
+		series := m.Series()
+		if seen[series] {
+			return fmt.Errorf("指标 %s 重复", series)
+		}
+		seen[series] = true
+		if source, ok := s.series[series]; ok {
+			return fmt.Errorf("指标 %s 与 %s 中的重复", series, source)
+		}
+		typ, ok := fileTypes[m.Name]
+		if !ok {
+			typ, ok = s.types[m.Name]
+		}
+		if ok && typ != m.Type {
+			return fmt.Errorf("指标 %s 的类型 %s 与之前的 %s 不一致", m.Name, m.Type, typ)
+		}
+		fileTypes[m.Name] = m.Type
+	}
+
+	for _, m := range metrics {
+		s.series[m.Series()] = m.Source
+		s.types[m.Name] = m.Type
+	}
+	s.metrics = append(s.metrics, metrics...)
+	return nil
+}
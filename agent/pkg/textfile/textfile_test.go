@@ -0,0 +1,209 @@
+package textfile
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xugou/agent/pkg/model"
+)
+
+// writeFiles 在临时目录中写入文件，返回目录
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestRead(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"backup.prom":    "# TYPE backup_size_bytes gauge\nbackup_size_bytes{host=\"db\"} 1024\n",
+		"queue.json":     `{"queue_length": 3}`,
+		"broken.prom":    "up 1 1700000000\n",
+		"conflict.json":  `[{"name": "backup_size_bytes", "type": "counter", "labels": {"host": "web"}, "value": 1}]`,
+		"duplicate.prom": "queue_length 4\n",
+		"binary.prom":    "up \xff\n",
+		".hidden.prom":   "hidden 1\n",
+		"notes.txt":      "ignored 1\n",
+		"big.prom":       strings.Repeat("#", MaxFileSize+1),
+	})
+	if err := os.Mkdir(filepath.Join(dir, "sub.prom"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	metrics, statuses, err := Read(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, m := range metrics {
+		got = append(got, fmt.Sprintf("%s %s %g %s", m.Series(), m.Type, m.Value, m.Source))
+	}
+	want := []string{
+		"backup_size_bytes{host=db} gauge 1024 backup.prom",
+		"queue_length untyped 4 duplicate.prom",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("metrics =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	// 按文件名排序，跳过目录、隐藏文件和其他扩展名的文件。duplicate.prom 先于 queue.json 加入，
+	// 因此 queue.json 中的指标与它重复
+	wantStatus := []struct {
+		file    string
+		metrics int
+		error   string
+	}{
+		{"backup.prom", 1, ""},
+		{"big.prom", 0, "文件超过"},
+		{"binary.prom", 0, "UTF-8"},
+		{"broken.prom", 0, "时间戳"},
+		{"conflict.json", 0, "类型 counter 与之前的 gauge 不一致"},
+		{"duplicate.prom", 1, ""},
+		{"queue.json", 0, "与 duplicate.prom 中的重复"},
+	}
+	if len(statuses) != len(wantStatus) {
+		t.Fatalf("statuses = %+v", statuses)
+	}
+	for i, s := range statuses {
+		w := wantStatus[i]
+		if s.File != w.file || s.Metrics != w.metrics || (w.error == "") != (s.Error == "") || !strings.Contains(s.Error, w.error) {
+			t.Errorf("status %d = %+v, want file %s, %d metrics, error containing %q", i, s, w.file, w.metrics, w.error)
+		}
+		if s.ModTime.IsZero() || s.Stale {
+			t.Errorf("status %s: mtime %s, stale %v", s.File, s.ModTime, s.Stale)
+		}
+	}
+
+	if _, _, err := Read(filepath.Join(dir, "missing"), 0); err == nil {
+		t.Error("Read() of a missing directory succeeded")
+	}
+}
+
+func TestReadStale(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"old.prom": "old_value 1\n", "new.prom": "new_value 2\n"})
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, "old.prom"), old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		maxAge time.Duration
+		stale  map[string]bool
+	}{
+		{DefaultMaxAge, map[string]bool{"old.prom": true, "new.prom": false}},
+		{3 * time.Hour, map[string]bool{"old.prom": false, "new.prom": false}},
+		{0, map[string]bool{"old.prom": false, "new.prom": false}},
+	}
+	for _, tt := range tests {
+		metrics, statuses, err := Read(dir, tt.maxAge)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// 过期文件中的指标仍然返回
+		if len(metrics) != 2 {
+			t.Errorf("max age %s: %d metrics, want 2", tt.maxAge, len(metrics))
+		}
+		for _, s := range statuses {
+			if s.Stale != tt.stale[s.File] {
+				t.Errorf("max age %s: %s stale = %v", tt.maxAge, s.File, s.Stale)
+			}
+			if s.File == "old.prom" && (s.AgeSeconds < 7199 || s.AgeSeconds > 7300) {
+				t.Errorf("old.prom age = %g seconds", s.AgeSeconds)
+			}
+		}
+	}
+}
+
+func TestMetricSetAdd(t *testing.T) {
+	gauge := func(name string, labels ...string) model.CustomMetric {
+		m := model.CustomMetric{Name: name, Type: TypeGauge, Source: "a.prom"}
+		for i := 0; i+1 < len(labels); i += 2 {
+			if m.Labels == nil {
+				m.Labels = make(map[string]string)
+			}
+			m.Labels[labels[i]] = labels[i+1]
+		}
+		return m
+	}
+	counter := func(name string) model.CustomMetric {
+		return model.CustomMetric{Name: name, Type: TypeCounter, Source: "a.prom"}
+	}
+	fillers := make([]model.CustomMetric, MaxMetrics)
+	for i := range fillers {
+		fillers[i] = gauge("filler", "i", fmt.Sprint(i))
+	}
+
+	tests := []struct {
+		name  string
+		files [][]model.CustomMetric
+		want  []string // 每个文件的错误，为空表示成功
+	}{
+		{
+			name:  "different labels",
+			files: [][]model.CustomMetric{{gauge("up", "a", "1"), gauge("up", "a", "2")}, {gauge("up", "a", "3")}},
+			want:  []string{"", ""},
+		},
+		{
+			name:  "duplicate in file",
+			files: [][]model.CustomMetric{{gauge("up", "a", "1", "b", "2"), gauge("up", "b", "2", "a", "1")}},
+			want:  []string{"指标 up{a=1,b=2} 重复"},
+		},
+		{
+			name:  "duplicate across files",
+			files: [][]model.CustomMetric{{gauge("up")}, {gauge("down"), gauge("up")}},
+			want:  []string{"", "指标 up 与 a.prom 中的重复"},
+		},
+		{
+			name:  "type conflict in file",
+			files: [][]model.CustomMetric{{gauge("up", "a", "1"), counter("up")}},
+			want:  []string{"类型 counter 与之前的 gauge 不一致"},
+		},
+		{
+			name:  "type conflict across files",
+			files: [][]model.CustomMetric{{counter("up")}, {gauge("up", "a", "1")}},
+			want:  []string{"", "类型 gauge 与之前的 counter 不一致"},
+		},
+		{
+			// 失败的文件不影响之后的文件
+			name:  "failed file is not added",
+			files: [][]model.CustomMetric{{gauge("up"), gauge("up")}, {counter("up")}},
+			want:  []string{"重复", ""},
+		},
+		{
+			name:  "too many metrics",
+			files: [][]model.CustomMetric{fillers, {gauge("up")}},
+			want:  []string{"", "超过 10000 条指标"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			set := newMetricSet()
+			added := 0
+			for i, metrics := range tt.files {
+				err := set.add(metrics)
+				switch {
+				case tt.want[i] == "" && err != nil:
+					t.Errorf("file %d: add() error = %v", i, err)
+				case tt.want[i] != "" && (err == nil || !strings.Contains(err.Error(), tt.want[i])):
+					t.Errorf("file %d: add() error = %v, want containing %q", i, err, tt.want[i])
+				}
+				if err == nil {
+					added += len(metrics)
+				}
+			}
+			if len(set.metrics) != added {
+				t.Errorf("set has %d metrics, want %d", len(set.metrics), added)
+			}
+		})
+	}
+}
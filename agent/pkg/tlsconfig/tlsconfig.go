@@ -0,0 +1,241 @@
+package tlsconfig
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Options 与服务器通信时使用的 TLS 配置
+type Options struct {
+	CAFile             string   // 额外信任的 CA 证书（PEM），与系统 CA 一起使用
+	CertFile           string   // 双向 TLS 使用的客户端证书
+	KeyFile            string   // 客户端证书的私钥
+	MinVersion         string   // 最低 TLS 版本: 1.2 或 1.3
+	Pins               []string // 证书链中任一证书的 SPKI SHA-256 指纹（base64），例如 sha256/AAAA...=
+	InsecureSkipVerify bool     // 不校验服务器证书，仅用于测试环境
+}
+
+// ErrPinMismatch 服务器证书与配置的指纹都不匹配
+var ErrPinMismatch = errors.New("服务器证书与配置的指纹不匹配")
+
+// checkInterval 检查证书文件是否变化的最短间隔
+const checkInterval = 30 * time.Second
+
+// Loader 加载 CA 和客户端证书，文件变化后自动重新加载
+type Loader struct {
+	opts       Options
+	minVersion uint16
+	pins       map[string]bool
+
+	mu        sync.Mutex
+	roots     *x509.CertPool
+	cert      *tls.Certificate
+	modTimes  map[string]time.Time
+	lastCheck time.Time
+
+	// OnReload 证书重新加载后调用，可以用来关闭已有的连接
+	OnReload func()
+}
+
+// Enabled 判断是否设置了任何 TLS 选项
+func (o Options) Enabled() bool {
+	return o.CAFile != "" || o.CertFile != "" || o.KeyFile != "" || o.MinVersion != "" || len(o.Pins) > 0 || o.InsecureSkipVerify
+}
+
+// NewLoader 校验配置并加载证书
+func NewLoader(opts Options) (*Loader, error) {
+	l := &Loader{opts: opts, pins: make(map[string]bool), modTimes: make(map[string]time.Time)}
+
+	switch opts.MinVersion {
+	case "", "1.2":
+		l.minVersion = tls.VersionTLS12
+	case "1.3":
+		l.minVersion = tls.VersionTLS13
+	default:
+		return nil, fmt.Errorf("不支持的 TLS 版本 %q，可选 1.2 或 1.3", opts.MinVersion)
+	}
+	if (opts.CertFile == "") != (opts.KeyFile == "") {
+		return nil, errors.New("客户端证书和私钥需要同时设置")
+	}
+	for _, pin := range opts.Pins {
+		hash, err := ParsePin(pin)
+		if err != nil {
+			return nil, err
+		}
+		l.pins[hash] = true
+	}
+
+	if err := l.load(); err != nil {
+		return nil, err
+	}
+	l.lastCheck = time.Now()
+	return l, nil
+}
+
+// ParsePin 解析 SPKI 指纹，支持 sha256/BASE64、sha256//BASE64 和 BASE64 三种写法
+func ParsePin(pin string) (string, error) {
+	hash := strings.TrimPrefix(strings.TrimPrefix(strings.TrimSpace(pin), "sha256/"), "/")
+	data, err := base64.StdEncoding.DecodeString(hash)
+	if err != nil || len(data) != sha256.Size {
+		return "", fmt.Errorf("无效的证书指纹 %q，需要 base64 编码的 SHA-256", pin)
+	}
+	return hash, nil
+}
+
+// SPKIPin 计算证书公钥的 SPKI SHA-256 指纹
+func SPKIPin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// load 读取所有证书文件，失败时保留之前加载的证书
+func (l *Loader) load() error {
+	roots, err := x509.SystemCertPool()
+	if err != nil {
+		roots = x509.NewCertPool()
+	}
+	if l.opts.CAFile != "" {
+		data, err := os.ReadFile(l.opts.CAFile)
+		if err != nil {
+			return fmt.Errorf("读取 CA 证书失败: %w", err)
+		}
+		if !roots.AppendCertsFromPEM(data) {
+			return fmt.Errorf("CA 证书 %s 中没有有效的 PEM 证书", l.opts.CAFile)
+		}
+	}
+
+	var cert *tls.Certificate
+	if l.opts.CertFile != "" {
+		c, err := tls.LoadX509KeyPair(l.opts.CertFile, l.opts.KeyFile)
+		if err != nil {
+			return fmt.Errorf("加载客户端证书失败: %w", err)
+		}
+		cert = &c
+	}
+
+	l.mu.Lock()
+	l.roots = roots
+	l.cert = cert
+	for _, path := range l.files() {
+		if info, err := os.Stat(path); err == nil {
+			l.modTimes[path] = info.ModTime()
+		}
+	}
+	l.mu.Unlock()
+	return nil
+}
+
+func (l *Loader) files() []string {
+	var files []string
+	for _, path := range []string{l.opts.CAFile, l.opts.CertFile, l.opts.KeyFile} {
+		if path != "" {
+			files = append(files, path)
+		}
+	}
+	return files
+}
+
+// reloadIfChanged 证书文件的修改时间变化后重新加载
+func (l *Loader) reloadIfChanged() {
+	l.mu.Lock()
+	if time.Since(l.lastCheck) < checkInterval {
+		l.mu.Unlock()
+		return
+	}
+	l.lastCheck = time.Now()
+	changed := false
+	for _, path := range l.files() {
+		info, err := os.Stat(path)
+		if err != nil {
+			// 文件被删除或暂时无法访问时继续使用之前的证书
+			slog.Warn("无法检查证书文件，继续使用之前的证书", "path", path, "error", err)
+			continue
+		}
+		if !info.ModTime().Equal(l.modTimes[path]) {
+			changed = true
+		}
+	}
+	l.mu.Unlock()
+
+	if !changed {
+		return
+	}
+	if err := l.load(); err != nil {
+		slog.Error("重新加载证书失败，继续使用之前的证书", "error", err)
+		return
+	}
+	slog.Info("证书文件已变化，已重新加载")
+	if l.OnReload != nil {
+		l.OnReload()
+	}
+}
+
+// Config 返回用于客户端连接的 TLS 配置，每次握手都会使用最新加载的证书
+func (l *Loader) Config() *tls.Config {
+	return &tls.Config{
+		MinVersion: l.minVersion,
+		// 证书校验在 VerifyConnection 中完成，以便使用重新加载后的 CA 并检查指纹
+		InsecureSkipVerify: true,
+		VerifyConnection:   l.verify,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			l.reloadIfChanged()
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			if l.cert == nil {
+				// 没有配置客户端证书时发送空证书，由服务器决定是否拒绝
+				return &tls.Certificate{}, nil
+			}
+			return l.cert, nil
+		},
+	}
+}
+
+// verify 校验服务器证书链和指纹
+func (l *Loader) verify(cs tls.ConnectionState) error {
+	l.reloadIfChanged()
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("服务器没有提供证书")
+	}
+
+	chains := [][]*x509.Certificate{cs.PeerCertificates}
+	if !l.opts.InsecureSkipVerify {
+		l.mu.Lock()
+		roots := l.roots
+		l.mu.Unlock()
+
+		intermediates := x509.NewCertPool()
+		for _, cert := range cs.PeerCertificates[1:] {
+			intermediates.AddCert(cert)
+		}
+		verified, err := cs.PeerCertificates[0].Verify(x509.VerifyOptions{
+			DNSName:       cs.ServerName,
+			Roots:         roots,
+			Intermediates: intermediates,
+		})
+		if err != nil {
+			return err
+		}
+		chains = verified
+	}
+
+	if len(l.pins) == 0 {
+		return nil
+	}
+	for _, chain := range chains {
+		for _, cert := range chain {
+			if l.pins[SPKIPin(cert)] {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("%w，服务器证书指纹为 sha256/%s", ErrPinMismatch, SPKIPin(cs.PeerCertificates[0]))
+}
@@ -0,0 +1,400 @@
+package tlsconfig
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"log"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCert 测试使用的证书和私钥
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func (c *testCert) pem() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw})
+}
+
+func (c *testCert) keyPEM(t *testing.T) []byte {
+	t.Helper()
+	der, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+}
+
+// newCert 签发证书，parent 为空时生成自签名的 CA 证书
+func newCert(t *testing.T, name string, parent *testCert, isCA bool, usage x509.ExtKeyUsage) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+	if isCA {
+		tmpl.KeyUsage = x509.KeyUsageCertSign
+	} else {
+		tmpl.KeyUsage = x509.KeyUsageDigitalSignature
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{usage}
+		tmpl.DNSNames = []string{"localhost"}
+		tmpl.IPAddresses = []net.IP{net.IPv4(127, 0, 0, 1)}
+	}
+	signerCert, signerKey := tmpl, key
+	if parent != nil {
+		signerCert, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signerCert, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: cert, key: key}
+}
+
+// testPKI 根证书、中间证书和由中间证书签发的服务器证书
+type testPKI struct {
+	root, intermediate, leaf *testCert
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	t.Helper()
+	root := newCert(t, "Test Root CA", nil, true, 0)
+	intermediate := newCert(t, "Test Intermediate CA", root, true, 0)
+	leaf := newCert(t, "localhost", intermediate, false, x509.ExtKeyUsageServerAuth)
+	return &testPKI{root: root, intermediate: intermediate, leaf: leaf}
+}
+
+// startServer 启动发送服务器证书和中间证书的 HTTPS 服务器，configure 可以修改服务器的 TLS 配置
+func (p *testPKI) startServer(t *testing.T, configure func(*tls.Config)) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) > 0 {
+			w.Header().Set("X-Client", r.TLS.PeerCertificates[0].Subject.CommonName)
+		}
+	}))
+	// 握手失败是测试的预期结果，不输出服务器日志
+	srv.Config.ErrorLog = log.New(io.Discard, "", 0)
+	srv.TLS = &tls.Config{Certificates: []tls.Certificate{{
+		Certificate: [][]byte{p.leaf.cert.Raw, p.intermediate.cert.Raw},
+		PrivateKey:  p.leaf.key,
+	}}}
+	if configure != nil {
+		configure(srv.TLS)
+	}
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func writeFile(t *testing.T, dir, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// get 使用 Loader 的配置请求 url
+func get(l *Loader, url string) (*http.Response, error) {
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: l.Config()}}
+	defer client.CloseIdleConnections()
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return resp, nil
+}
+
+func TestVerifyCA(t *testing.T) {
+	pki := newTestPKI(t)
+	srv := pki.startServer(t, nil)
+	dir := t.TempDir()
+
+	// 没有配置 CA 时不信任测试根证书
+	l, err := NewLoader(Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var unknown x509.UnknownAuthorityError
+	if _, err := get(l, srv.URL); !errors.As(err, &unknown) {
+		t.Errorf("request without ca_file = %v, want an unknown authority error", err)
+	}
+
+	l, err = NewLoader(Options{CAFile: writeFile(t, dir, "ca.pem", pki.root.pem())})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := get(l, srv.URL); err != nil {
+		t.Errorf("request with ca_file = %v", err)
+	}
+
+	// 其他 CA 签发的证书不被信任
+	other := newTestPKI(t)
+	l, err = NewLoader(Options{CAFile: writeFile(t, dir, "other.pem", other.root.pem())})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := get(l, srv.URL); err == nil {
+		t.Error("request trusting another CA succeeded")
+	}
+
+	// insecure_skip_verify 时不校验证书链
+	l, err = NewLoader(Options{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := get(l, srv.URL); err != nil {
+		t.Errorf("request with insecure_skip_verify = %v", err)
+	}
+}
+
+func TestVerifyPins(t *testing.T) {
+	pki := newTestPKI(t)
+	srv := pki.startServer(t, nil)
+	caFile := writeFile(t, t.TempDir(), "ca.pem", pki.root.pem())
+	other := newCert(t, "other", nil, true, 0)
+
+	tests := []struct {
+		name string
+		pins []string
+		ok   bool
+	}{
+		{"leaf", []string{"sha256/" + SPKIPin(pki.leaf.cert)}, true},
+		{"intermediate", []string{"sha256//" + SPKIPin(pki.intermediate.cert)}, true},
+		// 根证书不由服务器发送，但在校验后的证书链中
+		{"root", []string{SPKIPin(pki.root.cert)}, true},
+		{"one of several", []string{SPKIPin(other.cert), SPKIPin(pki.intermediate.cert)}, true},
+		{"mismatch", []string{"sha256/" + SPKIPin(other.cert)}, false},
+	}
+	for _, tt := range tests {
+		l, err := NewLoader(Options{CAFile: caFile, Pins: tt.pins})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = get(l, srv.URL)
+		if tt.ok && err != nil {
+			t.Errorf("%s: request = %v", tt.name, err)
+		}
+		if !tt.ok && (!errors.Is(err, ErrPinMismatch) || !strings.Contains(err.Error(), SPKIPin(pki.leaf.cert))) {
+			t.Errorf("%s: request = %v, want ErrPinMismatch with the server pin", tt.name, err)
+		}
+	}
+
+	// 跳过证书链校验时只检查服务器发送的证书
+	l, err := NewLoader(Options{InsecureSkipVerify: true, Pins: []string{SPKIPin(pki.intermediate.cert)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := get(l, srv.URL); err != nil {
+		t.Errorf("insecure request with an intermediate pin = %v", err)
+	}
+	l, err = NewLoader(Options{InsecureSkipVerify: true, Pins: []string{SPKIPin(pki.root.cert)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := get(l, srv.URL); !errors.Is(err, ErrPinMismatch) {
+		t.Errorf("insecure request with a root pin = %v, want ErrPinMismatch", err)
+	}
+}
+
+func TestMinVersion(t *testing.T) {
+	pki := newTestPKI(t)
+	srv := pki.startServer(t, func(c *tls.Config) { c.MaxVersion = tls.VersionTLS12 })
+	caFile := writeFile(t, t.TempDir(), "ca.pem", pki.root.pem())
+
+	l, err := NewLoader(Options{CAFile: caFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp, err := get(l, srv.URL); err != nil || resp.TLS.Version != tls.VersionTLS12 {
+		t.Errorf("request to a TLS 1.2 server = %v", err)
+	}
+
+	l, err = NewLoader(Options{CAFile: caFile, MinVersion: "1.3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := get(l, srv.URL); err == nil {
+		t.Error("request with min_version 1.3 to a TLS 1.2 server succeeded")
+	}
+	if resp, err := get(l, pki.startServer(t, nil).URL); err != nil || resp.TLS.Version != tls.VersionTLS13 {
+		t.Errorf("request with min_version 1.3 = %v", err)
+	}
+}
+
+func TestClientCertificate(t *testing.T) {
+	pki := newTestPKI(t)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(pki.root.cert)
+	srv := pki.startServer(t, func(c *tls.Config) {
+		c.ClientAuth = tls.RequireAndVerifyClientCert
+		c.ClientCAs = clientCAs
+	})
+	dir := t.TempDir()
+	caFile := writeFile(t, dir, "ca.pem", pki.root.pem())
+
+	// 没有客户端证书时服务器拒绝连接
+	l, err := NewLoader(Options{CAFile: caFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := get(l, srv.URL); err == nil {
+		t.Error("request without a client certificate succeeded")
+	}
+
+	client := newCert(t, "agent-1", pki.root, false, x509.ExtKeyUsageClientAuth)
+	l, err = NewLoader(Options{
+		CAFile:   caFile,
+		CertFile: writeFile(t, dir, "client.pem", client.pem()),
+		KeyFile:  writeFile(t, dir, "client.key", client.keyPEM(t)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := get(l, srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := resp.Header.Get("X-Client"); got != "agent-1" {
+		t.Errorf("server saw client certificate %q, want agent-1", got)
+	}
+}
+
+func TestReload(t *testing.T) {
+	pki := newTestPKI(t)
+	srv := pki.startServer(t, nil)
+	other := newTestPKI(t)
+	otherSrv := other.startServer(t, nil)
+
+	caFile := writeFile(t, t.TempDir(), "ca.pem", pki.root.pem())
+	l, err := NewLoader(Options{CAFile: caFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloaded := 0
+	l.OnReload = func() { reloaded++ }
+	if _, err := get(l, srv.URL); err != nil {
+		t.Fatal(err)
+	}
+
+	// 修改时间变化后重新加载 CA
+	mtime := time.Now().Add(time.Minute)
+	if err := os.WriteFile(caFile, other.root.pem(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(caFile, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	// 检查间隔内不重新加载
+	if _, err := get(l, srv.URL); err != nil {
+		t.Errorf("request within the check interval = %v", err)
+	}
+	l.lastCheck = time.Time{}
+	if _, err := get(l, otherSrv.URL); err != nil {
+		t.Errorf("request after reload = %v", err)
+	}
+	if _, err := get(l, srv.URL); err == nil {
+		t.Error("request trusting the replaced CA succeeded")
+	}
+	if reloaded != 1 {
+		t.Errorf("OnReload called %d times, want 1", reloaded)
+	}
+
+	// 新文件无效时继续使用之前的证书
+	if err := os.WriteFile(caFile, []byte("not a certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	mtime = mtime.Add(time.Minute)
+	if err := os.Chtimes(caFile, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	l.lastCheck = time.Time{}
+	if _, err := get(l, otherSrv.URL); err != nil {
+		t.Errorf("request after a failed reload = %v", err)
+	}
+
+	// 文件被删除时记录日志并继续使用之前的证书
+	var buf bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(&buf, nil)))
+	if err := os.Remove(caFile); err != nil {
+		t.Fatal(err)
+	}
+	l.lastCheck = time.Time{}
+	if _, err := get(l, otherSrv.URL); err != nil {
+		t.Errorf("request after the CA file was removed = %v", err)
+	}
+	if !strings.Contains(buf.String(), "level=WARN") || !strings.Contains(buf.String(), caFile) {
+		t.Errorf("log = %q, want a warning about %s", buf.String(), caFile)
+	}
+	if reloaded != 1 {
+		t.Errorf("OnReload called %d times, want 1", reloaded)
+	}
+}
+
+func TestNewLoaderErrors(t *testing.T) {
+	dir := t.TempDir()
+	for _, opts := range []Options{
+		{MinVersion: "1.1"},
+		{CertFile: "client.pem"},
+		{KeyFile: "client.key"},
+		{Pins: []string{"sha256/abc"}},
+		{CAFile: filepath.Join(dir, "missing.pem")},
+		{CAFile: writeFile(t, dir, "empty.pem", []byte("no certificates"))},
+		{CertFile: filepath.Join(dir, "missing.pem"), KeyFile: filepath.Join(dir, "missing.key")},
+	} {
+		if _, err := NewLoader(opts); err == nil {
+			t.Errorf("NewLoader(%+v) succeeded", opts)
+		}
+	}
+}
+
+func TestParsePin(t *testing.T) {
+	pin := SPKIPin(newCert(t, "pin", nil, true, 0).cert)
+	for _, s := range []string{pin, "sha256/" + pin, "sha256//" + pin, " sha256/" + pin + " "} {
+		if got, err := ParsePin(s); err != nil || got != pin {
+			t.Errorf("ParsePin(%q) = %q, %v", s, got, err)
+		}
+	}
+	for _, s := range []string{"", "sha256/", "md5/" + pin, "sha256/" + pin[:20], "sha256/not base64!"} {
+		if _, err := ParsePin(s); err == nil {
+			t.Errorf("ParsePin(%q) succeeded", s)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package updater
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/xugou/agent/pkg/utils"
+)
+
+// pendingFile 记录已替换但尚未被新版本确认的更新
+const pendingFile = "update-pending.json"
+
+// Pending 待确认的更新，新版本成功上报一次数据后删除该记录
+type Pending struct {
+	Binary      string    `json:"binary"`
+	Backup      string    `json:"backup"`
+	FromVersion string    `json:"from_version"`
+	ToVersion   string    `json:"to_version"`
+	SwappedAt   time.Time `json:"swapped_at"`
+}
+
+func writePending(stateDir string, p *Pending) error {
+	p.SwappedAt = time.Now()
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := utils.WriteFileAtomic(filepath.Join(stateDir, pendingFile), data, 0644); err != nil {
+		return fmt.Errorf("记录待确认的更新失败: %w", err)
+	}
+	return nil
+}
+
+// ReadPending 读取待确认的更新，没有待确认的更新时返回 nil
+func ReadPending(stateDir string) (*Pending, error) {
+	data, err := os.ReadFile(filepath.Join(stateDir, pendingFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	p := &Pending{}
+	if err := json.Unmarshal(data, p); err != nil {
+		return nil, fmt.Errorf("解析待确认的更新失败: %w", err)
+	}
+	return p, nil
+}
+
+// CommitPending 由新版本在成功上报数据后调用，表示新版本运行正常
+func CommitPending(stateDir string) error {
+	err := os.Remove(filepath.Join(stateDir, pendingFile))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Rollback 恢复备份的旧版本并清除待确认的更新
+func Rollback(stateDir string, p *Pending) error {
+	if _, err := os.Stat(p.Backup); err != nil {
+		return fmt.Errorf("找不到旧版本备份 %s: %w", p.Backup, err)
+	}
+	if err := os.Rename(p.Backup, p.Binary); err != nil {
+		return fmt.Errorf("恢复旧版本失败: %w", err)
+	}
+	return CommitPending(stateDir)
+}
@@ -0,0 +1,312 @@
+package updater
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xugou/agent/pkg/useragent"
+	"github.com/xugou/agent/pkg/utils"
+)
+
+// PublicKey 用于校验更新包签名的 ed25519 公钥（base64 编码），在编译时通过 -ldflags 设置
+var PublicKey = ""
+
+// maxBinarySize 下载的二进制文件大小上限
+const maxBinarySize = 200 << 20
+
+// Manifest 发布清单，列出某个版本所有平台的二进制文件
+type Manifest struct {
+	Version   string     `json:"version"`
+	Artifacts []Artifact `json:"artifacts"`
+}
+
+// Artifact 单个平台的二进制文件
+type Artifact struct {
+	OS        string `json:"os"`
+	Arch      string `json:"arch"`
+	URL       string `json:"url"`       // 下载地址，可以是相对于清单的路径
+	SHA256    string `json:"sha256"`    // 十六进制编码的 SHA-256
+	Signature string `json:"signature"` // base64 编码的 ed25519 签名，签名内容见 SignedMessage
+}
+
+// SignedMessage 返回签名的内容。签名同时绑定版本、平台和二进制文件的 SHA-256，
+// 防止旧版本或其他平台的已签名二进制文件被当作当前平台的新版本安装
+func SignedMessage(version, goos, goarch, sha256Hex string) []byte {
+	return []byte(fmt.Sprintf("xugou-agent-update\nversion=%s\nos=%s\narch=%s\nsha256=%s\n",
+		version, goos, goarch, strings.ToLower(strings.TrimSpace(sha256Hex))))
+}
+
+// Updater 负责检查、下载、校验和替换二进制文件
+type Updater struct {
+	ManifestURL    string
+	PublicKey      ed25519.PublicKey
+	Client         *http.Client
+	BinaryPath     string // 需要替换的二进制文件路径
+	StateDir       string // 状态目录，用于记录待确认的更新
+	CurrentVersion string
+	GOOS           string
+	GOARCH         string
+}
+
+// New 创建更新器，publicKey 为 base64 编码的 ed25519 公钥
+func New(manifestURL, publicKey, binaryPath, stateDir, currentVersion string, client *http.Client) (*Updater, error) {
+	if publicKey == "" {
+		return nil, errors.New("当前版本编译时没有内置更新签名公钥，无法安全更新")
+	}
+	key, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, errors.New("内置的更新签名公钥无效")
+	}
+	if client == nil {
+		client = &http.Client{Timeout: 5 * time.Minute}
+	}
+	return &Updater{
+		ManifestURL:    manifestURL,
+		PublicKey:      ed25519.PublicKey(key),
+		Client:         client,
+		BinaryPath:     binaryPath,
+		StateDir:       stateDir,
+		CurrentVersion: currentVersion,
+		GOOS:           runtime.GOOS,
+		GOARCH:         runtime.GOARCH,
+	}, nil
+}
+
+// Check 获取发布清单并找到当前平台的二进制文件，newer 表示清单中的版本比当前版本新
+func (u *Updater) Check(ctx context.Context) (manifest *Manifest, artifact *Artifact, newer bool, err error) {
+	body, err := u.get(ctx, u.ManifestURL, 1<<20)
+	if err != nil {
+		return nil, nil, false, fmt.Errorf("获取发布清单失败: %w", err)
+	}
+
+	manifest = &Manifest{}
+	if err := json.Unmarshal(body, manifest); err != nil {
+		return nil, nil, false, fmt.Errorf("解析发布清单失败: %w", err)
+	}
+
+	for i := range manifest.Artifacts {
+		a := &manifest.Artifacts[i]
+		if a.OS == u.GOOS && a.Arch == u.GOARCH {
+			artifact = a
+			break
+		}
+	}
+	if artifact == nil {
+		return manifest, nil, false, fmt.Errorf("版本 %s 没有适用于 %s/%s 的二进制文件", manifest.Version, u.GOOS, u.GOARCH)
+	}
+
+	return manifest, artifact, CompareVersions(manifest.Version, u.CurrentVersion) > 0, nil
+}
+
+// Download 下载清单中 version 版本的二进制文件并校验 SHA-256 和签名，校验通过后写入与目标文件同目录的临时文件并返回其路径
+func (u *Updater) Download(ctx context.Context, version string, artifact *Artifact) (string, error) {
+	downloadURL, err := u.resolve(artifact.URL)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := u.get(ctx, downloadURL, maxBinarySize)
+	if err != nil {
+		return "", fmt.Errorf("下载二进制文件失败: %w", err)
+	}
+
+	if err := u.Verify(data, version, artifact); err != nil {
+		return "", err
+	}
+
+	staged := u.BinaryPath + ".new"
+	if err := utils.WriteFileAtomic(staged, data, 0755); err != nil {
+		return "", fmt.Errorf("保存二进制文件失败: %w", err)
+	}
+	return staged, nil
+}
+
+// Verify 校验二进制文件的平台、SHA-256 和 ed25519 签名，签名需要覆盖清单中的版本、平台和 SHA-256
+func (u *Updater) Verify(data []byte, version string, artifact *Artifact) error {
+	if artifact.OS != u.GOOS || artifact.Arch != u.GOARCH {
+		return fmt.Errorf("二进制文件适用于 %s/%s，当前平台为 %s/%s", artifact.OS, artifact.Arch, u.GOOS, u.GOARCH)
+	}
+	if strings.TrimSpace(version) == "" {
+		return errors.New("发布清单缺少版本号")
+	}
+
+	sum := sha256.Sum256(data)
+	expected, err := hex.DecodeString(strings.TrimSpace(artifact.SHA256))
+	if err != nil || !bytes.Equal(sum[:], expected) {
+		return fmt.Errorf("SHA-256 校验失败: 期望 %s，实际 %s", artifact.SHA256, hex.EncodeToString(sum[:]))
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(artifact.Signature))
+	if err != nil {
+		return fmt.Errorf("签名格式无效: %w", err)
+	}
+	message := SignedMessage(version, artifact.OS, artifact.Arch, hex.EncodeToString(sum[:]))
+	if !ed25519.Verify(u.PublicKey, message, sig) {
+		return fmt.Errorf("签名校验失败，二进制文件可能被篡改或签名不是针对版本 %s 的 %s/%s", version, artifact.OS, artifact.Arch)
+	}
+	return nil
+}
+
+// Preflight 在替换前运行新版本的 version 命令，确保新二进制文件可以在本机执行
+func Preflight(ctx context.Context, path string) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	out, err := exec.CommandContext(ctx, path, "version").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("新版本无法运行: %v %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// Swap 用新的二进制文件替换当前文件，旧文件保留为备份以便回滚。
+// 备份通过硬链接（不支持时复制）生成，替换只有一次原子的重命名，任何时刻二进制文件都存在；
+// 待确认的记录在替换之前写入，避免新版本已经生效却没有记录可以回滚
+func (u *Updater) Swap(staged, version string) error {
+	backup := u.BinaryPath + ".old"
+	if err := os.Remove(backup); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("删除旧的备份失败: %w", err)
+	}
+	if err := os.Link(u.BinaryPath, backup); err != nil {
+		if err := copyFile(u.BinaryPath, backup); err != nil {
+			return fmt.Errorf("备份当前版本失败: %w", err)
+		}
+	}
+	if err := writePending(u.StateDir, &Pending{
+		Binary:      u.BinaryPath,
+		Backup:      backup,
+		FromVersion: u.CurrentVersion,
+		ToVersion:   version,
+	}); err != nil {
+		return err
+	}
+	if err := os.Rename(staged, u.BinaryPath); err != nil {
+		// 当前版本没有变化，清除待确认的记录
+		if cerr := CommitPending(u.StateDir); cerr != nil {
+			return fmt.Errorf("替换二进制文件失败: %v，且清除待确认的更新失败: %v", err, cerr)
+		}
+		return fmt.Errorf("替换二进制文件失败: %w", err)
+	}
+	return nil
+}
+
+// copyFile 复制文件并保留权限，用于不支持硬链接的文件系统
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(dst)
+		return err
+	}
+	return nil
+}
+
+func (u *Updater) resolve(ref string) (string, error) {
+	base, err := url.Parse(u.ManifestURL)
+	if err != nil {
+		return "", fmt.Errorf("无效的清单地址: %w", err)
+	}
+	target, err := url.Parse(ref)
+	if err != nil {
+		return "", fmt.Errorf("无效的下载地址: %w", err)
+	}
+	return base.ResolveReference(target).String(), nil
+}
+
+func (u *Updater) get(ctx context.Context, rawURL string, limit int64) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", useragent.String())
+	resp, err := u.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s 返回 %s", rawURL, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("%s 的内容超过 %d 字节", rawURL, limit)
+	}
+	return data, nil
+}
+
+// CompareVersions 比较形如 v1.2.3 的版本号，a 较新时返回 1，相同返回 0，较旧返回 -1
+func CompareVersions(a, b string) int {
+	pa, pb := parseVersion(a), parseVersion(b)
+	for i := 0; i < 3; i++ {
+		if pa[i] != pb[i] {
+			if pa[i] > pb[i] {
+				return 1
+			}
+			return -1
+		}
+	}
+	return 0
+}
+
+// parseVersion 解析版本号，无法解析的部分（如 dev-abc123）视为 0
+func parseVersion(v string) [3]int {
+	var parts [3]int
+	v = strings.TrimPrefix(strings.TrimSpace(v), "v")
+	if i := strings.IndexAny(v, "-+"); i >= 0 {
+		v = v[:i]
+	}
+	for i, s := range strings.SplitN(v, ".", 3) {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			break
+		}
+		parts[i] = n
+	}
+	return parts
+}
+
+// DefaultBinaryPath 返回当前运行的二进制文件路径
+func DefaultBinaryPath() (string, error) {
+	self, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(self)
+}
@@ -0,0 +1,281 @@
+package updater
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testRelease 使用测试密钥签名的发布
+type testRelease struct {
+	key  ed25519.PrivateKey
+	pub  string
+	data []byte
+}
+
+func newTestRelease(t *testing.T) *testRelease {
+	t.Helper()
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testRelease{key: key, pub: base64.StdEncoding.EncodeToString(pub), data: []byte("#!/bin/sh\necho v1.2.0\n")}
+}
+
+// artifact 返回对 version、goos 和 goarch 签名的二进制文件信息
+func (r *testRelease) artifact(version, goos, goarch string) Artifact {
+	sum := sha256.Sum256(r.data)
+	digest := hex.EncodeToString(sum[:])
+	sig := ed25519.Sign(r.key, SignedMessage(version, goos, goarch, digest))
+	return Artifact{
+		OS:        goos,
+		Arch:      goarch,
+		URL:       "xugou-agent-" + goos + "-" + goarch,
+		SHA256:    digest,
+		Signature: base64.StdEncoding.EncodeToString(sig),
+	}
+}
+
+func (r *testRelease) updater(t *testing.T, manifestURL string) *Updater {
+	t.Helper()
+	dir := t.TempDir()
+	u, err := New(manifestURL, r.pub, filepath.Join(dir, "xugou-agent"), dir, "v1.1.0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.GOOS, u.GOARCH = "linux", "amd64"
+	return u
+}
+
+func TestNewRequiresPublicKey(t *testing.T) {
+	if _, err := New("https://example.com/manifest.json", "", "/tmp/x", "/tmp", "v1.0.0", nil); err == nil {
+		t.Error("New accepted an empty public key")
+	}
+	if _, err := New("https://example.com/manifest.json", "bm90IGEga2V5", "/tmp/x", "/tmp", "v1.0.0", nil); err == nil {
+		t.Error("New accepted a public key of the wrong size")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	r := newTestRelease(t)
+	u := r.updater(t, "https://example.com/manifest.json")
+	other := newTestRelease(t)
+
+	tests := []struct {
+		name     string
+		data     []byte
+		version  string
+		artifact Artifact
+		ok       bool
+	}{
+		{"valid", r.data, "v1.2.0", r.artifact("v1.2.0", "linux", "amd64"), true},
+		{"sha256 uppercase", r.data, "v1.2.0", func() Artifact {
+			a := r.artifact("v1.2.0", "linux", "amd64")
+			a.SHA256 = strings.ToUpper(a.SHA256)
+			return a
+		}(), true},
+		{"modified binary", append([]byte("x"), r.data...), "v1.2.0", r.artifact("v1.2.0", "linux", "amd64"), false},
+		// 旧版本的签名不能用于新版本号，防止降级
+		{"signed for older version", r.data, "v1.2.0", r.artifact("v1.0.0", "linux", "amd64"), false},
+		{"signed for other platform", r.data, "v1.2.0", func() Artifact {
+			a := r.artifact("v1.2.0", "linux", "arm64")
+			a.Arch = "amd64"
+			return a
+		}(), false},
+		{"artifact for other platform", r.data, "v1.2.0", r.artifact("v1.2.0", "darwin", "amd64"), false},
+		{"other key", r.data, "v1.2.0", other.artifact("v1.2.0", "linux", "amd64"), false},
+		{"missing version", r.data, "", r.artifact("", "linux", "amd64"), false},
+		{"invalid signature encoding", r.data, "v1.2.0", func() Artifact {
+			a := r.artifact("v1.2.0", "linux", "amd64")
+			a.Signature = "!!!"
+			return a
+		}(), false},
+	}
+	for _, tt := range tests {
+		a := tt.artifact
+		err := u.Verify(tt.data, tt.version, &a)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: Verify() error = %v, want ok = %v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestCheckAndDownload(t *testing.T) {
+	r := newTestRelease(t)
+	manifest := Manifest{
+		Version: "v1.2.0",
+		Artifacts: []Artifact{
+			r.artifact("v1.2.0", "darwin", "arm64"),
+			r.artifact("v1.2.0", "linux", "amd64"),
+		},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/latest/manifest.json", func(w http.ResponseWriter, req *http.Request) {
+		json.NewEncoder(w).Encode(manifest)
+	})
+	mux.HandleFunc("/latest/xugou-agent-linux-amd64", func(w http.ResponseWriter, req *http.Request) {
+		w.Write(r.data)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	u := r.updater(t, srv.URL+"/latest/manifest.json")
+	got, artifact, newer, err := u.Check(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !newer || got.Version != "v1.2.0" || artifact.OS != "linux" || artifact.Arch != "amd64" {
+		t.Fatalf("Check() = %v, %+v, %v", got.Version, artifact, newer)
+	}
+
+	staged, err := u.Download(context.Background(), got.Version, artifact)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(staged)
+	if err != nil || string(data) != string(r.data) {
+		t.Fatalf("staged file = %q, %v", data, err)
+	}
+
+	// 清单中的版本号被篡改时签名不匹配
+	if _, err := u.Download(context.Background(), "v1.3.0", artifact); err == nil {
+		t.Error("Download accepted a binary signed for another version")
+	}
+
+	u.GOARCH = "riscv64"
+	if _, _, _, err := u.Check(context.Background()); err == nil {
+		t.Error("Check succeeded without an artifact for the current platform")
+	}
+}
+
+func TestSwapCommitAndRollback(t *testing.T) {
+	r := newTestRelease(t)
+	u := r.updater(t, "https://example.com/manifest.json")
+	if err := os.WriteFile(u.BinaryPath, []byte("old"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	staged := u.BinaryPath + ".new"
+	if err := os.WriteFile(staged, []byte("new"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := u.Swap(staged, "v1.2.0"); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(u.BinaryPath); string(data) != "new" {
+		t.Errorf("binary after swap = %q, want new", data)
+	}
+	pending, err := ReadPending(u.StateDir)
+	if err != nil || pending == nil {
+		t.Fatalf("ReadPending() = %v, %v", pending, err)
+	}
+	if pending.FromVersion != "v1.1.0" || pending.ToVersion != "v1.2.0" || pending.Backup != u.BinaryPath+".old" {
+		t.Errorf("pending = %+v", pending)
+	}
+
+	if err := Rollback(u.StateDir, pending); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(u.BinaryPath); string(data) != "old" {
+		t.Errorf("binary after rollback = %q, want old", data)
+	}
+	if p, err := ReadPending(u.StateDir); err != nil || p != nil {
+		t.Errorf("pending after rollback = %v, %v, want nil", p, err)
+	}
+
+	// 没有备份时回滚失败，当前文件保持不变
+	if err := Rollback(u.StateDir, pending); err == nil {
+		t.Error("Rollback succeeded without a backup")
+	}
+	if data, _ := os.ReadFile(u.BinaryPath); string(data) != "old" {
+		t.Errorf("binary after failed rollback = %q, want old", data)
+	}
+}
+
+func TestSwapFailure(t *testing.T) {
+	r := newTestRelease(t)
+	u := r.updater(t, "https://example.com/manifest.json")
+	if err := os.WriteFile(u.BinaryPath, []byte("old"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	// 上次更新残留的备份被替换
+	if err := os.WriteFile(u.BinaryPath+".old", []byte("older"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// 新版本文件不存在时替换失败，当前文件不变且不留下待确认的记录
+	if err := u.Swap(u.BinaryPath+".missing", "v1.2.0"); err == nil {
+		t.Fatal("Swap succeeded without a staged binary")
+	}
+	if data, _ := os.ReadFile(u.BinaryPath); string(data) != "old" {
+		t.Errorf("binary after failed swap = %q, want old", data)
+	}
+	if data, _ := os.ReadFile(u.BinaryPath + ".old"); string(data) != "old" {
+		t.Errorf("backup = %q, want old", data)
+	}
+	if p, err := ReadPending(u.StateDir); err != nil || p != nil {
+		t.Errorf("pending after failed swap = %v, %v, want nil", p, err)
+	}
+
+	// 无法写入待确认的记录时不替换二进制文件
+	staged := u.BinaryPath + ".new"
+	if err := os.WriteFile(staged, []byte("new"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	blocked := filepath.Join(u.StateDir, "blocked")
+	if err := os.WriteFile(blocked, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	u.StateDir = filepath.Join(blocked, "state")
+	if err := u.Swap(staged, "v1.2.0"); err == nil {
+		t.Fatal("Swap succeeded without a pending record")
+	}
+	if data, _ := os.ReadFile(u.BinaryPath); string(data) != "old" {
+		t.Errorf("binary after failed pending write = %q, want old", data)
+	}
+}
+
+func TestCommitPending(t *testing.T) {
+	dir := t.TempDir()
+	if err := CommitPending(dir); err != nil {
+		t.Errorf("CommitPending without a record: %v", err)
+	}
+	if err := writePending(dir, &Pending{ToVersion: "v1.2.0"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := CommitPending(dir); err != nil {
+		t.Fatal(err)
+	}
+	if p, err := ReadPending(dir); err != nil || p != nil {
+		t.Errorf("ReadPending() after commit = %v, %v", p, err)
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"v1.2.0", "v1.1.9", 1},
+		{"1.2.0", "v1.2.0", 0},
+		{"v1.10.0", "v1.9.0", 1},
+		{"v1.2.0-rc1", "v1.2.0", 0},
+		{"dev", "v0.0.1", -1},
+		{"v2", "v1.99.99", 1},
+	}
+	for _, tt := range tests {
+		if got := CompareVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("CompareVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package useragent
+
+import (
+	"fmt"
+	"net/http"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/xugou/agent/pkg/model"
+)
+
+// Agent 元数据头部，服务器可以据此协商功能或拒绝过旧的版本
+const (
+	HeaderAgentID       = "X-Xugou-Agent-ID"       // 注册后服务器分配的客户端 ID
+	HeaderSchemaVersion = "X-Xugou-Schema-Version" // 上报数据的结构版本
+	HeaderCapabilities  = "X-Xugou-Capabilities"   // 逗号分隔的功能列表
+)
+
+var (
+	mu      sync.RWMutex
+	version = "dev"
+	commit  = "unknown"
+
+	// capabilities 当前版本支持的功能，服务器据此决定下发哪些功能
+	capabilities = []string{"hmac-sha256", "clock-skew", "token-rotation", "batch", "alerts", "telemetry", "labels"}
+)
+
+// Set 设置版本信息，在程序启动时调用
+func Set(v, c string) {
+	mu.Lock()
+	defer mu.Unlock()
+	version, commit = v, c
+}
+
+// AddCapability 登记一个功能，由各功能模块在启用时调用
+func AddCapability(name string) {
+	mu.Lock()
+	defer mu.Unlock()
+	for _, c := range capabilities {
+		if c == name {
+			return
+		}
+	}
+	capabilities = append(capabilities, name)
+}
+
+// Capabilities 返回当前支持的功能列表
+func Capabilities() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	return append([]string(nil), capabilities...)
+}
+
+// Version 返回 Agent 版本
+func Version() string {
+	mu.RLock()
+	defer mu.RUnlock()
+	return version
+}
+
+// String 返回 User-Agent，例如 xugou-agent/1.2.0 (linux/amd64; 3f2c1ab)
+func String() string {
+	mu.RLock()
+	defer mu.RUnlock()
+	short := commit
+	if len(short) > 7 {
+		short = short[:7]
+	}
+	return fmt.Sprintf("xugou-agent/%s (%s/%s; %s)", version, runtime.GOOS, runtime.GOARCH, short)
+}
+
+// Apply 为发往 Xugou 服务器的请求设置 User-Agent 和元数据头部，agentID 为 0 表示尚未注册
+func Apply(req *http.Request, agentID int) {
+	req.Header.Set("User-Agent", String())
+	req.Header.Set(HeaderSchemaVersion, strconv.Itoa(model.SchemaVersion))
+	req.Header.Set(HeaderCapabilities, strings.Join(Capabilities(), ","))
+	if agentID > 0 {
+		req.Header.Set(HeaderAgentID, strconv.Itoa(agentID))
+	}
+}
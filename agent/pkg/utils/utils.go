@@ -1,6 +1,10 @@
 package utils
 
-import "net"
+import (
+	"net"
+	"os"
+	"path/filepath"
+)
 
 // NormalizeURL 处理URL格式，确保URL末尾没有斜杠
 func NormalizeURL(url string) string {
@@ -34,3 +38,45 @@ func GetLocalIPs() []string {
 
 	return ips
 }
+
+// RedactSecret 对令牌等敏感信息脱敏，只保留开头几个字符便于辨认
+func RedactSecret(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	if len(secret) <= 8 {
+		return "****"
+	}
+	return secret[:6] + "****"
+}
+
+// WriteFileAtomic 先写入同目录下的临时文件再重命名，避免留下写了一半的文件
+func WriteFileAtomic(path string, data []byte, mode os.FileMode) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
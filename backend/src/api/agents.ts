@@ -12,6 +12,7 @@ import {
   getAgentMetrics,
   getLatestAgentMetrics,
 } from "../services/AgentService";
+import { authenticateAgentRequest } from "../utils/agentAuth";
 
 const agents = new Hono<{
   Bindings: Bindings;
@@ -92,7 +93,16 @@ agents.post("/token/generate", async (c) => {
 
 // 客户端自注册接口
 agents.post("/register", async (c) => {
-  const { token, name, hostname, ip_addresses, os, version } = await c.req.json();
+  const auth = await authenticateAgentRequest(c.req, c.env);
+  if (!auth.ok) {
+    return c.json(
+      { success: false, message: auth.message },
+      auth.status,
+      auth.headers
+    );
+  }
+  const token = auth.token;
+  const { name, hostname, ip_addresses, os, version } = auth.body;
 
   const result = await registerAgentService(
     c.env,
@@ -116,13 +126,18 @@ agents.post("/register", async (c) => {
 
 // 通过令牌更新客户端状态
 agents.post("/status", async (c) => {
-  // 获取客户端发送的所有数据并打印日志
-  const statusData = await c.req.json();
-
-  console.log("statusData: ", statusData);
+  const auth = await authenticateAgentRequest(c.req, c.env);
+  if (!auth.ok) {
+    return c.json(
+      { success: false, message: auth.message },
+      auth.status,
+      auth.headers
+    );
+  }
+  const statusData = auth.body;
 
   try {
-    await updateAgentStatusService(statusData);
+    await updateAgentStatusService(statusData, auth.token);
     return c.json(
       {
         success: true,
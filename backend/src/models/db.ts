@@ -45,4 +45,6 @@ export type Bindings = {
   DB: D1Database;
   CF_VERSION_METADATA?: VersionMetadata;
   ASSETS: Fetcher;
+  // 设置为 "true" 时兼容在请求体中携带 token 的旧版客户端
+  AGENT_LEGACY_BODY_TOKEN?: string;
 };
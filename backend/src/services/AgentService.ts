@@ -267,13 +267,13 @@ export async function registerAgentService(
  * @param db 数据库连接
  * @param env 环境变量
  * @param status 客户端指标
+ * @param token 请求认证通过的客户端令牌
  * @returns 更新结果
  */
-export async function updateAgentStatusService(status: any) {
+export async function updateAgentStatusService(status: any, token: string) {
   try {
     const statusData = Array.isArray(status) ? status : [status];
     const norlmalInfo = {
-      token: statusData[0]?.token,
       ip_addresses: statusData[0]?.ip_addresses,
       hostname: statusData[0]?.hostname,
       os: statusData[0]?.os,
@@ -284,11 +284,11 @@ export async function updateAgentStatusService(status: any) {
 
     console.log("norlmalInfo", norlmalInfo);
 
-    if (!norlmalInfo.token) {
+    if (!token) {
       throw new Error("缺少API令牌");
     }
     // 通过token查找客户端
-    const agent = await AgentRepository.getAgentByToken(norlmalInfo.token);
+    const agent = await AgentRepository.getAgentByToken(token);
 
     if (
       agent.status != "active" ||
@@ -0,0 +1,154 @@
+/**
+ * 客户端请求认证
+ * 客户端把令牌放在 Authorization: Bearer <令牌> 头里，并以令牌为密钥对每个请求计算 HMAC-SHA256 签名，
+ * 签名内容为 `方法\n路径\n时间戳\n随机数\n请求体摘要`，与客户端 pkg/auth/signer.go 一致
+ */
+
+// 签名使用的请求头
+export const HEADER_TIMESTAMP = "X-Xugou-Timestamp";
+export const HEADER_NONCE = "X-Xugou-Nonce";
+export const HEADER_DIGEST = "X-Xugou-Content-SHA256";
+export const HEADER_SIGNATURE = "X-Xugou-Signature";
+export const HEADER_ERROR = "X-Xugou-Error";
+export const HEADER_SERVER_TIME = "X-Xugou-Server-Time";
+
+// 签名时间与服务器时间的最大偏差（秒）
+const MAX_SKEW_SECONDS = 5 * 60;
+
+// 时间窗口内已使用的随机数 -> 过期时间（毫秒）。只在当前实例内有效，
+// 多个实例之间无法共享，但签名时间窗口已经限制了重放的范围
+const usedNonces = new Map<string, number>();
+
+export type AgentAuthResult =
+  | { ok: true; token: string; body: any }
+  | {
+      ok: false;
+      status: 400 | 401;
+      message: string;
+      headers?: Record<string, string>;
+    };
+
+/**
+ * 校验客户端请求的令牌和签名，成功时返回令牌和解析后的请求体
+ * 设置环境变量 AGENT_LEGACY_BODY_TOKEN=true 时，兼容在请求体中携带 token 的旧版客户端
+ *
+ * @param req Hono 请求对象
+ * @param env 环境变量
+ * @returns 认证结果
+ */
+export async function authenticateAgentRequest(
+  req: { method: string; url: string; header(name: string): string | undefined; text(): Promise<string> },
+  env: any
+): Promise<AgentAuthResult> {
+  const raw = await req.text();
+  let body: any;
+  try {
+    body = raw ? JSON.parse(raw) : {};
+  } catch (error) {
+    return { ok: false, status: 400, message: "请求体不是有效的 JSON" };
+  }
+
+  const authorization = req.header("Authorization") || "";
+  const match = authorization.match(/^Bearer\s+(\S+)$/i);
+  if (!match) {
+    const legacyToken = Array.isArray(body) ? body[0]?.token : body?.token;
+    if (env?.AGENT_LEGACY_BODY_TOKEN === "true" && legacyToken) {
+      console.warn("客户端使用请求体中的令牌认证，请升级客户端");
+      return { ok: true, token: legacyToken, body };
+    }
+    return { ok: false, status: 401, message: "缺少API令牌" };
+  }
+  const token = match[1];
+
+  const timestamp = req.header(HEADER_TIMESTAMP) || "";
+  const nonce = req.header(HEADER_NONCE) || "";
+  const digest = (req.header(HEADER_DIGEST) || "").toLowerCase();
+  const signature = (req.header(HEADER_SIGNATURE) || "").toLowerCase();
+  if (!timestamp || !nonce || !digest || !signature) {
+    return { ok: false, status: 401, message: "缺少请求签名" };
+  }
+
+  const now = Math.floor(Date.now() / 1000);
+  const signedAt = Number(timestamp);
+  if (!/^\d+$/.test(timestamp) || Math.abs(now - signedAt) > MAX_SKEW_SECONDS) {
+    return {
+      ok: false,
+      status: 401,
+      message: "签名时间与服务器时间相差过大",
+      headers: {
+        [HEADER_ERROR]: "clock_skew",
+        [HEADER_SERVER_TIME]: String(now),
+      },
+    };
+  }
+
+  if (digest !== (await sha256Hex(raw))) {
+    return { ok: false, status: 401, message: "请求体摘要不匹配" };
+  }
+
+  const url = new URL(req.url);
+  const stringToSign = [
+    req.method.toUpperCase(),
+    url.pathname + url.search,
+    timestamp,
+    nonce,
+    digest,
+  ].join("\n");
+  if (!timingSafeEqual(signature, await hmacSha256Hex(token, stringToSign))) {
+    return { ok: false, status: 401, message: "请求签名无效" };
+  }
+
+  // 签名通过后再记录随机数，避免伪造的请求占用随机数
+  pruneNonces(Date.now());
+  if (usedNonces.has(nonce)) {
+    return { ok: false, status: 401, message: "重复的请求" };
+  }
+  usedNonces.set(nonce, Date.now() + MAX_SKEW_SECONDS * 2 * 1000);
+
+  return { ok: true, token, body };
+}
+
+function pruneNonces(now: number) {
+  for (const [nonce, expires] of usedNonces) {
+    if (expires <= now) {
+      usedNonces.delete(nonce);
+    }
+  }
+}
+
+function toHex(buffer: ArrayBuffer): string {
+  return Array.from(new Uint8Array(buffer), (byte) =>
+    byte.toString(16).padStart(2, "0")
+  ).join("");
+}
+
+async function sha256Hex(data: string): Promise<string> {
+  return toHex(
+    await crypto.subtle.digest("SHA-256", new TextEncoder().encode(data))
+  );
+}
+
+async function hmacSha256Hex(key: string, data: string): Promise<string> {
+  const cryptoKey = await crypto.subtle.importKey(
+    "raw",
+    new TextEncoder().encode(key),
+    { name: "HMAC", hash: "SHA-256" },
+    false,
+    ["sign"]
+  );
+  return toHex(
+    await crypto.subtle.sign("HMAC", cryptoKey, new TextEncoder().encode(data))
+  );
+}
+
+// 比较签名时不因提前返回泄露匹配的长度
+function timingSafeEqual(a: string, b: string): boolean {
+  if (a.length !== b.length) {
+    return false;
+  }
+  let diff = 0;
+  for (let i = 0; i < a.length; i++) {
+    diff |= a.charCodeAt(i) ^ b.charCodeAt(i);
+  }
+  return diff === 0;
+}
@@ -7,12 +7,16 @@ AGENT_NAME="xugou-agent"
 DOWNLOAD_BASE_URL="https://dl.xugou.mdzz.uk/latest"
 AGENT_INSTALL_PATH="/usr/local/bin/${AGENT_NAME}"
 SERVICE_NAME="${AGENT_NAME}.service"
-SERVICE_FILE_PATH="/etc/systemd/system/${SERVICE_NAME}"
+# 服务使用的配置文件，由 service install 生成
+CONFIG_PATH="/etc/${AGENT_NAME}/config.yaml"
+# 未安装为系统服务时令牌保存在该文件中（权限 0600），通过 --token-file 传给 Agent
+TOKEN_FILE="${XUGOU_TOKEN_FILE:-$HOME/.config/${AGENT_NAME}/token}"
 
 # --- 默认参数 ---
 SERVER_URL=""
-AGENT_TOKEN=""
+AGENT_TOKEN="${XUGOU_TOKEN:-}"
 AGENT_INTERVAL="60" # 默认间隔60秒
+OVERWRITE_CONFIG="" # 非空时覆盖已存在的服务配置文件
 
 # --- 环境变量相关函数 ---
 detect_shell_rc() {
@@ -27,16 +31,24 @@ detect_shell_rc() {
   fi
 }
 
+# 把令牌写入只有当前用户可读的文件，避免令牌出现在命令行参数和 shell 配置文件中
+write_token_file() {
+  mkdir -p "$(dirname "$TOKEN_FILE")"
+  chmod 700 "$(dirname "$TOKEN_FILE")"
+  (umask 077 && printf '%s\n' "$1" > "$TOKEN_FILE")
+  echo "令牌已保存到 $TOKEN_FILE"
+}
+
 set_env_vars() {
   local rc_file
   rc_file=$(detect_shell_rc)
-  # 先去除已有的同名变量
-  grep -v '^export XUGOU_SERVER=' "$rc_file" 2>/dev/null | grep -v '^export XUGOU_TOKEN=' > "${rc_file}.tmp" || true
+  # 先去除已有的同名变量，旧版本写入的 XUGOU_TOKEN 一并删除
+  grep -v '^export XUGOU_SERVER=' "$rc_file" 2>/dev/null | grep -v '^export XUGOU_TOKEN=' | grep -v '^export XUGOU_TOKEN_FILE=' > "${rc_file}.tmp" || true
   mv "${rc_file}.tmp" "$rc_file"
   echo "export XUGOU_SERVER=\"$SERVER_URL\"" >> "$rc_file"
-  echo "export XUGOU_TOKEN=\"$AGENT_TOKEN\"" >> "$rc_file"
-  
-  echo "已将 XUGOU_SERVER 和 XUGOU_TOKEN 写入 $rc_file"
+  echo "export XUGOU_TOKEN_FILE=\"$TOKEN_FILE\"" >> "$rc_file"
+
+  echo "已将 XUGOU_SERVER 和 XUGOU_TOKEN_FILE 写入 $rc_file"
 
   # 让环境变量立即生效（仅限交互式 shell）
   if [[ $- == *i* ]]; then
@@ -47,15 +59,6 @@ set_env_vars() {
   fi
 }
 
-read_env_vars() {
-  if [ -z "$XUGOU_SERVER" ]; then
-    XUGOU_SERVER=$(grep '^export XUGOU_SERVER=' "$(detect_shell_rc)" 2>/dev/null | tail -n1 | cut -d= -f2- | sed 's/^"//;s/"$//')
-  fi
-  if [ -z "$XUGOU_TOKEN" ]; then
-    XUGOU_TOKEN=$(grep '^export XUGOU_TOKEN=' "$(detect_shell_rc)" 2>/dev/null | tail -n1 | cut -d= -f2- | sed 's/^"//;s/"$//')
-  fi
-}
-
 # --- 辅助函数 ---
 print_usage() {
   echo "用法: $0 [command] [options]"
@@ -64,19 +67,21 @@ print_usage() {
   echo "  install (default)    下载、安装并配置 ${AGENT_NAME}."
   echo "                       在 Linux 上会尝试注册为 systemd 服务."
   echo "  update               更新 ${AGENT_NAME} 到最新版."
-  echo "                       会自动读取环境变量并重启 agent."
+  echo "                       由已安装的 Agent 下载并校验签名后替换二进制文件并重启服务，配置文件保持不变."
   echo "  uninstall            卸载 ${AGENT_NAME}."
   echo "                       在 Linux 上会尝试移除 systemd 服务."
   echo "  help                 显示此帮助信息."
   echo ""
   echo "Options for 'install' command:"
   echo "  --server <url>       必需. 服务器地址 (例如: http://localhost:8787)."
-  echo "  --token <token>      必需. Agent 认证令牌."
+  echo "  --token <token>      必需. Agent 认证令牌，也可以通过 XUGOU_TOKEN 环境变量设置."
   echo "  --interval <seconds> 可选. Agent 心跳间隔 (默认为 ${AGENT_INTERVAL} 秒)."
+  echo "  --overwrite-config   可选. 覆盖已存在的服务配置文件 ${CONFIG_PATH}，默认保留原有配置."
   echo ""
   echo "示例:"
   echo "  $0 install --server http://localhost:8787 --token yoursecrettoken"
   echo "  $0 --server http://localhost:8787 --token yoursecrettoken --interval 300"
+  echo "  XUGOU_TOKEN=yoursecrettoken $0 install --server http://localhost:8787"
   echo "  $0 update"
   echo "  $0 uninstall"
   exit 1
@@ -94,6 +99,18 @@ if [[ $EUID -ne 0 ]]; then
   fi
 fi
 
+# 运行 service install，令牌通过 XUGOU_TOKEN 环境变量传递，不出现在进程列表和 shell 历史中
+# 用法: service_install <令牌> <Agent 路径> [参数...]
+service_install() {
+  local token="$1"
+  shift
+  if [ -n "$SUDO_CMD" ]; then
+    XUGOU_TOKEN="$token" ${SUDO_CMD} --preserve-env=XUGOU_TOKEN "$1" service install "${@:2}"
+  else
+    XUGOU_TOKEN="$token" "$1" service install "${@:2}"
+  fi
+}
+
 # --- 检测操作系统和架构 ---
 OS_TYPE=$(uname -s)
 OS_ARCH=$(uname -m)
@@ -149,7 +166,7 @@ do_install() {
     print_usage
   fi
   if [ -z "$AGENT_TOKEN" ]; then
-    echo "错误: --token 参数是 'install' 命令所必需的。" >&2
+    echo "错误: 'install' 命令需要 --token 参数或 XUGOU_TOKEN 环境变量。" >&2
     print_usage
   fi
 
@@ -157,6 +174,7 @@ do_install() {
   echo "服务器地址: ${SERVER_URL}"
   echo "Agent 令牌: **** (已隐藏)"
   echo "心跳间隔: ${AGENT_INTERVAL} 秒"
+  write_token_file "${AGENT_TOKEN}"
 
   if [ "$PLATFORM" = "windows" ]; then
     echo ""
@@ -166,7 +184,8 @@ do_install() {
     echo "  ${DOWNLOAD_BASE_URL}/${AGENT_NAME}-windows-amd64${EXTENSION} (针对 AMD64/x86_64)"
     echo "  ${DOWNLOAD_BASE_URL}/${AGENT_NAME}-windows-arm64${EXTENSION} (针对 ARM64)"
     echo "下载后，您可以使用以下命令运行 (请替换参数值):"
-    echo "  .\\${AGENT_NAME}${EXTENSION} start --server \"${SERVER_URL}\" --token \"${AGENT_TOKEN}\" --interval \"${AGENT_INTERVAL}\""
+    echo "  \$env:XUGOU_TOKEN = \"<Agent 令牌>\""
+    echo "  .\\${AGENT_NAME}${EXTENSION} start --server \"${SERVER_URL}\" --interval \"${AGENT_INTERVAL}\""
     exit 0
   fi
 
@@ -210,69 +229,37 @@ do_install() {
     if [ -z "$SUDO_CMD" ] && [[ $EUID -ne 0 ]]; then
         echo "错误: 需要 sudo 权限来安装 systemd 服务，但 sudo 命令未找到或您不是 root 用户。" >&2
         echo "您可以尝试使用 root 用户运行此脚本，或手动将 ${LOCAL_AGENT_DOWNLOAD_PATH} 移动到 PATH 路径并运行。" >&2
-        echo "运行命令: ${LOCAL_AGENT_DOWNLOAD_PATH} start --server \"${SERVER_URL}\" --token \"${AGENT_TOKEN}\" --interval \"${AGENT_INTERVAL}\""
+        echo "运行命令: ${LOCAL_AGENT_DOWNLOAD_PATH} start --server \"${SERVER_URL}\" --token-file \"${TOKEN_FILE}\" --interval \"${AGENT_INTERVAL}\""
         exit 1
     fi
 
-    echo "将 Agent 移动到 ${AGENT_INSTALL_PATH}..."
-    ${SUDO_CMD} mv "${LOCAL_AGENT_DOWNLOAD_PATH}" "${AGENT_INSTALL_PATH}"
-    if [ $? -ne 0 ]; then
-        echo "错误: 移动 Agent 到 ${AGENT_INSTALL_PATH} 失败。请检查权限。" >&2
+    # 服务文件、运行用户和配置文件均由 agent 的 service install 命令生成，令牌只写入权限为 0600 的配置文件
+    echo "安装 ${SERVICE_NAME} 服务..."
+    if ! service_install "${AGENT_TOKEN}" "${LOCAL_AGENT_DOWNLOAD_PATH}" \
+        --bin "${AGENT_INSTALL_PATH}" --config-path "${CONFIG_PATH}" \
+        --server "${SERVER_URL}" --interval "${AGENT_INTERVAL}" ${OVERWRITE_CONFIG}; then
+        echo "错误: 安装服务失败。" >&2
         exit 1
     fi
+    rm -f "${LOCAL_AGENT_DOWNLOAD_PATH}"
 
-    echo "创建 systemd 服务文件: ${SERVICE_FILE_PATH}..."
-    SERVICE_FILE_CONTENT="[Unit]
-Description=${AGENT_NAME}
-After=network.target
-
-[Service]
-Type=simple
-ExecStart=${AGENT_INSTALL_PATH} start --server \"${SERVER_URL}\" --token \"${AGENT_TOKEN}\" --interval \"${AGENT_INTERVAL}\"
-Restart=on-failure
-RestartSec=10
-StandardOutput=journal
-StandardError=journal
-Environment="HOME=/root"
-# 考虑为更佳安全性设置 User= 和 Group= (例如创建一个 'xugou' 用户)
-# User=nobody
-# Group=nogroup
-
-[Install]
-WantedBy=multi-user.target"
-
-    # Use a temporary file to write content then sudo tee to destination
-    TEMP_SERVICE_FILE=$(mktemp)
-    echo "${SERVICE_FILE_CONTENT}" > "${TEMP_SERVICE_FILE}"
-    ${SUDO_CMD} mkdir -p "$(dirname "${SERVICE_FILE_PATH}")" # Ensure /etc/systemd/system exists
-    ${SUDO_CMD} cp "${TEMP_SERVICE_FILE}" "${SERVICE_FILE_PATH}"
-    rm "${TEMP_SERVICE_FILE}"
-    ${SUDO_CMD} chmod 644 "${SERVICE_FILE_PATH}"
-
-
-    echo "重新加载 systemd 管理器配置..."
-    ${SUDO_CMD} systemctl daemon-reload
-    echo "启用 ${SERVICE_NAME} 服务 (开机自启)..."
-    ${SUDO_CMD} systemctl enable "${SERVICE_NAME}"
-    echo "启动 ${SERVICE_NAME} 服务..."
-    ${SUDO_CMD} systemctl start "${SERVICE_NAME}"
     echo ""
-    echo "${AGENT_NAME} 已作为 systemd 服务安装并启动。"
+    echo "${AGENT_NAME} 已作为系统服务安装并启动。"
     echo "您可以使用以下命令检查服务状态:"
-    echo "  sudo systemctl status ${SERVICE_NAME}"
+    echo "  sudo ${AGENT_NAME} service status"
     echo "查看日志:"
     echo "  sudo journalctl -u ${SERVICE_NAME} -f"
   else
     echo "Agent 已下载到 ${LOCAL_AGENT_DOWNLOAD_PATH}"
     if [ "$PLATFORM" = "darwin" ]; then
         echo "在 macOS 上, 您可以这样运行 Agent:"
-        echo "  ${LOCAL_AGENT_DOWNLOAD_PATH} start --server \"${SERVER_URL}\" --token \"${AGENT_TOKEN}\" --interval \"${AGENT_INTERVAL}\""
+        echo "  ${LOCAL_AGENT_DOWNLOAD_PATH} start --server \"${SERVER_URL}\" --token-file \"${TOKEN_FILE}\" --interval \"${AGENT_INTERVAL}\""
         echo "要在后台运行, 可以使用 nohup:"
-        echo "  nohup ${LOCAL_AGENT_DOWNLOAD_PATH} start --server \"${SERVER_URL}\" --token \"${AGENT_TOKEN}\" --interval \"${AGENT_INTERVAL}\" > xugou-agent.log 2>&1 &"
+        echo "  nohup ${LOCAL_AGENT_DOWNLOAD_PATH} start --server \"${SERVER_URL}\" --token-file \"${TOKEN_FILE}\" --interval \"${AGENT_INTERVAL}\" > xugou-agent.log 2>&1 &"
         echo "或者考虑使用 launchd 创建一个持久化服务。"
     else # Generic Linux without systemd or other OS
         echo "您可以这样运行 Agent:"
-        echo "  ${LOCAL_AGENT_DOWNLOAD_PATH} start --server \"${SERVER_URL}\" --token \"${AGENT_TOKEN}\" --interval \"${AGENT_INTERVAL}\""
+        echo "  ${LOCAL_AGENT_DOWNLOAD_PATH} start --server \"${SERVER_URL}\" --token-file \"${TOKEN_FILE}\" --interval \"${AGENT_INTERVAL}\""
     fi
   fi
   echo "安装完成。"
@@ -280,84 +267,30 @@ WantedBy=multi-user.target"
 }
 
 # --- 更新 Agent ---
+# 由已安装的 Agent 执行 update 命令：下载发布清单中的新版本，校验 SHA-256 和签名后原子替换二进制文件，
+# 以服务运行时重启服务并等待新版本成功上报，失败时自动回滚。配置文件保持不变
 do_update() {
   detect_os_arch
 
-  read_env_vars
-  if [ -z "$XUGOU_SERVER" ] || [ -z "$XUGOU_TOKEN" ]; then
-    echo "错误: 未检测到 XUGOU_SERVER 或 XUGOU_TOKEN 环境变量，请先运行 install 命令。" >&2
-    exit 1
-  fi
-
   echo "开始更新 ${AGENT_NAME}..."
-  echo "服务器地址: ${XUGOU_SERVER}"
-  echo "Agent 令牌: **** (已隐藏)"
 
-  if [ "$PLATFORM" = "linux" ] && command -v systemctl &> /dev/null; then
-    if ${SUDO_CMD} systemctl list-unit-files | grep -q "${SERVICE_NAME}"; then
-      echo "停止 ${SERVICE_NAME} 服务..."
-      ${SUDO_CMD} systemctl stop "${SERVICE_NAME}" || true
+  if [ "$PLATFORM" = "linux" ] && command -v systemctl &> /dev/null && [ -x "${AGENT_INSTALL_PATH}" ]; then
+    if ! ${SUDO_CMD} "${AGENT_INSTALL_PATH}" update --yes \
+        --bin "${AGENT_INSTALL_PATH}" --config "${CONFIG_PATH}" --service-name "${AGENT_NAME}"; then
+      echo "错误: 更新失败。" >&2
+      exit 1
     fi
-  fi
-
-  if [ -f "${AGENT_INSTALL_PATH}" ]; then
-    echo "删除旧版 Agent: ${AGENT_INSTALL_PATH}"
-    ${SUDO_CMD} rm -f "${AGENT_INSTALL_PATH}"
-  fi
-  LOCAL_AGENT_DOWNLOAD_PATH="./${AGENT_NAME}${EXTENSION}"
-  if [ -f "${LOCAL_AGENT_DOWNLOAD_PATH}" ]; then
-    echo "删除本地旧版 Agent: ${LOCAL_AGENT_DOWNLOAD_PATH}"
-    rm -f "${LOCAL_AGENT_DOWNLOAD_PATH}"
-  fi
-
-  DOWNLOAD_URL="${DOWNLOAD_BASE_URL}/${AGENT_NAME}-${PLATFORM}-${ARCH}${EXTENSION}"
-  echo "下载最新 Agent: ${DOWNLOAD_URL}"
-  if command -v curl >/dev/null 2>&1; then
-    curl -sSL "${DOWNLOAD_URL}" -o "${LOCAL_AGENT_DOWNLOAD_PATH}"
-  elif command -v wget >/dev/null 2>&1; then
-    wget -qO "${LOCAL_AGENT_DOWNLOAD_PATH}" "${DOWNLOAD_URL}"
-  else
-    echo "错误: 系统中未找到 curl 或 wget。请先安装其中一个。" >&2
-    exit 1
-  fi
-  chmod +x "${LOCAL_AGENT_DOWNLOAD_PATH}"
-
-  if [ "$PLATFORM" = "linux" ] && command -v systemctl &> /dev/null; then
-    echo "将 Agent 移动到 ${AGENT_INSTALL_PATH}..."
-    ${SUDO_CMD} mv "${LOCAL_AGENT_DOWNLOAD_PATH}" "${AGENT_INSTALL_PATH}"
-
-    SERVICE_FILE_CONTENT="[Unit]
-Description=${AGENT_NAME}
-After=network.target
-
-[Service]
-Type=simple
-ExecStart=${AGENT_INSTALL_PATH} start --server \"${XUGOU_SERVER}\" --token \"${XUGOU_TOKEN}\" --interval \"${AGENT_INTERVAL}\"
-Restart=on-failure
-RestartSec=10
-StandardOutput=journal
-StandardError=journal
-Environment=\"HOME=/root\"
-# 考虑为更佳安全性设置 User= 和 Group= (例如创建一个 'xugou' 用户)
-# User=nobody
-# Group=nogroup
-
-[Install]
-WantedBy=multi-user.target"
-    TEMP_SERVICE_FILE=$(mktemp)
-    echo "${SERVICE_FILE_CONTENT}" > "${TEMP_SERVICE_FILE}"
-    ${SUDO_CMD} mkdir -p "$(dirname "${SERVICE_FILE_PATH}")"
-    ${SUDO_CMD} cp "${TEMP_SERVICE_FILE}" "${SERVICE_FILE_PATH}"
-    rm "${TEMP_SERVICE_FILE}"
-    ${SUDO_CMD} chmod 644 "${SERVICE_FILE_PATH}"
-    ${SUDO_CMD} systemctl daemon-reload
-    ${SUDO_CMD} systemctl enable "${SERVICE_NAME}"
-    ${SUDO_CMD} systemctl restart "${SERVICE_NAME}"
-    echo "${AGENT_NAME} 已更新并重启 systemd 服务。"
+    echo "${AGENT_NAME} 已更新并重启服务。"
   else
-    echo "Agent 已下载到 ${LOCAL_AGENT_DOWNLOAD_PATH}"
-    echo "使用如下命令启动 Agent："
-    echo "  ${LOCAL_AGENT_DOWNLOAD_PATH} start --server \"${XUGOU_SERVER}\" --token \"${XUGOU_TOKEN}\" --interval \"${AGENT_INTERVAL}\""
+    LOCAL_AGENT_DOWNLOAD_PATH="./${AGENT_NAME}${EXTENSION}"
+    if [ ! -x "${LOCAL_AGENT_DOWNLOAD_PATH}" ]; then
+      echo "错误: 未找到已安装的 Agent（${AGENT_INSTALL_PATH} 或 ${LOCAL_AGENT_DOWNLOAD_PATH}），请先运行 install 命令。" >&2
+      exit 1
+    fi
+    if ! "${LOCAL_AGENT_DOWNLOAD_PATH}" update --yes --bin "${LOCAL_AGENT_DOWNLOAD_PATH}"; then
+      echo "错误: 更新失败。" >&2
+      exit 1
+    fi
   fi
   echo "更新完成。"
 }
@@ -374,23 +307,9 @@ do_uninstall() {
         echo "警告: 需要 sudo 权限来移除 systemd 服务，但 sudo 命令未找到或您不是 root 用户。" >&2
         echo "将仅尝试删除本地文件 (如果存在于当前目录)。" >&2
     else
-        if ${SUDO_CMD} systemctl list-unit-files | grep -q "${SERVICE_NAME}"; then
-            echo "停止 ${SERVICE_NAME} 服务..."
-            ${SUDO_CMD} systemctl stop "${SERVICE_NAME}" || echo "服务可能未在运行。"
-            echo "禁用 ${SERVICE_NAME} 服务 (取消开机自启)..."
-            ${SUDO_CMD} systemctl disable "${SERVICE_NAME}" || echo "服务可能未启用。"
-            echo "删除 systemd 服务文件: ${SERVICE_FILE_PATH}..."
-            ${SUDO_CMD} rm -f "${SERVICE_FILE_PATH}"
-            echo "重新加载 systemd 管理器配置..."
-            ${SUDO_CMD} systemctl daemon-reload
-            echo "systemd 服务相关内容已移除。"
-        else
-            echo "${SERVICE_NAME} 服务未找到或未注册。"
-        fi
-
-        if [ -f "${AGENT_INSTALL_PATH}" ]; then
-            echo "删除 Agent 二进制文件: ${AGENT_INSTALL_PATH}..."
-            ${SUDO_CMD} rm -f "${AGENT_INSTALL_PATH}"
+        if [ -x "${AGENT_INSTALL_PATH}" ]; then
+            # 停止、禁用并删除服务文件，同时删除配置文件和二进制文件
+            ${SUDO_CMD} "${AGENT_INSTALL_PATH}" service uninstall --purge --bin "${AGENT_INSTALL_PATH}" || echo "服务可能未安装。"
         else
             echo "Agent 二进制文件 ${AGENT_INSTALL_PATH} 未找到。"
         fi
@@ -450,6 +369,10 @@ if [[ "$SUBCOMMAND" == "install" ]]; then
         AGENT_INTERVAL="$2"
         shift 2
         ;;
+      --overwrite-config)
+        OVERWRITE_CONFIG="--overwrite-config"
+        shift
+        ;;
       -h|--help) # Allow --help after 'install' subcommand too
         print_usage
         ;;